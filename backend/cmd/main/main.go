@@ -2,18 +2,43 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/michaelzhan1/split/internals/config"
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/events"
 	"github.com/michaelzhan1/split/internals/handlers"
+	"github.com/michaelzhan1/split/internals/idempotency"
 	"github.com/michaelzhan1/split/internals/logs"
+	"github.com/michaelzhan1/split/internals/metrics"
+	"github.com/michaelzhan1/split/internals/migrations"
+	"github.com/michaelzhan1/split/internals/ratelimit"
+	"github.com/michaelzhan1/split/internals/scheduler"
+	"github.com/michaelzhan1/split/internals/tracing"
+	"github.com/michaelzhan1/split/internals/webhooks"
+)
+
+const (
+	// DATABASE_URL that keeps everything in memory instead, for trying the API out
+	memoryURL = "memory://"
+
+	// DATABASE_URL prefix for a SQLite file, as in sqlite://split.db or sqlite:///var/lib/split.db
+	sqlitePrefix = "sqlite://"
 )
 
 func main() {
@@ -22,46 +47,260 @@ func main() {
 		fmt.Println("No .env file found")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	cfg, args, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
 
-	db, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
+	L := logs.New(os.Stdout, cfg.Log.LogLevels(), cfg.Log.Format, cfg.Log.Redact)
+	databaseL := logs.For(L, "database")
+	httpL := logs.For(L, "http")
+	idempotencyL := logs.For(L, "idempotency")
+	ratelimitL := logs.For(L, "ratelimit")
 
+	tracingEnabled := cfg.Tracing.Exporter != tracing.ExporterNone
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio, cfg.Tracing.ServiceName, os.Stdout)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Unable to set up tracing: %v\n", err)
 		os.Exit(1)
 	}
-	defer db.Close()
 
-	L := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
+	databaseURL := cfg.Database.URL
+	subcommand := ""
+	if len(args) > 0 {
+		subcommand = args[0]
+	}
+	var store database.Store
+	// what /readyz runs to decide whether this instance should get traffic
+	var ready func(ctx context.Context) error
+	// only set for Postgres, which can also hold the rate limit buckets
+	var pool *pgxpool.Pool
+	switch {
+	case databaseURL == memoryURL:
+		if subcommand == "migrate" || cfg.Database.Migrate {
+			fmt.Fprintln(os.Stderr, "The in-memory store has no migrations")
+			os.Exit(1)
+		}
+		L.Info("Using the in-memory store, data is lost on exit")
+		store = database.NewMemoryStore(databaseL)
+		ready = func(ctx context.Context) error { return nil }
+	case strings.HasPrefix(databaseURL, sqlitePrefix):
+		// the file's schema is brought up to date when it is opened, so -migrate changes nothing
+		if subcommand == "migrate" {
+			fmt.Fprintln(os.Stderr, "The SQLite store applies its schema when it opens")
+			os.Exit(1)
+		}
+		path := strings.TrimPrefix(databaseURL, sqlitePrefix)
+		sqliteStore, err := database.OpenSqliteStore(context.Background(), path, databaseL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open database: %v\n", err)
+			os.Exit(1)
+		}
+		defer sqliteStore.Close()
+
+		L.Info(fmt.Sprintf("Using the SQLite store at %s", path))
+		store = sqliteStore
+		ready = sqliteStore.Check
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Database.ConnectTimeout)
+		defer cancel()
+
+		poolConfig, err := pgxpool.ParseConfig(databaseURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid DATABASE_URL: %v\n", err)
+			os.Exit(1)
+		}
+		poolConfig.MaxConns = int32(cfg.Database.MaxConns)
+		poolConfig.MinConns = int32(cfg.Database.MinConns)
+		var tracers []pgx.QueryTracer
+		if cfg.Features.Metrics {
+			tracers = append(tracers, metrics.QueryTracer{})
+		}
+		if tracingEnabled {
+			tracers = append(tracers, tracing.QueryTracer{})
+		}
+		if len(tracers) > 0 {
+			poolConfig.ConnConfig.Tracer = multitracer.New(tracers...)
+		}
+
+		db, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		if cfg.Features.Metrics {
+			err := metrics.RegisterPool(db)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to register pool metrics: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if subcommand == "migrate" {
+			err := runMigrate(context.Background(), db, logs.For(L, "migrations"), args[1:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if cfg.Database.Migrate {
+			_, err := migrations.Up(context.Background(), db, logs.For(L, "migrations"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		err = migrations.Check(ctx, db)
+		if errors.Is(err, migrations.ErrPendingMigrations) {
+			fmt.Fprintf(os.Stderr, "%v. Run with the migrate subcommand, or start with -migrate\n", err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to check database schema: %v\n", err)
+			os.Exit(1)
+		}
+
+		store = database.NewPgStore(db, databaseL)
+		pool = db
+		ready = func(ctx context.Context) error {
+			err := db.Ping(ctx)
+			if err != nil {
+				return err
+			}
+			return migrations.Check(ctx, db)
+		}
+	}
+
+	if tracingEnabled {
+		store = tracing.Store(store)
+	}
+
+	// background work outlives the signal, so it is only stopped once requests have drained
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	var limitStore ratelimit.Store
+	switch cfg.RateLimit.Store {
+	case "memory":
+		limitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		limitStore = ratelimit.NewPgStore(pool, ratelimitL)
+	}
+
+	broker := events.NewBroker()
+	go broker.Listen(background, store, logs.For(L, "events"))
+
 	r := chi.NewRouter()
-	r.Use(logs.RequestLogger(L))
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins: []string{os.Getenv("FRONTEND_URL")},
-		AllowedMethods: []string{"GET", "POST", "PATCH", "DELETE"},
+	r.Use(logs.RequestID)
+	if tracingEnabled {
+		r.Use(tracing.Middleware)
+	}
+	if cfg.Features.Metrics {
+		r.Use(metrics.Middleware)
+	}
+	r.Use(logs.RequestLogger(httpL))
+	// with no origins the cors package would allow every one, so leave it out instead
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		r.Use(cors.Handler(cors.Options{
+			AllowedOrigins: cfg.CORS.AllowedOrigins,
+			AllowedMethods: []string{"GET", "POST", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Accept", "Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", "Last-Event-ID", "X-Person-ID"},
+			ExposedHeaders: []string{"ETag", "Idempotent-Replayed", ratelimit.LimitHeader, ratelimit.RemainingHeader, ratelimit.ResetHeader, ratelimit.RetryAfterHeader},
+		}))
+	}
+	r.Use(handlers.WithTimeouts(handlers.Timeouts{
+		Request: cfg.Timeouts.Request,
+		Batch:   cfg.Timeouts.Batch,
 	}))
+	r.NotFound(handlers.NotFound(httpL))
+	r.MethodNotAllowed(handlers.MethodNotAllowed(httpL))
+	registerProbes(r, httpL, ready, cfg.Features.Metrics)
+	// health checks and metrics stay out of the rate limits
+	r.Group(func(r chi.Router) {
+		// ahead of idempotency, which would otherwise keep a 429 as the response to replay
+		if limitStore != nil {
+			r.Use(ratelimit.Middleware(limitStore, ratelimitL, ratelimit.Limits{
+				Read:       ratelimit.Limit{Requests: cfg.RateLimit.Read, Window: cfg.RateLimit.Window},
+				Write:      ratelimit.Limit{Requests: cfg.RateLimit.Write, Window: cfg.RateLimit.Window},
+				GroupRead:  ratelimit.Limit{Requests: cfg.RateLimit.GroupRead, Window: cfg.RateLimit.Window},
+				GroupWrite: ratelimit.Limit{Requests: cfg.RateLimit.GroupWrite, Window: cfg.RateLimit.Window},
+				ClientKey:  cfg.RateLimit.ClientKey,
+				TrustProxy: cfg.RateLimit.TrustProxy,
+			}))
+		}
+		if cfg.Features.Idempotency {
+			r.Use(idempotency.Middleware(store, idempotencyL, cfg.Idempotency.Retention))
+		}
+		registerRoutes(r, store, httpL, broker)
+	})
 
-	r.Route("/groups", func(r chi.Router) {
-		r.Post("/", handlers.CreateGroup(db, L))
-		r.Get("/{group_id}", handlers.GetGroup(db, L))
-		r.Patch("/{group_id}", handlers.PatchGroup(db, L))
-		r.Delete("/{group_id}", handlers.DeleteGroup(db, L))
+	if cfg.Features.Recurring {
+		scheduler.Start(background, store, logs.For(L, "scheduler"), time.Minute)
+	}
+	if cfg.Features.Webhooks {
+		webhooks.Start(background, store, logs.For(L, "webhooks"), 5*time.Second)
+	}
+	if cfg.Features.Idempotency {
+		idempotency.Start(background, store, idempotencyL, time.Hour, cfg.Idempotency.Retention)
+	}
+	if limitStore != nil {
+		// a bucket left alone for a window is full, the same as one that was never made
+		ratelimit.Start(background, limitStore, ratelimitL, time.Minute, cfg.RateLimit.Window)
+	}
 
-		r.Get("/{group_id}/users", handlers.GetUsers(db, L))
-		r.Post("/{group_id}/users", handlers.AddUser(db, L))
-		r.Patch("/{group_id}/users/{user_id}", handlers.PatchUser(db, L))
-		r.Delete("/{group_id}/users/{user_id}", handlers.DeleteUser(db, L))
+	server := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      r,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	// event streams never finish on their own, so end them as soon as shutdown starts
+	server.RegisterOnShutdown(broker.Close)
 
-		r.Get("/{group_id}/payments", handlers.GetPayments(db, L))
-		r.Post("/{group_id}/payments", handlers.AddPayment(db, L))
-		r.Patch("/{group_id}/payments/{payment_id}", handlers.PatchPayment(db, L))
-		r.Delete("/{group_id}/payments/{payment_id}", handlers.DeletePayment(db, L))
-		r.Delete("/{group_id}/payments", handlers.DeleteAllPayments(db, L)) // delete all
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
 
-		r.Post("/{group_id}/calculate", handlers.Calculate(db, L))
-	})
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to listen: %v\n", err)
+		os.Exit(1)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	L.Info(fmt.Sprintf("Serving on %s", cfg.ListenAddr))
+
+	select {
+	case err := <-served:
+		fmt.Fprintf(os.Stderr, "Unable to serve: %v\n", err)
+		os.Exit(1)
+	case <-signals.Done():
+	}
+	// a second signal kills the process instead of waiting out the drain
+	stopSignals()
 
-	port := "3000"
-	L.Info(fmt.Sprintf("Serving on port %s", port))
-	http.ListenAndServe(":"+port, r)
+	L.Info(fmt.Sprintf("Shutting down, giving in-flight requests %s to finish", cfg.Server.ShutdownTimeout))
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		L.Warn(fmt.Sprintf("Requests still running after %s were cut off: %v", cfg.Server.ShutdownTimeout, err))
+		server.Close()
+	}
+	// the drain may have used up its deadline, so spans still buffered get their own
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	err = shutdownTracing(flushCtx)
+	if err != nil {
+		L.Warn(fmt.Sprintf("Unable to flush traces: %v", err))
+	}
+	L.Info("Server stopped")
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/michaelzhan1/split/internals/migrations"
+)
+
+var errMigrateUsage = errors.New("usage: migrate [up | down [steps] | status]")
+
+// runMigrate is the migrate subcommand
+func runMigrate(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch {
+	case command == "up" && len(args) <= 1:
+		version, err := migrations.Up(ctx, db, L)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Database is at version %d\n", version)
+	case command == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			parsed, err := strconv.Atoi(args[1])
+			if err != nil || parsed < 1 {
+				return errMigrateUsage
+			}
+			steps = parsed
+		}
+		version, err := migrations.Down(ctx, db, L, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Database is at version %d\n", version)
+	case command == "status" && len(args) == 1:
+		current, err := migrations.Current(ctx, db)
+		if err != nil {
+			return err
+		}
+		latest, err := migrations.Latest()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Database is at version %d, this server needs %d\n", current, latest)
+	default:
+		return errMigrateUsage
+	}
+	return nil
+}
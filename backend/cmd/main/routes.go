@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/events"
+	"github.com/michaelzhan1/split/internals/handlers"
+	"github.com/michaelzhan1/split/internals/metrics"
+	"github.com/michaelzhan1/split/internals/openapi"
+)
+
+func registerRoutes(r chi.Router, store database.Store, L *slog.Logger, broker *events.Broker) {
+	r.Route("/groups", func(r chi.Router) {
+		r.Get("/", handlers.ListGroups(store, L))
+		r.Post("/", handlers.CreateGroup(store, L))
+		r.Get("/{group_id}", handlers.GetGroup(store, L))
+		r.Get("/{group_id}/summary", handlers.GetGroupSummary(store, L))
+		r.Get("/{group_id}/events", handlers.GroupEvents(store, L, broker))
+		r.Patch("/{group_id}", handlers.PatchGroup(store, L))
+		r.Delete("/{group_id}", handlers.DeleteGroup(store, L))
+
+		r.Get("/{group_id}/users", handlers.GetUsers(store, L))
+		r.Post("/{group_id}/users", handlers.AddUser(store, L))
+		r.Patch("/{group_id}/users/{user_id}", handlers.PatchUser(store, L))
+		r.Delete("/{group_id}/users/{user_id}", handlers.DeleteUser(store, L))
+		r.Post("/{group_id}/users/{user_id}/deactivate", handlers.DeactivateUser(store, L))
+		r.Post("/{group_id}/users/{user_id}/merge", handlers.MergeUser(store, L))
+
+		r.Get("/{group_id}/payments", handlers.GetPayments(store, L))
+		r.Post("/{group_id}/payments", handlers.AddPayment(store, L))
+		r.Patch("/{group_id}/payments/{payment_id}", handlers.PatchPayment(store, L))
+		r.Delete("/{group_id}/payments/{payment_id}", handlers.DeletePayment(store, L))
+		r.Delete("/{group_id}/payments", handlers.DeleteAllPayments(store, L)) // delete all
+
+		r.Get("/{group_id}/recurring", handlers.GetRecurringPayments(store, L))
+		r.Post("/{group_id}/recurring", handlers.AddRecurringPayment(store, L))
+		r.Delete("/{group_id}/recurring/{recurring_id}", handlers.DeleteRecurringPayment(store, L))
+
+		r.Post("/{group_id}/settlements", handlers.RecordSettlement(store, L))
+
+		r.Post("/{group_id}/batch", handlers.Batch(store, L))
+
+		r.Get("/{group_id}/webhooks", handlers.GetWebhooks(store, L))
+		r.Post("/{group_id}/webhooks", handlers.AddWebhook(store, L))
+		r.Delete("/{group_id}/webhooks/{webhook_id}", handlers.DeleteWebhook(store, L))
+		r.Get("/{group_id}/webhooks/{webhook_id}/deliveries", handlers.GetWebhookDeliveries(store, L))
+		r.Post("/{group_id}/webhooks/{webhook_id}/deliveries/{delivery_id}/replay", handlers.ReplayWebhookDelivery(store, L))
+
+		r.Post("/{group_id}/calculate", handlers.Calculate(store, L))
+	})
+
+	r.Get("/openapi.json", openapi.Handler())
+
+	r.Route("/people", func(r chi.Router) {
+		r.Post("/", handlers.CreatePerson(store, L))
+		r.Get("/{person_id}", handlers.GetPerson(store, L))
+		r.Get("/{person_id}/balances", handlers.GetPersonBalances(store, L))
+
+		r.Post("/{person_id}/users", handlers.LinkUser(store, L))
+		r.Delete("/{person_id}/users/{user_id}", handlers.UnlinkUser(store, L))
+	})
+}
+
+// registerProbes adds the health checks and, with withMetrics, the Prometheus metrics, which
+// all stay out of the rate limits and idempotency. ready decides whether /readyz answers 200
+func registerProbes(r chi.Router, L *slog.Logger, ready func(ctx context.Context) error, withMetrics bool) {
+	r.Get("/healthz", handlers.Healthz())
+	r.Get("/readyz", handlers.Readyz(L, ready))
+	if withMetrics {
+		r.Method(http.MethodGet, "/metrics", metrics.Handler())
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/michaelzhan1/split/internals/client"
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+func money(amount float32) string {
+	return strconv.FormatFloat(float64(amount), 'f', 2, 32)
+}
+
+func profileSet(ctx context.Context, a *app, args []string) error {
+	fs := a.flags()
+	server := fs.String("server", "", "server URL")
+	token := fs.String("token", "", "API token")
+	person := fs.Int("person", 0, "person ID to act as")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	// only overwrite what was passed, so a profile can be updated one field at a time
+	profile := a.config.Profiles[args[0]]
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			profile.Server = *server
+		case "token":
+			profile.Token = *token
+		case "person":
+			profile.PersonID = *person
+		case "group":
+			profile.GroupID = a.group
+		}
+	})
+	if profile.Server == "" {
+		return fmt.Errorf("profile %q needs a --server", args[0])
+	}
+	a.config.Profiles[args[0]] = profile
+	return saveConfig(a.config)
+}
+
+func profileUse(ctx context.Context, a *app, args []string) error {
+	args, err := a.parse(a.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errUsage
+	}
+	if _, _, err := a.config.profile(args[0]); err != nil {
+		return err
+	}
+
+	a.config.Current = args[0]
+	return saveConfig(a.config)
+}
+
+func profileList(ctx context.Context, a *app, args []string) error {
+	args, err := a.parse(a.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	names := []string{}
+	for name := range a.config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := [][]string{}
+	for _, name := range names {
+		profile := a.config.Profiles[name]
+		current := ""
+		if name == a.config.Current {
+			current = "*"
+		}
+		rows = append(rows, []string{current, name, profile.Server, strconv.Itoa(profile.PersonID), strconv.Itoa(profile.GroupID)})
+	}
+	return a.print(a.config, []string{"", "PROFILE", "SERVER", "PERSON", "GROUP"}, rows)
+}
+
+func groupCreate(ctx context.Context, a *app, args []string) error {
+	args, err := a.parse(a.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	id, err := a.client.CreateGroup(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(map[string]int{"id": id}, []string{"ID", "NAME"}, [][]string{{strconv.Itoa(id), args[0]}})
+}
+
+func groupList(ctx context.Context, a *app, args []string) error {
+	fs := a.flags()
+	search := fs.String("search", "", "only show groups whose name contains this")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errUsage
+	}
+	if a.current.PersonID == 0 {
+		return fmt.Errorf("profile %q has no person, run split profile set %s --person ID", a.profile, a.profile)
+	}
+
+	page, err := a.client.ListGroups(ctx, client.ListGroupsOptions{Query: *search, Limit: 100})
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, group := range page.Groups {
+		rows = append(rows, []string{
+			strconv.Itoa(group.ID),
+			group.Name,
+			strconv.Itoa(group.MemberCount),
+			money(group.TotalSpent),
+			money(group.Balance),
+		})
+	}
+	return a.print(page, []string{"ID", "NAME", "MEMBERS", "SPENT", "BALANCE"}, rows)
+}
+
+func groupUse(ctx context.Context, a *app, args []string) error {
+	args, err := a.parse(a.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid group ID %q", args[0])
+	}
+
+	// make sure the group exists before saving it
+	if _, err := a.client.GetGroup(ctx, id); err != nil {
+		return err
+	}
+	a.current.GroupID = id
+	a.config.Profiles[a.profile] = a.current
+	return saveConfig(a.config)
+}
+
+func userAdd(ctx context.Context, a *app, args []string) error {
+	args, err := a.parse(a.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errUsage
+	}
+	groupID, err := a.groupID()
+	if err != nil {
+		return err
+	}
+
+	id, err := a.client.AddUser(ctx, groupID, args[0])
+	if err != nil {
+		return err
+	}
+	return a.print(map[string]int{"id": id}, []string{"ID", "NAME"}, [][]string{{strconv.Itoa(id), args[0]}})
+}
+
+func users(ctx context.Context, a *app, args []string) error {
+	args, err := a.parse(a.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errUsage
+	}
+	groupID, err := a.groupID()
+	if err != nil {
+		return err
+	}
+
+	users, err := a.client.GetUsers(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, user := range users {
+		status := "active"
+		if !user.Active {
+			status = "inactive"
+		}
+		rows = append(rows, []string{strconv.Itoa(user.ID), user.Name, status})
+	}
+	return a.print(users, []string{"ID", "NAME", "STATUS"}, rows)
+}
+
+// resolve finds the active member with the given name, ignoring case
+func resolve(users []handlers.User, name string) (handlers.User, error) {
+	matches := []handlers.User{}
+	for _, user := range users {
+		if user.Active && strings.EqualFold(user.Name, name) {
+			matches = append(matches, user)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return handlers.User{}, fmt.Errorf("no active member named %q", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return handlers.User{}, fmt.Errorf("%d members are named %q", len(matches), name)
+	}
+}
+
+func pay(ctx context.Context, a *app, args []string) error {
+	fs := a.flags()
+	payer := fs.String("payer", "", "name of the member who paid")
+	payees := fs.String("for", "", "comma separated names of the members paid for, everyone when empty")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *payer == "" || len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	amount, err := strconv.ParseFloat(args[0], 32)
+	if err != nil || amount <= 0 {
+		return fmt.Errorf("invalid amount %q", args[0])
+	}
+	description := ""
+	if len(args) == 2 {
+		description = args[1]
+	}
+	groupID, err := a.groupID()
+	if err != nil {
+		return err
+	}
+
+	users, err := a.client.GetUsers(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	payment := database.InsertPayment{
+		Description: description,
+		Amount:      float32(amount),
+		PayeeIDs:    []int{},
+	}
+	user, err := resolve(users, *payer)
+	if err != nil {
+		return err
+	}
+	payment.PayerID = user.ID
+	if *payees == "" {
+		for _, user := range users {
+			if user.Active {
+				payment.PayeeIDs = append(payment.PayeeIDs, user.ID)
+			}
+		}
+	} else {
+		for _, name := range strings.Split(*payees, ",") {
+			user, err := resolve(users, strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+			payment.PayeeIDs = append(payment.PayeeIDs, user.ID)
+		}
+	}
+
+	id, err := a.client.AddPayment(ctx, groupID, payment)
+	if err != nil {
+		return err
+	}
+	return a.print(map[string]int{"id": id}, []string{"ID", "AMOUNT", "DESCRIPTION"}, [][]string{{strconv.Itoa(id), money(payment.Amount), description}})
+}
+
+func balances(ctx context.Context, a *app, args []string) error {
+	args, err := a.parse(a.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errUsage
+	}
+	groupID, err := a.groupID()
+	if err != nil {
+		return err
+	}
+
+	users, err := a.client.GetUsers(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, user := range users {
+		if !user.Active {
+			continue
+		}
+		// a positive balance means the member owes money
+		status := "settled"
+		if user.Balance > database.SettledEpsilon {
+			status = "owes"
+		} else if user.Balance < -database.SettledEpsilon {
+			status = "is owed"
+		}
+		rows = append(rows, []string{user.Name, money(user.Balance), status})
+	}
+	return a.print(users, []string{"MEMBER", "BALANCE", "STATUS"}, rows)
+}
+
+type settlement struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float32 `json:"amount"`
+	ID     *int    `json:"id,omitempty"`
+}
+
+func settle(ctx context.Context, a *app, args []string) error {
+	fs := a.flags()
+	apply := fs.Bool("apply", false, "record the settlements instead of only printing them")
+	args, err := a.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errUsage
+	}
+	groupID, err := a.groupID()
+	if err != nil {
+		return err
+	}
+
+	users, err := a.client.GetUsers(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	names := map[int]string{}
+	for _, user := range users {
+		names[user.ID] = user.Name
+	}
+
+	ious, err := a.client.Calculate(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	settlements := []settlement{}
+	rows := [][]string{}
+	for _, iou := range ious {
+		// in an IOU, To owes From, so To is the one paying
+		s := settlement{From: names[iou.ToID], To: names[iou.FromID], Amount: iou.Amount}
+		if *apply {
+			id, err := a.client.RecordSettlement(ctx, groupID, iou.ToID, iou.FromID, iou.Amount)
+			if err != nil {
+				return err
+			}
+			s.ID = &id
+		}
+		settlements = append(settlements, s)
+		rows = append(rows, []string{s.From, "pays", s.To, money(s.Amount)})
+	}
+	return a.print(settlements, []string{"FROM", "", "TO", "AMOUNT"}, rows)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const defaultProfile = "default"
+
+type Profile struct {
+	Server   string `json:"server"`
+	Token    string `json:"token,omitempty"`
+	PersonID int    `json:"person_id,omitempty"`
+	GroupID  int    `json:"group_id,omitempty"`
+}
+
+type Config struct {
+	Current  string             `json:"current"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// configPath is $SPLIT_CONFIG, or split/config.json under the user config dir
+func configPath() (string, error) {
+	if path := os.Getenv("SPLIT_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "split", "config.json"), nil
+}
+
+func loadConfig() (Config, error) {
+	config := Config{
+		Current: defaultProfile,
+		Profiles: map[string]Profile{
+			defaultProfile: {Server: "http://localhost:3000"},
+		},
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+	return config, nil
+}
+
+func saveConfig(config Config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the file holds tokens, so keep it private
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// profile returns the named profile, or the current one when name is empty
+func (config Config) profile(name string) (string, Profile, error) {
+	if name == "" {
+		name = config.Current
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return name, profile, fmt.Errorf("no profile named %q", name)
+	}
+	return name, profile, nil
+}
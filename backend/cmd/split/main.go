@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/client"
+)
+
+const usage = `Usage: split <command> [flags] [args]
+
+Commands:
+  profile set NAME --server URL [--token TOKEN] [--person ID] [--group ID]
+  profile use NAME
+  profile list
+  group create NAME
+  group list [--search TEXT]
+  group use ID
+  user add NAME
+  users
+  pay --payer NAME [--for NAME,NAME] AMOUNT [DESCRIPTION]
+  balances
+  settle [--apply]
+
+Every command accepts --profile NAME to pick a profile other than the
+current one, --group ID to override the profile's group and --json to
+print JSON instead of a table.
+`
+
+var errUsage = errors.New("invalid usage")
+
+type command func(ctx context.Context, app *app, args []string) error
+
+var commands = map[string]map[string]command{
+	"profile":  {"set": profileSet, "use": profileUse, "list": profileList},
+	"group":    {"create": groupCreate, "list": groupList, "use": groupUse},
+	"user":     {"add": userAdd},
+	"users":    {"": users},
+	"pay":      {"": pay},
+	"balances": {"": balances},
+	"settle":   {"": settle},
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	os.Exit(exit(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// exit runs the command and reports how it went on stderr, returning the exit code:
+// 2 for bad usage and 1 for any other failure
+func exit(ctx context.Context, args []string, out io.Writer, stderr io.Writer) int {
+	err := run(ctx, args, out)
+	if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "split: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	subcommands, ok := commands[args[0]]
+	if !ok {
+		return errUsage
+	}
+	name := args[0]
+	args = args[1:]
+
+	cmd, ok := subcommands[""]
+	if !ok {
+		if len(args) == 0 {
+			return errUsage
+		}
+		cmd, ok = subcommands[args[0]]
+		if !ok {
+			return errUsage
+		}
+		name += " " + args[0]
+		args = args[1:]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return cmd(ctx, &app{name: name, out: out}, args)
+}
+
+// app holds what every command needs once its flags are parsed
+type app struct {
+	name    string
+	out     io.Writer
+	json    bool
+	group   int
+	profile string
+
+	config  Config
+	current Profile
+	client  *client.Client
+}
+
+// flags returns a flag set with the flags every command shares
+func (a *app) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("split "+a.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&a.json, "json", false, "print JSON")
+	fs.IntVar(&a.group, "group", 0, "group ID")
+	fs.StringVar(&a.profile, "profile", "", "profile name")
+	return fs
+}
+
+// parse parses the flags, which may come before or after the positional
+// arguments, loads the profile and builds the API client. It returns the
+// positional arguments.
+func (a *app) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	a.config = config
+	a.profile, a.current, err = config.profile(a.profile)
+	if err != nil {
+		return nil, err
+	}
+	if a.group == 0 {
+		a.group = a.current.GroupID
+	}
+
+	opts := []client.Option{}
+	if a.current.Token != "" {
+		opts = append(opts, client.WithToken(a.current.Token))
+	}
+	if a.current.PersonID != 0 {
+		opts = append(opts, client.WithPersonID(a.current.PersonID))
+	}
+	a.client = client.New(a.current.Server, opts...)
+	return positional, nil
+}
+
+func (a *app) groupID() (int, error) {
+	if a.group == 0 {
+		return 0, errors.New("no group selected, pass --group or run split group use ID")
+	}
+	return a.group, nil
+}
+
+// print writes v as JSON when --json is set, and the rows as an aligned table otherwise
+func (a *app) print(v any, header []string, rows [][]string) error {
+	if a.json {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(a.out, string(data))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+// newServer serves the routes the commands call from a memory store, and points
+// a fresh config file at it
+func newServer(t *testing.T) {
+	t.Helper()
+	L := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := database.NewMemoryStore(L)
+
+	r := chi.NewRouter()
+	r.NotFound(handlers.NotFound(L))
+	r.Route("/groups", func(r chi.Router) {
+		r.Get("/", handlers.ListGroups(store, L))
+		r.Post("/", handlers.CreateGroup(store, L))
+		r.Get("/{group_id}", handlers.GetGroup(store, L))
+		r.Get("/{group_id}/users", handlers.GetUsers(store, L))
+		r.Post("/{group_id}/users", handlers.AddUser(store, L))
+		r.Post("/{group_id}/payments", handlers.AddPayment(store, L))
+		r.Post("/{group_id}/settlements", handlers.RecordSettlement(store, L))
+		r.Post("/{group_id}/calculate", handlers.Calculate(store, L))
+	})
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	t.Setenv("SPLIT_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	if code, _, stderr := split("profile", "set", "local", "--server", server.URL); code != 0 {
+		t.Fatalf("profile set = %d: %s", code, stderr)
+	}
+	if code, _, stderr := split("profile", "use", "local"); code != 0 {
+		t.Fatalf("profile use = %d: %s", code, stderr)
+	}
+}
+
+// split runs the command line and returns its exit code, stdout and stderr
+func split(args ...string) (int, string, string) {
+	var out, stderr bytes.Buffer
+	code := exit(context.Background(), args, &out, &stderr)
+	return code, out.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	newServer(t)
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"group", "create", "Trip"}, "ID  NAME\n1   Trip\n"},
+		{[]string{"group", "use", "1"}, ""},
+		{[]string{"user", "add", "Alice"}, "ID  NAME\n1   Alice\n"},
+		{[]string{"user", "add", "Bob"}, "ID  NAME\n2   Bob\n"},
+		// flags may follow the positional arguments
+		{[]string{"pay", "30", "Dinner", "--payer", "alice"}, "ID  AMOUNT  DESCRIPTION\n1   30.00   Dinner\n"},
+		{[]string{"pay", "--payer", "Bob", "--for", "alice", "10"}, "ID  AMOUNT  DESCRIPTION\n2   10.00   \n"},
+		{[]string{"users"}, "ID  NAME   STATUS\n1   Alice  active\n2   Bob    active\n"},
+		{[]string{"balances"}, "MEMBER  BALANCE  STATUS\nAlice   -5.00    is owed\nBob     5.00     owes\n"},
+		{[]string{"settle"}, "FROM        TO     AMOUNT\nBob   pays  Alice  5.00\n"},
+	}
+	for _, step := range steps {
+		code, out, stderr := split(step.args...)
+		if code != 0 || stderr != "" {
+			t.Fatalf("split %s = %d: %s", strings.Join(step.args, " "), code, stderr)
+		}
+		if out != step.want {
+			t.Errorf("split %s printed\n%s\nwant\n%s", strings.Join(step.args, " "), out, step.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	newServer(t)
+
+	code, out, _ := split("group", "create", "--json", "Trip")
+	var created map[string]int
+	if err := json.Unmarshal([]byte(out), &created); code != 0 || err != nil || created["id"] != 1 {
+		t.Fatalf("group create --json = %d %q, want the id as JSON", code, out)
+	}
+	split("user", "add", "--group", "1", "Alice")
+	split("user", "add", "--group", "1", "Bob")
+	split("pay", "--group", "1", "--payer", "Alice", "20")
+
+	code, out, _ = split("settle", "--group", "1", "--apply", "--json")
+	var settlements []settlement
+	if err := json.Unmarshal([]byte(out), &settlements); code != 0 || err != nil {
+		t.Fatalf("settle --json = %d %q, want a JSON list", code, out)
+	}
+	if len(settlements) != 1 || settlements[0].From != "Bob" || settlements[0].To != "Alice" ||
+		settlements[0].Amount != 10 || settlements[0].ID == nil {
+		t.Errorf("settlements = %+v, want Bob paying Alice 10, recorded", settlements)
+	}
+	if _, out, _ := split("settle", "--group", "1", "--json"); strings.TrimSpace(out) != "[]" {
+		t.Errorf("settle after applying = %s, want nothing left", out)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	newServer(t)
+	split("group", "create", "Trip")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+		want string
+	}{
+		{"no command", nil, 2, "Usage: split"},
+		{"unknown command", []string{"transfer"}, 2, "Usage: split"},
+		{"missing subcommand", []string{"group"}, 2, "Usage: split"},
+		{"unknown flag", []string{"users", "--verbose"}, 2, "Usage: split"},
+		{"too many arguments", []string{"group", "create", "A", "B"}, 2, "Usage: split"},
+		{"no payer", []string{"pay", "--group", "1", "30"}, 2, "Usage: split"},
+		{"bad amount", []string{"pay", "--group", "1", "--payer", "Alice", "12x"}, 1, "split: invalid amount \"12x\"\n"},
+		{"no group", []string{"users"}, 1, "split: no group selected"},
+		{"unknown member", []string{"pay", "--group", "1", "--payer", "Zoe", "30"}, 1, "split: no active member named \"Zoe\"\n"},
+		{"unknown profile", []string{"users", "--profile", "work"}, 1, "split: no profile named \"work\"\n"},
+		// the server's problem+json is turned into a message
+		{"problem", []string{"group", "use", "9"}, 1, "split: Not Found: Not found\n"},
+		{"invalid body", []string{"user", "add", "--group", "1", ""}, 1, "split: Bad Request: Empty name field\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, out, stderr := split(test.args...)
+			if code != test.code || !strings.HasPrefix(stderr, test.want) || out != "" {
+				t.Errorf("split %s = %d %q %q, want %d and %q", strings.Join(test.args, " "), code, out, stderr, test.code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+// Batch applies ops in order in one transaction. Either all of them apply or none do,
+// and a failure is returned as *Error naming the operation that failed.
+func (c *Client) Batch(ctx context.Context, groupID int, ops []database.BatchOp) ([]handlers.BatchResult, error) {
+	body := map[string]any{
+		"operations": ops,
+	}
+
+	var res struct {
+		Results []handlers.BatchResult `json:"results"`
+	}
+	err := c.do(ctx, http.MethodPost, groupPath(groupID, "/batch"), nil, body, &res)
+	return res.Results, err
+}
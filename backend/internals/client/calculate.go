@@ -0,0 +1,17 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+// Calculate returns who owes whom. In each IOU, To owes From the amount.
+func (c *Client) Calculate(ctx context.Context, groupID int) ([]handlers.IOU, error) {
+	var res struct {
+		IOUs []handlers.IOU `json:"ious"`
+	}
+	err := c.do(ctx, http.MethodPost, groupPath(groupID, "/calculate"), nil, nil, &res)
+	return res.IOUs, err
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client talks to a split server. The zero value is not usable, use New.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	personID   *int
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithToken sends the token as a bearer token on every request
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithPersonID identifies the caller for endpoints that need one, like ListGroups
+func WithPersonID(personID int) Option {
+	return func(c *Client) {
+		c.personID = &personID
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey makes the call carry an Idempotency-Key, so retrying it with
+// the same key returns the first response instead of doing the work twice
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+type ifMatchKey struct{}
+
+// WithVersion makes the call send If-Match for version, so an update or delete fails
+// with ErrPreconditionFailed when someone else changed the resource first
+func WithVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, version)
+}
+
+type idResponse struct {
+	ID int `json:"id"`
+}
+
+// do sends body as JSON and decodes the response into out when both are set.
+// Non-2xx responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any, out any) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if key, ok := ctx.Value(idempotencyKey{}).(string); ok {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if version, ok := ctx.Value(ifMatchKey{}).(int); ok {
+		req.Header.Set("If-Match", `"`+strconv.Itoa(version)+`"`)
+	}
+	if c.personID != nil {
+		req.Header.Set("X-Person-ID", strconv.Itoa(*c.personID))
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newError(res)
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+func groupPath(groupID int, rest ...string) string {
+	return "/groups/" + strconv.Itoa(groupID) + strings.Join(rest, "")
+}
@@ -0,0 +1,303 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+func newTestClient(t *testing.T, mux *http.ServeMux, opts ...Option) *Client {
+	t.Helper()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return New(server.URL+"/", opts...)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestListGroups(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /groups", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Person-ID"); got != "7" {
+			t.Errorf("X-Person-ID = %q, want 7", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want Bearer secret", got)
+		}
+		if got := r.URL.Query().Get("q"); got != "trip" {
+			t.Errorf("q = %q, want trip", got)
+		}
+		if got := r.URL.Query().Get("limit"); got != "5" {
+			t.Errorf("limit = %q, want 5", got)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"groups": []handlers.GroupListing{{ID: 1, Name: "Trip", MemberCount: 3}},
+			"total":  1,
+			"limit":  5,
+			"offset": 0,
+		})
+	})
+	c := newTestClient(t, mux, WithPersonID(7), WithToken("secret"))
+
+	page, err := c.ListGroups(context.Background(), ListGroupsOptions{Query: "trip", Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Groups) != 1 || page.Groups[0].Name != "Trip" {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
+
+func TestCreateGroup(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /groups", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["name"] != "Trip" {
+			t.Errorf("name = %q, want Trip", body["name"])
+		}
+		writeJSON(w, http.StatusCreated, map[string]int{"id": 4})
+	})
+	c := newTestClient(t, mux)
+
+	id, err := c.CreateGroup(context.Background(), "Trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Errorf("id = %d, want 4", id)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /groups/3/users", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Idempotency-Key"); got != "add-alice" {
+			t.Errorf("Idempotency-Key = %q, want add-alice", got)
+		}
+		writeJSON(w, http.StatusCreated, map[string]int{"id": 1})
+	})
+	c := newTestClient(t, mux)
+
+	if _, err := c.AddUser(WithIdempotencyKey(context.Background(), "add-alice"), 3, "alice"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /groups/3/users/1", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("If-Match"); got != `"4"` {
+			t.Errorf("If-Match = %q, want \"4\"", got)
+		}
+		writeJSON(w, http.StatusPreconditionFailed, handlers.HttpError{Code: 412, Message: "Stale version, reload and try again"})
+	})
+	c := newTestClient(t, mux)
+
+	err := c.RenameUser(WithVersion(context.Background(), 4), 3, 1, "alice")
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("err = %v, want ErrPreconditionFailed", err)
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	want := []handlers.User{
+		{ID: 1, Name: "alice", Balance: -10, Active: true, Version: 1},
+		{ID: 2, Name: "bob", Balance: 10, Active: true, Version: 3},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /groups/3/users", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, want)
+	})
+	c := newTestClient(t, mux)
+
+	users, err := c.GetUsers(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("users = %+v, want %+v", users, want)
+	}
+}
+
+func TestAddPayment(t *testing.T) {
+	payment := database.InsertPayment{
+		Description: "Dinner",
+		Amount:      42.5,
+		PayerID:     1,
+		PayeeIDs:    []int{2, 3},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /groups/3/payments", func(w http.ResponseWriter, r *http.Request) {
+		var body database.InsertPayment
+		json.NewDecoder(r.Body).Decode(&body)
+		if !reflect.DeepEqual(body, payment) {
+			t.Errorf("body = %+v, want %+v", body, payment)
+		}
+		writeJSON(w, http.StatusCreated, map[string]int{"id": 9})
+	})
+	c := newTestClient(t, mux)
+
+	id, err := c.AddPayment(context.Background(), 3, payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 9 {
+		t.Errorf("id = %d, want 9", id)
+	}
+}
+
+func TestPatchPaymentNoContent(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /groups/3/payments/9", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := newTestClient(t, mux)
+
+	if err := c.PatchPayment(context.Background(), 3, 9, PatchPayment{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /groups/3/calculate", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"ious": []handlers.IOU{{FromID: 1, ToID: 2, Amount: 10}},
+		})
+	})
+	c := newTestClient(t, mux)
+
+	ious, err := c.Calculate(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ious) != 1 || ious[0] != (handlers.IOU{FromID: 1, ToID: 2, Amount: 10}) {
+		t.Errorf("ious = %+v", ious)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		code    int
+		body    string
+		target  error
+		message string
+	}{
+		{http.StatusBadRequest, `{"type":"/problems/bad-request","title":"Bad Request","status":400,"detail":"No payees in payment"}`, ErrBadRequest, "No payees in payment"},
+		{http.StatusNotFound, `{"type":"/problems/not-found","title":"Not Found","status":404,"detail":"Not found"}`, ErrNotFound, "Not found"},
+		{http.StatusConflict, `{"type":"/problems/conflict","title":"Conflict","status":409,"detail":"Payment involves inactive users"}`, ErrConflict, "Payment involves inactive users"},
+		{http.StatusUnprocessableEntity, `{"type":"/problems/unprocessable-entity","title":"Unprocessable Entity","status":422,"detail":"Idempotency-Key was already used for a different request"}`, ErrIdempotencyMismatch, "Idempotency-Key was already used for a different request"},
+		{http.StatusBadGateway, `<html>bad gateway</html>`, ErrServer, "Bad Gateway"},
+	}
+
+	for _, test := range tests {
+		mux := http.NewServeMux()
+		mux.HandleFunc("DELETE /groups/3/payments/9", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.code)
+			w.Write([]byte(test.body))
+		})
+		c := newTestClient(t, mux)
+
+		err := c.DeletePayment(context.Background(), 3, 9)
+		if !errors.Is(err, test.target) {
+			t.Errorf("%d: err = %v, want %v", test.code, err, test.target)
+		}
+		var apiErr *Error
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("%d: err is %T, want *Error", test.code, err)
+		}
+		if apiErr.StatusCode != test.code || apiErr.Message != test.message {
+			t.Errorf("%d: got %+v", test.code, apiErr)
+		}
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /groups/3", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, handlers.Group{ID: 3})
+	})
+	c := newTestClient(t, mux)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.GetGroup(ctx, 3); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestBatchFailure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /groups/3/batch", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Operations []map[string]any `json:"operations"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Operations) != 2 || body.Operations[1]["payer_id"] != "carol" {
+			t.Errorf("operations = %+v", body.Operations)
+		}
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"results": []handlers.BatchResult{
+				{Index: 0, Op: "create", Type: "user", Status: "rolled_back"},
+				{Index: 1, Op: "create", Type: "payment", Status: "failed", Error: &handlers.HttpError{Code: 404, Message: "Not found"}},
+			},
+		})
+	})
+	c := newTestClient(t, mux)
+
+	name := "carol"
+	amount := float32(60)
+	_, err := c.Batch(context.Background(), 3, []database.BatchOp{
+		{Op: database.BatchCreate, Type: database.BatchUser, Ref: "carol", Name: &name},
+		{Op: database.BatchCreate, Type: database.BatchPayment, Amount: &amount, PayerID: database.BatchID{Ref: "carol"}, PayeeIDs: []database.BatchID{{ID: 2}}},
+	})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "operation 1: Not found" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /groups/3/payments", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusUnprocessableEntity, handlers.HttpError{
+			Type:      "/problems/unprocessable-entity",
+			Title:     "Unprocessable Entity",
+			Code:      422,
+			Message:   "Users are not members of this group",
+			RequestID: "host/abc-000001",
+			Details:   []handlers.FieldError{{Field: "payee_ids[1]", Message: "User 9 is not a member of this group"}},
+		})
+	})
+	c := newTestClient(t, mux)
+
+	_, err := c.AddPayment(context.Background(), 3, database.InsertPayment{Amount: 10, PayerID: 1, PayeeIDs: []int{1, 9}})
+	if !errors.Is(err, ErrUnprocessable) || errors.Is(err, ErrIdempotencyMismatch) {
+		t.Errorf("err = %v, want only ErrUnprocessable", err)
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err is %T, want *Error", err)
+	}
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "payee_ids[1]" {
+		t.Errorf("details = %+v", apiErr.Details)
+	}
+	if apiErr.RequestID != "host/abc-000001" {
+		t.Errorf("request ID = %q", apiErr.RequestID)
+	}
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrServer       = errors.New("server error")
+
+	// the resource changed since the version passed WithVersion
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// the Idempotency-Key was already used for a different request
+	ErrIdempotencyMismatch = errors.New("idempotency key mismatch")
+
+	// the request is well formed but refers to something it cannot, like a user outside
+	// the group. Error.Details names the fields
+	ErrUnprocessable = errors.New("unprocessable")
+)
+
+// Error is a non-2xx response. It matches the sentinel errors above with
+// errors.Is, so callers can check the kind of failure without the status code.
+type Error struct {
+	StatusCode int
+	Message    string
+	Details    []handlers.FieldError
+
+	// quote it when reporting a problem with the server
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	return http.StatusText(e.StatusCode) + ": " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case ErrIdempotencyMismatch:
+		return e.StatusCode == http.StatusUnprocessableEntity && len(e.Details) == 0
+	case ErrUnprocessable:
+		return e.StatusCode == http.StatusUnprocessableEntity && len(e.Details) > 0
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+func newError(res *http.Response) error {
+	data, _ := io.ReadAll(res.Body)
+
+	var httpError handlers.HttpError
+	if err := json.Unmarshal(data, &httpError); err == nil && httpError.Message == "" {
+		httpError.Message = batchMessage(data)
+	}
+	if httpError.Message == "" {
+		return &Error{
+			StatusCode: res.StatusCode,
+			Message:    http.StatusText(res.StatusCode),
+		}
+	}
+	return &Error{
+		StatusCode: res.StatusCode,
+		Message:    httpError.Message,
+		Details:    httpError.Details,
+		RequestID:  httpError.RequestID,
+	}
+}
+
+// a failed batch responds with its results instead of an error, so report the failed operation
+func batchMessage(data []byte) string {
+	var batch struct {
+		Results []handlers.BatchResult `json:"results"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return ""
+	}
+	for _, result := range batch.Results {
+		if result.Error != nil {
+			return fmt.Sprintf("operation %d: %s", result.Index, result.Error.Message)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+type ListGroupsOptions struct {
+	Query  string
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+type GroupPage struct {
+	Groups []handlers.GroupListing `json:"groups"`
+	Total  int                     `json:"total"`
+	Limit  int                     `json:"limit"`
+	Offset int                     `json:"offset"`
+}
+
+// ListGroups needs the client to be created WithPersonID
+func (c *Client) ListGroups(ctx context.Context, opts ListGroupsOptions) (GroupPage, error) {
+	query := url.Values{}
+	if opts.Query != "" {
+		query.Set("q", opts.Query)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+
+	var page GroupPage
+	err := c.do(ctx, http.MethodGet, "/groups", query, nil, &page)
+	return page, err
+}
+
+func (c *Client) GetGroup(ctx context.Context, groupID int) (handlers.Group, error) {
+	var group handlers.Group
+	err := c.do(ctx, http.MethodGet, groupPath(groupID), nil, nil, &group)
+	return group, err
+}
+
+func (c *Client) GetGroupSummary(ctx context.Context, groupID int, recent int) (handlers.GroupSummary, error) {
+	query := url.Values{}
+	query.Set("recent", strconv.Itoa(recent))
+
+	var summary handlers.GroupSummary
+	err := c.do(ctx, http.MethodGet, groupPath(groupID, "/summary"), query, nil, &summary)
+	return summary, err
+}
+
+func (c *Client) CreateGroup(ctx context.Context, name string) (int, error) {
+	body := map[string]string{"name": name}
+
+	var res idResponse
+	err := c.do(ctx, http.MethodPost, "/groups", nil, body, &res)
+	return res.ID, err
+}
+
+func (c *Client) RenameGroup(ctx context.Context, groupID int, name string) error {
+	body := map[string]string{"name": name}
+	return c.do(ctx, http.MethodPatch, groupPath(groupID), nil, body, nil)
+}
+
+func (c *Client) DeleteGroup(ctx context.Context, groupID int) error {
+	return c.do(ctx, http.MethodDelete, groupPath(groupID), nil, nil, nil)
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+type PatchPayment struct {
+	Amount      *float32 `json:"amount,omitempty"`
+	Description *string  `json:"description,omitempty"`
+}
+
+func paymentPath(groupID int, paymentID int) string {
+	return groupPath(groupID, "/payments/"+strconv.Itoa(paymentID))
+}
+
+func (c *Client) GetPayments(ctx context.Context, groupID int) ([]handlers.Payment, error) {
+	var payments []handlers.Payment
+	err := c.do(ctx, http.MethodGet, groupPath(groupID, "/payments"), nil, nil, &payments)
+	return payments, err
+}
+
+func (c *Client) AddPayment(ctx context.Context, groupID int, payment database.InsertPayment) (int, error) {
+	var res idResponse
+	err := c.do(ctx, http.MethodPost, groupPath(groupID, "/payments"), nil, payment, &res)
+	return res.ID, err
+}
+
+func (c *Client) PatchPayment(ctx context.Context, groupID int, paymentID int, patch PatchPayment) error {
+	return c.do(ctx, http.MethodPatch, paymentPath(groupID, paymentID), nil, patch, nil)
+}
+
+func (c *Client) DeletePayment(ctx context.Context, groupID int, paymentID int) error {
+	return c.do(ctx, http.MethodDelete, paymentPath(groupID, paymentID), nil, nil, nil)
+}
+
+func (c *Client) DeleteAllPayments(ctx context.Context, groupID int) error {
+	return c.do(ctx, http.MethodDelete, groupPath(groupID, "/payments"), nil, nil, nil)
+}
+
+// RecordSettlement books fromID paying toID back and returns the payment ID
+func (c *Client) RecordSettlement(ctx context.Context, groupID int, fromID int, toID int, amount float32) (int, error) {
+	body := map[string]any{
+		"from_id": fromID,
+		"to_id":   toID,
+		"amount":  amount,
+	}
+
+	var res idResponse
+	err := c.do(ctx, http.MethodPost, groupPath(groupID, "/settlements"), nil, body, &res)
+	return res.ID, err
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+func userPath(groupID int, userID int, rest ...string) string {
+	return groupPath(groupID, append([]string{"/users/" + strconv.Itoa(userID)}, rest...)...)
+}
+
+func (c *Client) GetUsers(ctx context.Context, groupID int) ([]handlers.User, error) {
+	var users []handlers.User
+	err := c.do(ctx, http.MethodGet, groupPath(groupID, "/users"), nil, nil, &users)
+	return users, err
+}
+
+func (c *Client) AddUser(ctx context.Context, groupID int, name string) (int, error) {
+	body := map[string]string{"name": name}
+
+	var res idResponse
+	err := c.do(ctx, http.MethodPost, groupPath(groupID, "/users"), nil, body, &res)
+	return res.ID, err
+}
+
+func (c *Client) RenameUser(ctx context.Context, groupID int, userID int, name string) error {
+	body := map[string]string{"name": name}
+	return c.do(ctx, http.MethodPatch, userPath(groupID, userID), nil, body, nil)
+}
+
+func (c *Client) DeleteUser(ctx context.Context, groupID int, userID int) error {
+	return c.do(ctx, http.MethodDelete, userPath(groupID, userID), nil, nil, nil)
+}
+
+// DeactivateUser moves the user's balance to transferTo first when it is set
+func (c *Client) DeactivateUser(ctx context.Context, groupID int, userID int, transferTo *int) error {
+	body := map[string]*int{"transfer_to": transferTo}
+	return c.do(ctx, http.MethodPost, userPath(groupID, userID, "/deactivate"), nil, body, nil)
+}
+
+func (c *Client) MergeUser(ctx context.Context, groupID int, sourceID int, targetID int) error {
+	body := map[string]int{"target_id": targetID}
+	return c.do(ctx, http.MethodPost, userPath(groupID, sourceID, "/merge"), nil, body, nil)
+}
@@ -0,0 +1,535 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/michaelzhan1/split/internals/logs"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the server reads at startup. Values come from the defaults,
+// then an optional YAML or TOML file, then environment variables, then flags,
+// each overriding the ones before it.
+type Config struct {
+	ListenAddr  string      `yaml:"listen_addr" toml:"listen_addr"`
+	Server      Server      `yaml:"server" toml:"server"`
+	Database    Database    `yaml:"database" toml:"database"`
+	Timeouts    Timeouts    `yaml:"timeouts" toml:"timeouts"`
+	CORS        CORS        `yaml:"cors" toml:"cors"`
+	Log         Log         `yaml:"log" toml:"log"`
+	Features    Features    `yaml:"features" toml:"features"`
+	Idempotency Idempotency `yaml:"idempotency" toml:"idempotency"`
+	RateLimit   RateLimit   `yaml:"rate_limit" toml:"rate_limit"`
+	Tracing     Tracing     `yaml:"tracing" toml:"tracing"`
+}
+
+// Server holds the http.Server timeouts
+type Server struct {
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// how long in-flight requests get to finish after SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type Database struct {
+	// a postgres:// URL, memory:// or sqlite://<path>
+	URL            string        `yaml:"url" toml:"url"`
+	MaxConns       int           `yaml:"max_conns" toml:"max_conns"`
+	MinConns       int           `yaml:"min_conns" toml:"min_conns"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	// apply pending migrations before serving
+	Migrate bool `yaml:"migrate" toml:"migrate"`
+}
+
+type Timeouts struct {
+	// how long a handler's database work may take
+	Request time.Duration `yaml:"request" toml:"request"`
+	// the same for a batch, which can hold many operations
+	Batch time.Duration `yaml:"batch" toml:"batch"`
+}
+
+type CORS struct {
+	// origins allowed to call the API from a browser, none disables CORS
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+type Log struct {
+	Level string `yaml:"level" toml:"level"`
+	// levels for single packages, like database: debug to see every query
+	Levels map[string]string `yaml:"levels" toml:"levels"`
+	Format string            `yaml:"format" toml:"format"`
+	// mask names, descriptions and other free text in log lines
+	Redact bool `yaml:"redact" toml:"redact"`
+}
+
+// Features turn the background workers and optional middleware on and off
+type Features struct {
+	Recurring   bool `yaml:"recurring" toml:"recurring"`
+	Webhooks    bool `yaml:"webhooks" toml:"webhooks"`
+	Idempotency bool `yaml:"idempotency" toml:"idempotency"`
+	// serve Prometheus metrics on /metrics
+	Metrics bool `yaml:"metrics" toml:"metrics"`
+}
+
+// Idempotency is how long responses to requests with an Idempotency-Key are kept
+type Idempotency struct {
+	// retries within this are replayed, and older keys are deleted
+	Retention time.Duration `yaml:"retention" toml:"retention"`
+}
+
+// RateLimit sizes the token buckets, each holding its number of requests and refilling
+// over Window. Zero turns a limit off
+type RateLimit struct {
+	// none, memory, or postgres to share buckets between servers on one database
+	Store string `yaml:"store" toml:"store"`
+	// per client
+	Read  int `yaml:"read" toml:"read"`
+	Write int `yaml:"write" toml:"write"`
+	// per group, across every client using it
+	GroupRead  int           `yaml:"group_read" toml:"group_read"`
+	GroupWrite int           `yaml:"group_write" toml:"group_write"`
+	Window     time.Duration `yaml:"window" toml:"window"`
+	// ip, or token to tell clients apart by bearer token when a proxy in front checks them
+	ClientKey string `yaml:"client_key" toml:"client_key"`
+	// take client IPs from X-Forwarded-For, only safe behind a proxy that sets it
+	TrustProxy bool `yaml:"trust_proxy" toml:"trust_proxy"`
+}
+
+// Tracing picks where OpenTelemetry spans go
+type Tracing struct {
+	// none, stdout or otlp
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// OTLP/HTTP collector URL like http://localhost:4318, or empty for the OTEL_EXPORTER_OTLP_* variables
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	// share of new traces to keep, from 0 to 1
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+}
+
+func Default() Config {
+	return Config{
+		ListenAddr: ":3000",
+		Server: Server{
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    45 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 20 * time.Second,
+		},
+		Database: Database{
+			MaxConns:       10,
+			ConnectTimeout: 5 * time.Second,
+		},
+		Timeouts: Timeouts{
+			Request: 5 * time.Second,
+			Batch:   30 * time.Second,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+			Redact: true,
+		},
+		Features: Features{
+			Recurring:   true,
+			Webhooks:    true,
+			Idempotency: true,
+			Metrics:     true,
+		},
+		Idempotency: Idempotency{
+			Retention: 24 * time.Hour,
+		},
+		RateLimit: RateLimit{
+			Store:      "memory",
+			Read:       300,
+			Write:      60,
+			GroupRead:  600,
+			GroupWrite: 120,
+			Window:     time.Minute,
+			ClientKey:  "ip",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "split",
+		},
+	}
+}
+
+// setting is one value that can be set from the environment or a flag. key is
+// where it lives in a config file, and the first of env that is set wins
+type setting struct {
+	key   string
+	env   []string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+	bool  bool
+}
+
+var settings = []setting{
+	{key: "listen_addr", env: []string{"LISTEN_ADDR"}, flag: "addr", usage: "address to serve on", set: stringValue(func(c *Config) *string { return &c.ListenAddr })},
+	{key: "server.read_timeout", env: []string{"SERVER_READ_TIMEOUT"}, flag: "read-timeout", usage: "how long reading a request may take", set: durationValue(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+	{key: "server.write_timeout", env: []string{"SERVER_WRITE_TIMEOUT"}, flag: "write-timeout", usage: "how long writing a response may take", set: durationValue(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+	{key: "server.idle_timeout", env: []string{"SERVER_IDLE_TIMEOUT"}, flag: "idle-timeout", usage: "how long an idle keep-alive connection stays open", set: durationValue(func(c *Config) *time.Duration { return &c.Server.IdleTimeout })},
+	{key: "server.shutdown_timeout", env: []string{"SERVER_SHUTDOWN_TIMEOUT"}, flag: "shutdown-timeout", usage: "how long in-flight requests get to finish on shutdown", set: durationValue(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{key: "database.url", env: []string{"DATABASE_URL"}, flag: "database-url", usage: "postgres:// URL, memory:// or sqlite://<path>", set: stringValue(func(c *Config) *string { return &c.Database.URL })},
+	{key: "database.max_conns", env: []string{"DATABASE_MAX_CONNS"}, flag: "db-max-conns", usage: "most Postgres connections to keep open", set: intValue(func(c *Config) *int { return &c.Database.MaxConns })},
+	{key: "database.min_conns", env: []string{"DATABASE_MIN_CONNS"}, flag: "db-min-conns", usage: "Postgres connections to keep open when idle", set: intValue(func(c *Config) *int { return &c.Database.MinConns })},
+	{key: "database.connect_timeout", env: []string{"DATABASE_CONNECT_TIMEOUT"}, flag: "db-connect-timeout", usage: "how long to wait for the database at startup", set: durationValue(func(c *Config) *time.Duration { return &c.Database.ConnectTimeout })},
+	{key: "database.migrate", env: []string{"DATABASE_MIGRATE"}, flag: "migrate", usage: "apply pending database migrations before serving", set: boolValue(func(c *Config) *bool { return &c.Database.Migrate }), bool: true},
+	{key: "timeouts.request", env: []string{"REQUEST_TIMEOUT"}, flag: "request-timeout", usage: "how long a request's database work may take", set: durationValue(func(c *Config) *time.Duration { return &c.Timeouts.Request })},
+	{key: "timeouts.batch", env: []string{"BATCH_TIMEOUT"}, flag: "batch-timeout", usage: "how long a batch's database work may take", set: durationValue(func(c *Config) *time.Duration { return &c.Timeouts.Batch })},
+	{key: "cors.allowed_origins", env: []string{"CORS_ALLOWED_ORIGINS", "FRONTEND_URL"}, flag: "cors-origins", usage: "comma separated origins allowed to call the API from a browser", set: listValue(func(c *Config) *[]string { return &c.CORS.AllowedOrigins })},
+	{key: "log.level", env: []string{"LOG_LEVEL"}, flag: "log-level", usage: "debug, info, warn or error", set: stringValue(func(c *Config) *string { return &c.Log.Level })},
+	{key: "log.levels", env: []string{"LOG_LEVELS"}, flag: "log-levels", usage: "comma separated package=level pairs, like database=debug", set: mapValue(func(c *Config) *map[string]string { return &c.Log.Levels })},
+	{key: "log.format", env: []string{"LOG_FORMAT"}, flag: "log-format", usage: "text or json", set: stringValue(func(c *Config) *string { return &c.Log.Format })},
+	{key: "log.redact", env: []string{"LOG_REDACT"}, flag: "log-redact", usage: "mask names, descriptions and other free text in log lines", set: boolValue(func(c *Config) *bool { return &c.Log.Redact }), bool: true},
+	{key: "features.recurring", env: []string{"FEATURE_RECURRING"}, flag: "recurring", usage: "materialize recurring payments in the background", set: boolValue(func(c *Config) *bool { return &c.Features.Recurring }), bool: true},
+	{key: "features.webhooks", env: []string{"FEATURE_WEBHOOKS"}, flag: "webhooks", usage: "deliver webhooks in the background", set: boolValue(func(c *Config) *bool { return &c.Features.Webhooks }), bool: true},
+	{key: "features.idempotency", env: []string{"FEATURE_IDEMPOTENCY"}, flag: "idempotency", usage: "honor Idempotency-Key headers", set: boolValue(func(c *Config) *bool { return &c.Features.Idempotency }), bool: true},
+	{key: "features.metrics", env: []string{"FEATURE_METRICS"}, flag: "metrics", usage: "serve Prometheus metrics on /metrics", set: boolValue(func(c *Config) *bool { return &c.Features.Metrics }), bool: true},
+	{key: "idempotency.retention", env: []string{"IDEMPOTENCY_RETENTION"}, flag: "idempotency-retention", usage: "how long a response to an Idempotency-Key is replayed", set: durationValue(func(c *Config) *time.Duration { return &c.Idempotency.Retention })},
+	{key: "rate_limit.store", env: []string{"RATE_LIMIT_STORE"}, flag: "rate-limit-store", usage: "where rate limit buckets live: none, memory or postgres", set: stringValue(func(c *Config) *string { return &c.RateLimit.Store })},
+	{key: "rate_limit.read", env: []string{"RATE_LIMIT_READ"}, flag: "rate-limit-read", usage: "reads a client can make per window, 0 for no limit", set: intValue(func(c *Config) *int { return &c.RateLimit.Read })},
+	{key: "rate_limit.write", env: []string{"RATE_LIMIT_WRITE"}, flag: "rate-limit-write", usage: "writes a client can make per window, 0 for no limit", set: intValue(func(c *Config) *int { return &c.RateLimit.Write })},
+	{key: "rate_limit.group_read", env: []string{"RATE_LIMIT_GROUP_READ"}, flag: "rate-limit-group-read", usage: "reads of one group per window, 0 for no limit", set: intValue(func(c *Config) *int { return &c.RateLimit.GroupRead })},
+	{key: "rate_limit.group_write", env: []string{"RATE_LIMIT_GROUP_WRITE"}, flag: "rate-limit-group-write", usage: "writes to one group per window, 0 for no limit", set: intValue(func(c *Config) *int { return &c.RateLimit.GroupWrite })},
+	{key: "rate_limit.window", env: []string{"RATE_LIMIT_WINDOW"}, flag: "rate-limit-window", usage: "how long an empty bucket takes to refill", set: durationValue(func(c *Config) *time.Duration { return &c.RateLimit.Window })},
+	{key: "rate_limit.client_key", env: []string{"RATE_LIMIT_CLIENT_KEY"}, flag: "rate-limit-client-key", usage: "what tells clients apart: ip or token", set: stringValue(func(c *Config) *string { return &c.RateLimit.ClientKey })},
+	{key: "rate_limit.trust_proxy", env: []string{"RATE_LIMIT_TRUST_PROXY"}, flag: "rate-limit-trust-proxy", usage: "take client IPs from X-Forwarded-For", set: boolValue(func(c *Config) *bool { return &c.RateLimit.TrustProxy }), bool: true},
+	{key: "tracing.exporter", env: []string{"TRACING_EXPORTER"}, flag: "tracing-exporter", usage: "where spans go: none, stdout or otlp", set: stringValue(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{key: "tracing.endpoint", env: []string{"TRACING_ENDPOINT"}, flag: "tracing-endpoint", usage: "OTLP/HTTP collector URL, like http://localhost:4318", set: stringValue(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{key: "tracing.sample_ratio", env: []string{"TRACING_SAMPLE_RATIO"}, flag: "tracing-sample-ratio", usage: "share of new traces to keep, from 0 to 1", set: floatValue(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+	{key: "tracing.service_name", env: []string{"OTEL_SERVICE_NAME"}, flag: "tracing-service-name", usage: "service name spans are reported under", set: stringValue(func(c *Config) *string { return &c.Tracing.ServiceName })},
+}
+
+// Load builds the config from args (without the program name) and the environment,
+// and returns the arguments left after the flags. The file comes from -config or CONFIG_FILE
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	c := Default()
+
+	fs := flag.NewFlagSet("split", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML or TOML config file")
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		record := func(value string) error {
+			flagValues = append(flagValues, flagValue{s, value})
+			return nil
+		}
+		if s.bool {
+			fs.BoolFunc(s.flag, s.usage, record)
+		} else {
+			fs.Func(s.flag, s.usage, record)
+		}
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		err := c.loadFile(path)
+		if err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		for _, name := range s.env {
+			value, ok := lookupEnv(name)
+			if !ok || value == "" {
+				continue
+			}
+			err := s.set(&c, value)
+			if err != nil {
+				return Config{}, nil, fmt.Errorf("%s (%s): %w", name, s.key, err)
+			}
+			break
+		}
+	}
+
+	for _, f := range flagValues {
+		err := f.setting.set(&c, f.value)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("-%s (%s): %w", f.setting.flag, f.setting.key, err)
+		}
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return Config{}, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// loadFile overlays a config file, picking the format from its extension. Unknown keys are errors
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err := decoder.Decode(c)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("%s: expected a .yaml, .yml or .toml file", path)
+	}
+	return nil
+}
+
+// Validate reports every invalid value at once, naming each by its key in a config file
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	_, port, err := net.SplitHostPort(c.ListenAddr)
+	if err != nil {
+		invalid("listen_addr", "must look like :3000 or 127.0.0.1:3000, got %q", c.ListenAddr)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		invalid("listen_addr", "has a bad port %q", port)
+	}
+
+	if c.Server.ReadTimeout <= 0 {
+		invalid("server.read_timeout", "must be positive, got %s", c.Server.ReadTimeout)
+	}
+	// a response still being written when the handler's timeouts run out would be cut off
+	if c.Server.WriteTimeout <= c.Timeouts.Request || c.Server.WriteTimeout <= c.Timeouts.Batch {
+		invalid("server.write_timeout", "must be longer than timeouts.request and timeouts.batch, got %s", c.Server.WriteTimeout)
+	}
+	if c.Server.IdleTimeout <= 0 {
+		invalid("server.idle_timeout", "must be positive, got %s", c.Server.IdleTimeout)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout", "must be positive, got %s", c.Server.ShutdownTimeout)
+	}
+
+	if c.Database.URL == "" {
+		invalid("database.url", "is required, set DATABASE_URL")
+	}
+	if c.Database.MaxConns < 1 {
+		invalid("database.max_conns", "must be at least 1, got %d", c.Database.MaxConns)
+	}
+	if c.Database.MinConns < 0 || c.Database.MinConns > c.Database.MaxConns {
+		invalid("database.min_conns", "must be between 0 and database.max_conns, got %d", c.Database.MinConns)
+	}
+	if c.Database.ConnectTimeout <= 0 {
+		invalid("database.connect_timeout", "must be positive, got %s", c.Database.ConnectTimeout)
+	}
+
+	if c.Timeouts.Request <= 0 {
+		invalid("timeouts.request", "must be positive, got %s", c.Timeouts.Request)
+	}
+	if c.Timeouts.Batch <= 0 {
+		invalid("timeouts.batch", "must be positive, got %s", c.Timeouts.Batch)
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			invalid("cors.allowed_origins", "has %q, expected * or an origin like https://example.com", origin)
+		}
+	}
+
+	_, err = logs.ParseLevel(c.Log.Level)
+	if err != nil {
+		invalid("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	for _, pkg := range slices.Sorted(maps.Keys(c.Log.Levels)) {
+		if !slices.Contains(logs.Packages, pkg) {
+			invalid("log.levels", "has unknown package %q, expected one of %s", pkg, strings.Join(logs.Packages, ", "))
+		} else if _, err := logs.ParseLevel(c.Log.Levels[pkg]); err != nil {
+			invalid("log.levels", "must be debug, info, warn or error for %s, got %q", pkg, c.Log.Levels[pkg])
+		}
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		invalid("log.format", "must be text or json, got %q", c.Log.Format)
+	}
+
+	if c.Idempotency.Retention <= 0 {
+		invalid("idempotency.retention", "must be positive, got %s", c.Idempotency.Retention)
+	}
+
+	switch c.RateLimit.Store {
+	case "none", "memory":
+	case "postgres":
+		if c.Database.URL == "memory://" || strings.HasPrefix(c.Database.URL, "sqlite://") {
+			invalid("rate_limit.store", "can only be postgres with a Postgres database.url")
+		}
+	default:
+		invalid("rate_limit.store", "must be none, memory or postgres, got %q", c.RateLimit.Store)
+	}
+	if c.RateLimit.Read < 0 {
+		invalid("rate_limit.read", "must not be negative, got %d", c.RateLimit.Read)
+	}
+	if c.RateLimit.Write < 0 {
+		invalid("rate_limit.write", "must not be negative, got %d", c.RateLimit.Write)
+	}
+	if c.RateLimit.GroupRead < 0 {
+		invalid("rate_limit.group_read", "must not be negative, got %d", c.RateLimit.GroupRead)
+	}
+	if c.RateLimit.GroupWrite < 0 {
+		invalid("rate_limit.group_write", "must not be negative, got %d", c.RateLimit.GroupWrite)
+	}
+	if c.RateLimit.Window <= 0 {
+		invalid("rate_limit.window", "must be positive, got %s", c.RateLimit.Window)
+	}
+	if c.RateLimit.ClientKey != "ip" && c.RateLimit.ClientKey != "token" {
+		invalid("rate_limit.client_key", "must be ip or token, got %q", c.RateLimit.ClientKey)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		invalid("tracing.exporter", "must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.Endpoint != "" {
+		u, err := url.Parse(c.Tracing.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("tracing.endpoint", "must be a URL like http://localhost:4318, got %q", c.Tracing.Endpoint)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample_ratio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.ServiceName == "" {
+		invalid("tracing.service_name", "must not be empty")
+	}
+
+	return errors.Join(errs...)
+}
+
+// LogLevels parses the configured levels, which Validate has already checked
+func (l Log) LogLevels() logs.Levels {
+	levels := logs.Levels{Packages: map[string]slog.Level{}}
+	levels.Default, _ = logs.ParseLevel(l.Level)
+	for pkg, level := range l.Levels {
+		levels.Packages[pkg], _ = logs.ParseLevel(level)
+	}
+	return levels
+}
+
+func stringValue(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func intValue(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// maps are comma separated key=value pairs
+func mapValue(field func(*Config) *map[string]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		m := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value pairs, got %q", pair)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		*field(c) = m
+		return nil
+	}
+}
+
+func durationValue(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration like 5s, got %q", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func floatValue(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func boolValue(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// lists are comma separated, ignoring blanks
+func listValue(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
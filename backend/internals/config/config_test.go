@@ -0,0 +1,310 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOverrideOrder(t *testing.T) {
+	yamlFile := writeFile(t, "split.yaml", `
+listen_addr: ":4000"
+database:
+  url: memory://
+timeouts:
+  request: 2s
+rate_limit:
+  read: 10
+`)
+	tomlFile := writeFile(t, "split.toml", `
+listen_addr = ":4000"
+[database]
+url = "memory://"
+[timeouts]
+request = "2s"
+[rate_limit]
+read = 10
+`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		addr    string
+		request time.Duration
+		read    int
+	}{
+		{
+			name:    "defaults",
+			env:     map[string]string{"DATABASE_URL": "memory://"},
+			addr:    ":3000",
+			request: 5 * time.Second,
+			read:    300,
+		},
+		{
+			name:    "yaml file over defaults",
+			args:    []string{"-config", yamlFile},
+			addr:    ":4000",
+			request: 2 * time.Second,
+			read:    10,
+		},
+		{
+			name:    "toml file from CONFIG_FILE",
+			env:     map[string]string{"CONFIG_FILE": tomlFile},
+			addr:    ":4000",
+			request: 2 * time.Second,
+			read:    10,
+		},
+		{
+			name:    "env over file",
+			args:    []string{"-config", yamlFile},
+			env:     map[string]string{"LISTEN_ADDR": ":5000", "REQUEST_TIMEOUT": "3s"},
+			addr:    ":5000",
+			request: 3 * time.Second,
+			read:    10,
+		},
+		{
+			name:    "empty env is unset",
+			args:    []string{"-config", yamlFile},
+			env:     map[string]string{"LISTEN_ADDR": ""},
+			addr:    ":4000",
+			request: 2 * time.Second,
+			read:    10,
+		},
+		{
+			name:    "flags over env",
+			args:    []string{"-config", yamlFile, "-addr", ":6000", "-rate-limit-read", "20"},
+			env:     map[string]string{"LISTEN_ADDR": ":5000", "REQUEST_TIMEOUT": "3s", "RATE_LIMIT_READ": "15"},
+			addr:    ":6000",
+			request: 3 * time.Second,
+			read:    20,
+		},
+		{
+			name:    "last of a repeated flag",
+			args:    []string{"-database-url", "memory://", "-addr", ":6000", "-addr", ":7000"},
+			addr:    ":7000",
+			request: 5 * time.Second,
+			read:    300,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _, err := Load(test.args, env(test.env))
+			if err != nil {
+				t.Fatalf("Load = %v", err)
+			}
+			if c.ListenAddr != test.addr || c.Timeouts.Request != test.request || c.RateLimit.Read != test.read {
+				t.Errorf("got listen_addr %s, timeouts.request %s, rate_limit.read %d, want %s, %s, %d",
+					c.ListenAddr, c.Timeouts.Request, c.RateLimit.Read, test.addr, test.request, test.read)
+			}
+		})
+	}
+}
+
+func TestLoadValues(t *testing.T) {
+	c, rest, err := Load([]string{"-cors-origins", "https://a.example, ,https://b.example", "-log-levels", "database=debug, http = warn", "-migrate", "migrate", "extra"}, env(map[string]string{
+		"DATABASE_URL":          "memory://",
+		"FRONTEND_URL":          "https://ignored.example",
+		"LOG_REDACT":            "false",
+		"FEATURE_RECURRING":     "0",
+		"IDEMPOTENCY_RETENTION": "2h",
+	}))
+	if err != nil {
+		t.Fatalf("Load = %v", err)
+	}
+	if !slices.Equal(c.CORS.AllowedOrigins, []string{"https://a.example", "https://b.example"}) {
+		t.Errorf("cors.allowed_origins = %q, want the two origins from the flag", c.CORS.AllowedOrigins)
+	}
+	if len(c.Log.Levels) != 2 || c.Log.Levels["database"] != "debug" || c.Log.Levels["http"] != "warn" {
+		t.Errorf("log.levels = %v, want database=debug and http=warn", c.Log.Levels)
+	}
+	if !c.Database.Migrate || c.Log.Redact || c.Features.Recurring {
+		t.Errorf("database.migrate %v, log.redact %v, features.recurring %v, want true, false, false", c.Database.Migrate, c.Log.Redact, c.Features.Recurring)
+	}
+	if c.Idempotency.Retention != 2*time.Hour {
+		t.Errorf("idempotency.retention = %s, want 2h from the environment", c.Idempotency.Retention)
+	}
+	if !slices.Equal(rest, []string{"migrate", "extra"}) {
+		t.Errorf("arguments left = %q, want migrate extra", rest)
+	}
+
+	// the first of a setting's variables that is set wins
+	c, _, err = Load(nil, env(map[string]string{"DATABASE_URL": "memory://", "FRONTEND_URL": "https://app.example"}))
+	if err != nil || !slices.Equal(c.CORS.AllowedOrigins, []string{"https://app.example"}) {
+		t.Errorf("cors.allowed_origins from FRONTEND_URL = %q, %v", c.CORS.AllowedOrigins, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file [2]string
+		want string
+	}{
+		{
+			name: "bad duration in env",
+			env:  map[string]string{"REQUEST_TIMEOUT": "5"},
+			want: `REQUEST_TIMEOUT (timeouts.request): expected a duration like 5s, got "5"`,
+		},
+		{
+			name: "bad duration flag",
+			args: []string{"-rate-limit-window", "a minute"},
+			want: `-rate-limit-window (rate_limit.window): expected a duration like 5s, got "a minute"`,
+		},
+		{
+			name: "bad duration in file",
+			file: [2]string{"split.yaml", "server:\n  read_timeout: soon\n"},
+			want: "split.yaml",
+		},
+		{
+			name: "bad number",
+			env:  map[string]string{"DATABASE_MAX_CONNS": "ten"},
+			want: `DATABASE_MAX_CONNS (database.max_conns): expected a whole number, got "ten"`,
+		},
+		{
+			name: "bad bool",
+			env:  map[string]string{"FEATURE_METRICS": "sometimes"},
+			want: `FEATURE_METRICS (features.metrics): expected true or false, got "sometimes"`,
+		},
+		{
+			name: "bad map",
+			args: []string{"-log-levels", "database"},
+			want: `-log-levels (log.levels): expected key=value pairs, got "database"`,
+		},
+		{
+			name: "port out of range",
+			args: []string{"-addr", ":70000"},
+			want: `listen_addr has a bad port "70000"`,
+		},
+		{
+			name: "port that is not a number",
+			env:  map[string]string{"LISTEN_ADDR": ":http"},
+			want: `listen_addr has a bad port "http"`,
+		},
+		{
+			name: "unknown yaml key",
+			file: [2]string{"split.yaml", "database:\n  url: memory://\n  pool: 5\n"},
+			want: "field pool not found",
+		},
+		{
+			name: "unknown toml key",
+			file: [2]string{"split.toml", "[database]\nurl = \"memory://\"\npool = 5\n"},
+			want: "unknown key database.pool",
+		},
+		{
+			name: "unknown file format",
+			file: [2]string{"split.json", "{}"},
+			want: "expected a .yaml, .yml or .toml file",
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-colour"},
+			want: "flag provided but not defined: -colour",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vars := map[string]string{"DATABASE_URL": "memory://"}
+			for name, value := range test.env {
+				vars[name] = value
+			}
+			args := test.args
+			if test.file[0] != "" {
+				args = append([]string{"-config", writeFile(t, test.file[0], test.file[1])}, args...)
+			}
+
+			_, _, err := Load(args, env(vars))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Load = %v, want an error with %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		change func(c *Config)
+		want   string
+	}{
+		{func(c *Config) { c.ListenAddr = "3000" }, "listen_addr must look like :3000 or 127.0.0.1:3000"},
+		{func(c *Config) { c.ListenAddr = ":99999" }, "listen_addr has a bad port"},
+		{func(c *Config) { c.Server.ReadTimeout = 0 }, "server.read_timeout must be positive"},
+		{func(c *Config) { c.Server.WriteTimeout = c.Timeouts.Batch }, "server.write_timeout must be longer than timeouts.request and timeouts.batch"},
+		{func(c *Config) { c.Server.IdleTimeout = 0 }, "server.idle_timeout must be positive"},
+		{func(c *Config) { c.Server.ShutdownTimeout = -time.Second }, "server.shutdown_timeout must be positive"},
+		{func(c *Config) { c.Database.URL = "" }, "database.url is required"},
+		{func(c *Config) { c.Database.MaxConns = 0 }, "database.max_conns must be at least 1"},
+		{func(c *Config) { c.Database.MinConns = c.Database.MaxConns + 1 }, "database.min_conns must be between 0 and database.max_conns"},
+		{func(c *Config) { c.Database.ConnectTimeout = 0 }, "database.connect_timeout must be positive"},
+		{func(c *Config) { c.Timeouts.Request = 0 }, "timeouts.request must be positive"},
+		{func(c *Config) { c.Timeouts.Batch = 0 }, "timeouts.batch must be positive"},
+		{func(c *Config) { c.CORS.AllowedOrigins = []string{"*", "example.com"} }, `cors.allowed_origins has "example.com"`},
+		{func(c *Config) { c.CORS.AllowedOrigins = []string{"https://example.com/app"} }, `cors.allowed_origins has "https://example.com/app"`},
+		{func(c *Config) { c.Log.Level = "loud" }, "log.level must be debug, info, warn or error"},
+		{func(c *Config) { c.Log.Levels = map[string]string{"cache": "debug"} }, `log.levels has unknown package "cache"`},
+		{func(c *Config) { c.Log.Levels = map[string]string{"database": "loud"} }, "log.levels must be debug, info, warn or error for database"},
+		{func(c *Config) { c.Log.Format = "xml" }, "log.format must be text or json"},
+		{func(c *Config) { c.Idempotency.Retention = 0 }, "idempotency.retention must be positive"},
+		{func(c *Config) { c.RateLimit.Store = "redis" }, "rate_limit.store must be none, memory or postgres"},
+		{func(c *Config) { c.RateLimit.Store = "postgres" }, "rate_limit.store can only be postgres with a Postgres database.url"},
+		{func(c *Config) { c.RateLimit.Read = -1 }, "rate_limit.read must not be negative"},
+		{func(c *Config) { c.RateLimit.Write = -1 }, "rate_limit.write must not be negative"},
+		{func(c *Config) { c.RateLimit.GroupRead = -1 }, "rate_limit.group_read must not be negative"},
+		{func(c *Config) { c.RateLimit.GroupWrite = -1 }, "rate_limit.group_write must not be negative"},
+		{func(c *Config) { c.RateLimit.Window = 0 }, "rate_limit.window must be positive"},
+		{func(c *Config) { c.RateLimit.ClientKey = "cookie" }, "rate_limit.client_key must be ip or token"},
+		{func(c *Config) { c.Tracing.Exporter = "jaeger" }, "tracing.exporter must be none, stdout or otlp"},
+		{func(c *Config) { c.Tracing.Endpoint = "localhost:4318" }, "tracing.endpoint must be a URL"},
+		{func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sample_ratio must be between 0 and 1"},
+		{func(c *Config) { c.Tracing.ServiceName = "" }, "tracing.service_name must not be empty"},
+	}
+
+	valid := Default()
+	valid.Database.URL = "memory://"
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("the defaults with a database are invalid: %v", err)
+	}
+
+	for _, test := range tests {
+		c := Default()
+		c.Database.URL = "memory://"
+		test.change(&c)
+		err := c.Validate()
+		if err == nil {
+			t.Errorf("no error, want %q", test.want)
+			continue
+		}
+		// one bad value is reported once, and nothing else is
+		if lines := strings.Split(err.Error(), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], test.want) {
+			t.Errorf("Validate = %q, want only %q", err, test.want)
+		}
+	}
+
+	c := Default()
+	c.Log.Format = "xml"
+	c.Tracing.ServiceName = ""
+	if err := c.Validate(); err == nil || len(strings.Split(err.Error(), "\n")) != 3 {
+		t.Errorf("Validate with three bad values = %v, want all three", err)
+	}
+}
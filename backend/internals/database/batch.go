@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+
+	BatchUser    = "user"
+	BatchPayment = "payment"
+)
+
+// returned when an operation refers to a ref no earlier create in the batch defined
+var ErrUnknownRef = errors.New("unknown reference")
+
+// BatchID is either a plain ID or, in JSON a string, the ref of something created earlier in the batch
+type BatchID struct {
+	ID  int
+	Ref string
+}
+
+func (id *BatchID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &id.Ref)
+	}
+	return json.Unmarshal(data, &id.ID)
+}
+
+func (id BatchID) MarshalJSON() ([]byte, error) {
+	if id.Ref != "" {
+		return json.Marshal(id.Ref)
+	}
+	return json.Marshal(id.ID)
+}
+
+func (id BatchID) IsZero() bool {
+	return id.ID == 0 && id.Ref == ""
+}
+
+func (id BatchID) resolve(refs map[string]int) (int, error) {
+	if id.Ref == "" {
+		return id.ID, nil
+	}
+	resolved, ok := refs[id.Ref]
+	if !ok {
+		return 0, ErrUnknownRef
+	}
+	return resolved, nil
+}
+
+// one step of a batch. Which fields apply depends on Op and Type, the same as the single routes
+type BatchOp struct {
+	Op          string    `json:"op"`
+	Type        string    `json:"type"`
+	Ref         string    `json:"ref,omitempty"`
+	ID          BatchID   `json:"id"`
+	Version     *int      `json:"version,omitempty"`
+	Name        *string   `json:"name,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Amount      *float32  `json:"amount,omitempty"`
+	PayerID     BatchID   `json:"payer_id"`
+	PayeeIDs    []BatchID `json:"payee_ids,omitempty"`
+}
+
+// BatchError is the error of the operation at Index, which rolled the whole batch back
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyBatch runs ops in order in one transaction and returns the ID each one created or changed.
+// The first failing operation rolls everything back and is returned as a *BatchError.
+func ApplyBatch(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, ops []BatchOp) ([]int, error) {
+	return WithTx(ctx, db, func(tx pgx.Tx) ([]int, error) {
+		refs := map[string]int{}
+		ids := make([]int, 0, len(ops))
+		for idx, op := range ops {
+			id, err := applyBatchOp(ctx, tx, L, groupID, op, refs)
+			if err == nil && op.Type == BatchPayment {
+				err = checkDeferred(ctx, tx, L)
+			}
+			if err != nil {
+				return nil, &BatchError{Index: idx, Err: err}
+			}
+			if op.Ref != "" {
+				refs[op.Ref] = id
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+}
+
+// checkDeferred runs the payment triggers that would otherwise wait for the commit, so a
+// failure is pinned on the operation that caused it, then defers them again for the next one
+func checkDeferred(ctx context.Context, tx pgx.Tx, L *slog.Logger) error {
+	for _, query := range []string{
+		"SET CONSTRAINTS ensure_payment_has_users, ensure_payment_users_in_same_group IMMEDIATE",
+		"SET CONSTRAINTS ensure_payment_has_users, ensure_payment_users_in_same_group DEFERRED",
+	} {
+		L.DebugContext(ctx, "ApplyBatch.checkDeferred", "query", query)
+		_, err := tx.Exec(ctx, query)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Check failed: %v", err))
+			return err
+		}
+	}
+	return nil
+}
+
+func applyBatchOp(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, op BatchOp, refs map[string]int) (int, error) {
+	if op.Op == BatchCreate {
+		switch op.Type {
+		case BatchUser:
+			return addUser(ctx, tx, L, groupID, *op.Name)
+		case BatchPayment:
+			return addBatchPayment(ctx, tx, L, groupID, op, refs)
+		}
+		return 0, fmt.Errorf("unknown type %q", op.Type)
+	}
+
+	id, err := op.ID.resolve(refs)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case op.Op == BatchUpdate && op.Type == BatchUser:
+		err = patchUser(ctx, tx, L, groupID, id, op.Version, *op.Name)
+	case op.Op == BatchDelete && op.Type == BatchUser:
+		err = deleteUser(ctx, tx, L, groupID, id, op.Version)
+	case op.Op == BatchUpdate && op.Type == BatchPayment:
+		err = patchPayment(ctx, tx, L, groupID, id, op.Version, op.Amount, op.Description)
+	case op.Op == BatchDelete && op.Type == BatchPayment:
+		err = deletePayment(ctx, tx, L, groupID, id, op.Version)
+	default:
+		err = fmt.Errorf("unknown operation %q on %q", op.Op, op.Type)
+	}
+	return id, err
+}
+
+func addBatchPayment(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, op BatchOp, refs map[string]int) (int, error) {
+	payment := InsertPayment{
+		Amount:   *op.Amount,
+		PayeeIDs: make([]int, 0, len(op.PayeeIDs)),
+	}
+	if op.Description != nil {
+		payment.Description = *op.Description
+	}
+
+	var err error
+	payment.PayerID, err = op.PayerID.resolve(refs)
+	if err != nil {
+		return 0, err
+	}
+	for _, payee := range op.PayeeIDs {
+		payeeID, err := payee.resolve(refs)
+		if err != nil {
+			return 0, err
+		}
+		payment.PayeeIDs = append(payment.PayeeIDs, payeeID)
+	}
+
+	return addPayment(ctx, tx, L, groupID, payment)
+}
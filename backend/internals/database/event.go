@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	EventPaymentCreated     = "payment.created"
+	EventPaymentUpdated     = "payment.updated"
+	EventPaymentDeleted     = "payment.deleted"
+	EventUserAdded          = "user.added"
+	EventUserUpdated        = "user.updated"
+	EventUserDeleted        = "user.deleted"
+	EventUserDeactivated    = "user.deactivated"
+	EventUserMerged         = "user.merged"
+	EventSettlementRecorded = "settlement.recorded"
+)
+
+var Events = []string{
+	EventPaymentCreated,
+	EventPaymentUpdated,
+	EventPaymentDeleted,
+	EventUserAdded,
+	EventUserUpdated,
+	EventUserDeleted,
+	EventUserDeactivated,
+	EventUserMerged,
+	EventSettlementRecorded,
+}
+
+// events after which the group's balances are attached to the payload
+var balanceEvents = []string{
+	EventPaymentCreated,
+	EventPaymentUpdated,
+	EventPaymentDeleted,
+	EventUserDeactivated,
+	EventUserMerged,
+	EventSettlementRecorded,
+}
+
+// channel that committed events are announced on, with a "<group id>:<event id>" payload
+const EventChannel = "split_events"
+
+// records an event in the group log, queues a delivery for every subscribed webhook
+// and announces it to listeners once the transaction commits
+func enqueueEvent(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, event string, payload map[string]any) error {
+	if slices.Contains(balanceEvents, event) {
+		balanceQuery := "SELECT id, balance FROM users WHERE group_id = @groupID ORDER BY id"
+		balanceArgs := pgx.StrictNamedArgs{
+			"groupID": groupID,
+		}
+		L.DebugContext(ctx, "enqueueEvent.balances", "query", balanceQuery, "args", balanceArgs)
+		rows, err := tx.Query(ctx, balanceQuery, balanceArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+			return err
+		}
+
+		balances := []map[string]any{}
+		var id int
+		var balance float32
+		_, err = pgx.ForEachRow(rows, []any{&id, &balance}, func() error {
+			balances = append(balances, map[string]any{
+				"user_id": id,
+				"balance": balance,
+			})
+			return nil
+		})
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+			return err
+		}
+		payload["balances"] = balances
+	}
+
+	// ids come from a sequence, so two transactions can commit them out of order and a
+	// stream that already sent the higher one would never send the lower. Holding the group
+	// until commit hands out a group's ids in the order they become visible
+	lockQuery := "SELECT 1 FROM groups WHERE id = @groupID FOR NO KEY UPDATE"
+	lockArgs := pgx.StrictNamedArgs{
+		"groupID": groupID,
+	}
+	L.DebugContext(ctx, "enqueueEvent.lock", "query", lockQuery, "args", lockArgs)
+	_, err := tx.Exec(ctx, lockQuery, lockArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Lock failed: %v", err))
+		return err
+	}
+
+	eventQuery := "INSERT INTO group_event (group_id, event, payload) VALUES (@groupID, @event, @payload) RETURNING id"
+	eventArgs := pgx.StrictNamedArgs{
+		"groupID": groupID,
+		"event":   event,
+		"payload": payload,
+	}
+
+	var eventID int
+	L.DebugContext(ctx, "enqueueEvent.event", "query", eventQuery, "args", eventArgs)
+	err = tx.QueryRow(ctx, eventQuery, eventArgs).Scan(&eventID)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+		return err
+	}
+
+	deliveryQuery := `INSERT INTO webhook_delivery (webhook_id, event_id)
+SELECT id, @eventID FROM webhook WHERE group_id = @groupID AND @event = ANY(events)`
+	deliveryArgs := pgx.StrictNamedArgs{
+		"eventID": eventID,
+		"groupID": groupID,
+		"event":   event,
+	}
+	L.DebugContext(ctx, "enqueueEvent.delivery", "query", deliveryQuery, "args", deliveryArgs)
+	_, err = tx.Exec(ctx, deliveryQuery, deliveryArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+		return err
+	}
+
+	notifyQuery := "SELECT pg_notify(@channel, @payload)"
+	notifyArgs := pgx.StrictNamedArgs{
+		"channel": EventChannel,
+		"payload": fmt.Sprintf("%d:%d", groupID, eventID),
+	}
+	L.DebugContext(ctx, "enqueueEvent.notify", "query", notifyQuery, "args", notifyArgs)
+	_, err = tx.Exec(ctx, notifyQuery, notifyArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Notify failed: %v", err))
+		return err
+	}
+
+	return nil
+}
+
+func GetLatestEventID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int) (int, error) {
+	query := "SELECT COALESCE(MAX(id), 0) FROM group_event WHERE group_id = @groupID"
+	args := pgx.StrictNamedArgs{
+		"groupID": groupID,
+	}
+
+	var id int
+	L.DebugContext(ctx, "GetLatestEventID", "query", query, "args", args)
+	err := db.QueryRow(ctx, query, args).Scan(&id)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func GetEventsSince(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, afterID int, limit int) ([]GroupEvent, error) {
+	query := `
+	SELECT id, group_id, event, payload, created_at
+	FROM group_event
+	WHERE group_id = @groupID AND id > @afterID
+	ORDER BY id
+	LIMIT @limit`
+	args := pgx.StrictNamedArgs{
+		"groupID": groupID,
+		"afterID": afterID,
+		"limit":   limit,
+	}
+
+	L.DebugContext(ctx, "GetEventsSince", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []GroupEvent{}, err
+	}
+
+	events, err := pgx.CollectRows(rows, pgx.RowToStructByName[GroupEvent])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []GroupEvent{}, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentClaimsDoNotOverlap(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		_, err = store.AddWebhook(ctx, group, "https://example.com/hook", "whsec", []string{EventUserAdded})
+		failOn(t, err)
+		for _, name := range []string{"Alice", "Bob", "Carol", "Dave", "Erin", "Frank"} {
+			_, err := store.AddUserToGroupByID(ctx, group, name)
+			failOn(t, err)
+		}
+
+		// two dispatchers polling at once split the due deliveries between them
+		var wg sync.WaitGroup
+		claims := make([][]PendingDelivery, 2)
+		errs := make([]error, 2)
+		for i := range claims {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				claims[i], errs[i] = store.ClaimPendingDeliveries(ctx, 4, time.Minute)
+			}()
+		}
+		wg.Wait()
+
+		claimed := map[int]bool{}
+		for i, claim := range claims {
+			failOn(t, errs[i])
+			for _, delivery := range claim {
+				if claimed[delivery.ID] {
+					t.Errorf("delivery %d was claimed twice", delivery.ID)
+				}
+				claimed[delivery.ID] = true
+			}
+		}
+		if len(claimed) != 6 {
+			t.Errorf("claimed %d deliveries, want all 6", len(claimed))
+		}
+
+		// leased deliveries are not due again until the lease is over
+		again, err := store.ClaimPendingDeliveries(ctx, 4, time.Minute)
+		if len(again) != 0 || err != nil {
+			t.Errorf("claim during the lease = %d, %v, want nothing", len(again), err)
+		}
+	})
+}
+
+// notifications passes on what a store announces
+type notifications struct {
+	listening chan struct{}
+	events    chan [2]int
+}
+
+func (n notifications) Listening() {
+	n.listening <- struct{}{}
+}
+
+func (n notifications) Notify(groupID int, eventID int) {
+	n.events <- [2]int{groupID, eventID}
+}
+
+func TestListenEvents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		listener := notifications{make(chan struct{}, 1), make(chan [2]int, 10)}
+		done := make(chan error, 1)
+		go func() {
+			done <- store.ListenEvents(ctx, listener)
+		}()
+		select {
+		case <-listener.listening:
+		case err := <-done:
+			t.Fatalf("ListenEvents = %v before listening", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("ListenEvents never started listening")
+		}
+
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		_, err = store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		latest, err := store.GetLatestEventID(ctx, group)
+		failOn(t, err)
+
+		select {
+		case got := <-listener.events:
+			if got != [2]int{group, latest} {
+				t.Errorf("notified of event %d in group %d, want %d in %d", got[1], got[0], latest, group)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("no notification of the committed event")
+		}
+
+		cancel()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("ListenEvents returned no error after being cancelled")
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("ListenEvents kept running after being cancelled")
+		}
+	})
+}
@@ -11,27 +11,87 @@ import (
 )
 
 func GetGroupByID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) (Group, error) {
-	query := "SELECT id, name FROM groups WHERE groups.id = @id"
+	return getGroupByID(ctx, db, L, id)
+}
+
+func getGroupByID(ctx context.Context, db querier, L *slog.Logger, id int) (Group, error) {
+	query := "SELECT id, name, version FROM groups WHERE groups.id = @id"
 	args := pgx.StrictNamedArgs{
 		"id": id,
 	}
 
-	L.Info("GetGroupByID", "query", query, "args", args)
+	L.DebugContext(ctx, "GetGroupByID", "query", query, "args", args)
 	rows, err := db.Query(ctx, query, args)
 	if err != nil {
-		L.Error(fmt.Sprintf("Get failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
 		return Group{}, err
 	}
 
 	group, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Group])
 	if err != nil {
-		L.Error(fmt.Sprintf("Binding failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
 		return Group{}, err
 	}
 
 	return group, nil
 }
 
+func ListGroupsByPersonID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, personID int, search string, sort string, limit int, offset int) ([]GroupListing, int, error) {
+	orderBy := "g.last_activity_at DESC, g.id DESC"
+	if sort == "name" {
+		orderBy = "g.name, g.id"
+	}
+
+	query := `
+	SELECT
+		g.id,
+		g.name,
+		(SELECT COUNT(*) FROM users WHERE group_id = g.id AND active)           AS member_count,
+		(SELECT COALESCE(SUM(amount), 0) FROM payment WHERE group_id = g.id)    AS total_spent,
+		u.balance                                                               AS balance,
+		g.last_activity_at                                                      AS last_activity_at,
+		COUNT(*) OVER ()                                                        AS total
+	FROM groups AS g
+	JOIN users AS u
+		ON u.group_id = g.id
+	WHERE u.person_id = @personID
+		AND STRPOS(LOWER(g.name), LOWER(@search)) > 0
+	ORDER BY ` + orderBy + `
+	LIMIT @limit OFFSET @offset`
+	args := pgx.StrictNamedArgs{
+		"personID": personID,
+		"search":   search,
+		"limit":    limit,
+		"offset":   offset,
+	}
+
+	L.DebugContext(ctx, "ListGroupsByPersonID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []GroupListing{}, 0, err
+	}
+
+	type row struct {
+		GroupListing
+		Total int `db:"total"`
+	}
+	listed, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []GroupListing{}, 0, err
+	}
+
+	total := 0
+	groups := make([]GroupListing, 0, len(listed))
+	for _, group := range listed {
+		total = group.Total
+		groups = append(groups, group.GroupListing)
+	}
+
+	return groups, total, nil
+}
+
 func CreateGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, name string) (int, error) {
 	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
 		query := "INSERT INTO groups (name) VALUES (@name) RETURNING id"
@@ -40,10 +100,10 @@ func CreateGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, name str
 		}
 
 		var id int
-		L.Info("CreateGroup", "query", query, "args", args)
+		L.DebugContext(ctx, "CreateGroup", "query", query, "args", args)
 		err := tx.QueryRow(ctx, query, args).Scan(&id)
 		if err != nil {
-			L.Error(fmt.Sprintf("Insert failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
 			return 0, err
 		}
 
@@ -51,26 +111,32 @@ func CreateGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, name str
 	})
 }
 
-func PatchGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int, name string) error {
+// PatchGroup renames a group. When version is set, the group must still be at that version.
+func PatchGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int, version *int, name string) error {
 	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
-		query := "UPDATE groups SET name = @name WHERE id = @id"
+		err := lockGroup(ctx, tx, L, id, version)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		query := "UPDATE groups SET name = @name, version = version + 1 WHERE id = @id"
 		args := pgx.StrictNamedArgs{
 			"name": name,
 			"id":   id,
 		}
 
-		L.Info("PatchGroup", "query", query, "args", args)
+		L.DebugContext(ctx, "PatchGroup", "query", query, "args", args)
 		cmdTag, err := tx.Exec(ctx, query, args)
 		if err != nil {
-			L.Error(fmt.Sprintf("Patch failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Patch failed: %v", err))
 			return struct{}{}, err
 		}
 		if cmdTag.RowsAffected() > 1 {
-			L.Error("Patch failed: more than one row affected")
+			L.ErrorContext(ctx, "Patch failed: more than one row affected")
 			return struct{}{}, errors.New("more than one row affected")
 		}
 		if cmdTag.RowsAffected() == 0 {
-			L.Error(fmt.Sprintf("Patch failed: group %v does not exist", id))
+			L.ErrorContext(ctx, fmt.Sprintf("Patch failed: group %v does not exist", id))
 			return struct{}{}, pgx.ErrNoRows
 		}
 
@@ -79,17 +145,35 @@ func PatchGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int, n
 	return err
 }
 
-func DeleteGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) error {
+func DeleteGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int, version *int) error {
 	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		err := lockGroup(ctx, tx, L, id, version)
+		if err != nil {
+			return struct{}{}, err
+		}
+
 		paymentQuery := "DELETE FROM payment WHERE group_id = @id"
 		paymentArgs := pgx.StrictNamedArgs{
 			"id": id,
 		}
 
-		L.Info("DeleteGroup.DeletePayments", "query", paymentQuery, "args", paymentArgs)
-		_, err := tx.Exec(ctx, paymentQuery, paymentArgs)
+		L.DebugContext(ctx, "DeleteGroup.DeletePayments", "query", paymentQuery, "args", paymentArgs)
+		_, err = tx.Exec(ctx, paymentQuery, paymentArgs)
 		if err != nil {
-			L.Error(fmt.Sprintf("Delete failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+			return struct{}{}, err
+		}
+
+		// templates hold on to their payer and payees, so they go before the members do
+		recurringQuery := "DELETE FROM recurring_payment WHERE group_id = @id"
+		recurringArgs := pgx.StrictNamedArgs{
+			"id": id,
+		}
+
+		L.DebugContext(ctx, "DeleteGroup.DeleteRecurring", "query", recurringQuery, "args", recurringArgs)
+		_, err = tx.Exec(ctx, recurringQuery, recurringArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
 			return struct{}{}, err
 		}
 
@@ -98,18 +182,18 @@ func DeleteGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int)
 			"id": id,
 		}
 
-		L.Info("DeleteGroup.DeleteGroup", "query", query, "args", args)
+		L.DebugContext(ctx, "DeleteGroup.DeleteGroup", "query", query, "args", args)
 		cmdTag, err := tx.Exec(ctx, query, args)
 		if err != nil {
-			L.Error(fmt.Sprintf("Delete failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
 			return struct{}{}, err
 		}
 		if cmdTag.RowsAffected() > 1 {
-			L.Error("Delete failed: more than one row affected")
+			L.ErrorContext(ctx, "Delete failed: more than one row affected")
 			return struct{}{}, errors.New("more than one row affected")
 		}
 		if cmdTag.RowsAffected() == 0 {
-			L.Error(fmt.Sprintf("Delete failed: group %v does not exist", id))
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: group %v does not exist", id))
 			return struct{}{}, pgx.ErrNoRows
 		}
 
@@ -118,3 +202,11 @@ func DeleteGroup(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int)
 
 	return err
 }
+
+func lockGroup(ctx context.Context, tx pgx.Tx, L *slog.Logger, id int, version *int) error {
+	query := "SELECT version FROM groups WHERE id = @id FOR UPDATE"
+	args := pgx.StrictNamedArgs{
+		"id": id,
+	}
+	return lockVersion(ctx, tx, L, "lockGroup", query, args, version)
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ReserveIdempotencyKey claims key for a new request. When the key is already taken
+// within the retention window it returns false with the stored response instead.
+func ReserveIdempotencyKey(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, key string, fingerprint string, retention time.Duration) (bool, IdempotentResponse, error) {
+	type result struct {
+		reserved bool
+		existing IdempotentResponse
+	}
+
+	res, err := WithTx(ctx, db, func(tx pgx.Tx) (result, error) {
+		// an expired key is free to use again
+		query := "DELETE FROM idempotency_key WHERE key = @key AND created_at < NOW() - @retention::INTERVAL"
+		args := pgx.StrictNamedArgs{
+			"key":       key,
+			"retention": retention,
+		}
+
+		L.DebugContext(ctx, "ReserveIdempotencyKey", "query", query, "args", args)
+		_, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+			return result{}, err
+		}
+
+		query = "INSERT INTO idempotency_key (key, fingerprint) VALUES (@key, @fingerprint) ON CONFLICT (key) DO NOTHING"
+		args = pgx.StrictNamedArgs{
+			"key":         key,
+			"fingerprint": fingerprint,
+		}
+
+		L.DebugContext(ctx, "ReserveIdempotencyKey", "query", query, "args", args)
+		tag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+			return result{}, err
+		}
+		if tag.RowsAffected() == 1 {
+			return result{reserved: true}, nil
+		}
+
+		query = "SELECT key, fingerprint, status_code, headers, body, created_at FROM idempotency_key WHERE key = @key"
+		args = pgx.StrictNamedArgs{
+			"key": key,
+		}
+
+		L.DebugContext(ctx, "ReserveIdempotencyKey", "query", query, "args", args)
+		rows, err := tx.Query(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+			return result{}, err
+		}
+
+		existing, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[IdempotentResponse])
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+			return result{}, err
+		}
+
+		return result{existing: existing}, nil
+	})
+	return res.reserved, res.existing, err
+}
+
+func SaveIdempotentResponse(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, key string, statusCode int, headers map[string][]string, body []byte) error {
+	query := "UPDATE idempotency_key SET status_code = @statusCode, headers = @headers, body = @body WHERE key = @key"
+	args := pgx.StrictNamedArgs{
+		"key":        key,
+		"statusCode": statusCode,
+		"headers":    headers,
+		"body":       body,
+	}
+
+	L.DebugContext(ctx, "SaveIdempotentResponse", "query", query, "args", args)
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a reserved key, so a request that failed on our side can be retried
+func ReleaseIdempotencyKey(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, key string) error {
+	query := "DELETE FROM idempotency_key WHERE key = @key"
+	args := pgx.StrictNamedArgs{
+		"key": key,
+	}
+
+	L.DebugContext(ctx, "ReleaseIdempotencyKey", "query", query, "args", args)
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+		return err
+	}
+
+	return nil
+}
+
+func DeleteExpiredIdempotencyKeys(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, retention time.Duration) (int64, error) {
+	query := "DELETE FROM idempotency_key WHERE created_at < NOW() - @retention::INTERVAL"
+	args := pgx.StrictNamedArgs{
+		"retention": retention,
+	}
+
+	L.DebugContext(ctx, "DeleteExpiredIdempotencyKeys", "query", query, "args", args)
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
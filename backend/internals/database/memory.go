@@ -0,0 +1,1725 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// MemoryStore is a Store that keeps everything in process memory, for tests and for running
+// the API without a database. It follows the schema's rules: foreign keys, checks, unique keys
+// and the payment triggers fail with the same errors Postgres raises for them.
+//
+// A write works on a copy of the state that replaces it only if the write succeeds, so every
+// call is atomic the way a transaction is. Writes run one at a time.
+type MemoryStore struct {
+	L *slog.Logger
+
+	mu     sync.RWMutex
+	state  *memState
+	seq    map[string]int
+	events localEvents
+}
+
+func NewMemoryStore(L *slog.Logger) *MemoryStore {
+	s := &MemoryStore{
+		L:   L,
+		seq: map[string]int{},
+	}
+	s.state = &memState{
+		groups:      map[int]memGroup{},
+		people:      map[int]Person{},
+		users:       map[int]memUser{},
+		payments:    map[int]memPayment{},
+		transfers:   map[int]memTransfer{},
+		recurring:   map[int]RecurringPayment{},
+		occurrences: map[memOccurrence]*int{},
+		webhooks:    map[int]Webhook{},
+		events:      map[int]GroupEvent{},
+		deliveries:  map[int]WebhookDelivery{},
+		idempotency: map[string]IdempotentResponse{},
+	}
+	return s
+}
+
+type memGroup struct {
+	Group
+	lastActivityAt time.Time
+}
+
+type memUser struct {
+	ID       int
+	GroupID  int
+	PersonID *int
+	Name     string
+	Balance  float64
+	Active   bool
+	Version  int
+}
+
+func (u memUser) user() User {
+	return User{
+		ID:       u.ID,
+		PersonID: u.PersonID,
+		Name:     u.Name,
+		Balance:  float32(u.Balance),
+		Active:   u.Active,
+		Version:  u.Version,
+	}
+}
+
+// payee IDs are kept in the order they were added, which is the order they are read back in
+type memPayment struct {
+	ID          int
+	GroupID     int
+	Description string
+	Amount      float32
+	PayerID     int
+	PayeeIDs    []int
+	Version     int
+}
+
+type memTransfer struct {
+	ID      int
+	GroupID int
+	FromID  int
+	ToID    int
+	Amount  float64
+}
+
+type memOccurrence struct {
+	recurringID int
+	occursOn    string
+}
+
+// memState is one version of the data. Maps hold values, and a value's slices are replaced
+// rather than changed in place, so a shallow copy of every map is a full snapshot
+type memState struct {
+	groups      map[int]memGroup
+	people      map[int]Person
+	users       map[int]memUser
+	payments    map[int]memPayment
+	transfers   map[int]memTransfer
+	recurring   map[int]RecurringPayment
+	occurrences map[memOccurrence]*int
+	webhooks    map[int]Webhook
+	events      map[int]GroupEvent
+	deliveries  map[int]WebhookDelivery
+	idempotency map[string]IdempotentResponse
+
+	// per write: the sequences, the time NOW() would give, payments the deferred
+	// triggers have to look at, and events to announce after the write succeeds
+	seq     map[string]int
+	now     time.Time
+	touched map[int]bool
+	notices []eventNotice
+}
+
+func (st *memState) clone() *memState {
+	return &memState{
+		groups:      maps.Clone(st.groups),
+		people:      maps.Clone(st.people),
+		users:       maps.Clone(st.users),
+		payments:    maps.Clone(st.payments),
+		transfers:   maps.Clone(st.transfers),
+		recurring:   maps.Clone(st.recurring),
+		occurrences: maps.Clone(st.occurrences),
+		webhooks:    maps.Clone(st.webhooks),
+		events:      maps.Clone(st.events),
+		deliveries:  maps.Clone(st.deliveries),
+		idempotency: maps.Clone(st.idempotency),
+	}
+}
+
+// sequences are never rolled back, the same as Postgres serials
+func (st *memState) next(table string) int {
+	st.seq[table]++
+	return st.seq[table]
+}
+
+// memTx runs fn against a copy of the state and keeps the copy if fn and the deferred
+// payment checks pass
+func memTx[T any](ctx context.Context, s *MemoryStore, fn func(st *memState) (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	s.mu.Lock()
+	st := s.state.clone()
+	st.seq = s.seq
+	st.now = time.Now().Truncate(time.Microsecond)
+	st.touched = map[int]bool{}
+
+	res, err := fn(st)
+	if err == nil {
+		err = st.checkPayments()
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return zero, err
+	}
+	s.state = st
+	s.mu.Unlock()
+
+	s.events.announce(st.notices)
+	return res, nil
+}
+
+func memRead[T any](ctx context.Context, s *MemoryStore, fn func(st *memState) (T, error)) (T, error) {
+	if err := ctx.Err(); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := *s.state
+	st.now = time.Now().Truncate(time.Microsecond)
+	return fn(&st)
+}
+
+// errors shaped like the ones Postgres raises, so callers cannot tell the stores apart
+
+func fkMissing(table string, constraint string) *pgconn.PgError {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23503",
+		Message:        fmt.Sprintf("insert or update on table %q violates foreign key constraint %q", table, constraint),
+		TableName:      table,
+		ConstraintName: constraint,
+	}
+}
+
+func fkReferenced(table string, constraint string, referencing string) *pgconn.PgError {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23503",
+		Message:        fmt.Sprintf("update or delete on table %q violates foreign key constraint %q on table %q", table, constraint, referencing),
+		TableName:      referencing,
+		ConstraintName: constraint,
+	}
+}
+
+func checkFailed(table string, constraint string) *pgconn.PgError {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23514",
+		Message:        fmt.Sprintf("new row for relation %q violates check constraint %q", table, constraint),
+		TableName:      table,
+		ConstraintName: constraint,
+	}
+}
+
+func duplicateKey(table string, constraint string) *pgconn.PgError {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23505",
+		Message:        fmt.Sprintf("duplicate key value violates unique constraint %q", constraint),
+		TableName:      table,
+		ConstraintName: constraint,
+	}
+}
+
+func triggerFailed(constraint string, column string, message string) *pgconn.PgError {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "P0001",
+		Message:        message,
+		ConstraintName: constraint,
+		ColumnName:     column,
+	}
+}
+
+// checkPayments runs the deferred payment triggers over every payment the write touched
+func (st *memState) checkPayments() error {
+	ids := slices.Sorted(maps.Keys(st.touched))
+	for _, id := range ids {
+		payment, ok := st.payments[id]
+		if ok && len(payment.PayeeIDs) == 0 {
+			return triggerFailed("ensure_payment_has_users", "payee_ids",
+				fmt.Sprintf("Payment %d must have at least one associated user", id))
+		}
+	}
+	for _, id := range ids {
+		payment, ok := st.payments[id]
+		if !ok {
+			continue
+		}
+		payer, ok := st.users[payment.PayerID]
+		if !ok {
+			return triggerFailed("ensure_payment_users_in_same_group", "payer_id",
+				fmt.Sprintf("Payer %d does not exist", payment.PayerID))
+		}
+		if payer.GroupID != payment.GroupID {
+			return triggerFailed("ensure_payment_users_in_same_group", "payer_id",
+				fmt.Sprintf("Payer %d is not in the same group as the payment (group %d)", payment.PayerID, payment.GroupID))
+		}
+		for _, payeeID := range payment.PayeeIDs {
+			if st.users[payeeID].GroupID != payment.GroupID {
+				return triggerFailed("ensure_payment_users_in_same_group", "payee_ids",
+					fmt.Sprintf("One or more users in users_payment are not in the same group as the payment (group %d)", payment.GroupID))
+			}
+		}
+	}
+	return nil
+}
+
+// checkVersion stands in for lockVersion
+func checkVersion(found bool, current int, expected *int) error {
+	if !found {
+		return ErrNotFound
+	}
+	if expected != nil && *expected != current {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// any change to a group's members or payments counts as activity on the group
+func (st *memState) touchGroup(groupID int) {
+	group, ok := st.groups[groupID]
+	if ok {
+		group.lastActivityAt = st.now
+		st.groups[groupID] = group
+	}
+}
+
+func (st *memState) putUser(user memUser) {
+	st.users[user.ID] = user
+	st.touchGroup(user.GroupID)
+}
+
+func (st *memState) putPayment(payment memPayment) {
+	st.payments[payment.ID] = payment
+	st.touched[payment.ID] = true
+	st.touchGroup(payment.GroupID)
+}
+
+func (st *memState) addBalance(userID int, amount float64) {
+	user := st.users[userID]
+	user.Balance += amount
+	st.putUser(user)
+}
+
+func (st *memState) groupUsers(groupID int) []memUser {
+	users := []memUser{}
+	for _, id := range slices.Sorted(maps.Keys(st.users)) {
+		if st.users[id].GroupID == groupID {
+			users = append(users, st.users[id])
+		}
+	}
+	return users
+}
+
+func (st *memState) groupPayments(groupID int) []memPayment {
+	payments := []memPayment{}
+	for _, id := range slices.Sorted(maps.Keys(st.payments)) {
+		if st.payments[id].GroupID == groupID {
+			payments = append(payments, st.payments[id])
+		}
+	}
+	return payments
+}
+
+// the payment joined with its payer and payees, as the payment queries read it
+func (st *memState) payment(p memPayment) Payment {
+	description := p.Description
+	payer := st.users[p.PayerID]
+	payment := Payment{
+		ID:            p.ID,
+		GroupID:       p.GroupID,
+		Description:   &description,
+		Amount:        p.Amount,
+		PayerID:       payer.ID,
+		PayerName:     payer.Name,
+		PayerBalance:  float32(payer.Balance),
+		PayerActive:   payer.Active,
+		PayerVersion:  payer.Version,
+		PayeeIDs:      make([]int, 0, len(p.PayeeIDs)),
+		PayeeNames:    make([]string, 0, len(p.PayeeIDs)),
+		PayeeBalances: make([]float32, 0, len(p.PayeeIDs)),
+		PayeeActives:  make([]bool, 0, len(p.PayeeIDs)),
+		PayeeVersions: make([]int, 0, len(p.PayeeIDs)),
+		Version:       p.Version,
+	}
+	for _, payeeID := range p.PayeeIDs {
+		payee := st.users[payeeID]
+		payment.PayeeIDs = append(payment.PayeeIDs, payee.ID)
+		payment.PayeeNames = append(payment.PayeeNames, payee.Name)
+		payment.PayeeBalances = append(payment.PayeeBalances, float32(payee.Balance))
+		payment.PayeeActives = append(payment.PayeeActives, payee.Active)
+		payment.PayeeVersions = append(payment.PayeeVersions, payee.Version)
+	}
+	return payment
+}
+
+func (st *memState) enqueueEvent(groupID int, event string, payload map[string]any) error {
+	if _, ok := st.groups[groupID]; !ok {
+		return fkMissing("group_event", "group_event_group_id_fkey")
+	}
+
+	if slices.Contains(balanceEvents, event) {
+		balances := []map[string]any{}
+		for _, user := range st.groupUsers(groupID) {
+			balances = append(balances, map[string]any{
+				"user_id": user.ID,
+				"balance": float32(user.Balance),
+			})
+		}
+		payload["balances"] = balances
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	eventID := st.next("group_event")
+	st.events[eventID] = GroupEvent{
+		ID:        eventID,
+		GroupID:   groupID,
+		Event:     event,
+		Payload:   data,
+		CreatedAt: st.now,
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(st.webhooks)) {
+		webhook := st.webhooks[id]
+		if webhook.GroupID == groupID && slices.Contains(webhook.Events, event) {
+			st.queueDelivery(webhook.ID, eventID)
+		}
+	}
+
+	st.notices = append(st.notices, eventNotice{groupID, eventID})
+	return nil
+}
+
+func (st *memState) queueDelivery(webhookID int, eventID int) int {
+	id := st.next("webhook_delivery")
+	st.deliveries[id] = WebhookDelivery{
+		ID:            id,
+		WebhookID:     webhookID,
+		EventID:       eventID,
+		Status:        DeliveryPending,
+		NextAttemptAt: st.now,
+	}
+	return id
+}
+
+// cascades
+
+func (st *memState) deleteRecurring(id int) {
+	delete(st.recurring, id)
+	for key := range st.occurrences {
+		if key.recurringID == id {
+			delete(st.occurrences, key)
+		}
+	}
+}
+
+func (st *memState) deletePaymentRow(id int) {
+	payment := st.payments[id]
+	delete(st.payments, id)
+	st.touchGroup(payment.GroupID)
+	for key, paymentID := range st.occurrences {
+		if paymentID != nil && *paymentID == id {
+			st.occurrences[key] = nil
+		}
+	}
+}
+
+func (st *memState) deleteWebhookRow(id int) {
+	delete(st.webhooks, id)
+	for deliveryID, delivery := range st.deliveries {
+		if delivery.WebhookID == id {
+			delete(st.deliveries, deliveryID)
+		}
+	}
+}
+
+func (st *memState) deleteEventRow(id int) {
+	delete(st.events, id)
+	for deliveryID, delivery := range st.deliveries {
+		if delivery.EventID == id {
+			delete(st.deliveries, deliveryID)
+		}
+	}
+}
+
+// Groups
+
+func (s *MemoryStore) GetGroupByID(ctx context.Context, id int) (Group, error) {
+	return memRead(ctx, s, func(st *memState) (Group, error) {
+		group, ok := st.groups[id]
+		if !ok {
+			return Group{}, ErrNotFound
+		}
+		return group.Group, nil
+	})
+}
+
+func (s *MemoryStore) ListGroupsByPersonID(ctx context.Context, personID int, search string, sort string, limit int, offset int) ([]GroupListing, int, error) {
+	type result struct {
+		groups []GroupListing
+		total  int
+	}
+
+	res, err := memRead(ctx, s, func(st *memState) (result, error) {
+		type listed struct {
+			GroupListing
+			name string
+		}
+		matches := []listed{}
+		for _, user := range st.users {
+			if user.PersonID == nil || *user.PersonID != personID {
+				continue
+			}
+			group := st.groups[user.GroupID]
+			if !strings.Contains(strings.ToLower(group.Name), strings.ToLower(search)) {
+				continue
+			}
+
+			listing := GroupListing{
+				ID:             group.ID,
+				Name:           group.Name,
+				Balance:        float32(user.Balance),
+				LastActivityAt: group.lastActivityAt,
+			}
+			var totalSpent float64
+			for _, member := range st.groupUsers(group.ID) {
+				if member.Active {
+					listing.MemberCount++
+				}
+			}
+			for _, payment := range st.groupPayments(group.ID) {
+				totalSpent += float64(payment.Amount)
+			}
+			listing.TotalSpent = float32(totalSpent)
+			matches = append(matches, listed{listing, group.Name})
+		}
+
+		slices.SortFunc(matches, func(a, b listed) int {
+			if sort == "name" {
+				if c := strings.Compare(a.name, b.name); c != 0 {
+					return c
+				}
+				return a.ID - b.ID
+			}
+			if c := b.LastActivityAt.Compare(a.LastActivityAt); c != 0 {
+				return c
+			}
+			return b.ID - a.ID
+		})
+
+		groups := []GroupListing{}
+		for idx, match := range matches {
+			if idx >= offset && len(groups) < limit {
+				groups = append(groups, match.GroupListing)
+			}
+		}
+		// the total rides along on each row, so a page past the end has none
+		total := 0
+		if len(groups) > 0 {
+			total = len(matches)
+		}
+		return result{groups, total}, nil
+	})
+	return res.groups, res.total, err
+}
+
+func (s *MemoryStore) CreateGroup(ctx context.Context, name string) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		id := st.next("groups")
+		st.groups[id] = memGroup{
+			Group:          Group{ID: id, Name: name, Version: 1},
+			lastActivityAt: st.now,
+		}
+		return id, nil
+	})
+}
+
+func (s *MemoryStore) PatchGroup(ctx context.Context, id int, version *int, name string) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		group, ok := st.groups[id]
+		err := checkVersion(ok, group.Version, version)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		group.Name = name
+		group.Version++
+		st.groups[id] = group
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func (s *MemoryStore) DeleteGroup(ctx context.Context, id int, version *int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		group, ok := st.groups[id]
+		err := checkVersion(ok, group.Version, version)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		for _, payment := range st.groupPayments(id) {
+			st.deletePaymentRow(payment.ID)
+		}
+		for _, user := range st.groupUsers(id) {
+			delete(st.users, user.ID)
+		}
+		for transferID, transfer := range st.transfers {
+			if transfer.GroupID == id {
+				delete(st.transfers, transferID)
+			}
+		}
+		for recurringID, recurring := range st.recurring {
+			if recurring.GroupID == id {
+				st.deleteRecurring(recurringID)
+			}
+		}
+		for webhookID, webhook := range st.webhooks {
+			if webhook.GroupID == id {
+				st.deleteWebhookRow(webhookID)
+			}
+		}
+		for eventID, event := range st.events {
+			if event.GroupID == id {
+				st.deleteEventRow(eventID)
+			}
+		}
+		delete(st.groups, id)
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func (s *MemoryStore) GetGroupSummary(ctx context.Context, id int, recent int) (GroupSummary, error) {
+	return memRead(ctx, s, func(st *memState) (GroupSummary, error) {
+		group, ok := st.groups[id]
+		if !ok {
+			return GroupSummary{}, ErrNotFound
+		}
+
+		users := []User{}
+		for _, user := range st.groupUsers(id) {
+			users = append(users, user.user())
+		}
+
+		payments := st.groupPayments(id)
+		var totalSpent float64
+		for _, payment := range payments {
+			totalSpent += float64(payment.Amount)
+		}
+		recentPayments := []Payment{}
+		for idx := len(payments) - 1; idx >= 0 && len(recentPayments) < recent; idx-- {
+			recentPayments = append(recentPayments, st.payment(payments[idx]))
+		}
+
+		return GroupSummary{
+			Group:          group.Group,
+			Users:          users,
+			RecentPayments: recentPayments,
+			PaymentCount:   len(payments),
+			TotalSpent:     float32(totalSpent),
+		}, nil
+	})
+}
+
+// Users
+
+func (s *MemoryStore) GetUsersByGroupID(ctx context.Context, groupID int) ([]User, error) {
+	return memRead(ctx, s, func(st *memState) ([]User, error) {
+		users := []User{}
+		for _, user := range st.groupUsers(groupID) {
+			users = append(users, user.user())
+		}
+		return users, nil
+	})
+}
+
+func (s *MemoryStore) AddUserToGroupByID(ctx context.Context, groupID int, name string) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		return st.addUser(groupID, name)
+	})
+}
+
+func (st *memState) addUser(groupID int, name string) (int, error) {
+	if _, ok := st.groups[groupID]; !ok {
+		return 0, fkMissing("users", "users_group_id_fkey")
+	}
+
+	id := st.next("users")
+	st.putUser(memUser{
+		ID:      id,
+		GroupID: groupID,
+		Name:    name,
+		Active:  true,
+		Version: 1,
+	})
+
+	err := st.enqueueEvent(groupID, EventUserAdded, map[string]any{
+		"user_id": id,
+		"name":    name,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *MemoryStore) PatchUser(ctx context.Context, groupID int, userID int, version *int, name string) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		return struct{}{}, st.patchUser(groupID, userID, version, name)
+	})
+	return err
+}
+
+func (st *memState) patchUser(groupID int, userID int, version *int, name string) error {
+	user, ok := st.users[userID]
+	ok = ok && user.GroupID == groupID
+	err := checkVersion(ok, user.Version, version)
+	if err != nil {
+		return err
+	}
+
+	user.Name = name
+	user.Version++
+	st.putUser(user)
+
+	return st.enqueueEvent(groupID, EventUserUpdated, map[string]any{
+		"user_id": userID,
+		"name":    name,
+	})
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, groupID int, userID int, version *int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		return struct{}{}, st.deleteUser(groupID, userID, version)
+	})
+	return err
+}
+
+func (st *memState) deleteUser(groupID int, userID int, version *int) error {
+	user, ok := st.users[userID]
+	ok = ok && user.GroupID == groupID
+	err := checkVersion(ok, user.Version, version)
+	if err != nil {
+		return err
+	}
+
+	for _, payment := range st.payments {
+		if payment.PayerID == userID {
+			return fkReferenced("users", "payment_payer_id_fkey", "payment")
+		}
+		if slices.Contains(payment.PayeeIDs, userID) {
+			return fkReferenced("users", "users_payment_user_id_fkey", "users_payment")
+		}
+	}
+	for _, transfer := range st.transfers {
+		if transfer.FromID == userID {
+			return fkReferenced("users", "balance_transfer_from_user_id_fkey", "balance_transfer")
+		}
+		if transfer.ToID == userID {
+			return fkReferenced("users", "balance_transfer_to_user_id_fkey", "balance_transfer")
+		}
+	}
+	for _, recurring := range st.recurring {
+		if recurring.PayerID == userID {
+			return fkReferenced("users", "recurring_payment_payer_id_fkey", "recurring_payment")
+		}
+		if slices.Contains(recurring.PayeeIDs, userID) {
+			return fkReferenced("users", "recurring_payment_payee_ids_fkey", "recurring_payment")
+		}
+	}
+	delete(st.users, userID)
+	st.touchGroup(groupID)
+
+	return st.enqueueEvent(groupID, EventUserDeleted, map[string]any{
+		"user_id": userID,
+	})
+}
+
+func (s *MemoryStore) DeactivateUser(ctx context.Context, groupID int, userID int, transferTo *int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		user, ok := st.users[userID]
+		if !ok || user.GroupID != groupID {
+			return struct{}{}, ErrNotFound
+		}
+		if !user.Active {
+			return struct{}{}, ErrUserInactive
+		}
+
+		if transferTo != nil {
+			target, ok := st.users[*transferTo]
+			if !ok || target.GroupID != groupID || !target.Active {
+				return struct{}{}, ErrInvalidTransferUser
+			}
+
+			st.addBalance(target.ID, user.Balance)
+			id := st.next("balance_transfer")
+			st.transfers[id] = memTransfer{
+				ID:      id,
+				GroupID: groupID,
+				FromID:  userID,
+				ToID:    target.ID,
+				Amount:  user.Balance,
+			}
+		} else if math.Abs(user.Balance) >= SettledEpsilon {
+			return struct{}{}, ErrUnsettledBalance
+		}
+
+		user.Active = false
+		user.Balance = 0
+		user.Version++
+		st.putUser(user)
+
+		err := st.enqueueEvent(groupID, EventUserDeactivated, map[string]any{
+			"user_id":     userID,
+			"transfer_to": transferTo,
+		})
+		return struct{}{}, err
+	})
+	return err
+}
+
+func (s *MemoryStore) MergeUser(ctx context.Context, groupID int, sourceID int, targetID int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		source, sourceOK := st.users[sourceID]
+		target, targetOK := st.users[targetID]
+		if sourceID == targetID || !sourceOK || !targetOK || source.GroupID != groupID || target.GroupID != groupID {
+			return struct{}{}, ErrNotFound
+		}
+		if !target.Active {
+			return struct{}{}, ErrUserInactive
+		}
+		if source.PersonID != nil && target.PersonID != nil && *source.PersonID != *target.PersonID {
+			return struct{}{}, ErrPersonConflict
+		}
+
+		// payments the source is on change payer or payees
+		for _, payment := range st.payments {
+			if payment.PayerID == sourceID || slices.Contains(payment.PayeeIDs, sourceID) {
+				payment.Version++
+				st.putPayment(payment)
+			}
+		}
+
+		st.addBalance(targetID, source.Balance)
+
+		// a shared payment loses one payee, so re-split it across the remaining ones
+		for _, id := range slices.Sorted(maps.Keys(st.payments)) {
+			payment := st.payments[id]
+			if !slices.Contains(payment.PayeeIDs, sourceID) || !slices.Contains(payment.PayeeIDs, targetID) {
+				continue
+			}
+			payees := len(payment.PayeeIDs)
+			oldShare := payment.Amount / float32(payees)
+			newShare := payment.Amount / float32(payees-1)
+
+			for _, payeeID := range payment.PayeeIDs {
+				if payeeID != sourceID && payeeID != targetID {
+					st.addBalance(payeeID, float64(newShare-oldShare))
+				}
+			}
+			st.addBalance(targetID, float64(newShare-2*oldShare))
+
+			payment.PayeeIDs = slices.DeleteFunc(slices.Clone(payment.PayeeIDs), func(payeeID int) bool {
+				return payeeID == sourceID
+			})
+			st.putPayment(payment)
+		}
+
+		// rewrite references
+		for _, payment := range st.payments {
+			if payment.PayerID != sourceID && !slices.Contains(payment.PayeeIDs, sourceID) {
+				continue
+			}
+			payment.PayeeIDs = slices.Clone(payment.PayeeIDs)
+			for idx, payeeID := range payment.PayeeIDs {
+				if payeeID == sourceID {
+					payment.PayeeIDs[idx] = targetID
+				}
+			}
+			if payment.PayerID == sourceID {
+				payment.PayerID = targetID
+			}
+			st.putPayment(payment)
+		}
+		for id, transfer := range st.transfers {
+			if transfer.FromID == sourceID {
+				transfer.FromID = targetID
+			}
+			if transfer.ToID == sourceID {
+				transfer.ToID = targetID
+			}
+			st.transfers[id] = transfer
+		}
+
+		// a template with both users as payees keeps the target once, where it first appeared
+		for id, recurring := range st.recurring {
+			if recurring.PayerID != sourceID && !slices.Contains(recurring.PayeeIDs, sourceID) {
+				continue
+			}
+			payeeIDs := []int{}
+			for _, payeeID := range recurring.PayeeIDs {
+				if payeeID == sourceID {
+					payeeID = targetID
+				}
+				if !slices.Contains(payeeIDs, payeeID) {
+					payeeIDs = append(payeeIDs, payeeID)
+				}
+			}
+			recurring.PayeeIDs = payeeIDs
+			if recurring.PayerID == sourceID {
+				recurring.PayerID = targetID
+			}
+			recurring.Paused = false
+			st.recurring[id] = recurring
+		}
+
+		// remove duplicate
+		delete(st.users, sourceID)
+		st.touchGroup(groupID)
+
+		// the target takes over the source's person, who would otherwise lose the member
+		if source.PersonID != nil && target.PersonID == nil {
+			target = st.users[targetID]
+			target.PersonID = source.PersonID
+			target.Version++
+			st.putUser(target)
+		}
+
+		err := st.enqueueEvent(groupID, EventUserMerged, map[string]any{
+			"user_id":   sourceID,
+			"target_id": targetID,
+		})
+		return struct{}{}, err
+	})
+	return err
+}
+
+// Payments
+
+func (s *MemoryStore) GetPaymentsByGroupID(ctx context.Context, groupID int) ([]Payment, error) {
+	return memRead(ctx, s, func(st *memState) ([]Payment, error) {
+		payments := []Payment{}
+		for _, payment := range st.groupPayments(groupID) {
+			payments = append(payments, st.payment(payment))
+		}
+		return payments, nil
+	})
+}
+
+func (s *MemoryStore) GetPaymentByID(ctx context.Context, id int) (Payment, error) {
+	return memRead(ctx, s, func(st *memState) (Payment, error) {
+		payment, ok := st.payments[id]
+		if !ok {
+			return Payment{}, ErrNotFound
+		}
+		return st.payment(payment), nil
+	})
+}
+
+func (s *MemoryStore) AddPaymentByGroupId(ctx context.Context, groupID int, body InsertPayment) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		return st.addPayment(groupID, body)
+	})
+}
+
+func (st *memState) addPayment(groupID int, body InsertPayment) (int, error) {
+	for _, id := range append([]int{body.PayerID}, body.PayeeIDs...) {
+		user, ok := st.users[id]
+		if ok && user.GroupID == groupID && !user.Active {
+			return 0, ErrUserInactive
+		}
+	}
+
+	// insert payment
+	if body.Amount <= 0 {
+		return 0, checkFailed("payment", "payment_amount_check")
+	}
+	if _, ok := st.groups[groupID]; !ok {
+		return 0, fkMissing("payment", "payment_group_id_fkey")
+	}
+	if _, ok := st.users[body.PayerID]; !ok {
+		return 0, fkMissing("payment", "payment_payer_id_fkey")
+	}
+
+	// insert junction
+	for idx, payeeID := range body.PayeeIDs {
+		if _, ok := st.users[payeeID]; !ok {
+			return 0, fkMissing("users_payment", "users_payment_user_id_fkey")
+		}
+		if slices.Contains(body.PayeeIDs[:idx], payeeID) {
+			return 0, duplicateKey("users_payment", "users_payment_pkey")
+		}
+	}
+
+	paymentID := st.next("payment")
+	st.putPayment(memPayment{
+		ID:          paymentID,
+		GroupID:     groupID,
+		Description: body.Description,
+		Amount:      body.Amount,
+		PayerID:     body.PayerID,
+		PayeeIDs:    slices.Clone(body.PayeeIDs),
+		Version:     1,
+	})
+
+	// update balance, a payment without payees fails the deferred check instead
+	if len(body.PayeeIDs) > 0 {
+		payeeBalance := body.Amount / float32(len(body.PayeeIDs))
+		for _, payeeID := range body.PayeeIDs {
+			st.addBalance(payeeID, float64(payeeBalance))
+		}
+	}
+	st.addBalance(body.PayerID, -float64(body.Amount))
+
+	err := st.enqueueEvent(groupID, EventPaymentCreated, map[string]any{
+		"payment_id":  paymentID,
+		"description": body.Description,
+		"amount":      body.Amount,
+		"payer_id":    body.PayerID,
+		"payee_ids":   body.PayeeIDs,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return paymentID, nil
+}
+
+func (s *MemoryStore) PatchPayment(ctx context.Context, groupID int, paymentID int, version *int, amount *float32, description *string) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		return struct{}{}, st.patchPayment(groupID, paymentID, version, amount, description)
+	})
+	return err
+}
+
+func (st *memState) lockPayment(groupID int, paymentID int, version *int) (memPayment, error) {
+	payment, ok := st.payments[paymentID]
+	ok = ok && payment.GroupID == groupID
+	err := checkVersion(ok, payment.Version, version)
+	if err != nil {
+		return memPayment{}, err
+	}
+	return payment, nil
+}
+
+func (st *memState) patchPayment(groupID int, paymentID int, version *int, amount *float32, description *string) error {
+	payment, err := st.lockPayment(groupID, paymentID, version)
+	if err != nil {
+		return err
+	}
+	if amount != nil && hasInactiveUser(st.payment(payment)) {
+		return ErrUserInactive
+	}
+
+	oldAmount := payment.Amount
+	payment.Version++
+	if description != nil {
+		payment.Description = *description
+	}
+	if amount != nil {
+		if *amount <= 0 {
+			return checkFailed("payment", "payment_amount_check")
+		}
+		payment.Amount = *amount
+	}
+	st.putPayment(payment)
+
+	if amount != nil {
+		amtDiff := *amount - oldAmount
+		st.addBalance(payment.PayerID, -float64(amtDiff))
+
+		amtDiffPer := amtDiff / float32(len(payment.PayeeIDs))
+		for _, payeeID := range payment.PayeeIDs {
+			st.addBalance(payeeID, float64(amtDiffPer))
+		}
+	}
+
+	return st.enqueueEvent(payment.GroupID, EventPaymentUpdated, map[string]any{
+		"payment_id":  payment.ID,
+		"description": payment.Description,
+		"amount":      payment.Amount,
+	})
+}
+
+func (s *MemoryStore) DeletePayment(ctx context.Context, groupID int, paymentID int, version *int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		return struct{}{}, st.deletePayment(groupID, paymentID, version)
+	})
+	return err
+}
+
+func (st *memState) deletePayment(groupID int, paymentID int, version *int) error {
+	payment, err := st.lockPayment(groupID, paymentID, version)
+	if err != nil {
+		return err
+	}
+	if hasInactiveUser(st.payment(payment)) {
+		return ErrUserInactive
+	}
+
+	payeeBalance := payment.Amount / float32(len(payment.PayeeIDs))
+	for _, payeeID := range payment.PayeeIDs {
+		st.addBalance(payeeID, -float64(payeeBalance))
+	}
+	st.addBalance(payment.PayerID, float64(payment.Amount))
+	st.deletePaymentRow(payment.ID)
+
+	return st.enqueueEvent(payment.GroupID, EventPaymentDeleted, map[string]any{
+		"payment_id": payment.ID,
+	})
+}
+
+func (s *MemoryStore) DeleteAllPayments(ctx context.Context, groupID int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		for _, payment := range st.groupPayments(groupID) {
+			st.deletePaymentRow(payment.ID)
+			err := st.enqueueEvent(groupID, EventPaymentDeleted, map[string]any{
+				"payment_id": payment.ID,
+			})
+			if err != nil {
+				return struct{}{}, err
+			}
+		}
+
+		// clear all balances
+		for _, user := range st.groupUsers(groupID) {
+			user.Balance = 0
+			st.putUser(user)
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// a settlement is booked as a payment from the debtor with the creditor as the only payee
+func (s *MemoryStore) RecordSettlement(ctx context.Context, groupID int, fromID int, toID int, amount float32) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		paymentID, err := st.addPayment(groupID, InsertPayment{
+			Description: "Settlement",
+			Amount:      amount,
+			PayerID:     fromID,
+			PayeeIDs:    []int{toID},
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		err = st.enqueueEvent(groupID, EventSettlementRecorded, map[string]any{
+			"payment_id": paymentID,
+			"from_id":    fromID,
+			"to_id":      toID,
+			"amount":     amount,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		return paymentID, nil
+	})
+}
+
+func (s *MemoryStore) ApplyBatch(ctx context.Context, groupID int, ops []BatchOp) ([]int, error) {
+	return memTx(ctx, s, func(st *memState) ([]int, error) {
+		refs := map[string]int{}
+		ids := make([]int, 0, len(ops))
+		for idx, op := range ops {
+			id, err := st.applyBatchOp(groupID, op, refs)
+			if err == nil && op.Type == BatchPayment {
+				// stands in for checkDeferred
+				err = st.checkPayments()
+				clear(st.touched)
+			}
+			if err != nil {
+				return nil, &BatchError{Index: idx, Err: err}
+			}
+			if op.Ref != "" {
+				refs[op.Ref] = id
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+}
+
+func (st *memState) applyBatchOp(groupID int, op BatchOp, refs map[string]int) (int, error) {
+	if op.Op == BatchCreate {
+		switch op.Type {
+		case BatchUser:
+			return st.addUser(groupID, *op.Name)
+		case BatchPayment:
+			return st.addBatchPayment(groupID, op, refs)
+		}
+		return 0, fmt.Errorf("unknown type %q", op.Type)
+	}
+
+	id, err := op.ID.resolve(refs)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case op.Op == BatchUpdate && op.Type == BatchUser:
+		err = st.patchUser(groupID, id, op.Version, *op.Name)
+	case op.Op == BatchDelete && op.Type == BatchUser:
+		err = st.deleteUser(groupID, id, op.Version)
+	case op.Op == BatchUpdate && op.Type == BatchPayment:
+		err = st.patchPayment(groupID, id, op.Version, op.Amount, op.Description)
+	case op.Op == BatchDelete && op.Type == BatchPayment:
+		err = st.deletePayment(groupID, id, op.Version)
+	default:
+		err = fmt.Errorf("unknown operation %q on %q", op.Op, op.Type)
+	}
+	return id, err
+}
+
+func (st *memState) addBatchPayment(groupID int, op BatchOp, refs map[string]int) (int, error) {
+	payment := InsertPayment{
+		Amount:   *op.Amount,
+		PayeeIDs: make([]int, 0, len(op.PayeeIDs)),
+	}
+	if op.Description != nil {
+		payment.Description = *op.Description
+	}
+
+	var err error
+	payment.PayerID, err = op.PayerID.resolve(refs)
+	if err != nil {
+		return 0, err
+	}
+	for _, payee := range op.PayeeIDs {
+		payeeID, err := payee.resolve(refs)
+		if err != nil {
+			return 0, err
+		}
+		payment.PayeeIDs = append(payment.PayeeIDs, payeeID)
+	}
+
+	return st.addPayment(groupID, payment)
+}
+
+// Recurring payments
+
+func (s *MemoryStore) GetRecurringPaymentsByGroupID(ctx context.Context, groupID int) ([]RecurringPayment, error) {
+	return memRead(ctx, s, func(st *memState) ([]RecurringPayment, error) {
+		recurring := []RecurringPayment{}
+		for _, id := range slices.Sorted(maps.Keys(st.recurring)) {
+			if st.recurring[id].GroupID == groupID {
+				template := st.recurring[id]
+				template.PayeeIDs = slices.Clone(template.PayeeIDs)
+				recurring = append(recurring, template)
+			}
+		}
+		return recurring, nil
+	})
+}
+
+func (s *MemoryStore) AddRecurringPayment(ctx context.Context, groupID int, body InsertRecurringPayment) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		if body.Amount <= 0 {
+			return 0, checkFailed("recurring_payment", "recurring_payment_amount_check")
+		}
+		if body.DayOfMonth != nil && (*body.DayOfMonth < 1 || *body.DayOfMonth > 31) {
+			return 0, checkFailed("recurring_payment", "recurring_payment_day_of_month_check")
+		}
+		if !slices.Contains([]string{FrequencyWeekly, FrequencyMonthly, FrequencyYearly}, body.Frequency) {
+			return 0, checkFailed("recurring_payment", "recurring_payment_frequency_check")
+		}
+		if len(body.PayeeIDs) == 0 {
+			return 0, checkFailed("recurring_payment", "recurring_payment_payee_ids_check")
+		}
+		if _, ok := st.groups[groupID]; !ok {
+			return 0, fkMissing("recurring_payment", "recurring_payment_group_id_fkey")
+		}
+		if _, ok := st.users[body.PayerID]; !ok {
+			return 0, fkMissing("recurring_payment", "recurring_payment_payer_id_fkey")
+		}
+
+		dayOfMonth := 0
+		if body.DayOfMonth != nil {
+			dayOfMonth = *body.DayOfMonth
+		}
+		var endDate *time.Time
+		if body.EndDate != nil {
+			end := toDate(*body.EndDate)
+			endDate = &end
+		}
+
+		id := st.next("recurring_payment")
+		st.recurring[id] = RecurringPayment{
+			ID:          id,
+			GroupID:     groupID,
+			Description: body.Description,
+			Amount:      body.Amount,
+			PayerID:     body.PayerID,
+			PayeeIDs:    slices.Clone(body.PayeeIDs),
+			Frequency:   body.Frequency,
+			DayOfMonth:  body.DayOfMonth,
+			StartDate:   toDate(body.StartDate),
+			EndDate:     endDate,
+			NextRun:     NextOccurrence(body.Frequency, dayOfMonth, body.StartDate, body.StartDate.AddDate(0, 0, -1)),
+		}
+		return id, nil
+	})
+}
+
+func (s *MemoryStore) DeleteRecurringPayment(ctx context.Context, groupID int, id int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		recurring, ok := st.recurring[id]
+		if !ok || recurring.GroupID != groupID {
+			return struct{}{}, ErrNotFound
+		}
+		st.deleteRecurring(id)
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// books every occurrence due on or before today, including ones missed while the server was down
+func (s *MemoryStore) MaterializeRecurringPayments(ctx context.Context, today time.Time) (int, error) {
+	ids, err := memRead(ctx, s, func(st *memState) ([]int, error) {
+		ids := []int{}
+		for _, id := range slices.Sorted(maps.Keys(st.recurring)) {
+			recurring := st.recurring[id]
+			if !recurring.NextRun.After(today) && (recurring.EndDate == nil || !recurring.NextRun.After(*recurring.EndDate)) && !recurring.Paused {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// one write per template so a broken one does not hold up the rest
+	count := 0
+	for _, id := range ids {
+		booked, err := memTx(ctx, s, func(st *memState) (int, error) {
+			return st.materializeRecurringPayment(ctx, s.L, id, today)
+		})
+		if err != nil {
+			s.L.ErrorContext(ctx, fmt.Sprintf("Materialize failed for recurring payment %v: %v", id, err))
+			continue
+		}
+		count += booked
+	}
+
+	return count, nil
+}
+
+func (st *memState) materializeRecurringPayment(ctx context.Context, L *slog.Logger, id int, today time.Time) (int, error) {
+	recurring, ok := st.recurring[id]
+	if !ok || recurring.NextRun.After(today) || recurring.Paused {
+		return 0, nil
+	}
+
+	for _, userID := range append([]int{recurring.PayerID}, recurring.PayeeIDs...) {
+		user, ok := st.users[userID]
+		if ok && !user.Active {
+			// it would fail the same way on every run until someone deletes or fixes it
+			recurring.Paused = true
+			st.recurring[recurring.ID] = recurring
+			L.WarnContext(ctx, fmt.Sprintf("Paused recurring payment %v, which involves inactive users", recurring.ID))
+			return 0, nil
+		}
+	}
+
+	dayOfMonth := 0
+	if recurring.DayOfMonth != nil {
+		dayOfMonth = *recurring.DayOfMonth
+	}
+
+	booked := 0
+	run := recurring.NextRun
+	for !run.After(today) && (recurring.EndDate == nil || !run.After(*recurring.EndDate)) {
+		key := memOccurrence{recurring.ID, run.Format(time.DateOnly)}
+		if _, ok := st.occurrences[key]; !ok {
+			paymentID, err := st.addPayment(recurring.GroupID, InsertPayment{
+				Description: fmt.Sprintf("%s (%s)", recurring.Description, run.Format(time.DateOnly)),
+				Amount:      recurring.Amount,
+				PayerID:     recurring.PayerID,
+				PayeeIDs:    recurring.PayeeIDs,
+			})
+			if err != nil {
+				return 0, err
+			}
+			st.occurrences[key] = &paymentID
+			booked++
+		}
+
+		run = NextOccurrence(recurring.Frequency, dayOfMonth, recurring.StartDate, run)
+	}
+
+	recurring.NextRun = run
+	st.recurring[recurring.ID] = recurring
+
+	return booked, nil
+}
+
+// People
+
+func (s *MemoryStore) GetPersonByID(ctx context.Context, id int) (Person, error) {
+	return memRead(ctx, s, func(st *memState) (Person, error) {
+		person, ok := st.people[id]
+		if !ok {
+			return Person{}, ErrNotFound
+		}
+		return person, nil
+	})
+}
+
+func (s *MemoryStore) CreatePerson(ctx context.Context, name string) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		id := st.next("people")
+		st.people[id] = Person{ID: id, Name: name}
+		return id, nil
+	})
+}
+
+func (s *MemoryStore) GetMembershipsByPersonID(ctx context.Context, id int) ([]Membership, error) {
+	return memRead(ctx, s, func(st *memState) ([]Membership, error) {
+		memberships := []Membership{}
+		for _, userID := range slices.Sorted(maps.Keys(st.users)) {
+			user := st.users[userID]
+			if user.PersonID == nil || *user.PersonID != id {
+				continue
+			}
+			memberships = append(memberships, Membership{
+				GroupID:   user.GroupID,
+				GroupName: st.groups[user.GroupID].Name,
+				UserID:    user.ID,
+				Balance:   float32(user.Balance),
+			})
+		}
+		slices.SortStableFunc(memberships, func(a, b Membership) int {
+			return a.GroupID - b.GroupID
+		})
+		return memberships, nil
+	})
+}
+
+func (s *MemoryStore) LinkUserToPerson(ctx context.Context, personID int, userID int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		user, ok := st.users[userID]
+		if !ok {
+			return struct{}{}, ErrNotFound
+		}
+		if _, ok := st.people[personID]; !ok {
+			return struct{}{}, fkMissing("users", "users_person_id_fkey")
+		}
+		for _, other := range st.users {
+			if other.ID != userID && other.GroupID == user.GroupID && other.PersonID != nil && *other.PersonID == personID {
+				return struct{}{}, duplicateKey("users", "users_group_id_person_id_key")
+			}
+		}
+
+		user.PersonID = &personID
+		user.Version++
+		st.putUser(user)
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func (s *MemoryStore) UnlinkUserFromPerson(ctx context.Context, personID int, userID int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		user, ok := st.users[userID]
+		if !ok || user.PersonID == nil || *user.PersonID != personID {
+			return struct{}{}, ErrNotFound
+		}
+
+		user.PersonID = nil
+		user.Version++
+		st.putUser(user)
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// Webhooks
+
+func cloneWebhook(webhook Webhook) Webhook {
+	webhook.Events = slices.Clone(webhook.Events)
+	return webhook
+}
+
+func (s *MemoryStore) GetWebhooksByGroupID(ctx context.Context, groupID int) ([]Webhook, error) {
+	return memRead(ctx, s, func(st *memState) ([]Webhook, error) {
+		webhooks := []Webhook{}
+		for _, id := range slices.Sorted(maps.Keys(st.webhooks)) {
+			if st.webhooks[id].GroupID == groupID {
+				webhooks = append(webhooks, cloneWebhook(st.webhooks[id]))
+			}
+		}
+		return webhooks, nil
+	})
+}
+
+func (s *MemoryStore) GetWebhookByID(ctx context.Context, groupID int, id int) (Webhook, error) {
+	return memRead(ctx, s, func(st *memState) (Webhook, error) {
+		webhook, ok := st.webhooks[id]
+		if !ok || webhook.GroupID != groupID {
+			return Webhook{}, ErrNotFound
+		}
+		return cloneWebhook(webhook), nil
+	})
+}
+
+func (s *MemoryStore) AddWebhook(ctx context.Context, groupID int, url string, secret string, events []string) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		if _, ok := st.groups[groupID]; !ok {
+			return 0, fkMissing("webhook", "webhook_group_id_fkey")
+		}
+
+		id := st.next("webhook")
+		st.webhooks[id] = Webhook{
+			ID:        id,
+			GroupID:   groupID,
+			URL:       url,
+			Secret:    secret,
+			Events:    slices.Clone(events),
+			CreatedAt: st.now,
+		}
+		return id, nil
+	})
+}
+
+func (s *MemoryStore) DeleteWebhook(ctx context.Context, groupID int, id int) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		webhook, ok := st.webhooks[id]
+		if !ok || webhook.GroupID != groupID {
+			return struct{}{}, ErrNotFound
+		}
+		st.deleteWebhookRow(id)
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func (s *MemoryStore) GetDeliveriesByWebhookID(ctx context.Context, id int) ([]WebhookDelivery, error) {
+	return memRead(ctx, s, func(st *memState) ([]WebhookDelivery, error) {
+		deliveries := []WebhookDelivery{}
+		ids := slices.Sorted(maps.Keys(st.deliveries))
+		slices.Reverse(ids)
+		for _, deliveryID := range ids {
+			delivery := st.deliveries[deliveryID]
+			if delivery.WebhookID != id {
+				continue
+			}
+			delivery.Event = st.events[delivery.EventID].Event
+			deliveries = append(deliveries, delivery)
+			if len(deliveries) == 100 {
+				break
+			}
+		}
+		return deliveries, nil
+	})
+}
+
+// queues a fresh delivery of the same event, leaving the original in the log
+func (s *MemoryStore) ReplayDelivery(ctx context.Context, webhookID int, id int) (int, error) {
+	return memTx(ctx, s, func(st *memState) (int, error) {
+		delivery, ok := st.deliveries[id]
+		if !ok || delivery.WebhookID != webhookID {
+			return 0, ErrNotFound
+		}
+		return st.queueDelivery(delivery.WebhookID, delivery.EventID), nil
+	})
+}
+
+// leases due deliveries so that the next claim skips them while they are being sent
+func (s *MemoryStore) ClaimPendingDeliveries(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	return memTx(ctx, s, func(st *memState) ([]PendingDelivery, error) {
+		due := []WebhookDelivery{}
+		for _, delivery := range st.deliveries {
+			if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(st.now) {
+				due = append(due, delivery)
+			}
+		}
+		slices.SortFunc(due, func(a, b WebhookDelivery) int {
+			if c := a.NextAttemptAt.Compare(b.NextAttemptAt); c != 0 {
+				return c
+			}
+			return a.ID - b.ID
+		})
+		if len(due) > limit {
+			due = due[:limit]
+		}
+		slices.SortFunc(due, func(a, b WebhookDelivery) int {
+			return a.ID - b.ID
+		})
+
+		deliveries := []PendingDelivery{}
+		for _, delivery := range due {
+			delivery.NextAttemptAt = st.now.Add(lease)
+			st.deliveries[delivery.ID] = delivery
+
+			webhook := st.webhooks[delivery.WebhookID]
+			event := st.events[delivery.EventID]
+			deliveries = append(deliveries, PendingDelivery{
+				ID:             delivery.ID,
+				Attempts:       delivery.Attempts,
+				URL:            webhook.URL,
+				Secret:         webhook.Secret,
+				EventID:        event.ID,
+				GroupID:        event.GroupID,
+				Event:          event.Event,
+				Payload:        event.Payload,
+				EventCreatedAt: event.CreatedAt,
+			})
+		}
+		return deliveries, nil
+	})
+}
+
+// records the outcome of one attempt; retry is when to try again, or nil to give up
+func (s *MemoryStore) RecordDeliveryAttempt(ctx context.Context, id int, statusCode *int, deliveryErr *string, succeeded bool, retry *time.Time) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		delivery, ok := st.deliveries[id]
+		if !ok {
+			return struct{}{}, errors.New("unexpected number of rows affected")
+		}
+
+		delivery.Status = DeliveryFailed
+		if succeeded {
+			delivery.Status = DeliverySucceeded
+		} else if retry != nil {
+			delivery.Status = DeliveryPending
+		}
+		delivery.Attempts++
+		if retry != nil {
+			delivery.NextAttemptAt = *retry
+		}
+		delivery.LastStatusCode = nil
+		if statusCode != nil {
+			code := *statusCode
+			delivery.LastStatusCode = &code
+		}
+		delivery.LastError = nil
+		if deliveryErr != nil {
+			message := *deliveryErr
+			delivery.LastError = &message
+		}
+		delivery.DeliveredAt = nil
+		if succeeded {
+			now := st.now
+			delivery.DeliveredAt = &now
+		}
+		st.deliveries[id] = delivery
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// Events
+
+func (s *MemoryStore) GetLatestEventID(ctx context.Context, groupID int) (int, error) {
+	return memRead(ctx, s, func(st *memState) (int, error) {
+		latest := 0
+		for id, event := range st.events {
+			if event.GroupID == groupID && id > latest {
+				latest = id
+			}
+		}
+		return latest, nil
+	})
+}
+
+func (s *MemoryStore) GetEventsSince(ctx context.Context, groupID int, afterID int, limit int) ([]GroupEvent, error) {
+	return memRead(ctx, s, func(st *memState) ([]GroupEvent, error) {
+		events := []GroupEvent{}
+		for _, id := range slices.Sorted(maps.Keys(st.events)) {
+			event := st.events[id]
+			if event.GroupID == groupID && id > afterID && len(events) < limit {
+				events = append(events, event)
+			}
+		}
+		return events, nil
+	})
+}
+
+// ListenEvents passes on events as writes to this store succeed, until ctx is done
+func (s *MemoryStore) ListenEvents(ctx context.Context, listener EventListener) error {
+	return s.events.listen(ctx, listener)
+}
+
+// Idempotency keys
+
+func (s *MemoryStore) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, retention time.Duration) (bool, IdempotentResponse, error) {
+	type result struct {
+		reserved bool
+		existing IdempotentResponse
+	}
+
+	res, err := memTx(ctx, s, func(st *memState) (result, error) {
+		// an expired key is free to use again
+		existing, ok := st.idempotency[key]
+		if ok && existing.CreatedAt.Before(st.now.Add(-retention)) {
+			ok = false
+		}
+		if ok {
+			return result{existing: existing}, nil
+		}
+
+		st.idempotency[key] = IdempotentResponse{
+			Key:         key,
+			Fingerprint: fingerprint,
+			CreatedAt:   st.now,
+		}
+		return result{reserved: true}, nil
+	})
+	return res.reserved, res.existing, err
+}
+
+func (s *MemoryStore) SaveIdempotentResponse(ctx context.Context, key string, statusCode int, headers map[string][]string, body []byte) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		response, ok := st.idempotency[key]
+		if !ok {
+			return struct{}{}, nil
+		}
+
+		response.StatusCode = &statusCode
+		response.Headers = maps.Clone(headers)
+		response.Body = slices.Clone(body)
+		st.idempotency[key] = response
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a reserved key, so a request that failed on our side can be retried
+func (s *MemoryStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := memTx(ctx, s, func(st *memState) (struct{}, error) {
+		delete(st.idempotency, key)
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func (s *MemoryStore) DeleteExpiredIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	return memTx(ctx, s, func(st *memState) (int64, error) {
+		var deleted int64
+		for key, response := range st.idempotency {
+			if response.CreatedAt.Before(st.now.Add(-retention)) {
+				delete(st.idempotency, key)
+				deleted++
+			}
+		}
+		return deleted, nil
+	})
+}
@@ -1,24 +1,135 @@
 package database
 
+import "time"
+
 type Group struct {
+	ID      int    `db:"id"`
+	Name    string `db:"name"`
+	Version int    `db:"version"`
+}
+
+type GroupListing struct {
+	ID             int       `db:"id"`
+	Name           string    `db:"name"`
+	MemberCount    int       `db:"member_count"`
+	TotalSpent     float32   `db:"total_spent"`
+	Balance        float32   `db:"balance"`
+	LastActivityAt time.Time `db:"last_activity_at"`
+}
+
+type User struct {
+	ID       int     `db:"id"`
+	PersonID *int    `db:"person_id"`
+	Name     string  `db:"name"`
+	Balance  float32 `db:"balance"`
+	Active   bool    `db:"active"`
+	Version  int     `db:"version"`
+}
+
+type Person struct {
 	ID   int    `db:"id"`
 	Name string `db:"name"`
 }
 
-type User struct {
-	ID      int     `db:"id"`
-	Name    string  `db:"name"`
-	Balance float32 `db:"balance"`
+type Membership struct {
+	GroupID   int     `db:"group_id"`
+	GroupName string  `db:"group_name"`
+	UserID    int     `db:"user_id"`
+	Balance   float32 `db:"balance"`
 }
 
 type Payment struct {
 	ID            int       `db:"id"`
+	GroupID       int       `db:"group_id"`
 	Description   *string   `db:"description"`
 	Amount        float32   `db:"amount"`
 	PayerID       int       `db:"payer_id"`
 	PayerName     string    `db:"payer_name"`
 	PayerBalance  float32   `db:"payer_balance"`
+	PayerActive   bool      `db:"payer_active"`
+	PayerVersion  int       `db:"payer_version"`
 	PayeeIDs      []int     `db:"payee_ids"`
 	PayeeNames    []string  `db:"payee_names"`
 	PayeeBalances []float32 `db:"payee_balances"`
+	PayeeActives  []bool    `db:"payee_actives"`
+	PayeeVersions []int     `db:"payee_versions"`
+	Version       int       `db:"version"`
+}
+
+type GroupSummary struct {
+	Group          Group
+	Users          []User
+	RecentPayments []Payment
+	PaymentCount   int
+	TotalSpent     float32
+}
+
+type RecurringPayment struct {
+	ID          int        `db:"id"`
+	GroupID     int        `db:"group_id"`
+	Description string     `db:"description"`
+	Amount      float32    `db:"amount"`
+	PayerID     int        `db:"payer_id"`
+	PayeeIDs    []int      `db:"payee_ids"`
+	Frequency   string     `db:"frequency"`
+	DayOfMonth  *int       `db:"day_of_month"`
+	StartDate   time.Time  `db:"start_date"`
+	EndDate     *time.Time `db:"end_date"`
+	NextRun     time.Time  `db:"next_run"`
+	// set once a member turned inactive, which stops the template from being booked
+	Paused bool `db:"paused"`
+}
+
+type GroupEvent struct {
+	ID        int       `db:"id"`
+	GroupID   int       `db:"group_id"`
+	Event     string    `db:"event"`
+	Payload   []byte    `db:"payload"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type Webhook struct {
+	ID        int       `db:"id"`
+	GroupID   int       `db:"group_id"`
+	URL       string    `db:"url"`
+	Secret    string    `db:"secret"`
+	Events    []string  `db:"events"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int        `db:"id"`
+	WebhookID      int        `db:"webhook_id"`
+	EventID        int        `db:"event_id"`
+	Event          string     `db:"event"`
+	Status         string     `db:"status"`
+	Attempts       int        `db:"attempts"`
+	NextAttemptAt  time.Time  `db:"next_attempt_at"`
+	LastStatusCode *int       `db:"last_status_code"`
+	LastError      *string    `db:"last_error"`
+	DeliveredAt    *time.Time `db:"delivered_at"`
+}
+
+// a delivery claimed by the dispatcher, with everything needed to send it
+type PendingDelivery struct {
+	ID             int       `db:"id"`
+	Attempts       int       `db:"attempts"`
+	URL            string    `db:"url"`
+	Secret         string    `db:"secret"`
+	EventID        int       `db:"event_id"`
+	GroupID        int       `db:"group_id"`
+	Event          string    `db:"event"`
+	Payload        []byte    `db:"payload"`
+	EventCreatedAt time.Time `db:"event_created_at"`
+}
+
+// the stored outcome of a request made with an Idempotency-Key.
+// StatusCode is nil while the first request is still running
+type IdempotentResponse struct {
+	Key         string              `db:"key"`
+	Fingerprint string              `db:"fingerprint"`
+	StatusCode  *int                `db:"status_code"`
+	Headers     map[string][]string `db:"headers"`
+	Body        []byte              `db:"body"`
+	CreatedAt   time.Time           `db:"created_at"`
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -16,14 +17,20 @@ func GetPaymentsByGroupID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger,
 	query := `
 	SELECT
 		p.id,
+		p.group_id            AS group_id,
 		p.description         AS description,
 		p.amount              AS amount,
 		u.id                  AS payer_id,
 		u.name                AS payer_name,
 		u.balance             AS payer_balance,
+		u.active              AS payer_active,
+		u.version             AS payer_version,
 		ARRAY_AGG(uu.id)      AS payee_ids,
 		ARRAY_AGG(uu.name)    AS payee_names,
-		ARRAY_AGG(uu.balance) AS payee_balances
+		ARRAY_AGG(uu.balance) AS payee_balances,
+		ARRAY_AGG(uu.active)  AS payee_actives,
+		ARRAY_AGG(uu.version) AS payee_versions,
+		p.version             AS version
 	FROM payment AS p
 	LEFT JOIN users AS u
 		ON p.payer_id = u.id
@@ -32,21 +39,21 @@ func GetPaymentsByGroupID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger,
 	LEFT JOIN users AS uu
 		ON up.user_id = uu.id
 	WHERE p.group_id = @id
-	GROUP BY p.id, p.description, p.amount, u.name, u.id, u.balance`
+	GROUP BY p.id, p.group_id, p.description, p.amount, p.version, u.name, u.id, u.balance, u.active, u.version`
 	args := pgx.StrictNamedArgs{
 		"id": id,
 	}
 
-	L.Info("GetPaymentsByGroupID", "query", query, "args", args)
+	L.DebugContext(ctx, "GetPaymentsByGroupID", "query", query, "args", args)
 	rows, err := db.Query(ctx, query, args)
 	if err != nil {
-		L.Error(fmt.Sprintf("Get failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
 		return []Payment{}, err
 	}
 
 	payments, err := pgx.CollectRows(rows, pgx.RowToStructByName[Payment])
 	if err != nil {
-		L.Error(fmt.Sprintf("Binding failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
 		return []Payment{}, err
 	}
 
@@ -54,17 +61,27 @@ func GetPaymentsByGroupID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger,
 }
 
 func GetPaymentByID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) (Payment, error) {
+	return getPaymentByID(ctx, db, L, id)
+}
+
+func getPaymentByID(ctx context.Context, db querier, L *slog.Logger, id int) (Payment, error) {
 	query := `
 	SELECT
 		p.id,
+		p.group_id            AS group_id,
 		p.description         AS description,
 		p.amount              AS amount,
 		u.id                  AS payer_id,
 		u.name                AS payer_name,
 		u.balance             AS payer_balance,
+		u.active              AS payer_active,
+		u.version             AS payer_version,
 		ARRAY_AGG(uu.id)      AS payee_ids,
 		ARRAY_AGG(uu.name)    AS payee_names,
-		ARRAY_AGG(uu.balance) AS payee_balances
+		ARRAY_AGG(uu.balance) AS payee_balances,
+		ARRAY_AGG(uu.active)  AS payee_actives,
+		ARRAY_AGG(uu.version) AS payee_versions,
+		p.version             AS version
 	FROM payment AS p
 	LEFT JOIN users AS u
 		ON p.payer_id = u.id
@@ -73,21 +90,21 @@ func GetPaymentByID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id in
 	LEFT JOIN users AS uu
 		ON up.user_id = uu.id
 	WHERE p.id = @id
-	GROUP BY p.id, p.description, p.amount, u.name, u.id, u.balance`
+	GROUP BY p.id, p.group_id, p.description, p.amount, p.version, u.name, u.id, u.balance, u.active, u.version`
 	args := pgx.StrictNamedArgs{
 		"id": id,
 	}
 
-	L.Info("GetPaymentsByID", "query", query, "args", args)
+	L.DebugContext(ctx, "GetPaymentsByID", "query", query, "args", args)
 	rows, err := db.Query(ctx, query, args)
 	if err != nil {
-		L.Error(fmt.Sprintf("Get failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
 		return Payment{}, err
 	}
 
 	payment, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Payment])
 	if err != nil {
-		L.Error(fmt.Sprintf("Binding failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
 		return Payment{}, err
 	}
 
@@ -103,245 +120,362 @@ type InsertPayment struct {
 
 func AddPaymentByGroupId(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int, body InsertPayment) (int, error) {
 	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
-		// insert payment
-		paymentQuery := `INSERT INTO payment (group_id, description, amount, payer_id)
+		return addPayment(ctx, tx, L, id, body)
+	})
+}
+
+func addPayment(ctx context.Context, tx pgx.Tx, L *slog.Logger, id int, body InsertPayment) (int, error) {
+	// the lock makes a concurrent deactivation wait for this payment, so it sees the balance it leaves.
+	// FOR KEY SHARE rather than FOR SHARE, since two payments for one member would deadlock
+	// upgrading FOR SHARE to the balance update below
+	activeQuery := "SELECT active FROM users WHERE id = ANY(@ids) AND group_id = @groupID ORDER BY id FOR KEY SHARE"
+	activeArgs := pgx.StrictNamedArgs{
+		"ids":     append([]int{body.PayerID}, body.PayeeIDs...),
+		"groupID": id,
+	}
+	L.DebugContext(ctx, "AddPaymentByGroupId.active", "query", activeQuery, "args", activeArgs)
+	rows, err := tx.Query(ctx, activeQuery, activeArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return 0, err
+	}
+	actives, err := pgx.CollectRows(rows, pgx.RowTo[bool])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return 0, err
+	}
+	if slices.Contains(actives, false) {
+		L.ErrorContext(ctx, "Insert failed: payment involves inactive users")
+		return 0, ErrUserInactive
+	}
+
+	// insert payment
+	paymentQuery := `INSERT INTO payment (group_id, description, amount, payer_id)
 VALUES (@id, @description, @amount, @payer_id)
 RETURNING id`
-		paymentArgs := pgx.StrictNamedArgs{
-			"id":          id,
-			"description": body.Description,
-			"amount":      body.Amount,
-			"payer_id":    body.PayerID,
-		}
+	paymentArgs := pgx.StrictNamedArgs{
+		"id":          id,
+		"description": body.Description,
+		"amount":      body.Amount,
+		"payer_id":    body.PayerID,
+	}
 
-		var paymentID int
-		L.Info("AddPaymentByGroupId.payment", "query", paymentQuery, "args", paymentArgs)
-		err := tx.QueryRow(ctx, paymentQuery, paymentArgs).Scan(&paymentID)
-		if err != nil {
-			L.Error(fmt.Sprintf("Insert failed: %v", err))
-			return 0, err
-		}
+	var paymentID int
+	L.DebugContext(ctx, "AddPaymentByGroupId.payment", "query", paymentQuery, "args", paymentArgs)
+	err = tx.QueryRow(ctx, paymentQuery, paymentArgs).Scan(&paymentID)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+		return 0, err
+	}
 
-		// insert junction
-		upArgs := []any{}
-		upValues := []string{}
-		for _, payeeID := range body.PayeeIDs {
-			upArgs = append(upArgs, payeeID)
-			upArgs = append(upArgs, paymentID)
-			upValues = append(upValues, "($"+strconv.Itoa(len(upArgs)-1)+", $"+strconv.Itoa(len(upArgs))+")")
-		}
-		upQuery := "INSERT INTO users_payment (user_id, payment_id) VALUES " + strings.Join(upValues, ", ")
-		L.Info("AddPaymentByGroupId.users_payment", "query", upQuery, "args", upArgs)
-		cmdTag, err := tx.Exec(ctx, upQuery, upArgs...)
-		if err != nil {
-			L.Error(fmt.Sprintf("Insert failed: %v", err))
-			return 0, err
-		}
-		if cmdTag.RowsAffected() != int64(len(body.PayeeIDs)) {
-			L.Error("Unexpected number of rows affected in users_payment table")
-			return 0, errors.New("unexpected number of rows affected")
-		}
+	// insert junction
+	upArgs := []any{}
+	upValues := []string{}
+	for _, payeeID := range body.PayeeIDs {
+		upArgs = append(upArgs, payeeID)
+		upArgs = append(upArgs, paymentID)
+		upValues = append(upValues, "($"+strconv.Itoa(len(upArgs)-1)+", $"+strconv.Itoa(len(upArgs))+")")
+	}
+	upQuery := "INSERT INTO users_payment (user_id, payment_id) VALUES " + strings.Join(upValues, ", ")
+	L.DebugContext(ctx, "AddPaymentByGroupId.users_payment", "query", upQuery, "args", upArgs)
+	cmdTag, err := tx.Exec(ctx, upQuery, upArgs...)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+		return 0, err
+	}
+	if cmdTag.RowsAffected() != int64(len(body.PayeeIDs)) {
+		L.ErrorContext(ctx, "Unexpected number of rows affected in users_payment table")
+		return 0, errors.New("unexpected number of rows affected")
+	}
 
-		// update balance
-		payeeBalance := body.Amount / float32(len(body.PayeeIDs))
-		payeeQuery := "UPDATE users SET balance = balance + @payeeBalance WHERE id = ANY(@payeeIDs)"
-		payeeArgs := pgx.StrictNamedArgs{
-			"payeeBalance": payeeBalance,
-			"payeeIDs":     body.PayeeIDs,
-		}
-		L.Info("AddPaymentByGroupId.payees", "query", payeeQuery, "args", payeeArgs)
-		cmdTag, err = tx.Exec(ctx, payeeQuery, payeeArgs)
-		if err != nil {
-			L.Error(fmt.Sprintf("Update failed: %v", err))
-			return 0, err
-		}
-		if cmdTag.RowsAffected() != int64(len(body.PayeeIDs)) {
-			L.Error("Unexpected number of rows affected in users table")
-			return 0, errors.New("unexpected number of rows affected")
-		}
+	// update balance
+	payeeBalance := body.Amount / float32(len(body.PayeeIDs))
+	payeeQuery := "UPDATE users SET balance = balance + @payeeBalance WHERE id = ANY(@payeeIDs)"
+	payeeArgs := pgx.StrictNamedArgs{
+		"payeeBalance": payeeBalance,
+		"payeeIDs":     body.PayeeIDs,
+	}
+	L.DebugContext(ctx, "AddPaymentByGroupId.payees", "query", payeeQuery, "args", payeeArgs)
+	cmdTag, err = tx.Exec(ctx, payeeQuery, payeeArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+		return 0, err
+	}
+	if cmdTag.RowsAffected() != int64(len(body.PayeeIDs)) {
+		L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+		return 0, errors.New("unexpected number of rows affected")
+	}
 
-		payerQuery := "UPDATE users SET balance = balance - @amount WHERE id = @id"
-		payerArgs := pgx.StrictNamedArgs{
-			"amount": body.Amount,
-			"id":     body.PayerID,
-		}
-		L.Info("AddPaymentByGroupId.payer", "query", payerQuery, "args", payerArgs)
-		cmdTag, err = tx.Exec(ctx, payerQuery, payerArgs)
-		if err != nil {
-			L.Error(fmt.Sprintf("Update failed: %v", err))
-			return 0, err
-		}
-		if cmdTag.RowsAffected() != 1 {
-			L.Error("Unexpected number of rows affected in users table")
-			return 0, errors.New("unexpected number of rows affected")
-		}
+	payerQuery := "UPDATE users SET balance = balance - @amount WHERE id = @id"
+	payerArgs := pgx.StrictNamedArgs{
+		"amount": body.Amount,
+		"id":     body.PayerID,
+	}
+	L.DebugContext(ctx, "AddPaymentByGroupId.payer", "query", payerQuery, "args", payerArgs)
+	cmdTag, err = tx.Exec(ctx, payerQuery, payerArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+		return 0, err
+	}
+	if cmdTag.RowsAffected() != 1 {
+		L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+		return 0, errors.New("unexpected number of rows affected")
+	}
 
-		return paymentID, nil
+	err = enqueueEvent(ctx, tx, L, id, EventPaymentCreated, map[string]any{
+		"payment_id":  paymentID,
+		"description": body.Description,
+		"amount":      body.Amount,
+		"payer_id":    body.PayerID,
+		"payee_ids":   body.PayeeIDs,
 	})
+	if err != nil {
+		return 0, err
+	}
+
+	return paymentID, nil
 }
 
-func PatchPayment(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, payment Payment, amount *float32, description *string) error {
+// PatchPayment changes a payment's amount and description. The payment is re-read under a row lock,
+// so the balance diff is taken from its current amount. When version is set, the payment must
+// still be at that version.
+func PatchPayment(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, paymentID int, version *int, amount *float32, description *string) error {
 	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
-		if description != nil {
-			query := "UPDATE payment SET description = @description WHERE id = @id"
-			args := pgx.StrictNamedArgs{
-				"description": *description,
-				"id":          payment.ID,
-			}
-			L.Info("PatchPayment.description", "query", query, "args", args)
-			cmdTag, err := tx.Exec(ctx, query, args)
-			if err != nil {
-				L.Error(fmt.Sprintf("Update failed: %v", err))
-				return struct{}{}, err
-			}
-			if cmdTag.RowsAffected() != 1 {
-				L.Error("Unexpected number of rows affected in users table")
-				return struct{}{}, errors.New("unexpected number of rows affected")
-			}
-		}
+		return struct{}{}, patchPayment(ctx, tx, L, groupID, paymentID, version, amount, description)
+	})
+	return err
+}
 
-		if amount != nil {
-			// update payment
-			paymentQuery := "UPDATE payment SET amount = @amount WHERE id = @id"
-			paymentArgs := pgx.StrictNamedArgs{
-				"amount": amount,
-				"id":     payment.ID,
-			}
-			L.Info("PatchPayment.paymentAmount", "query", paymentQuery, "args", paymentArgs)
-			cmdTag, err := tx.Exec(ctx, paymentQuery, paymentArgs)
-			if err != nil {
-				L.Error(fmt.Sprintf("Update failed: %v", err))
-				return struct{}{}, err
-			}
-			if cmdTag.RowsAffected() != 1 {
-				L.Error("Unexpected number of rows affected in users table")
-				return struct{}{}, errors.New("unexpected number of rows affected")
-			}
+func patchPayment(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, paymentID int, version *int, amount *float32, description *string) error {
+	payment, err := lockPayment(ctx, tx, L, groupID, paymentID, version)
+	if err != nil {
+		return err
+	}
+	if amount != nil && hasInactiveUser(payment) {
+		L.ErrorContext(ctx, fmt.Sprintf("Patch failed: payment %v involves inactive users", paymentID))
+		return ErrUserInactive
+	}
 
-			// update payer balance
-			amtDiff := *amount - payment.Amount
-			payerQuery := "UPDATE users SET balance = balance - @diff WHERE id = @id"
-			payerArgs := pgx.StrictNamedArgs{
-				"diff": amtDiff,
-				"id":   payment.PayerID,
-			}
-			L.Info("PatchPayment.payerBalance", "query", payerQuery, "args", payerArgs)
-			cmdTag, err = tx.Exec(ctx, payerQuery, payerArgs)
-			if err != nil {
-				L.Error(fmt.Sprintf("Update failed: %v", err))
-				return struct{}{}, err
-			}
-			if cmdTag.RowsAffected() != 1 {
-				L.Error("Unexpected number of rows affected in users table")
-				return struct{}{}, errors.New("unexpected number of rows affected")
-			}
+	query := "UPDATE payment SET version = version + 1 WHERE id = @id"
+	args := pgx.StrictNamedArgs{
+		"id": payment.ID,
+	}
+	L.DebugContext(ctx, "PatchPayment.version", "query", query, "args", args)
+	_, err = tx.Exec(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+		return err
+	}
 
-			// update payee balances
-			amtDiffPer := amtDiff / float32(len(payment.PayeeIDs))
-			payeeQuery := "UPDATE users SET balance = balance + @amtDiffPer WHERE id = ANY(@ids)"
-			payeeArgs := pgx.StrictNamedArgs{
-				"amtDiffPer": amtDiffPer,
-				"ids":        payment.PayeeIDs,
-			}
-			L.Info("PatchPayment.payeeBalance", "query", payeeQuery, "args", payeeArgs)
-			cmdTag, err = tx.Exec(ctx, payeeQuery, payeeArgs)
-			if err != nil {
-				L.Error(fmt.Sprintf("Update failed: %v", err))
-				return struct{}{}, err
-			}
-			if cmdTag.RowsAffected() != int64(len(payment.PayeeIDs)) {
-				L.Error("Unexpected number of rows affected in users table")
-				return struct{}{}, errors.New("unexpected number of rows affected")
-			}
+	if description != nil {
+		query := "UPDATE payment SET description = @description WHERE id = @id"
+		args := pgx.StrictNamedArgs{
+			"description": *description,
+			"id":          payment.ID,
 		}
+		L.DebugContext(ctx, "PatchPayment.description", "query", query, "args", args)
+		cmdTag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return err
+		}
+		if cmdTag.RowsAffected() != 1 {
+			L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+			return errors.New("unexpected number of rows affected")
+		}
+	}
 
-		return struct{}{}, nil
-	})
-
-	return err
-}
-
-func DeletePayment(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, payment Payment) error {
-	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
-		// update payees
-		payeeBalance := payment.Amount / float32(len(payment.PayeeIDs))
-		payeeQuery := "UPDATE users SET balance = balance - @payeeBalance WHERE id = ANY(@payeeIDs)"
-		payeeArgs := pgx.StrictNamedArgs{
-			"payeeBalance": payeeBalance,
-			"payeeIDs":     payment.PayeeIDs,
+	if amount != nil {
+		// update payment
+		paymentQuery := "UPDATE payment SET amount = @amount WHERE id = @id"
+		paymentArgs := pgx.StrictNamedArgs{
+			"amount": amount,
+			"id":     payment.ID,
 		}
-		L.Info("DeletePayment.payees", "query", payeeQuery, "args", payeeArgs)
-		cmdTag, err := tx.Exec(ctx, payeeQuery, payeeArgs)
+		L.DebugContext(ctx, "PatchPayment.paymentAmount", "query", paymentQuery, "args", paymentArgs)
+		cmdTag, err := tx.Exec(ctx, paymentQuery, paymentArgs)
 		if err != nil {
-			L.Error(fmt.Sprintf("Update failed: %v", err))
-			return struct{}{}, err
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return err
 		}
-		if cmdTag.RowsAffected() != int64(len(payment.PayeeIDs)) {
-			L.Error("Unexpected number of rows affected in users table")
-			return struct{}{}, errors.New("unexpected number of rows affected")
+		if cmdTag.RowsAffected() != 1 {
+			L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+			return errors.New("unexpected number of rows affected")
 		}
 
-		// update payer
-		payerQuery := "UPDATE users SET balance = balance + @amount WHERE id = @id"
+		// update payer balance
+		amtDiff := *amount - payment.Amount
+		payerQuery := "UPDATE users SET balance = balance - @diff WHERE id = @id"
 		payerArgs := pgx.StrictNamedArgs{
-			"amount": payment.Amount,
-			"id":     payment.PayerID,
+			"diff": amtDiff,
+			"id":   payment.PayerID,
 		}
-		L.Info("AddPaymentByGroupId.payer", "query", payerQuery, "args", payerArgs)
+		L.DebugContext(ctx, "PatchPayment.payerBalance", "query", payerQuery, "args", payerArgs)
 		cmdTag, err = tx.Exec(ctx, payerQuery, payerArgs)
 		if err != nil {
-			L.Error(fmt.Sprintf("Update failed: %v", err))
-			return struct{}{}, err
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return err
 		}
 		if cmdTag.RowsAffected() != 1 {
-			L.Error("Unexpected number of rows affected in users table")
-			return struct{}{}, errors.New("unexpected number of rows affected")
+			L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+			return errors.New("unexpected number of rows affected")
 		}
 
-		// remove payment
-		deleteQuery := "DELETE FROM payment WHERE id = @id"
-		deleteArgs := pgx.StrictNamedArgs{
-			"id": payment.ID,
+		// update payee balances
+		amtDiffPer := amtDiff / float32(len(payment.PayeeIDs))
+		payeeQuery := "UPDATE users SET balance = balance + @amtDiffPer WHERE id = ANY(@ids)"
+		payeeArgs := pgx.StrictNamedArgs{
+			"amtDiffPer": amtDiffPer,
+			"ids":        payment.PayeeIDs,
 		}
-		L.Info("DeletePayment.delete", "query", deleteQuery, "args", deleteArgs)
-		cmdTag, err = tx.Exec(ctx, deleteQuery, deleteArgs)
+		L.DebugContext(ctx, "PatchPayment.payeeBalance", "query", payeeQuery, "args", payeeArgs)
+		cmdTag, err = tx.Exec(ctx, payeeQuery, payeeArgs)
 		if err != nil {
-			L.Error(fmt.Sprintf("Delete failed: %v", err))
-			return struct{}{}, err
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return err
 		}
-		if cmdTag.RowsAffected() != 1 {
-			L.Error("Unexpected number of rows affected in users table")
-			return struct{}{}, errors.New("unexpected number of rows affected")
+		if cmdTag.RowsAffected() != int64(len(payment.PayeeIDs)) {
+			L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+			return errors.New("unexpected number of rows affected")
 		}
+	}
 
-		return struct{}{}, nil
+	newAmount, newDescription := payment.Amount, payment.Description
+	if amount != nil {
+		newAmount = *amount
+	}
+	if description != nil {
+		newDescription = description
+	}
+	err = enqueueEvent(ctx, tx, L, payment.GroupID, EventPaymentUpdated, map[string]any{
+		"payment_id":  payment.ID,
+		"description": newDescription,
+		"amount":      newAmount,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeletePayment removes a payment and reverses its effect on balances, using the payment as it is
+// under a row lock. When version is set, the payment must still be at that version.
+func DeletePayment(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, paymentID int, version *int) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		return struct{}{}, deletePayment(ctx, tx, L, groupID, paymentID, version)
 	})
 	return err
 }
 
+func deletePayment(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, paymentID int, version *int) error {
+	payment, err := lockPayment(ctx, tx, L, groupID, paymentID, version)
+	if err != nil {
+		return err
+	}
+	if hasInactiveUser(payment) {
+		L.ErrorContext(ctx, fmt.Sprintf("Delete failed: payment %v involves inactive users", paymentID))
+		return ErrUserInactive
+	}
+
+	// update payees
+	payeeBalance := payment.Amount / float32(len(payment.PayeeIDs))
+	payeeQuery := "UPDATE users SET balance = balance - @payeeBalance WHERE id = ANY(@payeeIDs)"
+	payeeArgs := pgx.StrictNamedArgs{
+		"payeeBalance": payeeBalance,
+		"payeeIDs":     payment.PayeeIDs,
+	}
+	L.DebugContext(ctx, "DeletePayment.payees", "query", payeeQuery, "args", payeeArgs)
+	cmdTag, err := tx.Exec(ctx, payeeQuery, payeeArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+		return err
+	}
+	if cmdTag.RowsAffected() != int64(len(payment.PayeeIDs)) {
+		L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+		return errors.New("unexpected number of rows affected")
+	}
+
+	// update payer
+	payerQuery := "UPDATE users SET balance = balance + @amount WHERE id = @id"
+	payerArgs := pgx.StrictNamedArgs{
+		"amount": payment.Amount,
+		"id":     payment.PayerID,
+	}
+	L.DebugContext(ctx, "AddPaymentByGroupId.payer", "query", payerQuery, "args", payerArgs)
+	cmdTag, err = tx.Exec(ctx, payerQuery, payerArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+		return err
+	}
+	if cmdTag.RowsAffected() != 1 {
+		L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+		return errors.New("unexpected number of rows affected")
+	}
+
+	// remove payment
+	deleteQuery := "DELETE FROM payment WHERE id = @id"
+	deleteArgs := pgx.StrictNamedArgs{
+		"id": payment.ID,
+	}
+	L.DebugContext(ctx, "DeletePayment.delete", "query", deleteQuery, "args", deleteArgs)
+	cmdTag, err = tx.Exec(ctx, deleteQuery, deleteArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+		return err
+	}
+	if cmdTag.RowsAffected() != 1 {
+		L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+		return errors.New("unexpected number of rows affected")
+	}
+
+	err = enqueueEvent(ctx, tx, L, payment.GroupID, EventPaymentDeleted, map[string]any{
+		"payment_id": payment.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func DeleteAllPayments(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int) error {
 	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
 		// remove all payments
-		deleteQuery := "DELETE FROM payment WHERE group_id = @id"
+		deleteQuery := "DELETE FROM payment WHERE group_id = @id RETURNING id"
 		deleteArgs := pgx.StrictNamedArgs{
 			"id": groupID,
 		}
-		L.Info("DeleteAllPayments.delete", "query", deleteQuery, "args", deleteArgs)
-		_, err := tx.Exec(ctx, deleteQuery, deleteArgs)
+		L.DebugContext(ctx, "DeleteAllPayments.delete", "query", deleteQuery, "args", deleteArgs)
+		rows, err := tx.Query(ctx, deleteQuery, deleteArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+			return struct{}{}, err
+		}
+		paymentIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
 		if err != nil {
-			L.Error(fmt.Sprintf("Delete failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
 			return struct{}{}, err
 		}
 
+		for _, paymentID := range paymentIDs {
+			err = enqueueEvent(ctx, tx, L, groupID, EventPaymentDeleted, map[string]any{
+				"payment_id": paymentID,
+			})
+			if err != nil {
+				return struct{}{}, err
+			}
+		}
+
 		// clear all balances
 		userQuery := "UPDATE users SET balance = 0 WHERE group_id = @id"
 		userArgs := pgx.StrictNamedArgs{
 			"id": groupID,
 		}
-		L.Info("DeleteAllPayments.deleteAll", "query", userQuery, "args", userArgs)
+		L.DebugContext(ctx, "DeleteAllPayments.deleteAll", "query", userQuery, "args", userArgs)
 		_, err = tx.Exec(ctx, userQuery, userArgs)
 		if err != nil {
-			L.Error(fmt.Sprintf("Delete failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
 			return struct{}{}, err
 		}
 
@@ -350,3 +484,31 @@ func DeleteAllPayments(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, gr
 
 	return err
 }
+
+// lockPayment locks the payment row, checks its version and reads the payment as it is now
+func lockPayment(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, paymentID int, version *int) (Payment, error) {
+	query := "SELECT version FROM payment WHERE id = @id AND group_id = @groupID FOR UPDATE"
+	args := pgx.StrictNamedArgs{
+		"id":      paymentID,
+		"groupID": groupID,
+	}
+	err := lockVersion(ctx, tx, L, "lockPayment", query, args, version)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	return getPaymentByID(ctx, tx, L, paymentID)
+}
+
+// payments touching an inactive user are frozen so their balance stays settled
+func hasInactiveUser(payment Payment) bool {
+	if !payment.PayerActive {
+		return true
+	}
+	for _, active := range payment.PayeeActives {
+		if !active {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func GetPersonByID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) (Person, error) {
+	query := "SELECT id, name FROM people WHERE people.id = @id"
+	args := pgx.StrictNamedArgs{
+		"id": id,
+	}
+
+	L.DebugContext(ctx, "GetPersonByID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return Person{}, err
+	}
+
+	person, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Person])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return Person{}, err
+	}
+
+	return person, nil
+}
+
+func CreatePerson(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, name string) (int, error) {
+	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
+		query := "INSERT INTO people (name) VALUES (@name) RETURNING id"
+		args := pgx.StrictNamedArgs{
+			"name": name,
+		}
+
+		var id int
+		L.DebugContext(ctx, "CreatePerson", "query", query, "args", args)
+		err := tx.QueryRow(ctx, query, args).Scan(&id)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+			return 0, err
+		}
+
+		return id, nil
+	})
+}
+
+func GetMembershipsByPersonID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) ([]Membership, error) {
+	query := `
+	SELECT
+		g.id      AS group_id,
+		g.name    AS group_name,
+		u.id      AS user_id,
+		u.balance AS balance
+	FROM users AS u
+	JOIN groups AS g
+		ON u.group_id = g.id
+	WHERE u.person_id = @id
+	ORDER BY g.id`
+	args := pgx.StrictNamedArgs{
+		"id": id,
+	}
+
+	L.DebugContext(ctx, "GetMembershipsByPersonID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []Membership{}, err
+	}
+
+	memberships, err := pgx.CollectRows(rows, pgx.RowToStructByName[Membership])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []Membership{}, err
+	}
+
+	return memberships, nil
+}
+
+func LinkUserToPerson(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, personID int, userID int) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		query := "UPDATE users SET person_id = @personID, version = version + 1 WHERE id = @id"
+		args := pgx.StrictNamedArgs{
+			"personID": personID,
+			"id":       userID,
+		}
+
+		L.DebugContext(ctx, "LinkUserToPerson", "query", query, "args", args)
+		cmdTag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Patch failed: %v", err))
+			return struct{}{}, err
+		}
+		if cmdTag.RowsAffected() > 1 {
+			L.ErrorContext(ctx, "Patch failed: more than one row affected")
+			return struct{}{}, errors.New("more than one row affected")
+		}
+		if cmdTag.RowsAffected() == 0 {
+			L.ErrorContext(ctx, fmt.Sprintf("Patch failed: user %v does not exist", userID))
+			return struct{}{}, pgx.ErrNoRows
+		}
+
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func UnlinkUserFromPerson(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, personID int, userID int) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		query := "UPDATE users SET person_id = NULL, version = version + 1 WHERE id = @id AND person_id = @personID"
+		args := pgx.StrictNamedArgs{
+			"id":       userID,
+			"personID": personID,
+		}
+
+		L.DebugContext(ctx, "UnlinkUserFromPerson", "query", query, "args", args)
+		cmdTag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Patch failed: %v", err))
+			return struct{}{}, err
+		}
+		if cmdTag.RowsAffected() > 1 {
+			L.ErrorContext(ctx, "Patch failed: more than one row affected")
+			return struct{}{}, errors.New("more than one row affected")
+		}
+		if cmdTag.RowsAffected() == 0 {
+			L.ErrorContext(ctx, fmt.Sprintf("Patch failed: user %v is not linked to person %v", userID, personID))
+			return struct{}{}, pgx.ErrNoRows
+		}
+
+		return struct{}{}, nil
+	})
+	return err
+}
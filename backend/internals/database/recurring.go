@@ -0,0 +1,327 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyYearly  = "yearly"
+)
+
+type InsertRecurringPayment struct {
+	Description string
+	Amount      float32
+	PayerID     int
+	PayeeIDs    []int
+	Frequency   string
+	DayOfMonth  *int
+	StartDate   time.Time
+	EndDate     *time.Time
+}
+
+func GetRecurringPaymentsByGroupID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) ([]RecurringPayment, error) {
+	query := `
+	SELECT id, group_id, description, amount, payer_id, payee_ids, frequency, day_of_month, start_date, end_date, next_run, paused
+	FROM recurring_payment
+	WHERE group_id = @id
+	ORDER BY id`
+	args := pgx.StrictNamedArgs{
+		"id": id,
+	}
+
+	L.DebugContext(ctx, "GetRecurringPaymentsByGroupID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []RecurringPayment{}, err
+	}
+
+	recurring, err := pgx.CollectRows(rows, pgx.RowToStructByName[RecurringPayment])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []RecurringPayment{}, err
+	}
+
+	return recurring, nil
+}
+
+func AddRecurringPayment(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, body InsertRecurringPayment) (int, error) {
+	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
+		dayOfMonth := 0
+		if body.DayOfMonth != nil {
+			dayOfMonth = *body.DayOfMonth
+		}
+		nextRun := NextOccurrence(body.Frequency, dayOfMonth, body.StartDate, body.StartDate.AddDate(0, 0, -1))
+
+		query := `INSERT INTO recurring_payment (group_id, description, amount, payer_id, payee_ids, frequency, day_of_month, start_date, end_date, next_run)
+VALUES (@groupID, @description, @amount, @payerID, @payeeIDs, @frequency, @dayOfMonth, @startDate, @endDate, @nextRun)
+RETURNING id`
+		args := pgx.StrictNamedArgs{
+			"groupID":     groupID,
+			"description": body.Description,
+			"amount":      body.Amount,
+			"payerID":     body.PayerID,
+			"payeeIDs":    body.PayeeIDs,
+			"frequency":   body.Frequency,
+			"dayOfMonth":  body.DayOfMonth,
+			"startDate":   body.StartDate,
+			"endDate":     body.EndDate,
+			"nextRun":     nextRun,
+		}
+
+		var id int
+		L.DebugContext(ctx, "AddRecurringPayment", "query", query, "args", args)
+		err := tx.QueryRow(ctx, query, args).Scan(&id)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+			return 0, err
+		}
+
+		return id, nil
+	})
+}
+
+func DeleteRecurringPayment(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, id int) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		query := "DELETE FROM recurring_payment WHERE id = @id AND group_id = @groupID"
+		args := pgx.StrictNamedArgs{
+			"id":      id,
+			"groupID": groupID,
+		}
+
+		L.DebugContext(ctx, "DeleteRecurringPayment", "query", query, "args", args)
+		cmdTag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+			return struct{}{}, err
+		}
+		if cmdTag.RowsAffected() > 1 {
+			L.ErrorContext(ctx, "Delete failed: more than one row affected")
+			return struct{}{}, errors.New("more than one row affected")
+		}
+		if cmdTag.RowsAffected() == 0 {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: recurring payment %v does not exist", id))
+			return struct{}{}, pgx.ErrNoRows
+		}
+
+		return struct{}{}, nil
+	})
+
+	return err
+}
+
+// books every occurrence due on or before today, including ones missed while the server was down
+func MaterializeRecurringPayments(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, today time.Time) (int, error) {
+	query := "SELECT id FROM recurring_payment WHERE next_run <= @today AND (end_date IS NULL OR next_run <= end_date) AND NOT paused"
+	args := pgx.StrictNamedArgs{
+		"today": today,
+	}
+
+	L.DebugContext(ctx, "MaterializeRecurringPayments.due", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return 0, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return 0, err
+	}
+
+	// one transaction per template so a broken one does not hold up the rest
+	count := 0
+	for _, id := range ids {
+		booked, err := WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
+			return materializeRecurringPayment(ctx, tx, L, id, today)
+		})
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Materialize failed for recurring payment %v: %v", id, err))
+			continue
+		}
+		count += booked
+	}
+
+	return count, nil
+}
+
+func materializeRecurringPayment(ctx context.Context, tx pgx.Tx, L *slog.Logger, id int, today time.Time) (int, error) {
+	// skip templates another instance is already working on
+	query := `
+	SELECT id, group_id, description, amount, payer_id, payee_ids, frequency, day_of_month, start_date, end_date, next_run, paused
+	FROM recurring_payment
+	WHERE id = @id AND next_run <= @today AND NOT paused
+	FOR UPDATE SKIP LOCKED`
+	args := pgx.StrictNamedArgs{
+		"id":    id,
+		"today": today,
+	}
+
+	L.DebugContext(ctx, "materializeRecurringPayment.lock", "query", query, "args", args)
+	rows, err := tx.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return 0, err
+	}
+
+	recurring, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[RecurringPayment])
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return 0, err
+	}
+
+	inactiveQuery := "SELECT COUNT(*) FROM users WHERE id = ANY(@ids) AND NOT active"
+	inactiveArgs := pgx.StrictNamedArgs{
+		"ids": append([]int{recurring.PayerID}, recurring.PayeeIDs...),
+	}
+
+	var inactive int
+	L.DebugContext(ctx, "materializeRecurringPayment.inactive", "query", inactiveQuery, "args", inactiveArgs)
+	err = tx.QueryRow(ctx, inactiveQuery, inactiveArgs).Scan(&inactive)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return 0, err
+	}
+	if inactive > 0 {
+		// it would fail the same way on every run until someone deletes or fixes it
+		pauseQuery := "UPDATE recurring_payment SET paused = TRUE WHERE id = @id"
+		pauseArgs := pgx.StrictNamedArgs{
+			"id": recurring.ID,
+		}
+		L.DebugContext(ctx, "materializeRecurringPayment.pause", "query", pauseQuery, "args", pauseArgs)
+		_, err = tx.Exec(ctx, pauseQuery, pauseArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return 0, err
+		}
+		L.WarnContext(ctx, fmt.Sprintf("Paused recurring payment %v, which involves inactive users", recurring.ID))
+		return 0, nil
+	}
+
+	dayOfMonth := 0
+	if recurring.DayOfMonth != nil {
+		dayOfMonth = *recurring.DayOfMonth
+	}
+
+	booked := 0
+	run := recurring.NextRun
+	for !run.After(today) && (recurring.EndDate == nil || !run.After(*recurring.EndDate)) {
+		occurrenceQuery := `INSERT INTO recurring_occurrence (recurring_id, occurs_on)
+VALUES (@id, @occursOn)
+ON CONFLICT DO NOTHING`
+		occurrenceArgs := pgx.StrictNamedArgs{
+			"id":       recurring.ID,
+			"occursOn": run,
+		}
+		L.DebugContext(ctx, "materializeRecurringPayment.occurrence", "query", occurrenceQuery, "args", occurrenceArgs)
+		cmdTag, err := tx.Exec(ctx, occurrenceQuery, occurrenceArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+			return 0, err
+		}
+
+		if cmdTag.RowsAffected() == 1 {
+			paymentID, err := addPayment(ctx, tx, L, recurring.GroupID, InsertPayment{
+				Description: fmt.Sprintf("%s (%s)", recurring.Description, run.Format(time.DateOnly)),
+				Amount:      recurring.Amount,
+				PayerID:     recurring.PayerID,
+				PayeeIDs:    recurring.PayeeIDs,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			linkQuery := "UPDATE recurring_occurrence SET payment_id = @paymentID WHERE recurring_id = @id AND occurs_on = @occursOn"
+			linkArgs := pgx.StrictNamedArgs{
+				"paymentID": paymentID,
+				"id":        recurring.ID,
+				"occursOn":  run,
+			}
+			L.DebugContext(ctx, "materializeRecurringPayment.link", "query", linkQuery, "args", linkArgs)
+			_, err = tx.Exec(ctx, linkQuery, linkArgs)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+				return 0, err
+			}
+			booked++
+		}
+
+		run = NextOccurrence(recurring.Frequency, dayOfMonth, recurring.StartDate, run)
+	}
+
+	nextQuery := "UPDATE recurring_payment SET next_run = @nextRun WHERE id = @id"
+	nextArgs := pgx.StrictNamedArgs{
+		"nextRun": run,
+		"id":      recurring.ID,
+	}
+	L.DebugContext(ctx, "materializeRecurringPayment.next", "query", nextQuery, "args", nextArgs)
+	_, err = tx.Exec(ctx, nextQuery, nextArgs)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+		return 0, err
+	}
+
+	return booked, nil
+}
+
+// first occurrence of the rule strictly after the given date
+func NextOccurrence(frequency string, dayOfMonth int, start time.Time, after time.Time) time.Time {
+	start = toDate(start)
+	after = toDate(after)
+	if after.Before(start) {
+		after = start.AddDate(0, 0, -1)
+	}
+
+	switch frequency {
+	case FrequencyWeekly:
+		if after.Before(start) {
+			return start
+		}
+		weeks := int(after.Sub(start).Hours()/24)/7 + 1
+		return start.AddDate(0, 0, 7*weeks)
+	case FrequencyMonthly:
+		if dayOfMonth == 0 {
+			dayOfMonth = start.Day()
+		}
+		for month := 0; ; month++ {
+			candidate := clampDate(after.Year(), after.Month()+time.Month(month), dayOfMonth)
+			if candidate.After(after) && !candidate.Before(start) {
+				return candidate
+			}
+		}
+	default:
+		for year := after.Year(); ; year++ {
+			candidate := clampDate(year, start.Month(), start.Day())
+			if candidate.After(after) && !candidate.Before(start) {
+				return candidate
+			}
+		}
+	}
+}
+
+func toDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// the given day, or the last day of the month if the month is too short
+func clampDate(year int, month time.Month, day int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestClampDate(t *testing.T) {
+	tests := []struct {
+		year  int
+		month time.Month
+		day   int
+		want  time.Time
+	}{
+		{2026, time.January, 31, date(2026, time.January, 31)},
+		{2026, time.February, 31, date(2026, time.February, 28)},
+		{2028, time.February, 31, date(2028, time.February, 29)},
+		{2026, time.April, 31, date(2026, time.April, 30)},
+		{2026, time.February, 15, date(2026, time.February, 15)},
+		// months past December roll into the next year
+		{2026, time.February + 11, 31, date(2027, time.January, 31)},
+	}
+	for _, test := range tests {
+		if got := clampDate(test.year, test.month, test.day); !got.Equal(test.want) {
+			t.Errorf("clampDate(%d, %d, %d) = %s, want %s", test.year, test.month, test.day, got.Format(time.DateOnly), test.want.Format(time.DateOnly))
+		}
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	tests := []struct {
+		name       string
+		frequency  string
+		dayOfMonth int
+		start      time.Time
+		after      time.Time
+		want       time.Time
+	}{
+		{"weekly before the start", FrequencyWeekly, 0, date(2026, time.January, 5), date(2025, time.December, 1), date(2026, time.January, 5)},
+		{"weekly on a run", FrequencyWeekly, 0, date(2026, time.January, 5), date(2026, time.January, 5), date(2026, time.January, 12)},
+		{"weekly between runs", FrequencyWeekly, 0, date(2026, time.January, 5), date(2026, time.January, 15), date(2026, time.January, 19)},
+		{"weekly over the year end", FrequencyWeekly, 0, date(2026, time.December, 28), date(2026, time.December, 28), date(2027, time.January, 4)},
+
+		{"monthly before the start", FrequencyMonthly, 0, date(2026, time.January, 31), date(2026, time.January, 1), date(2026, time.January, 31)},
+		{"monthly from the 31st into February", FrequencyMonthly, 0, date(2026, time.January, 31), date(2026, time.January, 31), date(2026, time.February, 28)},
+		{"monthly from the 31st into a leap February", FrequencyMonthly, 0, date(2028, time.January, 31), date(2028, time.January, 31), date(2028, time.February, 29)},
+		{"monthly back to the 31st after February", FrequencyMonthly, 0, date(2026, time.January, 31), date(2026, time.February, 28), date(2026, time.March, 31)},
+		{"monthly into a 30 day month", FrequencyMonthly, 0, date(2026, time.January, 31), date(2026, time.March, 31), date(2026, time.April, 30)},
+		{"monthly on a fixed day", FrequencyMonthly, 31, date(2026, time.January, 10), date(2026, time.January, 10), date(2026, time.January, 31)},
+		{"monthly on a fixed day clamped", FrequencyMonthly, 30, date(2026, time.January, 30), date(2026, time.January, 30), date(2026, time.February, 28)},
+		{"monthly over the year end", FrequencyMonthly, 15, date(2026, time.January, 15), date(2026, time.December, 20), date(2027, time.January, 15)},
+
+		{"yearly before the start", FrequencyYearly, 0, date(2026, time.March, 1), date(2025, time.June, 1), date(2026, time.March, 1)},
+		{"yearly on a run", FrequencyYearly, 0, date(2026, time.March, 1), date(2026, time.March, 1), date(2027, time.March, 1)},
+		{"yearly from a leap day", FrequencyYearly, 0, date(2028, time.February, 29), date(2028, time.February, 29), date(2029, time.February, 28)},
+		{"yearly back to a leap day", FrequencyYearly, 0, date(2028, time.February, 29), date(2031, time.February, 28), date(2032, time.February, 29)},
+		{"yearly ignores the time of day", FrequencyYearly, 0, date(2026, time.March, 1), date(2026, time.March, 1).Add(23 * time.Hour), date(2027, time.March, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NextOccurrence(test.frequency, test.dayOfMonth, test.start, test.after)
+			if !got.Equal(test.want) {
+				t.Errorf("NextOccurrence(%s, %d, %s, %s) = %s, want %s", test.frequency, test.dayOfMonth,
+					test.start.Format(time.DateOnly), test.after.Format(time.DateOnly), got.Format(time.DateOnly), test.want.Format(time.DateOnly))
+			}
+		})
+	}
+}
+
+func TestInactiveMembersPauseRecurringPayments(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		alice, err := store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		bob, err := store.AddUserToGroupByID(ctx, group, "Bob")
+		failOn(t, err)
+
+		_, err = store.AddRecurringPayment(ctx, group, InsertRecurringPayment{
+			Description: "Rent",
+			Amount:      900,
+			PayerID:     alice,
+			PayeeIDs:    []int{alice, bob},
+			Frequency:   FrequencyMonthly,
+			StartDate:   date(2026, time.January, 1),
+		})
+		failOn(t, err)
+		failOn(t, store.DeactivateUser(ctx, group, bob, nil))
+
+		// the first run pauses the template and later runs leave it alone
+		today := date(2026, time.March, 1)
+		for run := range 2 {
+			booked, err := store.MaterializeRecurringPayments(ctx, today)
+			if booked != 0 || err != nil {
+				t.Errorf("run %d = %d, %v, want nothing booked and no error", run, booked, err)
+			}
+		}
+		templates, err := store.GetRecurringPaymentsByGroupID(ctx, group)
+		failOn(t, err)
+		if len(templates) != 1 || !templates[0].Paused || !templates[0].NextRun.Equal(date(2026, time.January, 1)) {
+			t.Fatalf("templates = %+v, want rent paused at its first run", templates)
+		}
+
+		// merging the inactive member away lets it catch up
+		failOn(t, store.MergeUser(ctx, group, bob, alice))
+		booked, err := store.MaterializeRecurringPayments(ctx, today)
+		if booked != 3 || err != nil {
+			t.Errorf("after the merge = %d, %v, want 3 months booked", booked, err)
+		}
+		templates, err = store.GetRecurringPaymentsByGroupID(ctx, group)
+		failOn(t, err)
+		if templates[0].Paused {
+			t.Errorf("rent is still paused after the merge")
+		}
+	})
+}
+
+func TestConcurrentRunsBookOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		alice, err := store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		_, err = store.AddRecurringPayment(ctx, group, InsertRecurringPayment{
+			Description: "Rent",
+			Amount:      900,
+			PayerID:     alice,
+			PayeeIDs:    []int{alice},
+			Frequency:   FrequencyMonthly,
+			StartDate:   date(2026, time.January, 1),
+		})
+		failOn(t, err)
+
+		// two schedulers catching up at once book each month once between them
+		var wg sync.WaitGroup
+		booked := make([]int, 2)
+		errs := make([]error, 2)
+		for i := range booked {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				booked[i], errs[i] = store.MaterializeRecurringPayments(ctx, date(2026, time.March, 1))
+			}()
+		}
+		wg.Wait()
+		failOn(t, errors.Join(errs...))
+		if booked[0]+booked[1] != 3 {
+			t.Errorf("runs booked %d and %d, want 3 months between them", booked[0], booked[1])
+		}
+		payments, err := store.GetPaymentsByGroupID(ctx, group)
+		failOn(t, err)
+		if len(payments) != 3 {
+			t.Errorf("group has %d payments, want 3", len(payments))
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// a settlement is booked as a payment from the debtor with the creditor as the only payee
+func RecordSettlement(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, fromID int, toID int, amount float32) (int, error) {
+	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
+		paymentID, err := addPayment(ctx, tx, L, groupID, InsertPayment{
+			Description: "Settlement",
+			Amount:      amount,
+			PayerID:     fromID,
+			PayeeIDs:    []int{toID},
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		err = enqueueEvent(ctx, tx, L, groupID, EventSettlementRecorded, map[string]any{
+			"payment_id": paymentID,
+			"from_id":    fromID,
+			"to_id":      toID,
+			"amount":     amount,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		return paymentID, nil
+	})
+}
@@ -0,0 +1,2377 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// the SQLite schema, one NNNN_name.sql per version. It keeps the Postgres constraint names
+// and does with triggers what the Postgres schema does with constraints and plpgsql
+//
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+var sqliteFileName = regexp.MustCompile(`^(\d+)_\w+\.sql$`)
+
+// times are kept as UTC text in this layout, which sorts the same as the times do
+const sqliteTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// SqliteStore is the Store backed by a single SQLite file, for running the server on its own.
+// Writes are serialized by the file lock, so the row locks the Postgres queries take are not
+// needed: a write transaction holds the whole database from the moment it begins.
+//
+// A file belongs to one server process, so events are only announced within that process.
+type SqliteStore struct {
+	db     *sql.DB
+	L      *slog.Logger
+	events localEvents
+}
+
+// OpenSqliteStore opens the database at path, creating it if needed, and brings its schema up
+// to date. Unlike Postgres there is no other server that could still need the old schema.
+func OpenSqliteStore(ctx context.Context, path string, L *slog.Logger) (*SqliteStore, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SqliteStore{db: db, L: L}
+	err = s.migrate(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *SqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// sqliteScripts are the embedded schema versions in order, the first one being version 1
+func sqliteScripts() ([]string, error) {
+	entries, err := fs.ReadDir(sqliteFiles, "sqlite")
+	if err != nil {
+		return nil, err
+	}
+	scripts := []string{}
+	for idx, entry := range entries {
+		match := sqliteFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected schema file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if version != idx+1 {
+			return nil, fmt.Errorf("schema version %d is missing", idx+1)
+		}
+		data, err := sqliteFiles.ReadFile("sqlite/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, string(data))
+	}
+	return scripts, nil
+}
+
+// migrate applies the schema versions the file is missing, tracking them in PRAGMA user_version
+func (s *SqliteStore) migrate(ctx context.Context) error {
+	scripts, err := sqliteScripts()
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current > len(scripts) {
+		return fmt.Errorf("database schema is at version %d, newer than this server (%d)", current, len(scripts))
+	}
+
+	for version := current + 1; version <= len(scripts); version++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, scripts[version-1])
+		if err == nil {
+			_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version))
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("schema version %d: %w", version, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		s.L.InfoContext(ctx, fmt.Sprintf("Applied schema version %d", version))
+	}
+
+	return nil
+}
+
+// Check reads the file's schema version, failing unless it is the one this server applies
+func (s *SqliteStore) Check(ctx context.Context) error {
+	scripts, err := sqliteScripts()
+	if err != nil {
+		return err
+	}
+	var current int
+	err = s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current != len(scripts) {
+		return fmt.Errorf("database schema is at version %d, this server needs %d", current, len(scripts))
+	}
+	return nil
+}
+
+// sqliteArgs are bound to the @name parameters of a query. Every parameter needs an argument
+type sqliteArgs map[string]any
+
+func (a sqliteArgs) bind() []any {
+	named := make([]any, 0, len(a))
+	for name, value := range a {
+		named = append(named, sql.Named(name, value))
+	}
+	return named
+}
+
+// satisfied by both *sql.DB and *sql.Tx
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// sqliteRun is one call into the store: what it runs on, the time NOW() stands for
+// and the events to announce once it commits
+type sqliteRun struct {
+	ctx     context.Context
+	db      sqliteQuerier
+	L       *slog.Logger
+	now     time.Time
+	notices []eventNotice
+}
+
+func (s *SqliteStore) run(ctx context.Context, db sqliteQuerier) *sqliteRun {
+	return &sqliteRun{ctx: ctx, db: db, L: s.L, now: time.Now()}
+}
+
+// sqliteTx runs fn in a write transaction. The payment checks Postgres defers to the commit
+// are run right before it, and the events fn queued are announced once it has committed
+func sqliteTx[T any](ctx context.Context, s *SqliteStore, fn func(r *sqliteRun) (T, error)) (res T, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	r := s.run(ctx, tx)
+	res, err = fn(r)
+	if err != nil {
+		return res, err
+	}
+
+	_, err = r.exec("checkPayments", "DELETE FROM payment_check", sqliteArgs{})
+	if err != nil {
+		return res, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return res, sqliteError(err)
+	}
+
+	s.events.announce(r.notices)
+	return res, nil
+}
+
+// runs fn against a single read-only snapshot, so every read sees the same state
+func sqliteRead[T any](ctx context.Context, s *SqliteStore, fn func(r *sqliteRun) (T, error)) (T, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer tx.Rollback()
+
+	return fn(s.run(ctx, tx))
+}
+
+func (r *sqliteRun) exec(name string, query string, args sqliteArgs) (int64, error) {
+	r.L.DebugContext(r.ctx, name, "query", query, "args", args)
+	res, err := r.db.ExecContext(r.ctx, query, args.bind()...)
+	if err != nil {
+		err = sqliteError(err)
+		r.L.ErrorContext(r.ctx, fmt.Sprintf("Query failed: %v", err))
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// query calls scan on each row the query returns
+func (r *sqliteRun) query(name string, query string, args sqliteArgs, scan func(rows *sql.Rows) error) error {
+	r.L.DebugContext(r.ctx, name, "query", query, "args", args)
+	rows, err := r.db.QueryContext(r.ctx, query, args.bind()...)
+	if err != nil {
+		err = sqliteError(err)
+		r.L.ErrorContext(r.ctx, fmt.Sprintf("Query failed: %v", err))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Binding failed: %v", err))
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		err = sqliteError(err)
+		r.L.ErrorContext(r.ctx, fmt.Sprintf("Query failed: %v", err))
+		return err
+	}
+
+	return nil
+}
+
+// queryRow scans the row the query returns into dest, or returns ErrNotFound
+func (r *sqliteRun) queryRow(name string, query string, args sqliteArgs, dest ...any) error {
+	found := false
+	err := r.query(name, query, args, func(rows *sql.Rows) error {
+		found = true
+		return rows.Scan(dest...)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// lockVersion reads the version of one row and checks it against expected when that is set
+func (r *sqliteRun) lockVersion(name string, query string, args sqliteArgs, expected *int) error {
+	var version int
+	err := r.queryRow(name, query, args, &version)
+	if err != nil {
+		return err
+	}
+	if expected != nil && *expected != version {
+		r.L.InfoContext(r.ctx, fmt.Sprintf("Version mismatch: expected %v, found %v", *expected, version))
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
+
+// expectRows turns an unexpected row count into an error, the way the Postgres queries check theirs
+func expectRows(ctx context.Context, L *slog.Logger, affected int64, expected int64, table string) error {
+	if affected != expected {
+		L.ErrorContext(ctx, fmt.Sprintf("Unexpected number of rows affected in %s table", table))
+		return errors.New("unexpected number of rows affected")
+	}
+	return nil
+}
+
+// tables of the checks in the schema, which SQLite leaves out of its message
+var sqliteChecks = map[string]string{
+	"payment_amount_check":                 "payment",
+	"recurring_payment_amount_check":       "recurring_payment",
+	"recurring_payment_day_of_month_check": "recurring_payment",
+	"recurring_payment_frequency_check":    "recurring_payment",
+	"recurring_payment_payee_ids_check":    "recurring_payment",
+	"webhook_delivery_status_check":        "webhook_delivery",
+}
+
+// unique keys by the columns SQLite reports for them
+var sqliteUniqueKeys = map[string]string{
+	"users.group_id, users.person_id":                                   "users_group_id_person_id_key",
+	"users_payment.user_id, users_payment.payment_id":                   "users_payment_pkey",
+	"recurring_occurrence.recurring_id, recurring_occurrence.occurs_on": "recurring_occurrence_pkey",
+	"idempotency_key.key":                                               "idempotency_key_pkey",
+}
+
+// sqliteError turns a constraint SQLite reports into the error Postgres raises for it.
+// The schema's triggers raise "<constraint> [column]: <message>"
+func sqliteError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	message := strings.TrimSuffix(sqliteErr.Error(), fmt.Sprintf(" (%d)", sqliteErr.Code()))
+	message = strings.TrimPrefix(message, "constraint failed: ")
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_TRIGGER:
+		raised, message, _ := strings.Cut(message, ": ")
+		constraint, column, _ := strings.Cut(raised, " ")
+		code := "P0001"
+		if strings.HasSuffix(constraint, "_fkey") {
+			code = "23503"
+		}
+		return &pgconn.PgError{
+			Severity:       "ERROR",
+			Code:           code,
+			Message:        message,
+			ConstraintName: constraint,
+			ColumnName:     column,
+		}
+	case sqlite3.SQLITE_CONSTRAINT_CHECK:
+		constraint := strings.TrimPrefix(message, "CHECK constraint failed: ")
+		return checkFailed(sqliteChecks[constraint], constraint)
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		columns := strings.TrimPrefix(message, "UNIQUE constraint failed: ")
+		table, _, _ := strings.Cut(columns, ".")
+		return duplicateKey(table, sqliteUniqueKeys[columns])
+	case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+		_, column, _ := strings.Cut(strings.TrimPrefix(message, "NOT NULL constraint failed: "), ".")
+		return &pgconn.PgError{
+			Severity:   "ERROR",
+			Code:       "23502",
+			Message:    fmt.Sprintf("null value in column %q violates not-null constraint", column),
+			ColumnName: column,
+		}
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		// only the foreign keys no request can trip are left to SQLite, which does not name them
+		return &pgconn.PgError{
+			Severity: "ERROR",
+			Code:     "23503",
+			Message:  message,
+		}
+	}
+	return err
+}
+
+func sqliteTimeText(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+func sqliteDateText(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.DateOnly)
+}
+
+// arrays are kept as JSON and searched with json_each
+func sqliteArray(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// sqliteTime scans a time or date kept as text
+type sqliteTime struct {
+	t *time.Time
+}
+
+func (d sqliteTime) Scan(src any) error {
+	text, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into a time", src)
+	}
+	layout := sqliteTimeLayout
+	if len(text) == len(time.DateOnly) {
+		layout = time.DateOnly
+	}
+	t, err := time.Parse(layout, text)
+	if err != nil {
+		return err
+	}
+	*d.t = t
+	return nil
+}
+
+// sqliteNullTime is sqliteTime for a column that may be NULL
+type sqliteNullTime struct {
+	t **time.Time
+}
+
+func (d sqliteNullTime) Scan(src any) error {
+	if src == nil {
+		*d.t = nil
+		return nil
+	}
+	var t time.Time
+	err := sqliteTime{&t}.Scan(src)
+	if err != nil {
+		return err
+	}
+	*d.t = &t
+	return nil
+}
+
+// sqliteJSON decodes a JSON column into v, leaving v alone when the column is NULL
+type sqliteJSON struct {
+	v any
+}
+
+func (j sqliteJSON) Scan(src any) error {
+	switch data := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(data), j.v)
+	case []byte:
+		return json.Unmarshal(data, j.v)
+	}
+	return fmt.Errorf("cannot scan %T as JSON", src)
+}
+
+// Groups
+
+func (s *SqliteStore) GetGroupByID(ctx context.Context, id int) (Group, error) {
+	return s.run(ctx, s.db).getGroupByID(id)
+}
+
+func (r *sqliteRun) getGroupByID(id int) (Group, error) {
+	query := "SELECT id, name, version FROM groups WHERE groups.id = @id"
+	args := sqliteArgs{
+		"id": id,
+	}
+
+	var group Group
+	err := r.queryRow("GetGroupByID", query, args, &group.ID, &group.Name, &group.Version)
+	if err != nil {
+		return Group{}, err
+	}
+
+	return group, nil
+}
+
+func (s *SqliteStore) ListGroupsByPersonID(ctx context.Context, personID int, search string, sort string, limit int, offset int) ([]GroupListing, int, error) {
+	orderBy := "g.last_activity_at DESC, g.id DESC"
+	if sort == "name" {
+		orderBy = "g.name, g.id"
+	}
+
+	// SQLite's lower() only folds ASCII, which is close enough for a search box
+	query := `
+	SELECT
+		g.id,
+		g.name,
+		(SELECT COUNT(*) FROM users WHERE group_id = g.id AND active)           AS member_count,
+		(SELECT COALESCE(SUM(amount), 0) FROM payment WHERE group_id = g.id)    AS total_spent,
+		u.balance                                                               AS balance,
+		g.last_activity_at                                                      AS last_activity_at,
+		COUNT(*) OVER ()                                                        AS total
+	FROM groups AS g
+	JOIN users AS u
+		ON u.group_id = g.id
+	WHERE u.person_id = @personID
+		AND instr(lower(g.name), lower(@search)) > 0
+	ORDER BY ` + orderBy + `
+	LIMIT @limit OFFSET @offset`
+	args := sqliteArgs{
+		"personID": personID,
+		"search":   search,
+		"limit":    limit,
+		"offset":   offset,
+	}
+
+	total := 0
+	groups := []GroupListing{}
+	err := s.run(ctx, s.db).query("ListGroupsByPersonID", query, args, func(rows *sql.Rows) error {
+		var group GroupListing
+		err := rows.Scan(&group.ID, &group.Name, &group.MemberCount, &group.TotalSpent, &group.Balance,
+			sqliteTime{&group.LastActivityAt}, &total)
+		groups = append(groups, group)
+		return err
+	})
+	if err != nil {
+		return []GroupListing{}, 0, err
+	}
+
+	return groups, total, nil
+}
+
+func (s *SqliteStore) CreateGroup(ctx context.Context, name string) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		query := "INSERT INTO groups (name) VALUES (@name) RETURNING id"
+		args := sqliteArgs{
+			"name": name,
+		}
+
+		var id int
+		err := r.queryRow("CreateGroup", query, args, &id)
+		return id, err
+	})
+}
+
+func (s *SqliteStore) PatchGroup(ctx context.Context, id int, version *int, name string) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		err := r.lockGroup(id, version)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		query := "UPDATE groups SET name = @name, version = version + 1 WHERE id = @id"
+		args := sqliteArgs{
+			"name": name,
+			"id":   id,
+		}
+		affected, err := r.exec("PatchGroup", query, args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, expectRows(r.ctx, r.L, affected, 1, "groups")
+	})
+	return err
+}
+
+// DeleteGroup removes the transfers and payments first, which the cascade from groups
+// would otherwise reach after the users they refer to
+func (s *SqliteStore) DeleteGroup(ctx context.Context, id int, version *int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		err := r.lockGroup(id, version)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		args := sqliteArgs{
+			"id": id,
+		}
+		_, err = r.exec("DeleteGroup.DeleteTransfers", "DELETE FROM balance_transfer WHERE group_id = @id", args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		_, err = r.exec("DeleteGroup.DeletePayments", "DELETE FROM payment WHERE group_id = @id", args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		// templates hold on to their payer and payees, so they go before the members do
+		_, err = r.exec("DeleteGroup.DeleteRecurring", "DELETE FROM recurring_payment WHERE group_id = @id", args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		affected, err := r.exec("DeleteGroup.DeleteGroup", "DELETE FROM groups WHERE id = @id", args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, expectRows(r.ctx, r.L, affected, 1, "groups")
+	})
+	return err
+}
+
+func (r *sqliteRun) lockGroup(id int, version *int) error {
+	query := "SELECT version FROM groups WHERE id = @id"
+	args := sqliteArgs{
+		"id": id,
+	}
+	return r.lockVersion("lockGroup", query, args, version)
+}
+
+func (s *SqliteStore) GetGroupSummary(ctx context.Context, id int, recent int) (GroupSummary, error) {
+	return sqliteRead(ctx, s, func(r *sqliteRun) (GroupSummary, error) {
+		group, err := r.getGroupByID(id)
+		if err != nil {
+			return GroupSummary{}, err
+		}
+
+		users, err := r.getUsersByGroupID(id)
+		if err != nil {
+			return GroupSummary{}, err
+		}
+
+		payments, err := r.getPayments("getRecentPaymentsByGroupID", "WHERE p.group_id = @id", "ORDER BY p.id DESC LIMIT @limit", sqliteArgs{
+			"id":    id,
+			"limit": recent,
+		})
+		if err != nil {
+			return GroupSummary{}, err
+		}
+
+		totalsQuery := "SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM payment WHERE group_id = @id"
+		totalsArgs := sqliteArgs{
+			"id": id,
+		}
+
+		var paymentCount int
+		var totalSpent float32
+		err = r.queryRow("GetGroupSummary.totals", totalsQuery, totalsArgs, &paymentCount, &totalSpent)
+		if err != nil {
+			return GroupSummary{}, err
+		}
+
+		return GroupSummary{
+			Group:          group,
+			Users:          users,
+			RecentPayments: payments,
+			PaymentCount:   paymentCount,
+			TotalSpent:     totalSpent,
+		}, nil
+	})
+}
+
+// Users
+
+func (s *SqliteStore) GetUsersByGroupID(ctx context.Context, groupID int) ([]User, error) {
+	return s.run(ctx, s.db).getUsersByGroupID(groupID)
+}
+
+func (r *sqliteRun) getUsersByGroupID(id int) ([]User, error) {
+	query := "SELECT id, person_id, name, balance, active, version FROM users WHERE users.group_id = @id ORDER BY id"
+	args := sqliteArgs{
+		"id": id,
+	}
+
+	users := []User{}
+	err := r.query("GetUsersByGroupID", query, args, func(rows *sql.Rows) error {
+		var user User
+		err := rows.Scan(&user.ID, &user.PersonID, &user.Name, &user.Balance, &user.Active, &user.Version)
+		users = append(users, user)
+		return err
+	})
+	if err != nil {
+		return []User{}, err
+	}
+
+	return users, nil
+}
+
+func (s *SqliteStore) AddUserToGroupByID(ctx context.Context, groupID int, name string) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		return r.addUser(groupID, name)
+	})
+}
+
+func (r *sqliteRun) addUser(groupID int, name string) (int, error) {
+	query := "INSERT INTO users (group_id, name) VALUES (@id, @name) RETURNING id"
+	args := sqliteArgs{
+		"id":   groupID,
+		"name": name,
+	}
+
+	var id int
+	err := r.queryRow("AddUserToGroupByID", query, args, &id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.enqueueEvent(groupID, EventUserAdded, map[string]any{
+		"user_id": id,
+		"name":    name,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *SqliteStore) PatchUser(ctx context.Context, groupID int, userID int, version *int, name string) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		return struct{}{}, r.patchUser(groupID, userID, version, name)
+	})
+	return err
+}
+
+func (r *sqliteRun) patchUser(groupID int, userID int, version *int, name string) error {
+	err := r.lockUser(groupID, userID, version)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET name = @name, version = version + 1 WHERE id = @id AND group_id = @groupID"
+	args := sqliteArgs{
+		"name":    name,
+		"id":      userID,
+		"groupID": groupID,
+	}
+	affected, err := r.exec("PatchUser", query, args)
+	if err != nil {
+		return err
+	}
+	err = expectRows(r.ctx, r.L, affected, 1, "users")
+	if err != nil {
+		return err
+	}
+
+	return r.enqueueEvent(groupID, EventUserUpdated, map[string]any{
+		"user_id": userID,
+		"name":    name,
+	})
+}
+
+func (s *SqliteStore) DeleteUser(ctx context.Context, groupID int, userID int, version *int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		return struct{}{}, r.deleteUser(groupID, userID, version)
+	})
+	return err
+}
+
+func (r *sqliteRun) deleteUser(groupID int, userID int, version *int) error {
+	err := r.lockUser(groupID, userID, version)
+	if err != nil {
+		return err
+	}
+
+	query := "DELETE FROM users WHERE id = @id AND group_id = @groupID"
+	args := sqliteArgs{
+		"id":      userID,
+		"groupID": groupID,
+	}
+	affected, err := r.exec("DeleteUser", query, args)
+	if err != nil {
+		return err
+	}
+	err = expectRows(r.ctx, r.L, affected, 1, "users")
+	if err != nil {
+		return err
+	}
+
+	return r.enqueueEvent(groupID, EventUserDeleted, map[string]any{
+		"user_id": userID,
+	})
+}
+
+func (s *SqliteStore) DeactivateUser(ctx context.Context, groupID int, userID int, transferTo *int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		userQuery := "SELECT balance, active FROM users WHERE id = @id AND group_id = @groupID"
+		userArgs := sqliteArgs{
+			"id":      userID,
+			"groupID": groupID,
+		}
+
+		var balance float32
+		var active bool
+		err := r.queryRow("DeactivateUser.user", userQuery, userArgs, &balance, &active)
+		if err != nil {
+			return struct{}{}, err
+		}
+		if !active {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Deactivate failed: user %v is already inactive", userID))
+			return struct{}{}, ErrUserInactive
+		}
+
+		if transferTo != nil {
+			targetQuery := "SELECT active FROM users WHERE id = @id AND group_id = @groupID"
+			targetArgs := sqliteArgs{
+				"id":      *transferTo,
+				"groupID": groupID,
+			}
+
+			var targetActive bool
+			err = r.queryRow("DeactivateUser.target", targetQuery, targetArgs, &targetActive)
+			if err == ErrNotFound || (err == nil && !targetActive) {
+				r.L.ErrorContext(r.ctx, fmt.Sprintf("Deactivate failed: user %v cannot receive a balance", *transferTo))
+				return struct{}{}, ErrInvalidTransferUser
+			}
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			// move balance
+			moveQuery := "UPDATE users SET balance = balance + @amount WHERE id = @id"
+			moveArgs := sqliteArgs{
+				"amount": balance,
+				"id":     *transferTo,
+			}
+			affected, err := r.exec("DeactivateUser.move", moveQuery, moveArgs)
+			if err != nil {
+				return struct{}{}, err
+			}
+			err = expectRows(r.ctx, r.L, affected, 1, "users")
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			// record transfer
+			auditQuery := `INSERT INTO balance_transfer (group_id, from_user_id, to_user_id, amount, created_at)
+VALUES (@groupID, @from, @to, @amount, @now)`
+			auditArgs := sqliteArgs{
+				"groupID": groupID,
+				"from":    userID,
+				"to":      *transferTo,
+				"amount":  balance,
+				"now":     sqliteTimeText(r.now),
+			}
+			_, err = r.exec("DeactivateUser.audit", auditQuery, auditArgs)
+			if err != nil {
+				return struct{}{}, err
+			}
+		} else if math.Abs(float64(balance)) >= SettledEpsilon {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Deactivate failed: user %v has balance %v", userID, balance))
+			return struct{}{}, ErrUnsettledBalance
+		}
+
+		query := "UPDATE users SET active = FALSE, balance = 0, version = version + 1 WHERE id = @id"
+		args := sqliteArgs{
+			"id": userID,
+		}
+		affected, err := r.exec("DeactivateUser.deactivate", query, args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		err = expectRows(r.ctx, r.L, affected, 1, "users")
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		return struct{}{}, r.enqueueEvent(groupID, EventUserDeactivated, map[string]any{
+			"user_id":     userID,
+			"transfer_to": transferTo,
+		})
+	})
+	return err
+}
+
+func (s *SqliteStore) MergeUser(ctx context.Context, groupID int, sourceID int, targetID int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		lockQuery := "SELECT id, active, person_id FROM users WHERE id IN (@source, @target) AND group_id = @groupID ORDER BY id"
+		lockArgs := sqliteArgs{
+			"source":  sourceID,
+			"target":  targetID,
+			"groupID": groupID,
+		}
+		active := map[int]bool{}
+		person := map[int]*int{}
+		err := r.query("MergeUser.lock", lockQuery, lockArgs, func(rows *sql.Rows) error {
+			var id int
+			var isActive bool
+			var personID *int
+			err := rows.Scan(&id, &isActive, &personID)
+			active[id] = isActive
+			person[id] = personID
+			return err
+		})
+		if err != nil {
+			return struct{}{}, err
+		}
+		if len(active) != 2 {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Merge failed: users %v and %v are not both in group %v", sourceID, targetID, groupID))
+			return struct{}{}, ErrNotFound
+		}
+		if !active[targetID] {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Merge failed: user %v is inactive", targetID))
+			return struct{}{}, ErrUserInactive
+		}
+		if person[sourceID] != nil && person[targetID] != nil && *person[sourceID] != *person[targetID] {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Merge failed: users %v and %v are linked to different people", sourceID, targetID))
+			return struct{}{}, ErrPersonConflict
+		}
+
+		// payments the source is on change payer or payees
+		bumpQuery := `UPDATE payment SET version = version + 1
+WHERE payer_id = @source OR id IN (SELECT payment_id FROM users_payment WHERE user_id = @source)`
+		bumpArgs := sqliteArgs{
+			"source": sourceID,
+		}
+		_, err = r.exec("MergeUser.bump", bumpQuery, bumpArgs)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		// combine balances
+		balanceQuery := `UPDATE users SET balance = balance + (SELECT balance FROM users WHERE id = @source)
+WHERE id = @target`
+		balanceArgs := sqliteArgs{
+			"source": sourceID,
+			"target": targetID,
+		}
+		affected, err := r.exec("MergeUser.balance", balanceQuery, balanceArgs)
+		if err != nil {
+			return struct{}{}, err
+		}
+		err = expectRows(r.ctx, r.L, affected, 1, "users")
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		// find payments both users are payees of
+		overlapQuery := `
+		SELECT p.id, p.amount, COUNT(up.user_id)
+		FROM payment AS p
+		JOIN users_payment AS up
+			ON up.payment_id = p.id
+		WHERE p.id IN (SELECT payment_id FROM users_payment WHERE user_id = @source)
+			AND p.id IN (SELECT payment_id FROM users_payment WHERE user_id = @target)
+		GROUP BY p.id, p.amount`
+		overlapArgs := sqliteArgs{
+			"source": sourceID,
+			"target": targetID,
+		}
+		type overlap struct {
+			paymentID int
+			amount    float32
+			payees    int
+		}
+		overlaps := []overlap{}
+		err = r.query("MergeUser.overlap", overlapQuery, overlapArgs, func(rows *sql.Rows) error {
+			var o overlap
+			err := rows.Scan(&o.paymentID, &o.amount, &o.payees)
+			overlaps = append(overlaps, o)
+			return err
+		})
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		// a shared payment loses one payee, so re-split it across the remaining ones
+		for _, o := range overlaps {
+			oldShare := o.amount / float32(o.payees)
+			newShare := o.amount / float32(o.payees-1)
+
+			othersQuery := `UPDATE users SET balance = balance + @diff
+WHERE id IN (SELECT user_id FROM users_payment WHERE payment_id = @paymentID)
+	AND id != @source AND id != @target`
+			othersArgs := sqliteArgs{
+				"diff":      newShare - oldShare,
+				"paymentID": o.paymentID,
+				"source":    sourceID,
+				"target":    targetID,
+			}
+			_, err = r.exec("MergeUser.others", othersQuery, othersArgs)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			targetQuery := "UPDATE users SET balance = balance + @diff WHERE id = @target"
+			targetArgs := sqliteArgs{
+				"diff":   newShare - 2*oldShare,
+				"target": targetID,
+			}
+			_, err = r.exec("MergeUser.target", targetQuery, targetArgs)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			dropQuery := "DELETE FROM users_payment WHERE user_id = @source AND payment_id = @paymentID"
+			dropArgs := sqliteArgs{
+				"source":    sourceID,
+				"paymentID": o.paymentID,
+			}
+			_, err = r.exec("MergeUser.drop", dropQuery, dropArgs)
+			if err != nil {
+				return struct{}{}, err
+			}
+		}
+
+		// rewrite references
+		rewrites := []struct {
+			name  string
+			query string
+		}{
+			{"MergeUser.payees", "UPDATE users_payment SET user_id = @target WHERE user_id = @source"},
+			{"MergeUser.payer", "UPDATE payment SET payer_id = @target WHERE payer_id = @source"},
+			{"MergeUser.transferFrom", "UPDATE balance_transfer SET from_user_id = @target WHERE from_user_id = @source"},
+			{"MergeUser.transferTo", "UPDATE balance_transfer SET to_user_id = @target WHERE to_user_id = @source"},
+			// a paused template gets another try, since the merged away member may be why it stopped
+			{"MergeUser.recurringPayer", "UPDATE recurring_payment SET payer_id = @target, paused = FALSE WHERE payer_id = @source"},
+			// a template with both users as payees keeps the target once, where it first appeared
+			{"MergeUser.recurringPayees", `UPDATE recurring_payment SET payee_ids = (
+	SELECT json_group_array(id) FROM (
+		SELECT IIF(payee.value = @source, @target, payee.value) AS id, MIN(payee.key) AS idx
+		FROM json_each(recurring_payment.payee_ids) AS payee
+		GROUP BY 1
+		ORDER BY idx
+	)
+), paused = FALSE
+WHERE EXISTS (SELECT 1 FROM json_each(recurring_payment.payee_ids) WHERE value = @source)`},
+		}
+		for _, rewrite := range rewrites {
+			args := sqliteArgs{
+				"source": sourceID,
+				"target": targetID,
+			}
+			_, err = r.exec(rewrite.name, rewrite.query, args)
+			if err != nil {
+				return struct{}{}, err
+			}
+		}
+
+		// remove duplicate
+		deleteQuery := "DELETE FROM users WHERE id = @id"
+		deleteArgs := sqliteArgs{
+			"id": sourceID,
+		}
+		affected, err = r.exec("MergeUser.delete", deleteQuery, deleteArgs)
+		if err != nil {
+			return struct{}{}, err
+		}
+		err = expectRows(r.ctx, r.L, affected, 1, "users")
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		// the target takes over the source's person, who would otherwise lose the member
+		if person[sourceID] != nil && person[targetID] == nil {
+			linkQuery := "UPDATE users SET person_id = @personID, version = version + 1 WHERE id = @target"
+			linkArgs := sqliteArgs{
+				"personID": *person[sourceID],
+				"target":   targetID,
+			}
+			_, err = r.exec("MergeUser.link", linkQuery, linkArgs)
+			if err != nil {
+				return struct{}{}, err
+			}
+		}
+
+		return struct{}{}, r.enqueueEvent(groupID, EventUserMerged, map[string]any{
+			"user_id":   sourceID,
+			"target_id": targetID,
+		})
+	})
+	return err
+}
+
+func (r *sqliteRun) lockUser(groupID int, userID int, version *int) error {
+	query := "SELECT version FROM users WHERE id = @id AND group_id = @groupID"
+	args := sqliteArgs{
+		"id":      userID,
+		"groupID": groupID,
+	}
+	return r.lockVersion("lockUser", query, args, version)
+}
+
+// Payments
+
+// json_group_array stands in for ARRAY_AGG. Payees come back in the order they were added
+const sqlitePaymentQuery = `
+	SELECT
+		p.id,
+		p.group_id                                                  AS group_id,
+		p.description                                               AS description,
+		p.amount                                                    AS amount,
+		u.id                                                        AS payer_id,
+		u.name                                                      AS payer_name,
+		u.balance                                                   AS payer_balance,
+		u.active                                                    AS payer_active,
+		u.version                                                   AS payer_version,
+		json_group_array(uu.id ORDER BY up.rowid)                   AS payee_ids,
+		json_group_array(uu.name ORDER BY up.rowid)                 AS payee_names,
+		json_group_array(uu.balance ORDER BY up.rowid)              AS payee_balances,
+		json_group_array(json(CASE WHEN uu.active THEN 'true' ELSE 'false' END) ORDER BY up.rowid)
+		                                                            AS payee_actives,
+		json_group_array(uu.version ORDER BY up.rowid)              AS payee_versions,
+		p.version                                                   AS version
+	FROM payment AS p
+	LEFT JOIN users AS u
+		ON p.payer_id = u.id
+	LEFT JOIN users_payment AS up
+		ON up.payment_id = p.id
+	LEFT JOIN users AS uu
+		ON up.user_id = uu.id
+	`
+
+func (r *sqliteRun) getPayments(name string, where string, orderBy string, args sqliteArgs) ([]Payment, error) {
+	query := sqlitePaymentQuery + where + "\n\tGROUP BY p.id\n\t" + orderBy
+
+	payments := []Payment{}
+	err := r.query(name, query, args, func(rows *sql.Rows) error {
+		var payment Payment
+		err := rows.Scan(
+			&payment.ID,
+			&payment.GroupID,
+			&payment.Description,
+			&payment.Amount,
+			&payment.PayerID,
+			&payment.PayerName,
+			&payment.PayerBalance,
+			&payment.PayerActive,
+			&payment.PayerVersion,
+			sqliteJSON{&payment.PayeeIDs},
+			sqliteJSON{&payment.PayeeNames},
+			sqliteJSON{&payment.PayeeBalances},
+			sqliteJSON{&payment.PayeeActives},
+			sqliteJSON{&payment.PayeeVersions},
+			&payment.Version,
+		)
+		payments = append(payments, payment)
+		return err
+	})
+	if err != nil {
+		return []Payment{}, err
+	}
+
+	return payments, nil
+}
+
+func (s *SqliteStore) GetPaymentsByGroupID(ctx context.Context, groupID int) ([]Payment, error) {
+	return s.run(ctx, s.db).getPayments("GetPaymentsByGroupID", "WHERE p.group_id = @id", "ORDER BY p.id", sqliteArgs{
+		"id": groupID,
+	})
+}
+
+func (s *SqliteStore) GetPaymentByID(ctx context.Context, id int) (Payment, error) {
+	return s.run(ctx, s.db).getPaymentByID(id)
+}
+
+func (r *sqliteRun) getPaymentByID(id int) (Payment, error) {
+	payments, err := r.getPayments("GetPaymentsByID", "WHERE p.id = @id", "", sqliteArgs{
+		"id": id,
+	})
+	if err != nil {
+		return Payment{}, err
+	}
+	if len(payments) == 0 {
+		return Payment{}, ErrNotFound
+	}
+
+	return payments[0], nil
+}
+
+func (s *SqliteStore) AddPaymentByGroupId(ctx context.Context, groupID int, body InsertPayment) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		return r.addPayment(groupID, body)
+	})
+}
+
+func (r *sqliteRun) addPayment(id int, body InsertPayment) (int, error) {
+	// the write transaction holds the database, so no deactivation can slip in after this
+	activeQuery := "SELECT COUNT(*) FROM users WHERE id IN (SELECT value FROM json_each(@ids)) AND group_id = @groupID AND NOT active"
+	activeArgs := sqliteArgs{
+		"ids":     sqliteArray(append([]int{body.PayerID}, body.PayeeIDs...)),
+		"groupID": id,
+	}
+
+	var inactive int
+	err := r.queryRow("AddPaymentByGroupId.active", activeQuery, activeArgs, &inactive)
+	if err != nil {
+		return 0, err
+	}
+	if inactive > 0 {
+		r.L.ErrorContext(r.ctx, "Insert failed: payment involves inactive users")
+		return 0, ErrUserInactive
+	}
+
+	// insert payment
+	paymentQuery := `INSERT INTO payment (group_id, description, amount, payer_id)
+VALUES (@id, @description, @amount, @payerID)
+RETURNING id`
+	paymentArgs := sqliteArgs{
+		"id":          id,
+		"description": body.Description,
+		"amount":      body.Amount,
+		"payerID":     body.PayerID,
+	}
+
+	var paymentID int
+	err = r.queryRow("AddPaymentByGroupId.payment", paymentQuery, paymentArgs, &paymentID)
+	if err != nil {
+		return 0, err
+	}
+
+	// a payment without payees is left for the check before commit to reject
+	if len(body.PayeeIDs) > 0 {
+		// insert junction
+		upQuery := "INSERT INTO users_payment (user_id, payment_id) SELECT value, @paymentID FROM json_each(@payeeIDs)"
+		upArgs := sqliteArgs{
+			"paymentID": paymentID,
+			"payeeIDs":  sqliteArray(body.PayeeIDs),
+		}
+		affected, err := r.exec("AddPaymentByGroupId.users_payment", upQuery, upArgs)
+		if err != nil {
+			return 0, err
+		}
+		err = expectRows(r.ctx, r.L, affected, int64(len(body.PayeeIDs)), "users_payment")
+		if err != nil {
+			return 0, err
+		}
+
+		// update balance
+		payeeBalance := body.Amount / float32(len(body.PayeeIDs))
+		payeeQuery := "UPDATE users SET balance = balance + @payeeBalance WHERE id IN (SELECT value FROM json_each(@payeeIDs))"
+		payeeArgs := sqliteArgs{
+			"payeeBalance": payeeBalance,
+			"payeeIDs":     sqliteArray(body.PayeeIDs),
+		}
+		affected, err = r.exec("AddPaymentByGroupId.payees", payeeQuery, payeeArgs)
+		if err != nil {
+			return 0, err
+		}
+		err = expectRows(r.ctx, r.L, affected, int64(len(body.PayeeIDs)), "users")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	payerQuery := "UPDATE users SET balance = balance - @amount WHERE id = @id"
+	payerArgs := sqliteArgs{
+		"amount": body.Amount,
+		"id":     body.PayerID,
+	}
+	affected, err := r.exec("AddPaymentByGroupId.payer", payerQuery, payerArgs)
+	if err != nil {
+		return 0, err
+	}
+	err = expectRows(r.ctx, r.L, affected, 1, "users")
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.enqueueEvent(id, EventPaymentCreated, map[string]any{
+		"payment_id":  paymentID,
+		"description": body.Description,
+		"amount":      body.Amount,
+		"payer_id":    body.PayerID,
+		"payee_ids":   body.PayeeIDs,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return paymentID, nil
+}
+
+func (s *SqliteStore) PatchPayment(ctx context.Context, groupID int, paymentID int, version *int, amount *float32, description *string) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		return struct{}{}, r.patchPayment(groupID, paymentID, version, amount, description)
+	})
+	return err
+}
+
+func (r *sqliteRun) patchPayment(groupID int, paymentID int, version *int, amount *float32, description *string) error {
+	payment, err := r.lockPayment(groupID, paymentID, version)
+	if err != nil {
+		return err
+	}
+	if amount != nil && hasInactiveUser(payment) {
+		r.L.ErrorContext(r.ctx, fmt.Sprintf("Patch failed: payment %v involves inactive users", paymentID))
+		return ErrUserInactive
+	}
+
+	query := `UPDATE payment SET
+	description = COALESCE(@description, description),
+	amount = COALESCE(@amount, amount),
+	version = version + 1
+WHERE id = @id`
+	args := sqliteArgs{
+		"description": description,
+		"amount":      amount,
+		"id":          payment.ID,
+	}
+	affected, err := r.exec("PatchPayment.payment", query, args)
+	if err != nil {
+		return err
+	}
+	err = expectRows(r.ctx, r.L, affected, 1, "payment")
+	if err != nil {
+		return err
+	}
+
+	if amount != nil {
+		// update payer balance
+		amtDiff := *amount - payment.Amount
+		payerQuery := "UPDATE users SET balance = balance - @diff WHERE id = @id"
+		payerArgs := sqliteArgs{
+			"diff": amtDiff,
+			"id":   payment.PayerID,
+		}
+		affected, err := r.exec("PatchPayment.payerBalance", payerQuery, payerArgs)
+		if err != nil {
+			return err
+		}
+		err = expectRows(r.ctx, r.L, affected, 1, "users")
+		if err != nil {
+			return err
+		}
+
+		// update payee balances
+		amtDiffPer := amtDiff / float32(len(payment.PayeeIDs))
+		payeeQuery := "UPDATE users SET balance = balance + @amtDiffPer WHERE id IN (SELECT value FROM json_each(@ids))"
+		payeeArgs := sqliteArgs{
+			"amtDiffPer": amtDiffPer,
+			"ids":        sqliteArray(payment.PayeeIDs),
+		}
+		affected, err = r.exec("PatchPayment.payeeBalance", payeeQuery, payeeArgs)
+		if err != nil {
+			return err
+		}
+		err = expectRows(r.ctx, r.L, affected, int64(len(payment.PayeeIDs)), "users")
+		if err != nil {
+			return err
+		}
+	}
+
+	newAmount, newDescription := payment.Amount, payment.Description
+	if amount != nil {
+		newAmount = *amount
+	}
+	if description != nil {
+		newDescription = description
+	}
+	return r.enqueueEvent(payment.GroupID, EventPaymentUpdated, map[string]any{
+		"payment_id":  payment.ID,
+		"description": newDescription,
+		"amount":      newAmount,
+	})
+}
+
+func (s *SqliteStore) DeletePayment(ctx context.Context, groupID int, paymentID int, version *int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		return struct{}{}, r.deletePayment(groupID, paymentID, version)
+	})
+	return err
+}
+
+func (r *sqliteRun) deletePayment(groupID int, paymentID int, version *int) error {
+	payment, err := r.lockPayment(groupID, paymentID, version)
+	if err != nil {
+		return err
+	}
+	if hasInactiveUser(payment) {
+		r.L.ErrorContext(r.ctx, fmt.Sprintf("Delete failed: payment %v involves inactive users", paymentID))
+		return ErrUserInactive
+	}
+
+	// update payees
+	payeeBalance := payment.Amount / float32(len(payment.PayeeIDs))
+	payeeQuery := "UPDATE users SET balance = balance - @payeeBalance WHERE id IN (SELECT value FROM json_each(@payeeIDs))"
+	payeeArgs := sqliteArgs{
+		"payeeBalance": payeeBalance,
+		"payeeIDs":     sqliteArray(payment.PayeeIDs),
+	}
+	affected, err := r.exec("DeletePayment.payees", payeeQuery, payeeArgs)
+	if err != nil {
+		return err
+	}
+	err = expectRows(r.ctx, r.L, affected, int64(len(payment.PayeeIDs)), "users")
+	if err != nil {
+		return err
+	}
+
+	// update payer
+	payerQuery := "UPDATE users SET balance = balance + @amount WHERE id = @id"
+	payerArgs := sqliteArgs{
+		"amount": payment.Amount,
+		"id":     payment.PayerID,
+	}
+	affected, err = r.exec("DeletePayment.payer", payerQuery, payerArgs)
+	if err != nil {
+		return err
+	}
+	err = expectRows(r.ctx, r.L, affected, 1, "users")
+	if err != nil {
+		return err
+	}
+
+	// remove payment
+	deleteQuery := "DELETE FROM payment WHERE id = @id"
+	deleteArgs := sqliteArgs{
+		"id": payment.ID,
+	}
+	affected, err = r.exec("DeletePayment.delete", deleteQuery, deleteArgs)
+	if err != nil {
+		return err
+	}
+	err = expectRows(r.ctx, r.L, affected, 1, "payment")
+	if err != nil {
+		return err
+	}
+
+	return r.enqueueEvent(payment.GroupID, EventPaymentDeleted, map[string]any{
+		"payment_id": payment.ID,
+	})
+}
+
+func (s *SqliteStore) DeleteAllPayments(ctx context.Context, groupID int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		deleteQuery := "DELETE FROM payment WHERE group_id = @id RETURNING id"
+		deleteArgs := sqliteArgs{
+			"id": groupID,
+		}
+		paymentIDs := []int{}
+		err := r.query("DeleteAllPayments.delete", deleteQuery, deleteArgs, func(rows *sql.Rows) error {
+			var id int
+			err := rows.Scan(&id)
+			paymentIDs = append(paymentIDs, id)
+			return err
+		})
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		// RETURNING gives no order in SQLite
+		slices.Sort(paymentIDs)
+		for _, paymentID := range paymentIDs {
+			err = r.enqueueEvent(groupID, EventPaymentDeleted, map[string]any{
+				"payment_id": paymentID,
+			})
+			if err != nil {
+				return struct{}{}, err
+			}
+		}
+
+		// clear all balances
+		userQuery := "UPDATE users SET balance = 0 WHERE group_id = @id"
+		userArgs := sqliteArgs{
+			"id": groupID,
+		}
+		_, err = r.exec("DeleteAllPayments.deleteAll", userQuery, userArgs)
+		return struct{}{}, err
+	})
+	return err
+}
+
+// lockPayment checks the payment's version and reads the payment as it is now
+func (r *sqliteRun) lockPayment(groupID int, paymentID int, version *int) (Payment, error) {
+	query := "SELECT version FROM payment WHERE id = @id AND group_id = @groupID"
+	args := sqliteArgs{
+		"id":      paymentID,
+		"groupID": groupID,
+	}
+	err := r.lockVersion("lockPayment", query, args, version)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	return r.getPaymentByID(paymentID)
+}
+
+// a settlement is booked as a payment from the debtor with the creditor as the only payee
+func (s *SqliteStore) RecordSettlement(ctx context.Context, groupID int, fromID int, toID int, amount float32) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		paymentID, err := r.addPayment(groupID, InsertPayment{
+			Description: "Settlement",
+			Amount:      amount,
+			PayerID:     fromID,
+			PayeeIDs:    []int{toID},
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		err = r.enqueueEvent(groupID, EventSettlementRecorded, map[string]any{
+			"payment_id": paymentID,
+			"from_id":    fromID,
+			"to_id":      toID,
+			"amount":     amount,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		return paymentID, nil
+	})
+}
+
+func (s *SqliteStore) ApplyBatch(ctx context.Context, groupID int, ops []BatchOp) ([]int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) ([]int, error) {
+		refs := map[string]int{}
+		ids := make([]int, 0, len(ops))
+		for idx, op := range ops {
+			id, err := r.applyBatchOp(groupID, op, refs)
+			if err != nil {
+				return nil, &BatchError{Index: idx, Err: err}
+			}
+			if op.Ref != "" {
+				refs[op.Ref] = id
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+}
+
+func (r *sqliteRun) applyBatchOp(groupID int, op BatchOp, refs map[string]int) (int, error) {
+	if op.Op == BatchCreate {
+		switch op.Type {
+		case BatchUser:
+			return r.addUser(groupID, *op.Name)
+		case BatchPayment:
+			return r.addBatchPayment(groupID, op, refs)
+		}
+		return 0, fmt.Errorf("unknown type %q", op.Type)
+	}
+
+	id, err := op.ID.resolve(refs)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case op.Op == BatchUpdate && op.Type == BatchUser:
+		err = r.patchUser(groupID, id, op.Version, *op.Name)
+	case op.Op == BatchDelete && op.Type == BatchUser:
+		err = r.deleteUser(groupID, id, op.Version)
+	case op.Op == BatchUpdate && op.Type == BatchPayment:
+		err = r.patchPayment(groupID, id, op.Version, op.Amount, op.Description)
+	case op.Op == BatchDelete && op.Type == BatchPayment:
+		err = r.deletePayment(groupID, id, op.Version)
+	default:
+		err = fmt.Errorf("unknown operation %q on %q", op.Op, op.Type)
+	}
+	return id, err
+}
+
+func (r *sqliteRun) addBatchPayment(groupID int, op BatchOp, refs map[string]int) (int, error) {
+	payment := InsertPayment{
+		Amount:   *op.Amount,
+		PayeeIDs: make([]int, 0, len(op.PayeeIDs)),
+	}
+	if op.Description != nil {
+		payment.Description = *op.Description
+	}
+
+	var err error
+	payment.PayerID, err = op.PayerID.resolve(refs)
+	if err != nil {
+		return 0, err
+	}
+	for _, payee := range op.PayeeIDs {
+		payeeID, err := payee.resolve(refs)
+		if err != nil {
+			return 0, err
+		}
+		payment.PayeeIDs = append(payment.PayeeIDs, payeeID)
+	}
+
+	return r.addPayment(groupID, payment)
+}
+
+// Recurring payments
+
+const sqliteRecurringColumns = "id, group_id, description, amount, payer_id, payee_ids, frequency, day_of_month, start_date, end_date, next_run, paused"
+
+func scanRecurring(rows *sql.Rows) (RecurringPayment, error) {
+	var recurring RecurringPayment
+	err := rows.Scan(
+		&recurring.ID,
+		&recurring.GroupID,
+		&recurring.Description,
+		&recurring.Amount,
+		&recurring.PayerID,
+		sqliteJSON{&recurring.PayeeIDs},
+		&recurring.Frequency,
+		&recurring.DayOfMonth,
+		sqliteTime{&recurring.StartDate},
+		sqliteNullTime{&recurring.EndDate},
+		sqliteTime{&recurring.NextRun},
+		&recurring.Paused,
+	)
+	return recurring, err
+}
+
+func (s *SqliteStore) GetRecurringPaymentsByGroupID(ctx context.Context, groupID int) ([]RecurringPayment, error) {
+	query := "SELECT " + sqliteRecurringColumns + " FROM recurring_payment WHERE group_id = @id ORDER BY id"
+	args := sqliteArgs{
+		"id": groupID,
+	}
+
+	recurring := []RecurringPayment{}
+	err := s.run(ctx, s.db).query("GetRecurringPaymentsByGroupID", query, args, func(rows *sql.Rows) error {
+		template, err := scanRecurring(rows)
+		recurring = append(recurring, template)
+		return err
+	})
+	if err != nil {
+		return []RecurringPayment{}, err
+	}
+
+	return recurring, nil
+}
+
+func (s *SqliteStore) AddRecurringPayment(ctx context.Context, groupID int, body InsertRecurringPayment) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		dayOfMonth := 0
+		if body.DayOfMonth != nil {
+			dayOfMonth = *body.DayOfMonth
+		}
+		nextRun := NextOccurrence(body.Frequency, dayOfMonth, body.StartDate, body.StartDate.AddDate(0, 0, -1))
+
+		query := `INSERT INTO recurring_payment (group_id, description, amount, payer_id, payee_ids, frequency, day_of_month, start_date, end_date, next_run)
+VALUES (@groupID, @description, @amount, @payerID, @payeeIDs, @frequency, @dayOfMonth, @startDate, @endDate, @nextRun)
+RETURNING id`
+		args := sqliteArgs{
+			"groupID":     groupID,
+			"description": body.Description,
+			"amount":      body.Amount,
+			"payerID":     body.PayerID,
+			"payeeIDs":    sqliteArray(body.PayeeIDs),
+			"frequency":   body.Frequency,
+			"dayOfMonth":  body.DayOfMonth,
+			"startDate":   sqliteDateText(&body.StartDate),
+			"endDate":     sqliteDateText(body.EndDate),
+			"nextRun":     sqliteDateText(&nextRun),
+		}
+
+		var id int
+		err := r.queryRow("AddRecurringPayment", query, args, &id)
+		return id, err
+	})
+}
+
+func (s *SqliteStore) DeleteRecurringPayment(ctx context.Context, groupID int, id int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		query := "DELETE FROM recurring_payment WHERE id = @id AND group_id = @groupID"
+		args := sqliteArgs{
+			"id":      id,
+			"groupID": groupID,
+		}
+		affected, err := r.exec("DeleteRecurringPayment", query, args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		if affected == 0 {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Delete failed: recurring payment %v does not exist", id))
+			return struct{}{}, ErrNotFound
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// books every occurrence due on or before today, including ones missed while the server was down
+func (s *SqliteStore) MaterializeRecurringPayments(ctx context.Context, today time.Time) (int, error) {
+	query := "SELECT id FROM recurring_payment WHERE next_run <= @today AND (end_date IS NULL OR next_run <= end_date) AND NOT paused"
+	args := sqliteArgs{
+		"today": sqliteDateText(&today),
+	}
+
+	ids := []int{}
+	err := s.run(ctx, s.db).query("MaterializeRecurringPayments.due", query, args, func(rows *sql.Rows) error {
+		var id int
+		err := rows.Scan(&id)
+		ids = append(ids, id)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// one transaction per template so a broken one does not hold up the rest
+	count := 0
+	for _, id := range ids {
+		booked, err := sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+			return r.materializeRecurringPayment(id, today)
+		})
+		if err != nil {
+			s.L.ErrorContext(ctx, fmt.Sprintf("Materialize failed for recurring payment %v: %v", id, err))
+			continue
+		}
+		count += booked
+	}
+
+	return count, nil
+}
+
+func (r *sqliteRun) materializeRecurringPayment(id int, today time.Time) (int, error) {
+	query := "SELECT " + sqliteRecurringColumns + " FROM recurring_payment WHERE id = @id AND next_run <= @today AND NOT paused"
+	args := sqliteArgs{
+		"id":    id,
+		"today": sqliteDateText(&today),
+	}
+
+	var recurring RecurringPayment
+	found := false
+	err := r.query("materializeRecurringPayment.lock", query, args, func(rows *sql.Rows) error {
+		var err error
+		recurring, err = scanRecurring(rows)
+		found = true
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, nil
+	}
+
+	inactiveQuery := "SELECT COUNT(*) FROM users WHERE id IN (SELECT value FROM json_each(@ids)) AND NOT active"
+	inactiveArgs := sqliteArgs{
+		"ids": sqliteArray(append([]int{recurring.PayerID}, recurring.PayeeIDs...)),
+	}
+
+	var inactive int
+	err = r.queryRow("materializeRecurringPayment.inactive", inactiveQuery, inactiveArgs, &inactive)
+	if err != nil {
+		return 0, err
+	}
+	if inactive > 0 {
+		// it would fail the same way on every run until someone deletes or fixes it
+		pauseQuery := "UPDATE recurring_payment SET paused = TRUE WHERE id = @id"
+		pauseArgs := sqliteArgs{
+			"id": recurring.ID,
+		}
+		_, err = r.exec("materializeRecurringPayment.pause", pauseQuery, pauseArgs)
+		if err != nil {
+			return 0, err
+		}
+		r.L.WarnContext(r.ctx, fmt.Sprintf("Paused recurring payment %v, which involves inactive users", recurring.ID))
+		return 0, nil
+	}
+
+	dayOfMonth := 0
+	if recurring.DayOfMonth != nil {
+		dayOfMonth = *recurring.DayOfMonth
+	}
+
+	booked := 0
+	run := recurring.NextRun
+	for !run.After(today) && (recurring.EndDate == nil || !run.After(*recurring.EndDate)) {
+		occurrenceQuery := `INSERT INTO recurring_occurrence (recurring_id, occurs_on)
+VALUES (@id, @occursOn)
+ON CONFLICT DO NOTHING`
+		occurrenceArgs := sqliteArgs{
+			"id":       recurring.ID,
+			"occursOn": sqliteDateText(&run),
+		}
+		affected, err := r.exec("materializeRecurringPayment.occurrence", occurrenceQuery, occurrenceArgs)
+		if err != nil {
+			return 0, err
+		}
+
+		if affected == 1 {
+			paymentID, err := r.addPayment(recurring.GroupID, InsertPayment{
+				Description: fmt.Sprintf("%s (%s)", recurring.Description, run.Format(time.DateOnly)),
+				Amount:      recurring.Amount,
+				PayerID:     recurring.PayerID,
+				PayeeIDs:    recurring.PayeeIDs,
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			linkQuery := "UPDATE recurring_occurrence SET payment_id = @paymentID WHERE recurring_id = @id AND occurs_on = @occursOn"
+			linkArgs := sqliteArgs{
+				"paymentID": paymentID,
+				"id":        recurring.ID,
+				"occursOn":  sqliteDateText(&run),
+			}
+			_, err = r.exec("materializeRecurringPayment.link", linkQuery, linkArgs)
+			if err != nil {
+				return 0, err
+			}
+			booked++
+		}
+
+		run = NextOccurrence(recurring.Frequency, dayOfMonth, recurring.StartDate, run)
+	}
+
+	nextQuery := "UPDATE recurring_payment SET next_run = @nextRun WHERE id = @id"
+	nextArgs := sqliteArgs{
+		"nextRun": sqliteDateText(&run),
+		"id":      recurring.ID,
+	}
+	_, err = r.exec("materializeRecurringPayment.next", nextQuery, nextArgs)
+	if err != nil {
+		return 0, err
+	}
+
+	return booked, nil
+}
+
+// People
+
+func (s *SqliteStore) GetPersonByID(ctx context.Context, id int) (Person, error) {
+	query := "SELECT id, name FROM people WHERE people.id = @id"
+	args := sqliteArgs{
+		"id": id,
+	}
+
+	var person Person
+	err := s.run(ctx, s.db).queryRow("GetPersonByID", query, args, &person.ID, &person.Name)
+	if err != nil {
+		return Person{}, err
+	}
+
+	return person, nil
+}
+
+func (s *SqliteStore) CreatePerson(ctx context.Context, name string) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		query := "INSERT INTO people (name) VALUES (@name) RETURNING id"
+		args := sqliteArgs{
+			"name": name,
+		}
+
+		var id int
+		err := r.queryRow("CreatePerson", query, args, &id)
+		return id, err
+	})
+}
+
+func (s *SqliteStore) GetMembershipsByPersonID(ctx context.Context, id int) ([]Membership, error) {
+	query := `
+	SELECT
+		g.id      AS group_id,
+		g.name    AS group_name,
+		u.id      AS user_id,
+		u.balance AS balance
+	FROM users AS u
+	JOIN groups AS g
+		ON u.group_id = g.id
+	WHERE u.person_id = @id
+	ORDER BY g.id`
+	args := sqliteArgs{
+		"id": id,
+	}
+
+	memberships := []Membership{}
+	err := s.run(ctx, s.db).query("GetMembershipsByPersonID", query, args, func(rows *sql.Rows) error {
+		var membership Membership
+		err := rows.Scan(&membership.GroupID, &membership.GroupName, &membership.UserID, &membership.Balance)
+		memberships = append(memberships, membership)
+		return err
+	})
+	if err != nil {
+		return []Membership{}, err
+	}
+
+	return memberships, nil
+}
+
+func (s *SqliteStore) LinkUserToPerson(ctx context.Context, personID int, userID int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		query := "UPDATE users SET person_id = @personID, version = version + 1 WHERE id = @id"
+		args := sqliteArgs{
+			"personID": personID,
+			"id":       userID,
+		}
+		affected, err := r.exec("LinkUserToPerson", query, args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		if affected == 0 {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Patch failed: user %v does not exist", userID))
+			return struct{}{}, ErrNotFound
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func (s *SqliteStore) UnlinkUserFromPerson(ctx context.Context, personID int, userID int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		query := "UPDATE users SET person_id = NULL, version = version + 1 WHERE id = @id AND person_id = @personID"
+		args := sqliteArgs{
+			"id":       userID,
+			"personID": personID,
+		}
+		affected, err := r.exec("UnlinkUserFromPerson", query, args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		if affected == 0 {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Patch failed: user %v is not linked to person %v", userID, personID))
+			return struct{}{}, ErrNotFound
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// Webhooks
+
+func scanWebhook(rows *sql.Rows) (Webhook, error) {
+	var webhook Webhook
+	err := rows.Scan(
+		&webhook.ID,
+		&webhook.GroupID,
+		&webhook.URL,
+		&webhook.Secret,
+		sqliteJSON{&webhook.Events},
+		sqliteTime{&webhook.CreatedAt},
+	)
+	return webhook, err
+}
+
+func (s *SqliteStore) GetWebhooksByGroupID(ctx context.Context, groupID int) ([]Webhook, error) {
+	query := "SELECT id, group_id, url, secret, events, created_at FROM webhook WHERE group_id = @id ORDER BY id"
+	args := sqliteArgs{
+		"id": groupID,
+	}
+
+	webhooks := []Webhook{}
+	err := s.run(ctx, s.db).query("GetWebhooksByGroupID", query, args, func(rows *sql.Rows) error {
+		webhook, err := scanWebhook(rows)
+		webhooks = append(webhooks, webhook)
+		return err
+	})
+	if err != nil {
+		return []Webhook{}, err
+	}
+
+	return webhooks, nil
+}
+
+func (s *SqliteStore) GetWebhookByID(ctx context.Context, groupID int, id int) (Webhook, error) {
+	query := "SELECT id, group_id, url, secret, events, created_at FROM webhook WHERE id = @id AND group_id = @groupID"
+	args := sqliteArgs{
+		"id":      id,
+		"groupID": groupID,
+	}
+
+	var webhook Webhook
+	found := false
+	err := s.run(ctx, s.db).query("GetWebhookByID", query, args, func(rows *sql.Rows) error {
+		var err error
+		webhook, err = scanWebhook(rows)
+		found = true
+		return err
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	if !found {
+		return Webhook{}, ErrNotFound
+	}
+
+	return webhook, nil
+}
+
+func (s *SqliteStore) AddWebhook(ctx context.Context, groupID int, url string, secret string, events []string) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		query := "INSERT INTO webhook (group_id, url, secret, events, created_at) VALUES (@groupID, @url, @secret, @events, @now) RETURNING id"
+		args := sqliteArgs{
+			"groupID": groupID,
+			"url":     url,
+			"secret":  secret,
+			"events":  sqliteArray(events),
+			"now":     sqliteTimeText(r.now),
+		}
+
+		var id int
+		err := r.queryRow("AddWebhook", query, args, &id)
+		return id, err
+	})
+}
+
+func (s *SqliteStore) DeleteWebhook(ctx context.Context, groupID int, id int) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		query := "DELETE FROM webhook WHERE id = @id AND group_id = @groupID"
+		args := sqliteArgs{
+			"id":      id,
+			"groupID": groupID,
+		}
+		affected, err := r.exec("DeleteWebhook", query, args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		if affected == 0 {
+			r.L.ErrorContext(r.ctx, fmt.Sprintf("Delete failed: webhook %v does not exist", id))
+			return struct{}{}, ErrNotFound
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func (s *SqliteStore) GetDeliveriesByWebhookID(ctx context.Context, id int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT
+		d.id,
+		d.webhook_id,
+		d.event_id,
+		e.event,
+		d.status,
+		d.attempts,
+		d.next_attempt_at,
+		d.last_status_code,
+		d.last_error,
+		d.delivered_at
+	FROM webhook_delivery AS d
+	JOIN group_event AS e
+		ON d.event_id = e.id
+	WHERE d.webhook_id = @id
+	ORDER BY d.id DESC
+	LIMIT 100`
+	args := sqliteArgs{
+		"id": id,
+	}
+
+	deliveries := []WebhookDelivery{}
+	err := s.run(ctx, s.db).query("GetDeliveriesByWebhookID", query, args, func(rows *sql.Rows) error {
+		var delivery WebhookDelivery
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.EventID,
+			&delivery.Event,
+			&delivery.Status,
+			&delivery.Attempts,
+			sqliteTime{&delivery.NextAttemptAt},
+			&delivery.LastStatusCode,
+			&delivery.LastError,
+			sqliteNullTime{&delivery.DeliveredAt},
+		)
+		deliveries = append(deliveries, delivery)
+		return err
+	})
+	if err != nil {
+		return []WebhookDelivery{}, err
+	}
+
+	return deliveries, nil
+}
+
+// queues a fresh delivery of the same event, leaving the original in the log
+func (s *SqliteStore) ReplayDelivery(ctx context.Context, webhookID int, id int) (int, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int, error) {
+		query := `INSERT INTO webhook_delivery (webhook_id, event_id, next_attempt_at)
+SELECT webhook_id, event_id, @now FROM webhook_delivery WHERE id = @id AND webhook_id = @webhookID
+RETURNING id`
+		args := sqliteArgs{
+			"id":        id,
+			"webhookID": webhookID,
+			"now":       sqliteTimeText(r.now),
+		}
+
+		var replayID int
+		err := r.queryRow("ReplayDelivery", query, args, &replayID)
+		return replayID, err
+	})
+}
+
+// leases due deliveries so that they are not claimed again while they are being sent
+func (s *SqliteStore) ClaimPendingDeliveries(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) ([]PendingDelivery, error) {
+		dueQuery := `SELECT id FROM webhook_delivery
+WHERE status = 'pending' AND next_attempt_at <= @now
+ORDER BY next_attempt_at
+LIMIT @limit`
+		dueArgs := sqliteArgs{
+			"now":   sqliteTimeText(r.now),
+			"limit": limit,
+		}
+		ids := []int{}
+		err := r.query("ClaimPendingDeliveries.due", dueQuery, dueArgs, func(rows *sql.Rows) error {
+			var id int
+			err := rows.Scan(&id)
+			ids = append(ids, id)
+			return err
+		})
+		if err != nil {
+			return []PendingDelivery{}, err
+		}
+
+		leaseQuery := "UPDATE webhook_delivery SET next_attempt_at = @until WHERE id IN (SELECT value FROM json_each(@ids))"
+		leaseArgs := sqliteArgs{
+			"until": sqliteTimeText(r.now.Add(lease)),
+			"ids":   sqliteArray(ids),
+		}
+		_, err = r.exec("ClaimPendingDeliveries.lease", leaseQuery, leaseArgs)
+		if err != nil {
+			return []PendingDelivery{}, err
+		}
+
+		query := `
+		SELECT
+			d.id,
+			d.attempts,
+			w.url,
+			w.secret,
+			e.id         AS event_id,
+			e.group_id   AS group_id,
+			e.event      AS event,
+			e.payload    AS payload,
+			e.created_at AS event_created_at
+		FROM webhook_delivery AS d
+		JOIN webhook AS w
+			ON d.webhook_id = w.id
+		JOIN group_event AS e
+			ON d.event_id = e.id
+		WHERE d.id IN (SELECT value FROM json_each(@ids))
+		ORDER BY d.id`
+		args := sqliteArgs{
+			"ids": sqliteArray(ids),
+		}
+		deliveries := []PendingDelivery{}
+		err = r.query("ClaimPendingDeliveries", query, args, func(rows *sql.Rows) error {
+			var delivery PendingDelivery
+			err := rows.Scan(
+				&delivery.ID,
+				&delivery.Attempts,
+				&delivery.URL,
+				&delivery.Secret,
+				&delivery.EventID,
+				&delivery.GroupID,
+				&delivery.Event,
+				&delivery.Payload,
+				sqliteTime{&delivery.EventCreatedAt},
+			)
+			deliveries = append(deliveries, delivery)
+			return err
+		})
+		if err != nil {
+			return []PendingDelivery{}, err
+		}
+
+		return deliveries, nil
+	})
+}
+
+// records the outcome of one attempt; retry is when to try again, or nil to give up
+func (s *SqliteStore) RecordDeliveryAttempt(ctx context.Context, id int, statusCode *int, deliveryErr *string, succeeded bool, retry *time.Time) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		status := DeliveryFailed
+		if succeeded {
+			status = DeliverySucceeded
+		} else if retry != nil {
+			status = DeliveryPending
+		}
+
+		var retryAt any
+		if retry != nil {
+			retryAt = sqliteTimeText(*retry)
+		}
+
+		query := `UPDATE webhook_delivery SET
+	status = @status,
+	attempts = attempts + 1,
+	next_attempt_at = COALESCE(@retry, next_attempt_at),
+	last_status_code = @statusCode,
+	last_error = @error,
+	delivered_at = CASE WHEN @succeeded THEN @now ELSE NULL END
+WHERE id = @id`
+		args := sqliteArgs{
+			"status":     status,
+			"retry":      retryAt,
+			"statusCode": statusCode,
+			"error":      deliveryErr,
+			"succeeded":  succeeded,
+			"now":        sqliteTimeText(r.now),
+			"id":         id,
+		}
+		affected, err := r.exec("RecordDeliveryAttempt", query, args)
+		if err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, expectRows(r.ctx, r.L, affected, 1, "webhook_delivery")
+	})
+	return err
+}
+
+// Events
+
+// records an event in the group log, queues a delivery for every subscribed webhook
+// and has it announced to listeners once the transaction commits
+func (r *sqliteRun) enqueueEvent(groupID int, event string, payload map[string]any) error {
+	if slices.Contains(balanceEvents, event) {
+		balanceQuery := "SELECT id, balance FROM users WHERE group_id = @groupID ORDER BY id"
+		balanceArgs := sqliteArgs{
+			"groupID": groupID,
+		}
+		balances := []map[string]any{}
+		err := r.query("enqueueEvent.balances", balanceQuery, balanceArgs, func(rows *sql.Rows) error {
+			var id int
+			var balance float32
+			err := rows.Scan(&id, &balance)
+			balances = append(balances, map[string]any{
+				"user_id": id,
+				"balance": balance,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		payload["balances"] = balances
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	eventQuery := "INSERT INTO group_event (group_id, event, payload, created_at) VALUES (@groupID, @event, @payload, @now) RETURNING id"
+	eventArgs := sqliteArgs{
+		"groupID": groupID,
+		"event":   event,
+		"payload": string(data),
+		"now":     sqliteTimeText(r.now),
+	}
+
+	var eventID int
+	err = r.queryRow("enqueueEvent.event", eventQuery, eventArgs, &eventID)
+	if err != nil {
+		return err
+	}
+
+	deliveryQuery := `INSERT INTO webhook_delivery (webhook_id, event_id, next_attempt_at)
+SELECT id, @eventID, @now FROM webhook
+WHERE group_id = @groupID AND EXISTS (SELECT 1 FROM json_each(webhook.events) WHERE value = @event)`
+	deliveryArgs := sqliteArgs{
+		"eventID": eventID,
+		"now":     sqliteTimeText(r.now),
+		"groupID": groupID,
+		"event":   event,
+	}
+	_, err = r.exec("enqueueEvent.delivery", deliveryQuery, deliveryArgs)
+	if err != nil {
+		return err
+	}
+
+	r.notices = append(r.notices, eventNotice{groupID, eventID})
+	return nil
+}
+
+func (s *SqliteStore) GetLatestEventID(ctx context.Context, groupID int) (int, error) {
+	query := "SELECT COALESCE(MAX(id), 0) FROM group_event WHERE group_id = @groupID"
+	args := sqliteArgs{
+		"groupID": groupID,
+	}
+
+	var id int
+	err := s.run(ctx, s.db).queryRow("GetLatestEventID", query, args, &id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *SqliteStore) GetEventsSince(ctx context.Context, groupID int, afterID int, limit int) ([]GroupEvent, error) {
+	query := `
+	SELECT id, group_id, event, payload, created_at
+	FROM group_event
+	WHERE group_id = @groupID AND id > @afterID
+	ORDER BY id
+	LIMIT @limit`
+	args := sqliteArgs{
+		"groupID": groupID,
+		"afterID": afterID,
+		"limit":   limit,
+	}
+
+	events := []GroupEvent{}
+	err := s.run(ctx, s.db).query("GetEventsSince", query, args, func(rows *sql.Rows) error {
+		var event GroupEvent
+		err := rows.Scan(&event.ID, &event.GroupID, &event.Event, &event.Payload, sqliteTime{&event.CreatedAt})
+		events = append(events, event)
+		return err
+	})
+	if err != nil {
+		return []GroupEvent{}, err
+	}
+
+	return events, nil
+}
+
+// ListenEvents passes on events as writes to this store commit, until ctx is done
+func (s *SqliteStore) ListenEvents(ctx context.Context, listener EventListener) error {
+	return s.events.listen(ctx, listener)
+}
+
+// Idempotency keys
+
+// ReserveIdempotencyKey claims key for a new request. When the key is already taken
+// within the retention window it returns false with the stored response instead.
+func (s *SqliteStore) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, retention time.Duration) (bool, IdempotentResponse, error) {
+	type result struct {
+		reserved bool
+		existing IdempotentResponse
+	}
+
+	res, err := sqliteTx(ctx, s, func(r *sqliteRun) (result, error) {
+		// an expired key is free to use again
+		query := "DELETE FROM idempotency_key WHERE key = @key AND created_at < @cutoff"
+		args := sqliteArgs{
+			"key":    key,
+			"cutoff": sqliteTimeText(r.now.Add(-retention)),
+		}
+		_, err := r.exec("ReserveIdempotencyKey", query, args)
+		if err != nil {
+			return result{}, err
+		}
+
+		query = "INSERT INTO idempotency_key (key, fingerprint, created_at) VALUES (@key, @fingerprint, @now) ON CONFLICT (key) DO NOTHING"
+		args = sqliteArgs{
+			"key":         key,
+			"fingerprint": fingerprint,
+			"now":         sqliteTimeText(r.now),
+		}
+		affected, err := r.exec("ReserveIdempotencyKey", query, args)
+		if err != nil {
+			return result{}, err
+		}
+		if affected == 1 {
+			return result{reserved: true}, nil
+		}
+
+		query = "SELECT key, fingerprint, status_code, headers, body, created_at FROM idempotency_key WHERE key = @key"
+		args = sqliteArgs{
+			"key": key,
+		}
+
+		var existing IdempotentResponse
+		err = r.queryRow("ReserveIdempotencyKey", query, args,
+			&existing.Key,
+			&existing.Fingerprint,
+			&existing.StatusCode,
+			sqliteJSON{&existing.Headers},
+			&existing.Body,
+			sqliteTime{&existing.CreatedAt},
+		)
+		if err != nil {
+			return result{}, err
+		}
+
+		return result{existing: existing}, nil
+	})
+	return res.reserved, res.existing, err
+}
+
+func (s *SqliteStore) SaveIdempotentResponse(ctx context.Context, key string, statusCode int, headers map[string][]string, body []byte) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		query := "UPDATE idempotency_key SET status_code = @statusCode, headers = @headers, body = @body WHERE key = @key"
+		args := sqliteArgs{
+			"key":        key,
+			"statusCode": statusCode,
+			"headers":    sqliteArray(headers),
+			"body":       body,
+		}
+		_, err := r.exec("SaveIdempotentResponse", query, args)
+		return struct{}{}, err
+	})
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a reserved key, so a request that failed on our side can be retried
+func (s *SqliteStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := sqliteTx(ctx, s, func(r *sqliteRun) (struct{}, error) {
+		query := "DELETE FROM idempotency_key WHERE key = @key"
+		args := sqliteArgs{
+			"key": key,
+		}
+		_, err := r.exec("ReleaseIdempotencyKey", query, args)
+		return struct{}{}, err
+	})
+	return err
+}
+
+func (s *SqliteStore) DeleteExpiredIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	return sqliteTx(ctx, s, func(r *sqliteRun) (int64, error) {
+		query := "DELETE FROM idempotency_key WHERE created_at < @cutoff"
+		args := sqliteArgs{
+			"cutoff": sqliteTimeText(r.now.Add(-retention)),
+		}
+		return r.exec("DeleteExpiredIdempotencyKeys", query, args)
+	})
+}
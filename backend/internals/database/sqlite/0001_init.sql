@@ -0,0 +1,304 @@
+-- SQLite version of migrations/sql/0001_init.up.sql. Foreign keys and checks keep the
+-- Postgres constraint names, and triggers raise "<constraint> [column]: <message>" so the
+-- store can report them the way Postgres would. Times are UTC text, which sorts correctly.
+CREATE TABLE groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_activity_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE TABLE people (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    person_id INTEGER REFERENCES people (id)
+        ON DELETE SET NULL,
+    name TEXT NOT NULL,
+    balance REAL NOT NULL DEFAULT 0,
+    active INTEGER NOT NULL DEFAULT 1,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT users_group_id_person_id_key UNIQUE (group_id, person_id)
+);
+
+CREATE TABLE payment (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    amount REAL NOT NULL CONSTRAINT payment_amount_check CHECK (amount > 0),
+    payer_id INTEGER REFERENCES users (id)
+        ON DELETE RESTRICT,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE users_payment (
+    user_id INTEGER REFERENCES users (id) ON DELETE RESTRICT,
+    payment_id INTEGER REFERENCES payment (id) ON DELETE CASCADE,
+    CONSTRAINT users_payment_pkey PRIMARY KEY (user_id, payment_id)
+);
+
+CREATE TABLE balance_transfer (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    from_user_id INTEGER REFERENCES users (id),
+    to_user_id INTEGER REFERENCES users (id),
+    amount REAL NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+-- payee_ids is a JSON array
+CREATE TABLE recurring_payment (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    amount REAL NOT NULL,
+    payer_id INTEGER REFERENCES users (id)
+        ON DELETE CASCADE,
+    payee_ids TEXT NOT NULL,
+    frequency TEXT NOT NULL,
+    day_of_month INTEGER,
+    start_date TEXT NOT NULL,
+    end_date TEXT,
+    next_run TEXT NOT NULL,
+    -- in name order, which is the order Postgres checks them in
+    CONSTRAINT recurring_payment_amount_check CHECK (amount > 0),
+    CONSTRAINT recurring_payment_day_of_month_check CHECK (day_of_month BETWEEN 1 AND 31),
+    CONSTRAINT recurring_payment_frequency_check CHECK (frequency IN ('weekly', 'monthly', 'yearly')),
+    CONSTRAINT recurring_payment_payee_ids_check CHECK (json_array_length(payee_ids) > 0)
+);
+
+CREATE TABLE recurring_occurrence (
+    recurring_id INTEGER REFERENCES recurring_payment (id)
+        ON DELETE CASCADE,
+    occurs_on TEXT NOT NULL,
+    payment_id INTEGER REFERENCES payment (id)
+        ON DELETE SET NULL,
+    PRIMARY KEY (recurring_id, occurs_on)
+);
+
+-- events is a JSON array
+CREATE TABLE webhook (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE TABLE group_event (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+CREATE TABLE webhook_delivery (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER REFERENCES webhook (id)
+        ON DELETE CASCADE,
+    event_id INTEGER REFERENCES group_event (id)
+        ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CONSTRAINT webhook_delivery_status_check CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TEXT
+);
+
+CREATE INDEX webhook_delivery_pending ON webhook_delivery (next_attempt_at)
+    WHERE status = 'pending';
+
+CREATE TABLE idempotency_key (
+    key TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    headers TEXT,
+    body BLOB,
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+
+-- SQLite only reports that some foreign key failed, so the ones a request can trip
+-- are checked first by triggers that name them
+
+CREATE TRIGGER users_group_id_fkey
+BEFORE INSERT ON users
+WHEN NOT EXISTS (SELECT 1 FROM groups WHERE id = NEW.group_id)
+BEGIN
+    SELECT RAISE(ABORT, 'users_group_id_fkey: insert or update on table "users" violates foreign key constraint "users_group_id_fkey"');
+END;
+
+CREATE TRIGGER users_person_id_fkey
+BEFORE UPDATE OF person_id ON users
+WHEN NEW.person_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM people WHERE id = NEW.person_id)
+BEGIN
+    SELECT RAISE(ABORT, 'users_person_id_fkey: insert or update on table "users" violates foreign key constraint "users_person_id_fkey"');
+END;
+
+CREATE TRIGGER users_referenced
+BEFORE DELETE ON users
+BEGIN
+    SELECT RAISE(ABORT, 'payment_payer_id_fkey: update or delete on table "users" violates foreign key constraint "payment_payer_id_fkey" on table "payment"')
+    WHERE EXISTS (SELECT 1 FROM payment WHERE payer_id = OLD.id);
+    SELECT RAISE(ABORT, 'users_payment_user_id_fkey: update or delete on table "users" violates foreign key constraint "users_payment_user_id_fkey" on table "users_payment"')
+    WHERE EXISTS (SELECT 1 FROM users_payment WHERE user_id = OLD.id);
+    SELECT RAISE(ABORT, 'balance_transfer_from_user_id_fkey: update or delete on table "users" violates foreign key constraint "balance_transfer_from_user_id_fkey" on table "balance_transfer"')
+    WHERE EXISTS (SELECT 1 FROM balance_transfer WHERE from_user_id = OLD.id);
+    SELECT RAISE(ABORT, 'balance_transfer_to_user_id_fkey: update or delete on table "users" violates foreign key constraint "balance_transfer_to_user_id_fkey" on table "balance_transfer"')
+    WHERE EXISTS (SELECT 1 FROM balance_transfer WHERE to_user_id = OLD.id);
+END;
+
+CREATE TRIGGER payment_fkeys
+BEFORE INSERT ON payment
+BEGIN
+    SELECT RAISE(ABORT, 'payment_group_id_fkey: insert or update on table "payment" violates foreign key constraint "payment_group_id_fkey"')
+    WHERE NOT EXISTS (SELECT 1 FROM groups WHERE id = NEW.group_id);
+    SELECT RAISE(ABORT, 'payment_payer_id_fkey: insert or update on table "payment" violates foreign key constraint "payment_payer_id_fkey"')
+    WHERE NOT EXISTS (SELECT 1 FROM users WHERE id = NEW.payer_id);
+END;
+
+CREATE TRIGGER users_payment_user_id_fkey
+BEFORE INSERT ON users_payment
+WHEN NOT EXISTS (SELECT 1 FROM users WHERE id = NEW.user_id)
+BEGIN
+    SELECT RAISE(ABORT, 'users_payment_user_id_fkey: insert or update on table "users_payment" violates foreign key constraint "users_payment_user_id_fkey"');
+END;
+
+CREATE TRIGGER balance_transfer_to_user_id_fkey
+BEFORE INSERT ON balance_transfer
+WHEN NOT EXISTS (SELECT 1 FROM users WHERE id = NEW.to_user_id)
+BEGIN
+    SELECT RAISE(ABORT, 'balance_transfer_to_user_id_fkey: insert or update on table "balance_transfer" violates foreign key constraint "balance_transfer_to_user_id_fkey"');
+END;
+
+CREATE TRIGGER recurring_payment_fkeys
+BEFORE INSERT ON recurring_payment
+BEGIN
+    SELECT RAISE(ABORT, 'recurring_payment_group_id_fkey: insert or update on table "recurring_payment" violates foreign key constraint "recurring_payment_group_id_fkey"')
+    WHERE NOT EXISTS (SELECT 1 FROM groups WHERE id = NEW.group_id);
+    SELECT RAISE(ABORT, 'recurring_payment_payer_id_fkey: insert or update on table "recurring_payment" violates foreign key constraint "recurring_payment_payer_id_fkey"')
+    WHERE NOT EXISTS (SELECT 1 FROM users WHERE id = NEW.payer_id);
+END;
+
+CREATE TRIGGER webhook_group_id_fkey
+BEFORE INSERT ON webhook
+WHEN NOT EXISTS (SELECT 1 FROM groups WHERE id = NEW.group_id)
+BEGIN
+    SELECT RAISE(ABORT, 'webhook_group_id_fkey: insert or update on table "webhook" violates foreign key constraint "webhook_group_id_fkey"');
+END;
+
+CREATE TRIGGER group_event_group_id_fkey
+BEFORE INSERT ON group_event
+WHEN NOT EXISTS (SELECT 1 FROM groups WHERE id = NEW.group_id)
+BEGIN
+    SELECT RAISE(ABORT, 'group_event_group_id_fkey: insert or update on table "group_event" violates foreign key constraint "group_event_group_id_fkey"');
+END;
+
+-- payment has to have at least 1 user associated. SQLite has no deferred triggers, so
+-- written payments are queued here and the store empties the queue right before it
+-- commits, which runs the check against the finished transaction
+CREATE TABLE payment_check (
+    payment_id INTEGER PRIMARY KEY
+);
+
+CREATE TRIGGER queue_payment_check_on_insert
+AFTER INSERT ON payment
+BEGIN
+    INSERT OR IGNORE INTO payment_check (payment_id) VALUES (NEW.id);
+END;
+
+CREATE TRIGGER queue_payment_check_on_update
+AFTER UPDATE ON payment
+BEGIN
+    INSERT OR IGNORE INTO payment_check (payment_id) VALUES (NEW.id);
+END;
+
+CREATE TRIGGER ensure_payment_has_users
+BEFORE DELETE ON payment_check
+WHEN EXISTS (SELECT 1 FROM payment WHERE id = OLD.payment_id)
+    AND NOT EXISTS (SELECT 1 FROM users_payment WHERE payment_id = OLD.payment_id)
+BEGIN
+    SELECT RAISE(ABORT, 'ensure_payment_has_users payee_ids: Payment ' || OLD.payment_id || ' must have at least one associated user');
+END;
+
+-- payees and payer have to be in the same group as the payment. Neither a user's nor a
+-- payment's group ever changes, so this can be checked as rows are written
+CREATE TRIGGER ensure_payment_payer_in_same_group
+AFTER INSERT ON payment
+BEGIN
+    SELECT RAISE(ABORT, 'ensure_payment_users_in_same_group payer_id: Payer ' || NEW.payer_id || ' does not exist')
+    WHERE NOT EXISTS (SELECT 1 FROM users WHERE id = NEW.payer_id);
+    SELECT RAISE(ABORT, 'ensure_payment_users_in_same_group payer_id: Payer ' || NEW.payer_id || ' is not in the same group as the payment (group ' || NEW.group_id || ')')
+    WHERE (SELECT group_id FROM users WHERE id = NEW.payer_id) != NEW.group_id;
+END;
+
+CREATE TRIGGER ensure_payment_payer_in_same_group_on_update
+AFTER UPDATE OF payer_id ON payment
+BEGIN
+    SELECT RAISE(ABORT, 'ensure_payment_users_in_same_group payer_id: Payer ' || NEW.payer_id || ' is not in the same group as the payment (group ' || NEW.group_id || ')')
+    WHERE (SELECT group_id FROM users WHERE id = NEW.payer_id) IS NOT NEW.group_id;
+END;
+
+CREATE TRIGGER ensure_payment_payees_in_same_group
+AFTER INSERT ON users_payment
+WHEN (SELECT group_id FROM users WHERE id = NEW.user_id) IS NOT (SELECT group_id FROM payment WHERE id = NEW.payment_id)
+BEGIN
+    SELECT RAISE(ABORT, 'ensure_payment_users_in_same_group payee_ids: One or more users in users_payment are not in the same group as the payment (group ' || (SELECT group_id FROM payment WHERE id = NEW.payment_id) || ')');
+END;
+
+CREATE TRIGGER ensure_payment_payees_in_same_group_on_update
+AFTER UPDATE OF user_id ON users_payment
+WHEN (SELECT group_id FROM users WHERE id = NEW.user_id) IS NOT (SELECT group_id FROM payment WHERE id = NEW.payment_id)
+BEGIN
+    SELECT RAISE(ABORT, 'ensure_payment_users_in_same_group payee_ids: One or more users in users_payment are not in the same group as the payment (group ' || (SELECT group_id FROM payment WHERE id = NEW.payment_id) || ')');
+END;
+
+-- any change to a group's members or payments counts as activity on the group
+CREATE TRIGGER touch_group_activity_on_users_insert
+AFTER INSERT ON users
+BEGIN
+    UPDATE groups SET last_activity_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.group_id;
+END;
+
+CREATE TRIGGER touch_group_activity_on_users_update
+AFTER UPDATE ON users
+BEGIN
+    UPDATE groups SET last_activity_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.group_id;
+END;
+
+CREATE TRIGGER touch_group_activity_on_users_delete
+AFTER DELETE ON users
+BEGIN
+    UPDATE groups SET last_activity_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = OLD.group_id;
+END;
+
+CREATE TRIGGER touch_group_activity_on_payment_insert
+AFTER INSERT ON payment
+BEGIN
+    UPDATE groups SET last_activity_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.group_id;
+END;
+
+CREATE TRIGGER touch_group_activity_on_payment_update
+AFTER UPDATE ON payment
+BEGIN
+    UPDATE groups SET last_activity_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = NEW.group_id;
+END;
+
+CREATE TRIGGER touch_group_activity_on_payment_delete
+AFTER DELETE ON payment
+BEGIN
+    UPDATE groups SET last_activity_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE id = OLD.group_id;
+END;
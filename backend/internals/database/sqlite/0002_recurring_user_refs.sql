@@ -0,0 +1,11 @@
+-- SQLite version of migrations/sql/0003_recurring_user_refs.up.sql. The payer_id foreign key
+-- still says ON DELETE CASCADE, since SQLite cannot alter it, but this trigger aborts the
+-- delete before the cascade would run
+CREATE TRIGGER recurring_payment_referenced
+BEFORE DELETE ON users
+BEGIN
+    SELECT RAISE(ABORT, 'recurring_payment_payer_id_fkey: update or delete on table "users" violates foreign key constraint "recurring_payment_payer_id_fkey" on table "recurring_payment"')
+    WHERE EXISTS (SELECT 1 FROM recurring_payment WHERE payer_id = OLD.id);
+    SELECT RAISE(ABORT, 'recurring_payment_payee_ids_fkey: update or delete on table "users" violates foreign key constraint "recurring_payment_payee_ids_fkey" on table "recurring_payment"')
+    WHERE EXISTS (SELECT 1 FROM recurring_payment, json_each(recurring_payment.payee_ids) WHERE json_each.value = OLD.id);
+END;
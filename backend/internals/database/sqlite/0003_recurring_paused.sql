@@ -0,0 +1,2 @@
+-- SQLite version of migrations/sql/0004_recurring_paused.up.sql
+ALTER TABLE recurring_payment ADD COLUMN paused INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// returned by every Store when the row asked for does not exist
+var ErrNotFound = pgx.ErrNoRows
+
+// Store is everything the server keeps. Handlers and background jobs only talk to a Store,
+// so the same API runs on Postgres or fully in memory.
+//
+// Implementations share one error vocabulary: ErrNotFound, ErrVersionMismatch, the sentinels
+// in this package, and *pgconn.PgError with the code and constraint name Postgres would use,
+// so that a violated rule reads the same whichever store is behind it.
+type Store interface {
+	GetGroupByID(ctx context.Context, id int) (Group, error)
+	ListGroupsByPersonID(ctx context.Context, personID int, search string, sort string, limit int, offset int) ([]GroupListing, int, error)
+	CreateGroup(ctx context.Context, name string) (int, error)
+	PatchGroup(ctx context.Context, id int, version *int, name string) error
+	DeleteGroup(ctx context.Context, id int, version *int) error
+	GetGroupSummary(ctx context.Context, id int, recent int) (GroupSummary, error)
+
+	GetUsersByGroupID(ctx context.Context, groupID int) ([]User, error)
+	AddUserToGroupByID(ctx context.Context, groupID int, name string) (int, error)
+	PatchUser(ctx context.Context, groupID int, userID int, version *int, name string) error
+	DeleteUser(ctx context.Context, groupID int, userID int, version *int) error
+	DeactivateUser(ctx context.Context, groupID int, userID int, transferTo *int) error
+	MergeUser(ctx context.Context, groupID int, sourceID int, targetID int) error
+
+	GetPaymentsByGroupID(ctx context.Context, groupID int) ([]Payment, error)
+	GetPaymentByID(ctx context.Context, id int) (Payment, error)
+	AddPaymentByGroupId(ctx context.Context, groupID int, body InsertPayment) (int, error)
+	PatchPayment(ctx context.Context, groupID int, paymentID int, version *int, amount *float32, description *string) error
+	DeletePayment(ctx context.Context, groupID int, paymentID int, version *int) error
+	DeleteAllPayments(ctx context.Context, groupID int) error
+	RecordSettlement(ctx context.Context, groupID int, fromID int, toID int, amount float32) (int, error)
+	ApplyBatch(ctx context.Context, groupID int, ops []BatchOp) ([]int, error)
+
+	GetRecurringPaymentsByGroupID(ctx context.Context, groupID int) ([]RecurringPayment, error)
+	AddRecurringPayment(ctx context.Context, groupID int, body InsertRecurringPayment) (int, error)
+	DeleteRecurringPayment(ctx context.Context, groupID int, id int) error
+	MaterializeRecurringPayments(ctx context.Context, today time.Time) (int, error)
+
+	GetPersonByID(ctx context.Context, id int) (Person, error)
+	CreatePerson(ctx context.Context, name string) (int, error)
+	GetMembershipsByPersonID(ctx context.Context, id int) ([]Membership, error)
+	LinkUserToPerson(ctx context.Context, personID int, userID int) error
+	UnlinkUserFromPerson(ctx context.Context, personID int, userID int) error
+
+	GetWebhooksByGroupID(ctx context.Context, groupID int) ([]Webhook, error)
+	GetWebhookByID(ctx context.Context, groupID int, id int) (Webhook, error)
+	AddWebhook(ctx context.Context, groupID int, url string, secret string, events []string) (int, error)
+	DeleteWebhook(ctx context.Context, groupID int, id int) error
+	GetDeliveriesByWebhookID(ctx context.Context, id int) ([]WebhookDelivery, error)
+	ReplayDelivery(ctx context.Context, webhookID int, id int) (int, error)
+	ClaimPendingDeliveries(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error)
+	RecordDeliveryAttempt(ctx context.Context, id int, statusCode *int, deliveryErr *string, succeeded bool, retry *time.Time) error
+
+	GetLatestEventID(ctx context.Context, groupID int) (int, error)
+	GetEventsSince(ctx context.Context, groupID int, afterID int, limit int) ([]GroupEvent, error)
+	// ListenEvents tells listener about every event committed by any instance until ctx is done
+	// or the store loses its connection, which it returns as the error
+	ListenEvents(ctx context.Context, listener EventListener) error
+
+	ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, retention time.Duration) (bool, IdempotentResponse, error)
+	SaveIdempotentResponse(ctx context.Context, key string, statusCode int, headers map[string][]string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// EventListener hears about committed events from Store.ListenEvents
+type EventListener interface {
+	// Listening is called each time the store starts listening, since events committed
+	// before then were not announced
+	Listening()
+	Notify(groupID int, eventID int)
+}
+
+// localEvents hands committed events to the listeners in this process, for the stores
+// that are only ever used by one process
+type localEvents struct {
+	mu        sync.Mutex
+	listeners map[*localListener]struct{}
+}
+
+type localListener struct {
+	EventListener
+}
+
+type eventNotice struct {
+	groupID int
+	eventID int
+}
+
+func (e *localEvents) listen(ctx context.Context, listener EventListener) error {
+	registration := &localListener{listener}
+
+	e.mu.Lock()
+	if e.listeners == nil {
+		e.listeners = map[*localListener]struct{}{}
+	}
+	e.listeners[registration] = struct{}{}
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.listeners, registration)
+		e.mu.Unlock()
+	}()
+
+	listener.Listening()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (e *localEvents) announce(notices []eventNotice) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, notice := range notices {
+		for listener := range e.listeners {
+			listener.Notify(notice.groupID, notice.eventID)
+		}
+	}
+}
+
+// PgStore is the Store backed by a Postgres pool
+type PgStore struct {
+	db *pgxpool.Pool
+	L  *slog.Logger
+}
+
+func NewPgStore(db *pgxpool.Pool, L *slog.Logger) *PgStore {
+	return &PgStore{db: db, L: L}
+}
+
+func (s *PgStore) GetGroupByID(ctx context.Context, id int) (Group, error) {
+	return GetGroupByID(ctx, s.db, s.L, id)
+}
+
+func (s *PgStore) ListGroupsByPersonID(ctx context.Context, personID int, search string, sort string, limit int, offset int) ([]GroupListing, int, error) {
+	return ListGroupsByPersonID(ctx, s.db, s.L, personID, search, sort, limit, offset)
+}
+
+func (s *PgStore) CreateGroup(ctx context.Context, name string) (int, error) {
+	return CreateGroup(ctx, s.db, s.L, name)
+}
+
+func (s *PgStore) PatchGroup(ctx context.Context, id int, version *int, name string) error {
+	return PatchGroup(ctx, s.db, s.L, id, version, name)
+}
+
+func (s *PgStore) DeleteGroup(ctx context.Context, id int, version *int) error {
+	return DeleteGroup(ctx, s.db, s.L, id, version)
+}
+
+func (s *PgStore) GetGroupSummary(ctx context.Context, id int, recent int) (GroupSummary, error) {
+	return GetGroupSummary(ctx, s.db, s.L, id, recent)
+}
+
+func (s *PgStore) GetUsersByGroupID(ctx context.Context, groupID int) ([]User, error) {
+	return GetUsersByGroupID(ctx, s.db, s.L, groupID)
+}
+
+func (s *PgStore) AddUserToGroupByID(ctx context.Context, groupID int, name string) (int, error) {
+	return AddUserToGroupByID(ctx, s.db, s.L, groupID, name)
+}
+
+func (s *PgStore) PatchUser(ctx context.Context, groupID int, userID int, version *int, name string) error {
+	return PatchUser(ctx, s.db, s.L, groupID, userID, version, name)
+}
+
+func (s *PgStore) DeleteUser(ctx context.Context, groupID int, userID int, version *int) error {
+	return DeleteUser(ctx, s.db, s.L, groupID, userID, version)
+}
+
+func (s *PgStore) DeactivateUser(ctx context.Context, groupID int, userID int, transferTo *int) error {
+	return DeactivateUser(ctx, s.db, s.L, groupID, userID, transferTo)
+}
+
+func (s *PgStore) MergeUser(ctx context.Context, groupID int, sourceID int, targetID int) error {
+	return MergeUser(ctx, s.db, s.L, groupID, sourceID, targetID)
+}
+
+func (s *PgStore) GetPaymentsByGroupID(ctx context.Context, groupID int) ([]Payment, error) {
+	return GetPaymentsByGroupID(ctx, s.db, s.L, groupID)
+}
+
+func (s *PgStore) GetPaymentByID(ctx context.Context, id int) (Payment, error) {
+	return GetPaymentByID(ctx, s.db, s.L, id)
+}
+
+func (s *PgStore) AddPaymentByGroupId(ctx context.Context, groupID int, body InsertPayment) (int, error) {
+	return AddPaymentByGroupId(ctx, s.db, s.L, groupID, body)
+}
+
+func (s *PgStore) PatchPayment(ctx context.Context, groupID int, paymentID int, version *int, amount *float32, description *string) error {
+	return PatchPayment(ctx, s.db, s.L, groupID, paymentID, version, amount, description)
+}
+
+func (s *PgStore) DeletePayment(ctx context.Context, groupID int, paymentID int, version *int) error {
+	return DeletePayment(ctx, s.db, s.L, groupID, paymentID, version)
+}
+
+func (s *PgStore) DeleteAllPayments(ctx context.Context, groupID int) error {
+	return DeleteAllPayments(ctx, s.db, s.L, groupID)
+}
+
+func (s *PgStore) RecordSettlement(ctx context.Context, groupID int, fromID int, toID int, amount float32) (int, error) {
+	return RecordSettlement(ctx, s.db, s.L, groupID, fromID, toID, amount)
+}
+
+func (s *PgStore) ApplyBatch(ctx context.Context, groupID int, ops []BatchOp) ([]int, error) {
+	return ApplyBatch(ctx, s.db, s.L, groupID, ops)
+}
+
+func (s *PgStore) GetRecurringPaymentsByGroupID(ctx context.Context, groupID int) ([]RecurringPayment, error) {
+	return GetRecurringPaymentsByGroupID(ctx, s.db, s.L, groupID)
+}
+
+func (s *PgStore) AddRecurringPayment(ctx context.Context, groupID int, body InsertRecurringPayment) (int, error) {
+	return AddRecurringPayment(ctx, s.db, s.L, groupID, body)
+}
+
+func (s *PgStore) DeleteRecurringPayment(ctx context.Context, groupID int, id int) error {
+	return DeleteRecurringPayment(ctx, s.db, s.L, groupID, id)
+}
+
+func (s *PgStore) MaterializeRecurringPayments(ctx context.Context, today time.Time) (int, error) {
+	return MaterializeRecurringPayments(ctx, s.db, s.L, today)
+}
+
+func (s *PgStore) GetPersonByID(ctx context.Context, id int) (Person, error) {
+	return GetPersonByID(ctx, s.db, s.L, id)
+}
+
+func (s *PgStore) CreatePerson(ctx context.Context, name string) (int, error) {
+	return CreatePerson(ctx, s.db, s.L, name)
+}
+
+func (s *PgStore) GetMembershipsByPersonID(ctx context.Context, id int) ([]Membership, error) {
+	return GetMembershipsByPersonID(ctx, s.db, s.L, id)
+}
+
+func (s *PgStore) LinkUserToPerson(ctx context.Context, personID int, userID int) error {
+	return LinkUserToPerson(ctx, s.db, s.L, personID, userID)
+}
+
+func (s *PgStore) UnlinkUserFromPerson(ctx context.Context, personID int, userID int) error {
+	return UnlinkUserFromPerson(ctx, s.db, s.L, personID, userID)
+}
+
+func (s *PgStore) GetWebhooksByGroupID(ctx context.Context, groupID int) ([]Webhook, error) {
+	return GetWebhooksByGroupID(ctx, s.db, s.L, groupID)
+}
+
+func (s *PgStore) GetWebhookByID(ctx context.Context, groupID int, id int) (Webhook, error) {
+	return GetWebhookByID(ctx, s.db, s.L, groupID, id)
+}
+
+func (s *PgStore) AddWebhook(ctx context.Context, groupID int, url string, secret string, events []string) (int, error) {
+	return AddWebhook(ctx, s.db, s.L, groupID, url, secret, events)
+}
+
+func (s *PgStore) DeleteWebhook(ctx context.Context, groupID int, id int) error {
+	return DeleteWebhook(ctx, s.db, s.L, groupID, id)
+}
+
+func (s *PgStore) GetDeliveriesByWebhookID(ctx context.Context, id int) ([]WebhookDelivery, error) {
+	return GetDeliveriesByWebhookID(ctx, s.db, s.L, id)
+}
+
+func (s *PgStore) ReplayDelivery(ctx context.Context, webhookID int, id int) (int, error) {
+	return ReplayDelivery(ctx, s.db, s.L, webhookID, id)
+}
+
+func (s *PgStore) ClaimPendingDeliveries(ctx context.Context, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	return ClaimPendingDeliveries(ctx, s.db, s.L, limit, lease)
+}
+
+func (s *PgStore) RecordDeliveryAttempt(ctx context.Context, id int, statusCode *int, deliveryErr *string, succeeded bool, retry *time.Time) error {
+	return RecordDeliveryAttempt(ctx, s.db, s.L, id, statusCode, deliveryErr, succeeded, retry)
+}
+
+func (s *PgStore) GetLatestEventID(ctx context.Context, groupID int) (int, error) {
+	return GetLatestEventID(ctx, s.db, s.L, groupID)
+}
+
+func (s *PgStore) GetEventsSince(ctx context.Context, groupID int, afterID int, limit int) ([]GroupEvent, error) {
+	return GetEventsSince(ctx, s.db, s.L, groupID, afterID, limit)
+}
+
+// ListenEvents holds a connection LISTENing on EventChannel
+func (s *PgStore) ListenEvents(ctx context.Context, listener EventListener) error {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "LISTEN "+EventChannel)
+	if err != nil {
+		return err
+	}
+	listener.Listening()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		groupIDStr, eventIDStr, _ := strings.Cut(notification.Payload, ":")
+		groupID, err := strconv.Atoi(groupIDStr)
+		if err != nil {
+			s.L.ErrorContext(ctx, fmt.Sprintf("Bad event notification %q", notification.Payload))
+			continue
+		}
+		eventID, _ := strconv.Atoi(eventIDStr)
+		listener.Notify(groupID, eventID)
+	}
+}
+
+func (s *PgStore) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, retention time.Duration) (bool, IdempotentResponse, error) {
+	return ReserveIdempotencyKey(ctx, s.db, s.L, key, fingerprint, retention)
+}
+
+func (s *PgStore) SaveIdempotentResponse(ctx context.Context, key string, statusCode int, headers map[string][]string, body []byte) error {
+	return SaveIdempotentResponse(ctx, s.db, s.L, key, statusCode, headers, body)
+}
+
+func (s *PgStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	return ReleaseIdempotencyKey(ctx, s.db, s.L, key)
+}
+
+func (s *PgStore) DeleteExpiredIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	return DeleteExpiredIdempotencyKeys(ctx, s.db, s.L, retention)
+}
+
+var (
+	_ Store = (*PgStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*SqliteStore)(nil)
+)
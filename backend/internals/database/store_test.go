@@ -0,0 +1,375 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/michaelzhan1/split/internals/migrations"
+)
+
+// forEachStore runs test against an empty store of every kind: in memory, a SQLite file, and
+// Postgres when TEST_DATABASE_URL names a database the tests are allowed to wipe
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	L := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore(L))
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := OpenSqliteStore(context.Background(), filepath.Join(t.TempDir(), "split.db"), L)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer store.Close()
+		test(t, store)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("TEST_DATABASE_URL")
+		if url == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		ctx := context.Background()
+		db, err := pgxpool.New(ctx, url)
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer db.Close()
+		if _, err := migrations.Up(ctx, db, L); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		_, err = db.Exec(ctx, `TRUNCATE groups, people, users, payment, users_payment, balance_transfer,
+recurring_payment, recurring_occurrence, webhook, group_event, webhook_delivery, idempotency_key
+RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+		test(t, NewPgStore(db, L))
+	})
+}
+
+// constraint names the constraint behind err, or is empty when err is not a violation
+func constraint(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.ConstraintName
+	}
+	return ""
+}
+
+// failOn stops the test at an error in its setup
+func failOn(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecurringPaymentsKeepTheirMembers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		alice, err := store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		bob, err := store.AddUserToGroupByID(ctx, group, "Bob")
+		failOn(t, err)
+		carol, err := store.AddUserToGroupByID(ctx, group, "Carol")
+		failOn(t, err)
+		dave, err := store.AddUserToGroupByID(ctx, group, "Dave")
+		failOn(t, err)
+
+		start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+		_, err = store.AddRecurringPayment(ctx, group, InsertRecurringPayment{
+			Description: "Rent",
+			Amount:      900,
+			PayerID:     alice,
+			PayeeIDs:    []int{alice, carol},
+			Frequency:   FrequencyMonthly,
+			StartDate:   start,
+		})
+		failOn(t, err)
+		_, err = store.AddRecurringPayment(ctx, group, InsertRecurringPayment{
+			Description: "Internet",
+			Amount:      40,
+			PayerID:     bob,
+			PayeeIDs:    []int{alice, bob},
+			Frequency:   FrequencyMonthly,
+			StartDate:   start,
+		})
+		failOn(t, err)
+		_, err = store.AddRecurringPayment(ctx, group, InsertRecurringPayment{
+			Description: "Water",
+			Amount:      30,
+			PayerID:     dave,
+			PayeeIDs:    []int{carol},
+			Frequency:   FrequencyMonthly,
+			StartDate:   start,
+		})
+		failOn(t, err)
+
+		if err := store.DeleteUser(ctx, group, dave, nil); constraint(err) != "recurring_payment_payer_id_fkey" {
+			t.Errorf("deleting a template's payer = %v, want the payer foreign key", err)
+		}
+		if err := store.DeleteUser(ctx, group, carol, nil); constraint(err) != "recurring_payment_payee_ids_fkey" {
+			t.Errorf("deleting a template's payee = %v, want the payee foreign key", err)
+		}
+
+		if err := store.MergeUser(ctx, group, alice, bob); err != nil {
+			t.Fatalf("merge: %v", err)
+		}
+		templates, err := store.GetRecurringPaymentsByGroupID(ctx, group)
+		failOn(t, err)
+		if len(templates) != 3 {
+			t.Fatalf("after the merge there are %d templates, want all 3", len(templates))
+		}
+		if rent := templates[0]; rent.PayerID != bob || !slices.Equal(rent.PayeeIDs, []int{bob, carol}) {
+			t.Errorf("rent = payer %d payees %v, want payer %d payees [%d %d]", rent.PayerID, rent.PayeeIDs, bob, bob, carol)
+		}
+		if internet := templates[1]; internet.PayerID != bob || !slices.Equal(internet.PayeeIDs, []int{bob}) {
+			t.Errorf("internet = payer %d payees %v, want payer and only payee %d", internet.PayerID, internet.PayeeIDs, bob)
+		}
+
+		booked, err := store.MaterializeRecurringPayments(ctx, time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC))
+		if booked != 9 || err != nil {
+			t.Errorf("materialize = %d, %v, want 3 months of every template", booked, err)
+		}
+
+		// deleting the group takes the templates with it even though they hold on to members
+		if err := store.DeleteGroup(ctx, group, nil); err != nil {
+			t.Errorf("deleting the group = %v", err)
+		}
+	})
+}
+
+func TestBatchPinsTriggerFailuresOnTheirOperation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		alice, err := store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		other, err := store.CreateGroup(ctx, "Other")
+		failOn(t, err)
+		stranger, err := store.AddUserToGroupByID(ctx, other, "Stranger")
+		failOn(t, err)
+
+		name, amount := "Bob", float32(10)
+		_, err = store.ApplyBatch(ctx, group, []BatchOp{
+			{Op: BatchCreate, Type: BatchUser, Ref: "bob", Name: &name},
+			{Op: BatchCreate, Type: BatchPayment, Amount: &amount, PayerID: BatchID{ID: alice}, PayeeIDs: []BatchID{{Ref: "bob"}}},
+			{Op: BatchCreate, Type: BatchPayment, Amount: &amount, PayerID: BatchID{ID: alice}, PayeeIDs: []BatchID{{ID: stranger}}},
+			{Op: BatchCreate, Type: BatchPayment, Amount: &amount, PayerID: BatchID{ID: alice}, PayeeIDs: []BatchID{{ID: alice}}},
+		})
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) || batchErr.Index != 2 || constraint(err) != "ensure_payment_users_in_same_group" {
+			t.Errorf("batch paying another group's member = %v, want operation 2 to fail the same group trigger", err)
+		}
+
+		users, err := store.GetUsersByGroupID(ctx, group)
+		failOn(t, err)
+		if len(users) != 1 || users[0].Balance != 0 {
+			t.Errorf("after the failed batch the group has %+v, want it rolled back", users)
+		}
+	})
+}
+
+func TestInactiveMembersCannotPay(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		alice, err := store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		bob, err := store.AddUserToGroupByID(ctx, group, "Bob")
+		failOn(t, err)
+		carol, err := store.AddUserToGroupByID(ctx, group, "Carol")
+		failOn(t, err)
+		failOn(t, store.DeactivateUser(ctx, group, bob, nil))
+
+		if _, err := store.AddPaymentByGroupId(ctx, group, InsertPayment{Amount: 10, PayerID: alice, PayeeIDs: []int{alice, bob}}); err != ErrUserInactive {
+			t.Errorf("paying an inactive member = %v, want ErrUserInactive", err)
+		}
+		if _, err := store.AddPaymentByGroupId(ctx, group, InsertPayment{Amount: 10, PayerID: bob, PayeeIDs: []int{alice}}); err != ErrUserInactive {
+			t.Errorf("an inactive member paying = %v, want ErrUserInactive", err)
+		}
+		if _, err := store.RecordSettlement(ctx, group, alice, bob, 10); err != ErrUserInactive {
+			t.Errorf("settling with an inactive member = %v, want ErrUserInactive", err)
+		}
+		amount := float32(10)
+		_, err = store.ApplyBatch(ctx, group, []BatchOp{
+			{Op: BatchCreate, Type: BatchPayment, Amount: &amount, PayerID: BatchID{ID: alice}, PayeeIDs: []BatchID{{ID: bob}}},
+		})
+		if !errors.Is(err, ErrUserInactive) {
+			t.Errorf("batch paying an inactive member = %v, want ErrUserInactive", err)
+		}
+		if got := balances(t, store, group); got[alice] != 0 || got[bob] != 0 || got[carol] != 0 {
+			t.Errorf("balances = %v, want none of the payments booked", got)
+		}
+
+		pg, ok := store.(*PgStore)
+		if !ok {
+			return
+		}
+		// a payment still in flight holds its members, so deactivating one waits and sees its balance
+		tx, err := pg.db.Begin(ctx)
+		failOn(t, err)
+		defer tx.Rollback(ctx)
+		_, err = addPayment(ctx, tx, pg.L, group, InsertPayment{Amount: 10, PayerID: alice, PayeeIDs: []int{carol}})
+		failOn(t, err)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		if err := store.DeactivateUser(waitCtx, group, carol, nil); !errors.Is(err, context.DeadlineExceeded) && !pgconn.Timeout(err) {
+			t.Errorf("deactivating a member of an open payment = %v, want it to wait", err)
+		}
+		failOn(t, tx.Commit(ctx))
+		if err := store.DeactivateUser(ctx, group, carol, nil); err != ErrUnsettledBalance {
+			t.Errorf("deactivating after the payment = %v, want ErrUnsettledBalance", err)
+		}
+	})
+}
+
+// balances keys the group's balances by member
+func balances(t *testing.T, store Store, groupID int) map[int]float32 {
+	t.Helper()
+	users, err := store.GetUsersByGroupID(context.Background(), groupID)
+	failOn(t, err)
+	res := map[int]float32{}
+	for _, user := range users {
+		res[user.ID] = user.Balance
+	}
+	return res
+}
+
+// replayed is what the balances would be had every payment been recorded as it reads now
+func replayed(t *testing.T, store Store, groupID int) map[int]float32 {
+	t.Helper()
+	payments, err := store.GetPaymentsByGroupID(context.Background(), groupID)
+	failOn(t, err)
+	res := map[int]float32{}
+	for _, payment := range payments {
+		res[payment.PayerID] -= payment.Amount
+		for _, payeeID := range payment.PayeeIDs {
+			res[payeeID] += payment.Amount / float32(len(payment.PayeeIDs))
+		}
+	}
+	return res
+}
+
+func closeTo(a, b map[int]float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, balance := range a {
+		if diff := balance - b[id]; diff > 0.001 || diff < -0.001 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMergeUserBalances(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		alice, err := store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		bob, err := store.AddUserToGroupByID(ctx, group, "Bob")
+		failOn(t, err)
+		carol, err := store.AddUserToGroupByID(ctx, group, "Carol")
+		failOn(t, err)
+
+		// Bob is a second account Alice made by mistake, and shares a payment with her
+		payments := []InsertPayment{
+			{Amount: 90, PayerID: alice, PayeeIDs: []int{alice, bob, carol}},
+			{Amount: 40, PayerID: bob, PayeeIDs: []int{bob, carol}},
+			{Amount: 12, PayerID: carol, PayeeIDs: []int{carol}},
+		}
+		for _, payment := range payments {
+			_, err := store.AddPaymentByGroupId(ctx, group, payment)
+			failOn(t, err)
+		}
+		before := balances(t, store, group)
+		if want := (map[int]float32{alice: -60, bob: 10, carol: 50}); !closeTo(before, want) {
+			t.Fatalf("before the merge balances = %v, want %v", before, want)
+		}
+
+		if err := store.MergeUser(ctx, group, bob, alice); err != nil {
+			t.Fatalf("merge: %v", err)
+		}
+
+		// the shared payment is split two ways instead of three, and Bob's payment is Alice's
+		after := balances(t, store, group)
+		if want := (map[int]float32{alice: -65, carol: 65}); !closeTo(after, want) {
+			t.Errorf("after the merge balances = %v, want %v", after, want)
+		}
+		if again := replayed(t, store, group); !closeTo(after, again) {
+			t.Errorf("after the merge balances = %v, but the payments add up to %v", after, again)
+		}
+		got, err := store.GetPaymentsByGroupID(ctx, group)
+		failOn(t, err)
+		for _, payment := range got {
+			if payment.PayerID == bob || slices.Contains(payment.PayeeIDs, bob) {
+				t.Errorf("payment %d still refers to the merged member: %+v", payment.ID, payment)
+			}
+		}
+	})
+}
+
+func TestMergeUserKeepsPersonLink(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		group, err := store.CreateGroup(ctx, "Flat")
+		failOn(t, err)
+		alice, err := store.AddUserToGroupByID(ctx, group, "Alice")
+		failOn(t, err)
+		bob, err := store.AddUserToGroupByID(ctx, group, "Bob")
+		failOn(t, err)
+		carol, err := store.AddUserToGroupByID(ctx, group, "Carol")
+		failOn(t, err)
+		dave, err := store.AddUserToGroupByID(ctx, group, "Dave")
+		failOn(t, err)
+		person, err := store.CreatePerson(ctx, "Bob")
+		failOn(t, err)
+		failOn(t, store.LinkUserToPerson(ctx, person, bob))
+
+		if err := store.MergeUser(ctx, group, bob, alice); err != nil {
+			t.Fatalf("merge: %v", err)
+		}
+		users, err := store.GetUsersByGroupID(ctx, group)
+		failOn(t, err)
+		if users[0].ID != alice || users[0].PersonID == nil || *users[0].PersonID != person || users[0].Version != 2 {
+			t.Errorf("target after the merge = %+v, want it linked to person %d at version 2", users[0], person)
+		}
+
+		// two people cannot both keep the merged member
+		other, err := store.CreatePerson(ctx, "Carol")
+		failOn(t, err)
+		failOn(t, store.LinkUserToPerson(ctx, other, carol))
+		if err := store.MergeUser(ctx, group, carol, alice); !errors.Is(err, ErrPersonConflict) {
+			t.Errorf("merging members of different people = %v, want ErrPersonConflict", err)
+		}
+
+		// a target keeps its own person
+		if err := store.MergeUser(ctx, group, dave, alice); err != nil {
+			t.Fatalf("merge: %v", err)
+		}
+		users, err = store.GetUsersByGroupID(ctx, group)
+		failOn(t, err)
+		if users[0].PersonID == nil || *users[0].PersonID != person {
+			t.Errorf("target after merging an unlinked member = %+v, want it still linked to person %d", users[0], person)
+		}
+	})
+}
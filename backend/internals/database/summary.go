@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func GetGroupSummary(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int, recent int) (GroupSummary, error) {
+	return WithReadTx(ctx, db, func(tx pgx.Tx) (GroupSummary, error) {
+		group, err := getGroupByID(ctx, tx, L, id)
+		if err != nil {
+			return GroupSummary{}, err
+		}
+
+		users, err := getUsersByGroupID(ctx, tx, L, id)
+		if err != nil {
+			return GroupSummary{}, err
+		}
+
+		payments, err := getRecentPaymentsByGroupID(ctx, tx, L, id, recent)
+		if err != nil {
+			return GroupSummary{}, err
+		}
+
+		totalsQuery := "SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM payment WHERE group_id = @id"
+		totalsArgs := pgx.StrictNamedArgs{
+			"id": id,
+		}
+
+		var paymentCount int
+		var totalSpent float32
+		L.DebugContext(ctx, "GetGroupSummary.totals", "query", totalsQuery, "args", totalsArgs)
+		err = tx.QueryRow(ctx, totalsQuery, totalsArgs).Scan(&paymentCount, &totalSpent)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+			return GroupSummary{}, err
+		}
+
+		return GroupSummary{
+			Group:          group,
+			Users:          users,
+			RecentPayments: payments,
+			PaymentCount:   paymentCount,
+			TotalSpent:     totalSpent,
+		}, nil
+	})
+}
+
+func getRecentPaymentsByGroupID(ctx context.Context, db querier, L *slog.Logger, id int, limit int) ([]Payment, error) {
+	query := `
+	SELECT
+		p.id,
+		p.group_id            AS group_id,
+		p.description         AS description,
+		p.amount              AS amount,
+		u.id                  AS payer_id,
+		u.name                AS payer_name,
+		u.balance             AS payer_balance,
+		u.active              AS payer_active,
+		u.version             AS payer_version,
+		ARRAY_AGG(uu.id)      AS payee_ids,
+		ARRAY_AGG(uu.name)    AS payee_names,
+		ARRAY_AGG(uu.balance) AS payee_balances,
+		ARRAY_AGG(uu.active)  AS payee_actives,
+		ARRAY_AGG(uu.version) AS payee_versions,
+		p.version             AS version
+	FROM payment AS p
+	LEFT JOIN users AS u
+		ON p.payer_id = u.id
+	LEFT JOIN users_payment AS up
+		ON up.payment_id = p.id
+	LEFT JOIN users AS uu
+		ON up.user_id = uu.id
+	WHERE p.group_id = @id
+	GROUP BY p.id, p.group_id, p.description, p.amount, p.version, u.name, u.id, u.balance, u.active, u.version
+	ORDER BY p.id DESC
+	LIMIT @limit`
+	args := pgx.StrictNamedArgs{
+		"id":    id,
+		"limit": limit,
+	}
+
+	L.DebugContext(ctx, "getRecentPaymentsByGroupID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []Payment{}, err
+	}
+
+	payments, err := pgx.CollectRows(rows, pgx.RowToStructByName[Payment])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []Payment{}, err
+	}
+
+	return payments, nil
+}
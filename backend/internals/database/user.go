@@ -5,27 +5,42 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var (
+	ErrUserInactive        = errors.New("user is inactive")
+	ErrUnsettledBalance    = errors.New("user has an unsettled balance")
+	ErrInvalidTransferUser = errors.New("invalid user to transfer balance to")
+	ErrPersonConflict      = errors.New("users are linked to different people")
+)
+
+// balances within a cent of zero count as settled
+const SettledEpsilon = 0.005
+
 func GetUsersByGroupID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) ([]User, error) {
-	query := "SELECT id, name, balance FROM users WHERE users.group_id = @id ORDER BY id"
+	return getUsersByGroupID(ctx, db, L, id)
+}
+
+func getUsersByGroupID(ctx context.Context, db querier, L *slog.Logger, id int) ([]User, error) {
+	query := "SELECT id, person_id, name, balance, active, version FROM users WHERE users.group_id = @id ORDER BY id"
 	args := pgx.StrictNamedArgs{
 		"id": id,
 	}
 
-	L.Info("GetUsersByGroupID", "query", query, "args", args)
+	L.DebugContext(ctx, "GetUsersByGroupID", "query", query, "args", args)
 	rows, err := db.Query(ctx, query, args)
 	if err != nil {
-		L.Error(fmt.Sprintf("Get failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
 		return []User{}, err
 	}
 
 	users, err := pgx.CollectRows(rows, pgx.RowToStructByName[User])
 	if err != nil {
-		L.Error(fmt.Sprintf("Binding failed: %v", err))
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
 		return []User{}, err
 	}
 
@@ -34,78 +49,472 @@ func GetUsersByGroupID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id
 
 func AddUserToGroupByID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, name string) (int, error) {
 	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
-		query := "INSERT INTO users (group_id, name) VALUES (@id, @name) RETURNING id"
-		args := pgx.StrictNamedArgs{
-			"id":   groupID,
-			"name": name,
-		}
+		return addUser(ctx, tx, L, groupID, name)
+	})
+}
 
-		var id int
-		L.Info("AddUserToGroupByID", "query", query, "args", args)
-		err := tx.QueryRow(ctx, query, args).Scan(&id)
-		if err != nil {
-			L.Error(fmt.Sprintf("Insert failed: %v", err))
-			return 0, err
-		}
+func addUser(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, name string) (int, error) {
+	query := "INSERT INTO users (group_id, name) VALUES (@id, @name) RETURNING id"
+	args := pgx.StrictNamedArgs{
+		"id":   groupID,
+		"name": name,
+	}
+
+	var id int
+	L.DebugContext(ctx, "AddUserToGroupByID", "query", query, "args", args)
+	err := tx.QueryRow(ctx, query, args).Scan(&id)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+		return 0, err
+	}
 
-		return id, nil
+	err = enqueueEvent(ctx, tx, L, groupID, EventUserAdded, map[string]any{
+		"user_id": id,
+		"name":    name,
 	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
 }
 
-func PatchUser(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, userID int, name string) error {
+// PatchUser renames a user. When version is set, the user must still be at that version.
+func PatchUser(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, userID int, version *int, name string) error {
 	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
-		query := "UPDATE users SET name = @name WHERE id = @id AND group_id = @groupID"
-		args := pgx.StrictNamedArgs{
-			"name":    name,
+		return struct{}{}, patchUser(ctx, tx, L, groupID, userID, version, name)
+	})
+	return err
+}
+
+func patchUser(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, userID int, version *int, name string) error {
+	err := lockUser(ctx, tx, L, groupID, userID, version)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET name = @name, version = version + 1 WHERE id = @id AND group_id = @groupID"
+	args := pgx.StrictNamedArgs{
+		"name":    name,
+		"id":      userID,
+		"groupID": groupID,
+	}
+
+	L.DebugContext(ctx, "PatchUser", "query", query, "args", args)
+	cmdTag, err := tx.Exec(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Patch failed: %v", err))
+		return err
+	}
+	if cmdTag.RowsAffected() > 1 {
+		L.ErrorContext(ctx, "Patch failed: more than one row affected")
+		return errors.New("more than one row affected")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		L.ErrorContext(ctx, fmt.Sprintf("Patch failed: user %v does not exist", userID))
+		return pgx.ErrNoRows
+	}
+
+	err = enqueueEvent(ctx, tx, L, groupID, EventUserUpdated, map[string]any{
+		"user_id": userID,
+		"name":    name,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteUser(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, userID int, version *int) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		return struct{}{}, deleteUser(ctx, tx, L, groupID, userID, version)
+	})
+	return err
+}
+
+func deleteUser(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, userID int, version *int) error {
+	err := lockUser(ctx, tx, L, groupID, userID, version)
+	if err != nil {
+		return err
+	}
+
+	query := "DELETE FROM users WHERE id = @id AND group_id = @groupID"
+	args := pgx.StrictNamedArgs{
+		"id":      userID,
+		"groupID": groupID,
+	}
+
+	L.DebugContext(ctx, "DeleteUser", "query", query, "args", args)
+	cmdTag, err := tx.Exec(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+		return err
+	}
+	if cmdTag.RowsAffected() > 1 {
+		L.ErrorContext(ctx, "Delete failed: more than one row affected")
+		return errors.New("more than one row affected")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		L.ErrorContext(ctx, fmt.Sprintf("Delete failed: user %v does not exist", userID))
+		return pgx.ErrNoRows
+	}
+
+	err = enqueueEvent(ctx, tx, L, groupID, EventUserDeleted, map[string]any{
+		"user_id": userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeactivateUser(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, userID int, transferTo *int) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		// lock user
+		userQuery := "SELECT balance, active FROM users WHERE id = @id AND group_id = @groupID FOR UPDATE"
+		userArgs := pgx.StrictNamedArgs{
 			"id":      userID,
 			"groupID": groupID,
 		}
 
-		L.Info("PatchUser", "query", query, "args", args)
+		var balance float32
+		var active bool
+		L.DebugContext(ctx, "DeactivateUser.user", "query", userQuery, "args", userArgs)
+		err := tx.QueryRow(ctx, userQuery, userArgs).Scan(&balance, &active)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+			return struct{}{}, err
+		}
+		if !active {
+			L.ErrorContext(ctx, fmt.Sprintf("Deactivate failed: user %v is already inactive", userID))
+			return struct{}{}, ErrUserInactive
+		}
+
+		if transferTo != nil {
+			// lock target
+			targetQuery := "SELECT active FROM users WHERE id = @id AND group_id = @groupID FOR UPDATE"
+			targetArgs := pgx.StrictNamedArgs{
+				"id":      *transferTo,
+				"groupID": groupID,
+			}
+
+			var targetActive bool
+			L.DebugContext(ctx, "DeactivateUser.target", "query", targetQuery, "args", targetArgs)
+			err = tx.QueryRow(ctx, targetQuery, targetArgs).Scan(&targetActive)
+			if err == pgx.ErrNoRows || (err == nil && !targetActive) {
+				L.ErrorContext(ctx, fmt.Sprintf("Deactivate failed: user %v cannot receive a balance", *transferTo))
+				return struct{}{}, ErrInvalidTransferUser
+			}
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+				return struct{}{}, err
+			}
+
+			// move balance
+			moveQuery := "UPDATE users SET balance = balance + @amount WHERE id = @id"
+			moveArgs := pgx.StrictNamedArgs{
+				"amount": balance,
+				"id":     *transferTo,
+			}
+			L.DebugContext(ctx, "DeactivateUser.move", "query", moveQuery, "args", moveArgs)
+			cmdTag, err := tx.Exec(ctx, moveQuery, moveArgs)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+				return struct{}{}, err
+			}
+			if cmdTag.RowsAffected() != 1 {
+				L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+				return struct{}{}, errors.New("unexpected number of rows affected")
+			}
+
+			// record transfer
+			auditQuery := `INSERT INTO balance_transfer (group_id, from_user_id, to_user_id, amount)
+VALUES (@groupID, @from, @to, @amount)`
+			auditArgs := pgx.StrictNamedArgs{
+				"groupID": groupID,
+				"from":    userID,
+				"to":      *transferTo,
+				"amount":  balance,
+			}
+			L.DebugContext(ctx, "DeactivateUser.audit", "query", auditQuery, "args", auditArgs)
+			_, err = tx.Exec(ctx, auditQuery, auditArgs)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+				return struct{}{}, err
+			}
+		} else if math.Abs(float64(balance)) >= SettledEpsilon {
+			L.ErrorContext(ctx, fmt.Sprintf("Deactivate failed: user %v has balance %v", userID, balance))
+			return struct{}{}, ErrUnsettledBalance
+		}
+
+		// deactivate
+		query := "UPDATE users SET active = FALSE, balance = 0, version = version + 1 WHERE id = @id"
+		args := pgx.StrictNamedArgs{
+			"id": userID,
+		}
+		L.DebugContext(ctx, "DeactivateUser.deactivate", "query", query, "args", args)
 		cmdTag, err := tx.Exec(ctx, query, args)
 		if err != nil {
-			L.Error(fmt.Sprintf("Patch failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
 			return struct{}{}, err
 		}
-		if cmdTag.RowsAffected() > 1 {
-			L.Error("Patch failed: more than one row affected")
-			return struct{}{}, errors.New("more than one row affected")
+		if cmdTag.RowsAffected() != 1 {
+			L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+			return struct{}{}, errors.New("unexpected number of rows affected")
 		}
-		if cmdTag.RowsAffected() == 0 {
-			L.Error(fmt.Sprintf("Patch failed: user %v does not exist", userID))
-			return struct{}{}, pgx.ErrNoRows
+
+		err = enqueueEvent(ctx, tx, L, groupID, EventUserDeactivated, map[string]any{
+			"user_id":     userID,
+			"transfer_to": transferTo,
+		})
+		if err != nil {
+			return struct{}{}, err
 		}
 
 		return struct{}{}, nil
 	})
+
 	return err
 }
 
-func DeleteUser(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, userID int) error {
+func MergeUser(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, sourceID int, targetID int) error {
 	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
-		query := "DELETE FROM users WHERE id = @id AND group_id = @groupID"
-		args := pgx.StrictNamedArgs{
-			"id":      userID,
+		// lock both users
+		lockQuery := "SELECT id, active, person_id FROM users WHERE id = ANY(@ids) AND group_id = @groupID ORDER BY id FOR UPDATE"
+		lockArgs := pgx.StrictNamedArgs{
+			"ids":     []int{sourceID, targetID},
 			"groupID": groupID,
 		}
-
-		L.Info("DeleteUser", "query", query, "args", args)
-		cmdTag, err := tx.Exec(ctx, query, args)
+		L.DebugContext(ctx, "MergeUser.lock", "query", lockQuery, "args", lockArgs)
+		rows, err := tx.Query(ctx, lockQuery, lockArgs)
 		if err != nil {
-			L.Error(fmt.Sprintf("Delete failed: %v", err))
+			L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
 			return struct{}{}, err
 		}
-		if cmdTag.RowsAffected() > 1 {
-			L.Error("Delete failed: more than one row affected")
-			return struct{}{}, errors.New("more than one row affected")
+		active := map[int]bool{}
+		person := map[int]*int{}
+		var id int
+		var isActive bool
+		var personID *int
+		_, err = pgx.ForEachRow(rows, []any{&id, &isActive, &personID}, func() error {
+			active[id] = isActive
+			person[id] = personID
+			return nil
+		})
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+			return struct{}{}, err
 		}
-		if cmdTag.RowsAffected() == 0 {
-			L.Error(fmt.Sprintf("Delete failed: user %v does not exist", userID))
+		if len(active) != 2 {
+			L.ErrorContext(ctx, fmt.Sprintf("Merge failed: users %v and %v are not both in group %v", sourceID, targetID, groupID))
 			return struct{}{}, pgx.ErrNoRows
 		}
+		if !active[targetID] {
+			L.ErrorContext(ctx, fmt.Sprintf("Merge failed: user %v is inactive", targetID))
+			return struct{}{}, ErrUserInactive
+		}
+		if person[sourceID] != nil && person[targetID] != nil && *person[sourceID] != *person[targetID] {
+			L.ErrorContext(ctx, fmt.Sprintf("Merge failed: users %v and %v are linked to different people", sourceID, targetID))
+			return struct{}{}, ErrPersonConflict
+		}
+
+		// payments the source is on change payer or payees
+		bumpQuery := `UPDATE payment SET version = version + 1
+WHERE payer_id = @source OR id IN (SELECT payment_id FROM users_payment WHERE user_id = @source)`
+		bumpArgs := pgx.StrictNamedArgs{
+			"source": sourceID,
+		}
+		L.DebugContext(ctx, "MergeUser.bump", "query", bumpQuery, "args", bumpArgs)
+		_, err = tx.Exec(ctx, bumpQuery, bumpArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return struct{}{}, err
+		}
+
+		// combine balances
+		balanceQuery := `UPDATE users SET balance = balance + (SELECT balance FROM users WHERE id = @source)
+WHERE id = @target`
+		balanceArgs := pgx.StrictNamedArgs{
+			"source": sourceID,
+			"target": targetID,
+		}
+		L.DebugContext(ctx, "MergeUser.balance", "query", balanceQuery, "args", balanceArgs)
+		cmdTag, err := tx.Exec(ctx, balanceQuery, balanceArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return struct{}{}, err
+		}
+		if cmdTag.RowsAffected() != 1 {
+			L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+			return struct{}{}, errors.New("unexpected number of rows affected")
+		}
+
+		// find payments both users are payees of
+		overlapQuery := `
+		SELECT p.id, p.amount, COUNT(up.user_id)
+		FROM payment AS p
+		JOIN users_payment AS up
+			ON up.payment_id = p.id
+		WHERE p.id IN (SELECT payment_id FROM users_payment WHERE user_id = @source)
+			AND p.id IN (SELECT payment_id FROM users_payment WHERE user_id = @target)
+		GROUP BY p.id, p.amount`
+		overlapArgs := pgx.StrictNamedArgs{
+			"source": sourceID,
+			"target": targetID,
+		}
+		L.DebugContext(ctx, "MergeUser.overlap", "query", overlapQuery, "args", overlapArgs)
+		rows, err = tx.Query(ctx, overlapQuery, overlapArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+			return struct{}{}, err
+		}
+		type overlap struct {
+			paymentID int
+			amount    float32
+			payees    int
+		}
+		overlaps := []overlap{}
+		var o overlap
+		_, err = pgx.ForEachRow(rows, []any{&o.paymentID, &o.amount, &o.payees}, func() error {
+			overlaps = append(overlaps, o)
+			return nil
+		})
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+			return struct{}{}, err
+		}
+
+		// a shared payment loses one payee, so re-split it across the remaining ones
+		for _, o := range overlaps {
+			oldShare := o.amount / float32(o.payees)
+			newShare := o.amount / float32(o.payees-1)
+
+			othersQuery := `UPDATE users SET balance = balance + @diff
+WHERE id IN (SELECT user_id FROM users_payment WHERE payment_id = @paymentID)
+	AND id != @source AND id != @target`
+			othersArgs := pgx.StrictNamedArgs{
+				"diff":      newShare - oldShare,
+				"paymentID": o.paymentID,
+				"source":    sourceID,
+				"target":    targetID,
+			}
+			L.DebugContext(ctx, "MergeUser.others", "query", othersQuery, "args", othersArgs)
+			_, err = tx.Exec(ctx, othersQuery, othersArgs)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+				return struct{}{}, err
+			}
+
+			targetQuery := "UPDATE users SET balance = balance + @diff WHERE id = @target"
+			targetArgs := pgx.StrictNamedArgs{
+				"diff":   newShare - 2*oldShare,
+				"target": targetID,
+			}
+			L.DebugContext(ctx, "MergeUser.target", "query", targetQuery, "args", targetArgs)
+			_, err = tx.Exec(ctx, targetQuery, targetArgs)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+				return struct{}{}, err
+			}
+
+			dropQuery := "DELETE FROM users_payment WHERE user_id = @source AND payment_id = @paymentID"
+			dropArgs := pgx.StrictNamedArgs{
+				"source":    sourceID,
+				"paymentID": o.paymentID,
+			}
+			L.DebugContext(ctx, "MergeUser.drop", "query", dropQuery, "args", dropArgs)
+			_, err = tx.Exec(ctx, dropQuery, dropArgs)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+				return struct{}{}, err
+			}
+		}
+
+		// rewrite references
+		rewrites := []struct {
+			name  string
+			query string
+		}{
+			{"MergeUser.payees", "UPDATE users_payment SET user_id = @target WHERE user_id = @source"},
+			{"MergeUser.payer", "UPDATE payment SET payer_id = @target WHERE payer_id = @source"},
+			{"MergeUser.transferFrom", "UPDATE balance_transfer SET from_user_id = @target WHERE from_user_id = @source"},
+			{"MergeUser.transferTo", "UPDATE balance_transfer SET to_user_id = @target WHERE to_user_id = @source"},
+			// a paused template gets another try, since the merged away member may be why it stopped
+			{"MergeUser.recurringPayer", "UPDATE recurring_payment SET payer_id = @target, paused = FALSE WHERE payer_id = @source"},
+			// a template with both users as payees keeps the target once, where it first appeared
+			{"MergeUser.recurringPayees", `UPDATE recurring_payment SET payee_ids = ARRAY(
+	SELECT payee.id
+	FROM UNNEST(ARRAY_REPLACE(payee_ids, @source, @target)) WITH ORDINALITY AS payee (id, idx)
+	GROUP BY payee.id
+	ORDER BY MIN(payee.idx)
+), paused = FALSE
+WHERE @source = ANY(payee_ids)`},
+		}
+		for _, rewrite := range rewrites {
+			args := pgx.StrictNamedArgs{
+				"source": sourceID,
+				"target": targetID,
+			}
+			L.DebugContext(ctx, rewrite.name, "query", rewrite.query, "args", args)
+			_, err = tx.Exec(ctx, rewrite.query, args)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+				return struct{}{}, err
+			}
+		}
+
+		// remove duplicate
+		deleteQuery := "DELETE FROM users WHERE id = @id"
+		deleteArgs := pgx.StrictNamedArgs{
+			"id": sourceID,
+		}
+		L.DebugContext(ctx, "MergeUser.delete", "query", deleteQuery, "args", deleteArgs)
+		cmdTag, err = tx.Exec(ctx, deleteQuery, deleteArgs)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+			return struct{}{}, err
+		}
+		if cmdTag.RowsAffected() != 1 {
+			L.ErrorContext(ctx, "Unexpected number of rows affected in users table")
+			return struct{}{}, errors.New("unexpected number of rows affected")
+		}
+
+		// the target takes over the source's person, who would otherwise lose the member
+		if person[sourceID] != nil && person[targetID] == nil {
+			linkQuery := "UPDATE users SET person_id = @personID, version = version + 1 WHERE id = @target"
+			linkArgs := pgx.StrictNamedArgs{
+				"personID": *person[sourceID],
+				"target":   targetID,
+			}
+			L.DebugContext(ctx, "MergeUser.link", "query", linkQuery, "args", linkArgs)
+			_, err = tx.Exec(ctx, linkQuery, linkArgs)
+			if err != nil {
+				L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+				return struct{}{}, err
+			}
+		}
+
+		err = enqueueEvent(ctx, tx, L, groupID, EventUserMerged, map[string]any{
+			"user_id":   sourceID,
+			"target_id": targetID,
+		})
+		if err != nil {
+			return struct{}{}, err
+		}
 
 		return struct{}{}, nil
 	})
 
 	return err
 }
+
+func lockUser(ctx context.Context, tx pgx.Tx, L *slog.Logger, groupID int, userID int, version *int) error {
+	query := "SELECT version FROM users WHERE id = @id AND group_id = @groupID FOR UPDATE"
+	args := pgx.StrictNamedArgs{
+		"id":      userID,
+		"groupID": groupID,
+	}
+	return lockVersion(ctx, tx, L, "lockUser", query, args, version)
+}
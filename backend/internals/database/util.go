@@ -2,11 +2,22 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// returned when an If-Match version no longer matches the row
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// satisfied by both *pgxpool.Pool and pgx.Tx
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
 func WithTx[T any](ctx context.Context, db *pgxpool.Pool, fn func(pgx.Tx) (T, error)) (res T, err error) {
 	tx, err := db.Begin(ctx)
 	if err != nil {
@@ -31,4 +42,37 @@ func WithTx[T any](ctx context.Context, db *pgxpool.Pool, fn func(pgx.Tx) (T, er
 	}
 
 	return res, nil
-}
\ No newline at end of file
+}
+
+// runs fn against a single read-only snapshot, so every read sees the same state
+func WithReadTx[T any](ctx context.Context, db *pgxpool.Pool, fn func(pgx.Tx) (T, error)) (T, error) {
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer tx.Rollback(ctx)
+
+	return fn(tx)
+}
+
+// lockVersion runs query, which has to select the version of one row FOR UPDATE,
+// and checks it against expected when that is set
+func lockVersion(ctx context.Context, tx pgx.Tx, L *slog.Logger, name string, query string, args pgx.StrictNamedArgs, expected *int) error {
+	var version int
+	L.DebugContext(ctx, name, "query", query, "args", args)
+	err := tx.QueryRow(ctx, query, args).Scan(&version)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Lock failed: %v", err))
+		return err
+	}
+	if expected != nil && *expected != version {
+		L.InfoContext(ctx, fmt.Sprintf("Version mismatch: expected %v, found %v", *expected, version))
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+func GetWebhooksByGroupID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) ([]Webhook, error) {
+	query := "SELECT id, group_id, url, secret, events, created_at FROM webhook WHERE group_id = @id ORDER BY id"
+	args := pgx.StrictNamedArgs{
+		"id": id,
+	}
+
+	L.DebugContext(ctx, "GetWebhooksByGroupID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []Webhook{}, err
+	}
+
+	webhooks, err := pgx.CollectRows(rows, pgx.RowToStructByName[Webhook])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []Webhook{}, err
+	}
+
+	return webhooks, nil
+}
+
+func GetWebhookByID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, id int) (Webhook, error) {
+	query := "SELECT id, group_id, url, secret, events, created_at FROM webhook WHERE id = @id AND group_id = @groupID"
+	args := pgx.StrictNamedArgs{
+		"id":      id,
+		"groupID": groupID,
+	}
+
+	L.DebugContext(ctx, "GetWebhookByID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return Webhook{}, err
+	}
+
+	webhook, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Webhook])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+func AddWebhook(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, url string, secret string, events []string) (int, error) {
+	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
+		query := "INSERT INTO webhook (group_id, url, secret, events) VALUES (@groupID, @url, @secret, @events) RETURNING id"
+		args := pgx.StrictNamedArgs{
+			"groupID": groupID,
+			"url":     url,
+			"secret":  secret,
+			"events":  events,
+		}
+
+		var id int
+		L.DebugContext(ctx, "AddWebhook", "query", query, "args", args)
+		err := tx.QueryRow(ctx, query, args).Scan(&id)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+			return 0, err
+		}
+
+		return id, nil
+	})
+}
+
+func DeleteWebhook(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, groupID int, id int) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		query := "DELETE FROM webhook WHERE id = @id AND group_id = @groupID"
+		args := pgx.StrictNamedArgs{
+			"id":      id,
+			"groupID": groupID,
+		}
+
+		L.DebugContext(ctx, "DeleteWebhook", "query", query, "args", args)
+		cmdTag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+			return struct{}{}, err
+		}
+		if cmdTag.RowsAffected() > 1 {
+			L.ErrorContext(ctx, "Delete failed: more than one row affected")
+			return struct{}{}, errors.New("more than one row affected")
+		}
+		if cmdTag.RowsAffected() == 0 {
+			L.ErrorContext(ctx, fmt.Sprintf("Delete failed: webhook %v does not exist", id))
+			return struct{}{}, pgx.ErrNoRows
+		}
+
+		return struct{}{}, nil
+	})
+
+	return err
+}
+
+func GetDeliveriesByWebhookID(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT
+		d.id,
+		d.webhook_id,
+		d.event_id,
+		e.event,
+		d.status,
+		d.attempts,
+		d.next_attempt_at,
+		d.last_status_code,
+		d.last_error,
+		d.delivered_at
+	FROM webhook_delivery AS d
+	JOIN group_event AS e
+		ON d.event_id = e.id
+	WHERE d.webhook_id = @id
+	ORDER BY d.id DESC
+	LIMIT 100`
+	args := pgx.StrictNamedArgs{
+		"id": id,
+	}
+
+	L.DebugContext(ctx, "GetDeliveriesByWebhookID", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []WebhookDelivery{}, err
+	}
+
+	deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByName[WebhookDelivery])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []WebhookDelivery{}, err
+	}
+
+	return deliveries, nil
+}
+
+// queues a fresh delivery of the same event, leaving the original in the log
+func ReplayDelivery(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, webhookID int, id int) (int, error) {
+	return WithTx(ctx, db, func(tx pgx.Tx) (int, error) {
+		query := `INSERT INTO webhook_delivery (webhook_id, event_id)
+SELECT webhook_id, event_id FROM webhook_delivery WHERE id = @id AND webhook_id = @webhookID
+RETURNING id`
+		args := pgx.StrictNamedArgs{
+			"id":        id,
+			"webhookID": webhookID,
+		}
+
+		var replayID int
+		L.DebugContext(ctx, "ReplayDelivery", "query", query, "args", args)
+		err := tx.QueryRow(ctx, query, args).Scan(&replayID)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Insert failed: %v", err))
+			return 0, err
+		}
+
+		return replayID, nil
+	})
+}
+
+// leases due deliveries so that other instances skip them while they are being sent
+func ClaimPendingDeliveries(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, limit int, lease time.Duration) ([]PendingDelivery, error) {
+	query := `
+	WITH claimed AS (
+		UPDATE webhook_delivery
+		SET next_attempt_at = NOW() + @lease::INTERVAL
+		WHERE id IN (
+			SELECT id FROM webhook_delivery
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT @limit
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, webhook_id, event_id, attempts
+	)
+	SELECT
+		c.id,
+		c.attempts,
+		w.url,
+		w.secret,
+		e.id         AS event_id,
+		e.group_id   AS group_id,
+		e.event      AS event,
+		e.payload    AS payload,
+		e.created_at AS event_created_at
+	FROM claimed AS c
+	JOIN webhook AS w
+		ON c.webhook_id = w.id
+	JOIN group_event AS e
+		ON c.event_id = e.id
+	ORDER BY c.id`
+	args := pgx.StrictNamedArgs{
+		"lease": lease,
+		"limit": limit,
+	}
+
+	L.DebugContext(ctx, "ClaimPendingDeliveries", "query", query, "args", args)
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Get failed: %v", err))
+		return []PendingDelivery{}, err
+	}
+
+	deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByName[PendingDelivery])
+	if err != nil {
+		L.ErrorContext(ctx, fmt.Sprintf("Binding failed: %v", err))
+		return []PendingDelivery{}, err
+	}
+
+	return deliveries, nil
+}
+
+// records the outcome of one attempt; retry is when to try again, or nil to give up
+func RecordDeliveryAttempt(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, id int, statusCode *int, deliveryErr *string, succeeded bool, retry *time.Time) error {
+	_, err := WithTx(ctx, db, func(tx pgx.Tx) (struct{}, error) {
+		status := DeliveryFailed
+		if succeeded {
+			status = DeliverySucceeded
+		} else if retry != nil {
+			status = DeliveryPending
+		}
+
+		query := `UPDATE webhook_delivery SET
+	status = @status,
+	attempts = attempts + 1,
+	next_attempt_at = COALESCE(@retry, next_attempt_at),
+	last_status_code = @statusCode,
+	last_error = @error,
+	delivered_at = CASE WHEN @succeeded THEN NOW() ELSE NULL END
+WHERE id = @id`
+		args := pgx.StrictNamedArgs{
+			"status":     status,
+			"retry":      retry,
+			"statusCode": statusCode,
+			"error":      deliveryErr,
+			"succeeded":  succeeded,
+			"id":         id,
+		}
+
+		L.DebugContext(ctx, "RecordDeliveryAttempt", "query", query, "args", args)
+		cmdTag, err := tx.Exec(ctx, query, args)
+		if err != nil {
+			L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+			return struct{}{}, err
+		}
+		if cmdTag.RowsAffected() != 1 {
+			L.ErrorContext(ctx, "Unexpected number of rows affected in webhook_delivery table")
+			return struct{}{}, errors.New("unexpected number of rows affected")
+		}
+
+		return struct{}{}, nil
+	})
+
+	return err
+}
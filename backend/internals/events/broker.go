@@ -0,0 +1,111 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+// Broker fans committed group events out to subscribers on this instance.
+// Subscribers are only woken up; they read the events themselves from the group log.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[int]map[chan struct{}]struct{}
+	closed bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subs: map[int]map[chan struct{}]struct{}{},
+	}
+}
+
+// Subscribe returns a channel that is signalled whenever the group has new events,
+// and a function to stop the subscription. The channel is closed when the broker is.
+func (b *Broker) Subscribe(groupID int) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs[groupID] == nil {
+		b.subs[groupID] = map[chan struct{}]struct{}{}
+	}
+	b.subs[groupID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[groupID], ch)
+		if len(b.subs[groupID]) == 0 {
+			delete(b.subs, groupID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Close ends every subscription, so streams stop holding their connections open during shutdown
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, chans := range b.subs {
+		for ch := range chans {
+			close(ch)
+		}
+	}
+	b.subs = map[int]map[chan struct{}]struct{}{}
+}
+
+func (b *Broker) publish(groupID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[groupID] {
+		// a pending signal already covers this event
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Listen passes events committed through store on to subscribers until ctx is done,
+// starting over if the store loses its connection.
+func (b *Broker) Listen(ctx context.Context, store database.Store, L *slog.Logger) {
+	for ctx.Err() == nil {
+		err := store.ListenEvents(ctx, b)
+		if err != nil && ctx.Err() == nil {
+			L.Error(fmt.Sprintf("Event listener failed, reconnecting: %v", err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// Listening wakes everyone so nothing committed while not listening is missed
+func (b *Broker) Listening() {
+	b.mu.Lock()
+	groupIDs := make([]int, 0, len(b.subs))
+	for groupID := range b.subs {
+		groupIDs = append(groupIDs, groupID)
+	}
+	b.mu.Unlock()
+	for _, groupID := range groupIDs {
+		b.publish(groupID)
+	}
+}
+
+func (b *Broker) Notify(groupID int, eventID int) {
+	b.publish(groupID)
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/metrics"
+)
+
+const maxBatchOps = 100
+
+const (
+	batchOK         = "ok"
+	batchFailed     = "failed"
+	batchRolledBack = "rolled_back"
+	batchSkipped    = "skipped"
+)
+
+func Batch(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		Operations []database.BatchOp `json:"operations"`
+	}
+
+	type response struct {
+		Results []BatchResult `json:"results"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Batch)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		_, err := store.GetGroupByID(ctx, groupID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		var body request
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if len(body.Operations) == 0 || len(body.Operations) > maxBatchOps {
+			httpError = &HttpError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Batch must have between 1 and %d operations", maxBatchOps),
+			}
+			return
+		}
+
+		results := make([]BatchResult, 0, len(body.Operations))
+		for idx, op := range body.Operations {
+			results = append(results, BatchResult{
+				Index:  idx,
+				Op:     op.Op,
+				Type:   op.Type,
+				Status: batchSkipped,
+			})
+		}
+
+		// fail marks the operation at idx as failed and everything before it as rolled back
+		fail := func(idx int, opError *HttpError) {
+			for i := range idx {
+				results[i].Status = batchRolledBack
+			}
+			problem := toProblem(r, opError)
+			results[idx].Status = batchFailed
+			results[idx].Error = &problem
+
+			L.InfoContext(r.Context(), problem.Message, "code", problem.Code, "operation", idx)
+			data, _ := json.Marshal(response{results})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(opError.Code)
+			w.Write(data)
+		}
+
+		refs := map[string]bool{}
+		for idx, op := range body.Operations {
+			opError := validateBatchOp(op, refs)
+			if opError != nil {
+				fail(idx, opError)
+				return
+			}
+			if op.Ref != "" {
+				refs[op.Ref] = true
+			}
+		}
+
+		users, err := store.GetUsersByGroupID(ctx, groupID)
+		if err != nil {
+			httpError = &HttpError{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			}
+			return
+		}
+		for idx, op := range body.Operations {
+			if op.Op != database.BatchCreate || op.Type != database.BatchPayment {
+				continue
+			}
+			if opError := checkMembers(users, batchPaymentRefs(op)); opError != nil {
+				opError.Code = http.StatusBadRequest
+				fail(idx, opError)
+				return
+			}
+		}
+
+		ids, err := store.ApplyBatch(ctx, groupID, body.Operations)
+		if err != nil {
+			var batchErr *database.BatchError
+			if errors.As(err, &batchErr) {
+				fail(batchErr.Index, batchOpError(body.Operations[batchErr.Index], batchErr.Err))
+				return
+			}
+			httpError = &HttpError{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			}
+			return
+		}
+
+		for idx, op := range body.Operations {
+			results[idx].Status = batchOK
+			results[idx].ID = &ids[idx]
+			if op.Op == database.BatchCreate && op.Type == database.BatchPayment {
+				metrics.PaymentCreated(*op.Amount)
+			}
+		}
+
+		res := response{results}
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// checks an operation the way the matching single route checks its body
+func validateBatchOp(op database.BatchOp, refs map[string]bool) *HttpError {
+	badRequest := func(message string) *HttpError {
+		return &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: message,
+		}
+	}
+
+	if op.Type != database.BatchUser && op.Type != database.BatchPayment {
+		return badRequest("Type must be user or payment")
+	}
+	switch op.Op {
+	case database.BatchCreate:
+		if op.Ref != "" && refs[op.Ref] {
+			return badRequest(fmt.Sprintf("Ref %q is used twice", op.Ref))
+		}
+	case database.BatchUpdate, database.BatchDelete:
+		if op.Ref != "" {
+			return badRequest("Only create operations can have a ref")
+		}
+		if op.ID.IsZero() {
+			return badRequest("Missing id")
+		}
+	default:
+		return badRequest("Op must be create, update or delete")
+	}
+	for _, id := range append([]database.BatchID{op.ID, op.PayerID}, op.PayeeIDs...) {
+		if id.Ref != "" && !refs[id.Ref] {
+			return badRequest(fmt.Sprintf("Ref %q is not created earlier in the batch", id.Ref))
+		}
+	}
+
+	switch {
+	case op.Type == database.BatchUser && op.Op != database.BatchDelete:
+		if op.Name == nil || *op.Name == "" {
+			return badRequest("Empty name field")
+		}
+	case op.Type == database.BatchPayment && op.Op == database.BatchCreate:
+		if op.Amount == nil || *op.Amount <= 0 {
+			return badRequest("Non-positive balance")
+		}
+		if op.PayerID.IsZero() {
+			return badRequest("Missing payer_id")
+		}
+		if len(op.PayeeIDs) == 0 {
+			return badRequest("No payees in payment")
+		}
+		if httpError := duplicatePayee(op.PayeeIDs); httpError != nil {
+			return httpError
+		}
+	case op.Type == database.BatchPayment && op.Op == database.BatchUpdate:
+		if op.Amount == nil && op.Description == nil {
+			return badRequest("Nothing to update")
+		}
+		if op.Amount != nil && *op.Amount <= 0 {
+			return badRequest("Invalid amount field")
+		}
+		if op.Description != nil && *op.Description == "" {
+			return badRequest("Invalid description field")
+		}
+	}
+	return nil
+}
+
+// refs to the members a payment operation names by ID. Refs name members created earlier
+// in the batch, so only IDs can point outside the group
+func batchPaymentRefs(op database.BatchOp) []memberRef {
+	refs := []memberRef{}
+	if op.PayerID.Ref == "" {
+		refs = append(refs, memberRef{"payer_id", op.PayerID.ID})
+	}
+	for idx, id := range op.PayeeIDs {
+		if id.Ref == "" {
+			refs = append(refs, memberRef{fmt.Sprintf("payee_ids[%d]", idx), id.ID})
+		}
+	}
+	return refs
+}
+
+// maps the error an operation failed with the way the matching single route does
+func batchOpError(op database.BatchOp, err error) *HttpError {
+	switch {
+	case errors.Is(err, database.ErrUnknownRef):
+		return &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Unknown ref",
+		}
+	case errors.Is(err, database.ErrUserInactive) && op.Op == database.BatchCreate:
+		return &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Inactive user in payment",
+		}
+	case errors.Is(err, database.ErrUserInactive) && op.Op == database.BatchUpdate:
+		return &HttpError{
+			Code:    http.StatusConflict,
+			Message: "Cannot change amount of payment with inactive users",
+		}
+	case errors.Is(err, database.ErrUserInactive):
+		return &HttpError{
+			Code:    http.StatusConflict,
+			Message: "Cannot delete payment with inactive users",
+		}
+	}
+
+	// the store enforces the same rule Batch checks up front
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == "ensure_payment_users_in_same_group" {
+		httpError := dbError(err)
+		httpError.Code = http.StatusBadRequest
+		httpError.Message = "Users are not members of this group"
+		return httpError
+	}
+	return dbError(err)
+}
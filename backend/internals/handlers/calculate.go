@@ -5,41 +5,31 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/metrics"
 )
 
-func Calculate(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func Calculate(store database.Store, L *slog.Logger) http.HandlerFunc {
 	type response struct {
 		IOUs []IOU `json:"ious"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupId, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupId)
+		_, err := store.GetGroupByID(ctx, groupId)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -53,7 +43,7 @@ func Calculate(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		users, err := database.GetUsersByGroupID(ctx, db, L, groupId)
+		users, err := store.GetUsersByGroupID(ctx, groupId)
 		if err != nil {
 			httpError = &HttpError{
 				Code:    http.StatusInternalServerError,
@@ -63,6 +53,7 @@ func Calculate(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 		}
 
 		// calculate ious
+		metrics.CalculateInvoked()
 		ious := calculate(users)
 		res := response{ious}
 		data, _ := json.Marshal(res)
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+// request fields behind the constraints a write can trip, keyed by constraint name.
+// Triggers name their column themselves, see the migrations
+var constraintFields = map[string]string{
+	"payment_payer_id_fkey":                "payer_id",
+	"payment_amount_check":                 "amount",
+	"users_payment_user_id_fkey":           "payee_ids",
+	"balance_transfer_to_user_id_fkey":     "transfer_to",
+	"recurring_payment_payer_id_fkey":      "payer_id",
+	"recurring_payment_amount_check":       "amount",
+	"recurring_payment_payee_ids_check":    "payee_ids",
+	"recurring_payment_frequency_check":    "frequency",
+	"recurring_payment_day_of_month_check": "day_of_month",
+	"users_group_id_person_id_key":         "person_id",
+}
+
+// messages for conflicts with the current state, keyed by constraint name
+var conflictMessages = map[string]string{
+	"users_payment_user_id_fkey":         "Cannot delete user with associated payments, deactivate them instead",
+	"payment_payer_id_fkey":              "Cannot delete user with associated payments, deactivate them instead",
+	"balance_transfer_from_user_id_fkey": "Cannot delete user with balance transfers, deactivate them instead",
+	"balance_transfer_to_user_id_fkey":   "Cannot delete user with balance transfers, deactivate them instead",
+	"recurring_payment_payer_id_fkey":    "Cannot delete user with recurring payments, delete those first",
+	"recurring_payment_payee_ids_fkey":   "Cannot delete user with recurring payments, delete those first",
+	"users_group_id_person_id_key":       "Person is already linked to a member of this group",
+}
+
+// dbError translates an error from the database package into the response for it.
+// Constraint violations become 4xx errors pointing at the field involved, anything
+// it does not recognize is a 500
+func dbError(err error) *HttpError {
+	if errors.Is(err, database.ErrNotFound) {
+		return &HttpError{
+			Code:    http.StatusNotFound,
+			Message: "Not found",
+		}
+	}
+	if errors.Is(err, database.ErrVersionMismatch) {
+		return &HttpError{
+			Code:    http.StatusPreconditionFailed,
+			Message: "Stale version, reload and try again",
+		}
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return &HttpError{
+			Code:    http.StatusInternalServerError,
+			Message: "Internal server error",
+		}
+	}
+
+	field := constraintFields[pgErr.ConstraintName]
+	if field == "" {
+		field = pgErr.ColumnName
+	}
+	withField := func(code int, message string, detail string) *HttpError {
+		httpError := &HttpError{
+			Code:    code,
+			Message: message,
+		}
+		if field != "" {
+			httpError.Details = []FieldError{{Field: field, Message: detail}}
+		}
+		return httpError
+	}
+
+	switch pgErr.Code {
+	case "23503":
+		// deleting something still referenced, as opposed to referencing something missing
+		if strings.HasPrefix(pgErr.Message, "update or delete") {
+			return &HttpError{
+				Code:    http.StatusConflict,
+				Message: conflictMessage(pgErr, "Still referenced by other records"),
+			}
+		}
+		return withField(http.StatusUnprocessableEntity, "Referenced record does not exist", "Does not exist")
+	case "23505":
+		return withField(http.StatusConflict, conflictMessage(pgErr, "Already exists"), "Already taken")
+	case "23502", "23514":
+		return withField(http.StatusUnprocessableEntity, "Invalid value", "Invalid value")
+	case "P0001":
+		// raised by our triggers, whose messages are written for the caller
+		return withField(http.StatusUnprocessableEntity, pgErr.Message, pgErr.Message)
+	}
+	return &HttpError{
+		Code:    http.StatusInternalServerError,
+		Message: "Internal server error",
+	}
+}
+
+func conflictMessage(pgErr *pgconn.PgError, fallback string) string {
+	if message, ok := conflictMessages[pgErr.ConstraintName]; ok {
+		return message
+	}
+	return fallback
+}
+
+type memberRef struct {
+	field string
+	id    int
+}
+
+// refs to the payer and payees of a payment, with the request field each came from
+func paymentRefs(payerID int, payeeIDs []int) []memberRef {
+	refs := []memberRef{{"payer_id", payerID}}
+	for idx, id := range payeeIDs {
+		refs = append(refs, memberRef{fmt.Sprintf("payee_ids[%d]", idx), id})
+	}
+	return refs
+}
+
+// duplicatePayee points at the first payee listed a second time. A payee has one share
+// of a payment, so there is no meaning to give the repeat
+func duplicatePayee[T comparable](payeeIDs []T) *HttpError {
+	seen := map[T]bool{}
+	for idx, id := range payeeIDs {
+		if seen[id] {
+			return invalidField(fmt.Sprintf("payee_ids[%d]", idx), "Duplicate payee in payment")
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// webhookURL makes sure deliveries have somewhere to go: an absolute http or https URL
+// naming a host. Credentials are turned away, since webhooks are listed back with their URL
+func webhookURL(raw string) *HttpError {
+	parsed, err := url.Parse(raw)
+	if err != nil || !parsed.IsAbs() || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return invalidField("url", "Webhook url must be an absolute http or https URL with a host")
+	}
+	if parsed.User != nil {
+		return invalidField("url", "Webhook url must not carry credentials")
+	}
+	return nil
+}
+
+// checkMembers makes sure every ref is a member of the group up front, instead of
+// leaving it to a foreign key or a trigger when the transaction commits
+func checkMembers(users []database.User, refs []memberRef) *HttpError {
+	members := map[int]bool{}
+	for _, user := range users {
+		members[user.ID] = true
+	}
+
+	details := []FieldError{}
+	for _, ref := range refs {
+		if !members[ref.id] {
+			details = append(details, FieldError{
+				Field:   ref.field,
+				Message: fmt.Sprintf("User %d is not a member of this group", ref.id),
+			})
+		}
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return &HttpError{
+		Code:    http.StatusUnprocessableEntity,
+		Message: "Users are not members of this group",
+		Details: details,
+	}
+}
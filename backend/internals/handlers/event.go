@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/events"
+)
+
+// how long a single write to an event stream may take before the client is given up on
+const eventWriteTimeout = 10 * time.Second
+
+func GroupEvents(store database.Store, L *slog.Logger, broker *events.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the stream outlives the usual request timeout, so only individual queries get one
+		ctx := r.Context()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		queryCtx, cancel := context.WithTimeout(ctx, timeouts(ctx).Request)
+		_, err := store.GetGroupByID(queryCtx, groupID)
+		cancel()
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		// subscribe before reading the log, so nothing committed in between is missed
+		wake, unsubscribe := broker.Subscribe(groupID)
+		defer unsubscribe()
+
+		lastID := 0
+		lastIDStr := r.Header.Get("Last-Event-ID")
+		if lastIDStr == "" {
+			lastIDStr = r.URL.Query().Get("last_event_id")
+		}
+		if lastIDStr != "" {
+			lastID, err = strconv.Atoi(lastIDStr)
+			if err != nil || lastID < 0 {
+				httpError = &HttpError{
+					Code:    http.StatusBadRequest,
+					Message: "Bad Last-Event-ID header",
+				}
+				return
+			}
+		} else {
+			queryCtx, cancel := context.WithTimeout(ctx, timeouts(ctx).Request)
+			lastID, err = store.GetLatestEventID(queryCtx, groupID)
+			cancel()
+			if err != nil {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+				return
+			}
+		}
+
+		// the server's write timeout would cut the stream off, so each write gets its own deadline instead
+		rc := http.NewResponseController(w)
+		extendDeadline := func() {
+			_ = rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		}
+		extendDeadline()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 3000\n\n")
+		rc.Flush()
+
+		heartbeat := time.NewTicker(30 * time.Second)
+		defer heartbeat.Stop()
+
+		// replay anything after Last-Event-ID first, then wait for more. A group's events become
+		// visible in id order, so moving lastID past one never skips another still to commit
+		for {
+			for {
+				queryCtx, cancel := context.WithTimeout(ctx, timeouts(ctx).Request)
+				pending, err := store.GetEventsSince(queryCtx, groupID, lastID, 100)
+				cancel()
+				if err != nil {
+					L.ErrorContext(ctx, fmt.Sprintf("Event stream for group %v failed: %v", groupID, err))
+					return
+				}
+
+				extendDeadline()
+				for _, event := range pending {
+					fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, event.Payload)
+					lastID = event.ID
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+				if len(pending) < 100 {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-wake:
+				if !ok {
+					return
+				}
+			case <-heartbeat.C:
+				extendDeadline()
+				fmt.Fprint(w, ": ping\n\n")
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
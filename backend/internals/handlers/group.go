@@ -5,37 +5,27 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"time"
+	"strconv"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/michaelzhan1/split/internals/database"
 )
 
-func GetGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func GetGroup(store database.Store, L *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		group, err := database.GetGroupByID(ctx, db, L, groupID)
+		group, err := store.GetGroupByID(ctx, groupID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -49,6 +39,10 @@ func GetGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
+		if notModified(w, r, versionETag(group.Version)) {
+			return
+		}
+
 		res := toGroupView(group)
 		data, _ := json.Marshal(res)
 		w.Header().Set("Content-Type", "application/json")
@@ -57,48 +51,94 @@ func GetGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func CreateGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
-	type request struct {
-		Name string `json:"name"`
+func GetGroupSummary(store database.Store, L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		recent := 10
+		if recentStr := r.URL.Query().Get("recent"); recentStr != "" {
+			recentInt, err := strconv.Atoi(recentStr)
+			if err != nil || recentInt < 0 || recentInt > 100 {
+				httpError = &HttpError{
+					Code:    http.StatusBadRequest,
+					Message: "Bad recent, must be between 0 and 100",
+				}
+				return
+			}
+			recent = recentInt
+		}
+
+		summary, err := store.GetGroupSummary(ctx, groupID, recent)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		res := toGroupSummaryView(summary)
+		data, _ := json.Marshal(res)
+		if notModified(w, r, bodyETag(data)) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
 	}
+}
 
+func ListGroups(store database.Store, L *slog.Logger) http.HandlerFunc {
 	type response struct {
-		ID int `json:"id"`
+		Groups []GroupListing `json:"groups"`
+		Total  int            `json:"total"`
+		Limit  int            `json:"limit"`
+		Offset int            `json:"offset"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
-		var body request
-		err := json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid JSON",
-			}
+		callerID, httpError := withCallerID(r)
+		if httpError != nil {
 			return
 		}
-		if body.Name == "" {
+
+		limit, offset, httpError := withPagination(r)
+		if httpError != nil {
+			return
+		}
+
+		sort := r.URL.Query().Get("sort")
+		if sort != "" && sort != "activity" && sort != "name" {
 			httpError = &HttpError{
 				Code:    http.StatusBadRequest,
-				Message: "Empty name field",
+				Message: "Bad sort, must be activity or name",
 			}
 			return
 		}
 
-		id, err := database.CreateGroup(ctx, db, L, body.Name)
+		groups, total, err := store.ListGroupsByPersonID(ctx, callerID, r.URL.Query().Get("q"), sort, limit, offset)
 		if err != nil {
 			httpError = &HttpError{
 				Code:    http.StatusInternalServerError,
@@ -107,6 +147,46 @@ func CreateGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
+		res := response{toGroupListingList(groups), total, limit, offset}
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+func CreateGroup(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+	}
+
+	type response struct {
+		ID int `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		var body request
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if body.Name == "" {
+			httpError = invalidField("name", "Empty name field")
+			return
+		}
+
+		id, err := store.CreateGroup(ctx, body.Name)
+		if err != nil {
+			httpError = dbError(err)
+			return
+		}
+
 		res := response{id}
 		data, _ := json.Marshal(res)
 		w.Header().Set("Content-Type", "application/json")
@@ -115,38 +195,31 @@ func CreateGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func PatchGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func PatchGroup(store database.Store, L *slog.Logger) http.HandlerFunc {
 	type request struct {
 		Name *string `json:"name"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
+		version, httpError := withIfMatch(r)
+		if httpError != nil {
+			return
+		}
+
 		var body request
-		err := json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid JSON",
-			}
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
 			return
 		}
 		if body.Name == nil {
@@ -154,19 +227,13 @@ func PatchGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 		if body.Name != nil && *body.Name == "" {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Empty name field",
-			}
+			httpError = invalidField("name", "Empty name field")
 			return
 		}
 
-		err = database.PatchGroup(ctx, db, L, groupID, *body.Name)
+		err := store.PatchGroup(ctx, groupID, version, *body.Name)
 		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
-			}
+			httpError = dbError(err)
 			return
 		}
 
@@ -176,40 +243,27 @@ func PatchGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func DeleteGroup(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func DeleteGroup(store database.Store, L *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		err := database.DeleteGroup(ctx, db, L, groupID)
+		version, httpError := withIfMatch(r)
+		if httpError != nil {
+			return
+		}
+
+		err := store.DeleteGroup(ctx, groupID, version)
 		if err != nil {
-			if err == pgx.ErrNoRows {
-				httpError = &HttpError{
-					Code:    http.StatusNotFound,
-					Message: "Not found",
-				}
-			} else {
-				httpError = &HttpError{
-					Code:    http.StatusInternalServerError,
-					Message: "Internal server error",
-				}
-			}
+			httpError = dbError(err)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
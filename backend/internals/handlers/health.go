@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// Healthz reports that the process is up and serving, without touching the database
+func Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(healthResponse{"ok"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// Readyz reports whether requests can be served, which check decides by reaching the
+// database and confirming its schema is the one this server was built for
+func Readyz(L *slog.Logger, check func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		err := check(ctx)
+		if err != nil {
+			L.WarnContext(ctx, fmt.Sprintf("Not ready: %v", err))
+			WriteError(w, r, L, &HttpError{
+				Code:    http.StatusServiceUnavailable,
+				Message: "Database is not ready",
+			})
+			return
+		}
+
+		data, _ := json.Marshal(healthResponse{"ready"})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
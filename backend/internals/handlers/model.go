@@ -1,31 +1,74 @@
 package handlers
 
+import "time"
+
+// HttpError is an RFC 7807 problem. Handlers only set Code and Message, the rest is
+// filled in when it is written, see WriteError
 type HttpError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Code      int          `json:"status"`
+	Message   string       `json:"detail"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Details   []FieldError `json:"invalid_params,omitempty"`
 }
 
 func (error *HttpError) Error() string {
 	return error.Message
 }
 
+// FieldError points at the request field that made a request fail
+type FieldError struct {
+	Field   string `json:"name"`
+	Message string `json:"reason"`
+}
+
 type Group struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+type GroupListing struct {
+	ID             int       `json:"id"`
+	Name           string    `json:"name"`
+	MemberCount    int       `json:"member_count"`
+	TotalSpent     float32   `json:"total_spent"`
+	Balance        float32   `json:"balance"`
+	LastActivityAt time.Time `json:"last_activity_at"`
 }
 
 type User struct {
-	ID      int     `json:"id"`
-	Name    string  `json:"name"`
-	Balance float32 `json:"balance"`
+	ID       int     `json:"id"`
+	PersonID *int    `json:"person_id"`
+	Name     string  `json:"name"`
+	Balance  float32 `json:"balance"`
+	Active   bool    `json:"active"`
+	Version  int     `json:"version"`
 }
 
 type Payment struct {
-	ID          int      `json:"id"`
-	Description *string  `json:"description"`
-	Amount      float32  `json:"amount"`
-	Payer       User   `json:"payer"`
-	Payees      []User `json:"payees"`
+	ID          int     `json:"id"`
+	Description *string `json:"description"`
+	Amount      float32 `json:"amount"`
+	Payer       User    `json:"payer"`
+	Payees      []User  `json:"payees"`
+	Version     int     `json:"version"`
+}
+
+type Totals struct {
+	MemberCount  int     `json:"member_count"`
+	PaymentCount int     `json:"payment_count"`
+	TotalSpent   float32 `json:"total_spent"`
+}
+
+type GroupSummary struct {
+	Group          Group     `json:"group"`
+	Users          []User    `json:"users"`
+	RecentPayments []Payment `json:"recent_payments"`
+	Totals         Totals    `json:"totals"`
+	IOUs           []IOU     `json:"ious"`
 }
 
 type IOU struct {
@@ -33,3 +76,77 @@ type IOU struct {
 	ToID   int     `json:"to"`
 	Amount float32 `json:"amount"`
 }
+
+type Person struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type GroupBalance struct {
+	GroupID   int     `json:"group_id"`
+	GroupName string  `json:"group_name"`
+	UserID    int     `json:"user_id"`
+	Balance   float32 `json:"balance"`
+}
+
+// a party is either a linked person or a member that is not linked to anyone
+type Party struct {
+	PersonID *int   `json:"person_id"`
+	UserID   *int   `json:"user_id"`
+	Name     string `json:"name"`
+}
+
+type Transfer struct {
+	Debtor   Party   `json:"debtor"`
+	Creditor Party   `json:"creditor"`
+	Amount   float32 `json:"amount"`
+}
+
+type PersonBalances struct {
+	PersonID int            `json:"person_id"`
+	Groups   []GroupBalance `json:"groups"`
+	Total    float32        `json:"total"`
+	Plan     []Transfer     `json:"plan,omitempty"`
+}
+
+type RecurringPayment struct {
+	ID          int     `json:"id"`
+	Description string  `json:"description"`
+	Amount      float32 `json:"amount"`
+	PayerID     int     `json:"payer_id"`
+	PayeeIDs    []int   `json:"payee_ids"`
+	Frequency   string  `json:"frequency"`
+	DayOfMonth  *int    `json:"day_of_month"`
+	StartDate   string  `json:"start_date"`
+	EndDate     *string `json:"end_date"`
+	NextRun     string  `json:"next_run"`
+	Paused      bool    `json:"paused"`
+}
+
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int        `json:"id"`
+	EventID        int        `json:"event_id"`
+	Event          string     `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastStatusCode *int       `json:"last_status_code"`
+	LastError      *string    `json:"last_error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+type BatchResult struct {
+	Index  int        `json:"index"`
+	Op     string     `json:"op"`
+	Type   string     `json:"type"`
+	Status string     `json:"status"`
+	ID     *int       `json:"id,omitempty"`
+	Error  *HttpError `json:"error,omitempty"`
+}
@@ -5,37 +5,27 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/metrics"
 )
 
-func GetPayments(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func GetPayments(store database.Store, L *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupID)
+		_, err := store.GetGroupByID(ctx, groupID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -49,7 +39,7 @@ func GetPayments(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		payments, err := database.GetPaymentsByGroupID(ctx, db, L, groupID)
+		payments, err := store.GetPaymentsByGroupID(ctx, groupID)
 		if err != nil {
 			httpError = &HttpError{
 				Code:    http.StatusInternalServerError,
@@ -60,13 +50,16 @@ func GetPayments(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 
 		res := toPaymentList(payments)
 		data, _ := json.Marshal(res)
+		if notModified(w, r, bodyETag(data)) {
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write(data)
 	}
 }
 
-func AddPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func AddPayment(store database.Store, L *slog.Logger) http.HandlerFunc {
 	type request = database.InsertPayment
 
 	type response struct {
@@ -74,28 +67,20 @@ func AddPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupId, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupId)
+		_, err := store.GetGroupByID(ctx, groupId)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -110,30 +95,24 @@ func AddPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 		}
 
 		var body request
-		err = json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid JSON",
-			}
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
 			return
 		}
 		if body.Amount <= 0 {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Non-positive balance",
-			}
+			httpError = invalidField("amount", "Non-positive balance")
 			return
 		}
 		if len(body.PayeeIDs) == 0 {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "No payees in payment",
-			}
+			httpError = invalidField("payee_ids", "No payees in payment")
+			return
+		}
+		httpError = duplicatePayee(body.PayeeIDs)
+		if httpError != nil {
 			return
 		}
 
-		id, err := database.AddPaymentByGroupId(ctx, db, L, groupId, body)
+		users, err := store.GetUsersByGroupID(ctx, groupId)
 		if err != nil {
 			httpError = &HttpError{
 				Code:    http.StatusInternalServerError,
@@ -141,6 +120,24 @@ func AddPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			}
 			return
 		}
+		httpError = checkMembers(users, paymentRefs(body.PayerID, body.PayeeIDs))
+		if httpError != nil {
+			return
+		}
+
+		id, err := store.AddPaymentByGroupId(ctx, groupId, body)
+		if err != nil {
+			if err == database.ErrUserInactive {
+				httpError = &HttpError{
+					Code:    http.StatusBadRequest,
+					Message: "Inactive user in payment",
+				}
+			} else {
+				httpError = dbError(err)
+			}
+			return
+		}
+		metrics.PaymentCreated(body.Amount)
 
 		res := response{id}
 		data, _ := json.Marshal(res)
@@ -150,35 +147,27 @@ func AddPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func PatchPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func PatchPayment(store database.Store, L *slog.Logger) http.HandlerFunc {
 	type request struct {
 		Amount      *float32 `json:"amount"`
 		Description *string  `json:"description"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupId, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupId)
+		_, err := store.GetGroupByID(ctx, groupId)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -197,29 +186,14 @@ func PatchPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		payment, err := database.GetPaymentByID(ctx, db, L, paymentID)
-		if err != nil {
-			if err == pgx.ErrNoRows {
-				httpError = &HttpError{
-					Code:    http.StatusNotFound,
-					Message: "Not found",
-				}
-			} else {
-				httpError = &HttpError{
-					Code:    http.StatusInternalServerError,
-					Message: "Internal server error",
-				}
-			}
+		version, httpError := withIfMatch(r)
+		if httpError != nil {
 			return
 		}
 
 		var body request
-		err = json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid JSON",
-			}
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
 			return
 		}
 		if body.Amount == nil && body.Description == nil {
@@ -227,25 +201,23 @@ func PatchPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 		if body.Amount != nil && *body.Amount <= 0 {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid amount field",
-			}
+			httpError = invalidField("amount", "Invalid amount field")
 			return
 		}
 		if body.Description != nil && *body.Description == "" {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid description field",
-			}
+			httpError = invalidField("description", "Invalid description field")
 			return
 		}
 
-		err = database.PatchPayment(ctx, db, L, payment, body.Amount, body.Description)
+		err = store.PatchPayment(ctx, groupId, paymentID, version, body.Amount, body.Description)
 		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
+			if err == database.ErrUserInactive {
+				httpError = &HttpError{
+					Code:    http.StatusConflict,
+					Message: "Cannot change amount of payment with inactive users",
+				}
+			} else {
+				httpError = dbError(err)
 			}
 			return
 		}
@@ -256,30 +228,22 @@ func PatchPayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func DeletePayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func DeletePayment(store database.Store, L *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupId, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupId)
+		_, err := store.GetGroupByID(ctx, groupId)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -298,34 +262,20 @@ func DeletePayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		payment, err := database.GetPaymentByID(ctx, db, L, paymentID)
-		if err != nil {
-			if err == pgx.ErrNoRows {
-				httpError = &HttpError{
-					Code:    http.StatusNotFound,
-					Message: "Not found",
-				}
-			} else {
-				httpError = &HttpError{
-					Code:    http.StatusInternalServerError,
-					Message: "Internal server error",
-				}
-			}
+		version, httpError := withIfMatch(r)
+		if httpError != nil {
 			return
 		}
 
-		err = database.DeletePayment(ctx, db, L, payment)
+		err = store.DeletePayment(ctx, groupId, paymentID, version)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrUserInactive {
 				httpError = &HttpError{
-					Code:    http.StatusNotFound,
-					Message: "Not found",
+					Code:    http.StatusConflict,
+					Message: "Cannot delete payment with inactive users",
 				}
 			} else {
-				httpError = &HttpError{
-					Code:    http.StatusInternalServerError,
-					Message: "Internal server error",
-				}
+				httpError = dbError(err)
 			}
 			return
 		}
@@ -336,30 +286,22 @@ func DeletePayment(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func DeleteAllPayments(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func DeleteAllPayments(store database.Store, L *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupId, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupId)
+		_, err := store.GetGroupByID(ctx, groupId)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -373,9 +315,9 @@ func DeleteAllPayments(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		err = database.DeleteAllPayments(ctx, db, L, groupId)
+		err = store.DeleteAllPayments(ctx, groupId)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
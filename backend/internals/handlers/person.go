@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+func GetPerson(store database.Store, L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		personID, httpError := withPersonID(r)
+		if httpError != nil {
+			return
+		}
+
+		person, err := store.GetPersonByID(ctx, personID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		res := toPersonView(person)
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+func CreatePerson(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+	}
+
+	type response struct {
+		ID int `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		var body request
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if body.Name == "" {
+			httpError = invalidField("name", "Empty name field")
+			return
+		}
+
+		id, err := store.CreatePerson(ctx, body.Name)
+		if err != nil {
+			httpError = &HttpError{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			}
+			return
+		}
+
+		res := response{id}
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	}
+}
+
+func LinkUser(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		UserID int `json:"user_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		personID, httpError := withPersonID(r)
+		if httpError != nil {
+			return
+		}
+
+		_, err := store.GetPersonByID(ctx, personID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		var body request
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if body.UserID <= 0 {
+			httpError = invalidField("user_id", "Invalid user_id field")
+			return
+		}
+
+		err = store.LinkUserToPerson(ctx, personID, body.UserID)
+		if err != nil {
+			httpError = dbError(err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}
+}
+
+func UnlinkUser(store database.Store, L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		personID, httpError := withPersonID(r)
+		if httpError != nil {
+			return
+		}
+
+		userID, httpError := withUserID(r)
+		if httpError != nil {
+			return
+		}
+
+		err := store.UnlinkUserFromPerson(ctx, personID, userID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}
+}
+
+func GetPersonBalances(store database.Store, L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		personID, httpError := withPersonID(r)
+		if httpError != nil {
+			return
+		}
+
+		person, err := store.GetPersonByID(ctx, personID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		memberships, err := store.GetMembershipsByPersonID(ctx, personID)
+		if err != nil {
+			httpError = &HttpError{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			}
+			return
+		}
+
+		res := PersonBalances{
+			PersonID: personID,
+			Groups:   toGroupBalanceList(memberships),
+		}
+		for _, membership := range memberships {
+			res.Total += membership.Balance
+		}
+
+		if r.URL.Query().Get("plan") == "true" {
+			usersByGroup := map[int][]database.User{}
+			people := map[int]database.Person{}
+			for _, membership := range memberships {
+				users, err := store.GetUsersByGroupID(ctx, membership.GroupID)
+				if err != nil {
+					httpError = &HttpError{
+						Code:    http.StatusInternalServerError,
+						Message: "Internal server error",
+					}
+					return
+				}
+				usersByGroup[membership.GroupID] = users
+
+				for _, user := range users {
+					if user.PersonID == nil {
+						continue
+					}
+					if _, ok := people[*user.PersonID]; ok {
+						continue
+					}
+					other, err := store.GetPersonByID(ctx, *user.PersonID)
+					if err != nil {
+						httpError = &HttpError{
+							Code:    http.StatusInternalServerError,
+							Message: "Internal server error",
+						}
+						return
+					}
+					people[other.ID] = other
+				}
+			}
+			res.Plan = netAcrossGroups(person, memberships, usersByGroup, people)
+		}
+
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/michaelzhan1/split/internals/logs"
+)
+
+// largest request body the API reads
+const MaxBodyBytes = 1 << 20
+
+// problem types are relative URIs named after the status, documented in the OpenAPI spec
+func problemType(code int) string {
+	return "/problems/" + strings.ToLower(strings.ReplaceAll(http.StatusText(code), " ", "-"))
+}
+
+// toProblem fills in what handlers leave out of an HttpError
+func toProblem(r *http.Request, httpError *HttpError) HttpError {
+	problem := *httpError
+	if problem.Type == "" {
+		problem.Type = problemType(problem.Code)
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Code)
+	}
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+	if problem.RequestID == "" {
+		problem.RequestID = logs.GetRequestID(r.Context())
+	}
+	return problem
+}
+
+// WriteError is the one place errors are rendered, as application/problem+json
+func WriteError(w http.ResponseWriter, r *http.Request, L *slog.Logger, httpError *HttpError) {
+	problem := toProblem(r, httpError)
+	data, _ := json.Marshal(problem)
+	L.InfoContext(r.Context(), problem.Message, "code", problem.Code)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Code)
+	w.Write(data)
+}
+
+// writeErrorIfSet is deferred by handlers with a pointer to their httpError, so
+// whatever it ends up as gets written
+func writeErrorIfSet(w http.ResponseWriter, r *http.Request, L *slog.Logger, httpError **HttpError) {
+	if *httpError != nil {
+		WriteError(w, r, L, *httpError)
+	}
+}
+
+func NotFound(L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, L, &HttpError{
+			Code:    http.StatusNotFound,
+			Message: "No route for " + r.URL.Path,
+		})
+	}
+}
+
+func MethodNotAllowed(L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, L, &HttpError{
+			Code:    http.StatusMethodNotAllowed,
+			Message: r.Method + " is not allowed on " + r.URL.Path,
+		})
+	}
+}
+
+// invalidField is a 400 for one bad field of the body
+func invalidField(field string, message string) *HttpError {
+	return &HttpError{
+		Code:    http.StatusBadRequest,
+		Message: message,
+		Details: []FieldError{{Field: field, Message: message}},
+	}
+}
+
+var (
+	errEmptyBody    = errors.New("empty body")
+	errTrailingData = errors.New("trailing data")
+)
+
+// decodeJSON reads exactly one JSON value into v. Unknown fields, trailing data and
+// bodies over MaxBodyBytes are rejected with a message saying which it was
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) *HttpError {
+	err := decodeBody(w, r, v)
+	if err == errEmptyBody {
+		return &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Empty request body, expected a JSON object",
+		}
+	}
+	return decodeError(err)
+}
+
+// decodeOptionalJSON is decodeJSON for routes where the body can be left out
+func decodeOptionalJSON(w http.ResponseWriter, r *http.Request, v any) *HttpError {
+	err := decodeBody(w, r, v)
+	if err == errEmptyBody {
+		return nil
+	}
+	return decodeError(err)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == io.EOF {
+		return errEmptyBody
+	}
+	if err != nil {
+		return err
+	}
+
+	// anything but the end of the body after the value is trailing data
+	var extra json.RawMessage
+	err = decoder.Decode(&extra)
+	if err == io.EOF {
+		return nil
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return errTrailingData
+}
+
+func decodeError(err error) *HttpError {
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &HttpError{
+			Code:    http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("Request body is larger than %d bytes", maxBytesErr.Limit),
+		}
+	case err == errTrailingData:
+		return &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Request body has data after the JSON object",
+		}
+	case errors.As(err, &syntaxErr):
+		return &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Malformed JSON at byte %d: %v", syntaxErr.Offset, syntaxErr),
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Malformed JSON, the body ends early",
+		}
+	case errors.As(err, &typeErr):
+		got := typeErr.Value
+		if got == "bool" {
+			got = "boolean"
+		}
+		if typeErr.Field == "" {
+			return &HttpError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Expected %s, got %s", jsonKind(typeErr.Type.String()), got),
+			}
+		}
+		field := fieldPath(typeErr.Field)
+		return invalidField(field, fmt.Sprintf("Field %s must be %s, got %s", field, jsonKind(typeErr.Type.String()), got))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return invalidField(field, fmt.Sprintf("Unknown field %s", field))
+	}
+	return &HttpError{
+		Code:    http.StatusBadRequest,
+		Message: "Invalid JSON: " + err.Error(),
+	}
+}
+
+// turns the decoder's payee_ids.1 into payee_ids[1]
+func fieldPath(field string) string {
+	var path strings.Builder
+	for idx, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			path.WriteString("[" + part + "]")
+			continue
+		}
+		if idx > 0 {
+			path.WriteString(".")
+		}
+		path.WriteString(part)
+	}
+	return path.String()
+}
+
+// names Go types the way a JSON client thinks of them
+func jsonKind(goType string) string {
+	goType = strings.TrimPrefix(goType, "*")
+	switch {
+	case strings.HasPrefix(goType, "[]"):
+		return "an array"
+	case strings.HasPrefix(goType, "int"), strings.HasPrefix(goType, "uint"), strings.HasPrefix(goType, "float"):
+		return "a number"
+	case goType == "string":
+		return "a string"
+	case goType == "bool":
+		return "a boolean"
+	}
+	return "an object"
+}
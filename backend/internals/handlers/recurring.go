@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+func GetRecurringPayments(store database.Store, L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		_, err := store.GetGroupByID(ctx, groupID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		recurring, err := store.GetRecurringPaymentsByGroupID(ctx, groupID)
+		if err != nil {
+			httpError = &HttpError{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			}
+			return
+		}
+
+		res := toRecurringPaymentList(recurring)
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+func AddRecurringPayment(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		Description string  `json:"description"`
+		Amount      float32 `json:"amount"`
+		PayerID     int     `json:"payer_id"`
+		PayeeIDs    []int   `json:"payee_ids"`
+		Frequency   string  `json:"frequency"`
+		DayOfMonth  *int    `json:"day_of_month"`
+		StartDate   string  `json:"start_date"`
+		EndDate     *string `json:"end_date"`
+	}
+
+	type response struct {
+		ID int `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		_, err := store.GetGroupByID(ctx, groupID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		var body request
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if body.Description == "" {
+			httpError = invalidField("description", "Empty description field")
+			return
+		}
+		if body.Amount <= 0 {
+			httpError = invalidField("amount", "Non-positive balance")
+			return
+		}
+		if len(body.PayeeIDs) == 0 {
+			httpError = invalidField("payee_ids", "No payees in payment")
+			return
+		}
+		httpError = duplicatePayee(body.PayeeIDs)
+		if httpError != nil {
+			return
+		}
+		if body.Frequency != database.FrequencyWeekly && body.Frequency != database.FrequencyMonthly && body.Frequency != database.FrequencyYearly {
+			httpError = invalidField("frequency", "Invalid frequency field, must be weekly, monthly or yearly")
+			return
+		}
+		if body.DayOfMonth != nil && (body.Frequency != database.FrequencyMonthly || *body.DayOfMonth < 1 || *body.DayOfMonth > 31) {
+			httpError = invalidField("day_of_month", "Invalid day_of_month field")
+			return
+		}
+		startDate, err := time.Parse(time.DateOnly, body.StartDate)
+		if err != nil {
+			httpError = invalidField("start_date", "Invalid start_date field")
+			return
+		}
+		var endDate *time.Time
+		if body.EndDate != nil {
+			parsed, err := time.Parse(time.DateOnly, *body.EndDate)
+			if err != nil || parsed.Before(startDate) {
+				httpError = invalidField("end_date", "Invalid end_date field")
+				return
+			}
+			endDate = &parsed
+		}
+
+		users, err := store.GetUsersByGroupID(ctx, groupID)
+		if err != nil {
+			httpError = &HttpError{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			}
+			return
+		}
+		httpError = checkMembers(users, paymentRefs(body.PayerID, body.PayeeIDs))
+		if httpError != nil {
+			return
+		}
+
+		id, err := store.AddRecurringPayment(ctx, groupID, database.InsertRecurringPayment{
+			Description: body.Description,
+			Amount:      body.Amount,
+			PayerID:     body.PayerID,
+			PayeeIDs:    body.PayeeIDs,
+			Frequency:   body.Frequency,
+			DayOfMonth:  body.DayOfMonth,
+			StartDate:   startDate,
+			EndDate:     endDate,
+		})
+		if err != nil {
+			httpError = dbError(err)
+			return
+		}
+
+		res := response{id}
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	}
+}
+
+func DeleteRecurringPayment(store database.Store, L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		recurringID, httpError := withRecurringID(r)
+		if httpError != nil {
+			return
+		}
+
+		err := store.DeleteRecurringPayment(ctx, groupID, recurringID)
+		if err != nil {
+			httpError = dbError(err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}
+}
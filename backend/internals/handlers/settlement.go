@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+func RecordSettlement(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		FromID int     `json:"from_id"`
+		ToID   int     `json:"to_id"`
+		Amount float32 `json:"amount"`
+	}
+
+	type response struct {
+		ID int `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		_, err := store.GetGroupByID(ctx, groupID)
+		if err != nil {
+			if err == database.ErrNotFound {
+				httpError = &HttpError{
+					Code:    http.StatusNotFound,
+					Message: "Not found",
+				}
+			} else {
+				httpError = &HttpError{
+					Code:    http.StatusInternalServerError,
+					Message: "Internal server error",
+				}
+			}
+			return
+		}
+
+		var body request
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if body.Amount <= 0 {
+			httpError = invalidField("amount", "Non-positive balance")
+			return
+		}
+		if body.FromID == body.ToID {
+			httpError = &HttpError{
+				Code:    http.StatusBadRequest,
+				Message: "Cannot settle with the same user",
+			}
+			return
+		}
+
+		users, err := store.GetUsersByGroupID(ctx, groupID)
+		if err != nil {
+			httpError = &HttpError{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			}
+			return
+		}
+		httpError = checkMembers(users, []memberRef{{"from_id", body.FromID}, {"to_id", body.ToID}})
+		if httpError != nil {
+			return
+		}
+
+		id, err := store.RecordSettlement(ctx, groupID, body.FromID, body.ToID, body.Amount)
+		if err != nil {
+			if err == database.ErrUserInactive {
+				httpError = &HttpError{
+					Code:    http.StatusBadRequest,
+					Message: "Inactive user in settlement",
+				}
+			} else {
+				httpError = dbError(err)
+			}
+			return
+		}
+
+		res := response{id}
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	}
+}
@@ -3,41 +3,28 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"log/slog"
 	"net/http"
-	"time"
 
-	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/michaelzhan1/split/internals/database"
 )
 
-func GetUsers(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func GetUsers(store database.Store, L *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupID)
+		_, err := store.GetGroupByID(ctx, groupID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -51,7 +38,7 @@ func GetUsers(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		users, err := database.GetUsersByGroupID(ctx, db, L, groupID)
+		users, err := store.GetUsersByGroupID(ctx, groupID)
 		if err != nil {
 			httpError = &HttpError{
 				Code:    http.StatusInternalServerError,
@@ -62,13 +49,16 @@ func GetUsers(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 
 		res := toUserList(users)
 		data, _ := json.Marshal(res)
+		if notModified(w, r, bodyETag(data)) {
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write(data)
 	}
 }
 
-func AddUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func AddUser(store database.Store, L *slog.Logger) http.HandlerFunc {
 	type request struct {
 		Name string `json:"name"`
 	}
@@ -78,28 +68,20 @@ func AddUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupID)
+		_, err := store.GetGroupByID(ctx, groupID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -114,28 +96,18 @@ func AddUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 		}
 
 		var body request
-		err = json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid JSON",
-			}
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
 			return
 		}
 		if body.Name == "" {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Empty name field",
-			}
+			httpError = invalidField("name", "Empty name field")
 			return
 		}
 
-		id, err := database.AddUserToGroupByID(ctx, db, L, groupID, body.Name)
+		id, err := store.AddUserToGroupByID(ctx, groupID, body.Name)
 		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
-			}
+			httpError = dbError(err)
 			return
 		}
 
@@ -147,34 +119,26 @@ func AddUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 	}
 }
 
-func PatchUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func PatchUser(store database.Store, L *slog.Logger) http.HandlerFunc {
 	type request struct {
 		Name *string `json:"name"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupID)
+		_, err := store.GetGroupByID(ctx, groupID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -193,13 +157,14 @@ func PatchUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
+		version, httpError := withIfMatch(r)
+		if httpError != nil {
+			return
+		}
+
 		var body request
-		err = json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Invalid JSON",
-			}
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
 			return
 		}
 		if body.Name == nil {
@@ -207,16 +172,38 @@ func PatchUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 		if body.Name != nil && *body.Name == "" {
-			httpError = &HttpError{
-				Code:    http.StatusBadRequest,
-				Message: "Empty name field",
-			}
+			httpError = invalidField("name", "Empty name field")
 			return
 		}
 
-		err = database.PatchUser(ctx, db, L, groupID, userID, *body.Name)
+		err = store.PatchUser(ctx, groupID, userID, version, *body.Name)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			httpError = dbError(err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}
+}
+
+func DeleteUser(store database.Store, L *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		_, err := store.GetGroupByID(ctx, groupID)
+		if err != nil {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -230,36 +217,47 @@ func PatchUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
+		userID, httpError := withUserID(r)
+		if httpError != nil {
+			return
+		}
+
+		version, httpError := withIfMatch(r)
+		if httpError != nil {
+			return
+		}
+
+		err = store.DeleteUser(ctx, groupID, userID, version)
+		if err != nil {
+			httpError = dbError(err)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("{}"))
 	}
 }
 
-func DeleteUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
+func DeactivateUser(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		TransferTo *int `json:"transfer_to"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
 		defer cancel()
 
 		var httpError *HttpError
-		defer func() {
-			if httpError != nil {
-				data, _ := json.Marshal(httpError)
-				L.Info(httpError.Message, "code", httpError.Code)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(httpError.Code)
-				w.Write(data)
-			}
-		}()
+		defer writeErrorIfSet(w, r, L, &httpError)
 
 		groupID, httpError := withGroupID(r)
 		if httpError != nil {
 			return
 		}
 
-		_, err := database.GetGroupByID(ctx, db, L, groupID)
+		_, err := store.GetGroupByID(ctx, groupID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -278,15 +276,66 @@ func DeleteUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			return
 		}
 
-		err = database.DeleteUser(ctx, db, L, groupID, userID)
+		// body is optional when the balance is already settled
+		var body request
+		httpError = decodeOptionalJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if body.TransferTo != nil && *body.TransferTo == userID {
+			httpError = &HttpError{
+				Code:    http.StatusBadRequest,
+				Message: "Cannot transfer balance to the same user",
+			}
+			return
+		}
+
+		err = store.DeactivateUser(ctx, groupID, userID, body.TransferTo)
 		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			if err == database.ErrUserInactive {
 				httpError = &HttpError{
 					Code:    http.StatusConflict,
-					Message: "Cannot delete user with associated payments",
+					Message: "User is already inactive",
 				}
-			} else if err == pgx.ErrNoRows {
+			} else if err == database.ErrUnsettledBalance {
+				httpError = &HttpError{
+					Code:    http.StatusConflict,
+					Message: "Cannot deactivate user with unsettled balance",
+				}
+			} else if err == database.ErrInvalidTransferUser {
+				httpError = invalidField("transfer_to", "Invalid transfer_to field")
+			} else {
+				httpError = dbError(err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}
+}
+
+func MergeUser(store database.Store, L *slog.Logger) http.HandlerFunc {
+	type request struct {
+		TargetID int `json:"target_id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeouts(r.Context()).Request)
+		defer cancel()
+
+		var httpError *HttpError
+		defer writeErrorIfSet(w, r, L, &httpError)
+
+		groupID, httpError := withGroupID(r)
+		if httpError != nil {
+			return
+		}
+
+		_, err := store.GetGroupByID(ctx, groupID)
+		if err != nil {
+			if err == database.ErrNotFound {
 				httpError = &HttpError{
 					Code:    http.StatusNotFound,
 					Message: "Not found",
@@ -299,6 +348,47 @@ func DeleteUser(db *pgxpool.Pool, L *slog.Logger) http.HandlerFunc {
 			}
 			return
 		}
+
+		userID, httpError := withUserID(r)
+		if httpError != nil {
+			return
+		}
+
+		var body request
+		httpError = decodeJSON(w, r, &body)
+		if httpError != nil {
+			return
+		}
+		if body.TargetID <= 0 {
+			httpError = invalidField("target_id", "Invalid target_id field")
+			return
+		}
+		if body.TargetID == userID {
+			httpError = &HttpError{
+				Code:    http.StatusBadRequest,
+				Message: "Cannot merge user into itself",
+			}
+			return
+		}
+
+		err = store.MergeUser(ctx, groupID, userID, body.TargetID)
+		if err != nil {
+			if err == database.ErrUserInactive {
+				httpError = &HttpError{
+					Code:    http.StatusConflict,
+					Message: "Cannot merge into inactive user",
+				}
+			} else if err == database.ErrPersonConflict {
+				httpError = &HttpError{
+					Code:    http.StatusConflict,
+					Message: "Cannot merge members linked to different people, unlink one first",
+				}
+			} else {
+				httpError = dbError(err)
+			}
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("{}"))
@@ -1,13 +1,135 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/michaelzhan1/split/internals/database"
 )
 
+// Timeouts bound the database work handlers do for one request
+type Timeouts struct {
+	Request time.Duration
+	// a batch does the work of many requests, so it gets longer than one
+	Batch time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Request: 5 * time.Second,
+	Batch:   30 * time.Second,
+}
+
+type timeoutsKey struct{}
+
+// WithTimeouts makes the handlers behind it use t instead of DefaultTimeouts
+func WithTimeouts(t Timeouts) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), timeoutsKey{}, t)))
+		})
+	}
+}
+
+func timeouts(ctx context.Context) Timeouts {
+	t, ok := ctx.Value(timeoutsKey{}).(Timeouts)
+	if !ok {
+		return DefaultTimeouts
+	}
+	return t
+}
+
+// callers identify themselves as a person until there is real authentication
+func withCallerID(r *http.Request) (int, *HttpError) {
+	callerIDStr := r.Header.Get("X-Person-ID")
+	if callerIDStr == "" {
+		return 0, &HttpError{
+			Code:    http.StatusUnauthorized,
+			Message: "Empty or missing X-Person-ID header",
+		}
+	}
+	callerIDInt, err := strconv.Atoi(callerIDStr)
+	if err != nil || callerIDInt <= 0 {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Bad X-Person-ID header",
+		}
+	}
+	return callerIDInt, nil
+}
+
+// reads the version an If-Match header expects. A missing header or * matches any version
+func withIfMatch(r *http.Request) (*int, *HttpError) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return nil, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(ifMatch, `"`))
+	if err != nil || !strings.HasPrefix(ifMatch, `"`) || !strings.HasSuffix(ifMatch, `"`) {
+		return nil, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Bad If-Match header, expected a quoted version like \"3\"",
+		}
+	}
+	return &version, nil
+}
+
+// strong ETag for a single versioned row
+func versionETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// weak ETag for a response without a single version, like a list
+func bodyETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// sets the ETag and reports whether the client's If-None-Match already has it,
+// in which case a 304 has been written
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func withPagination(r *http.Request) (int, int, *HttpError) {
+	limit, offset := 20, 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limitInt, err := strconv.Atoi(limitStr)
+		if err != nil || limitInt <= 0 || limitInt > 100 {
+			return 0, 0, &HttpError{
+				Code:    http.StatusBadRequest,
+				Message: "Bad limit, must be between 1 and 100",
+			}
+		}
+		limit = limitInt
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offsetInt, err := strconv.Atoi(offsetStr)
+		if err != nil || offsetInt < 0 {
+			return 0, 0, &HttpError{
+				Code:    http.StatusBadRequest,
+				Message: "Bad offset",
+			}
+		}
+		offset = offsetInt
+	}
+	return limit, offset, nil
+}
+
 func withGroupID(r *http.Request) (int, *HttpError) {
 	groupIDStr := chi.URLParam(r, "group_id")
 	if groupIDStr == "" {
@@ -44,6 +166,24 @@ func withUserID(r *http.Request) (int, *HttpError) {
 	return userIDInt, nil
 }
 
+func withPersonID(r *http.Request) (int, *HttpError) {
+	personIDStr := chi.URLParam(r, "person_id")
+	if personIDStr == "" {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Empty or missing person ID",
+		}
+	}
+	personIDInt, err := strconv.Atoi(personIDStr)
+	if err != nil || personIDInt <= 0 {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Bad person ID",
+		}
+	}
+	return personIDInt, nil
+}
+
 func withPaymentID(r *http.Request) (int, *HttpError) {
 	paymentIDStr := chi.URLParam(r, "payment_id")
 	if paymentIDStr == "" {
@@ -62,20 +202,93 @@ func withPaymentID(r *http.Request) (int, *HttpError) {
 	return paymentIDInt, nil
 }
 
+func withRecurringID(r *http.Request) (int, *HttpError) {
+	recurringIDStr := chi.URLParam(r, "recurring_id")
+	if recurringIDStr == "" {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Empty or missing recurring payment ID",
+		}
+	}
+	recurringIDInt, err := strconv.Atoi(recurringIDStr)
+	if err != nil || recurringIDInt <= 0 {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Bad recurring payment ID",
+		}
+	}
+	return recurringIDInt, nil
+}
+
+func withWebhookID(r *http.Request) (int, *HttpError) {
+	webhookIDStr := chi.URLParam(r, "webhook_id")
+	if webhookIDStr == "" {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Empty or missing webhook ID",
+		}
+	}
+	webhookIDInt, err := strconv.Atoi(webhookIDStr)
+	if err != nil || webhookIDInt <= 0 {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Bad webhook ID",
+		}
+	}
+	return webhookIDInt, nil
+}
+
+func withDeliveryID(r *http.Request) (int, *HttpError) {
+	deliveryIDStr := chi.URLParam(r, "delivery_id")
+	if deliveryIDStr == "" {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Empty or missing delivery ID",
+		}
+	}
+	deliveryIDInt, err := strconv.Atoi(deliveryIDStr)
+	if err != nil || deliveryIDInt <= 0 {
+		return 0, &HttpError{
+			Code:    http.StatusBadRequest,
+			Message: "Bad delivery ID",
+		}
+	}
+	return deliveryIDInt, nil
+}
+
 func toGroupView(group database.Group) Group {
 	return Group{
-		ID:   group.ID,
-		Name: group.Name,
+		ID:      group.ID,
+		Name:    group.Name,
+		Version: group.Version,
 	}
 }
 
+func toGroupListingList(groups []database.GroupListing) []GroupListing {
+	res := make([]GroupListing, 0, len(groups))
+	for _, group := range groups {
+		res = append(res, GroupListing{
+			ID:             group.ID,
+			Name:           group.Name,
+			MemberCount:    group.MemberCount,
+			TotalSpent:     group.TotalSpent,
+			Balance:        group.Balance,
+			LastActivityAt: group.LastActivityAt,
+		})
+	}
+	return res
+}
+
 func toUserList(users []database.User) []User {
 	res := make([]User, 0, len(users))
 	for _, user := range users {
 		res = append(res, User{
-			ID:      user.ID,
-			Name:    user.Name,
-			Balance: user.Balance,
+			ID:       user.ID,
+			PersonID: user.PersonID,
+			Name:     user.Name,
+			Balance:  user.Balance,
+			Active:   user.Active,
+			Version:  user.Version,
 		})
 	}
 	return res
@@ -90,6 +303,8 @@ func toPaymentList(payments []database.Payment) []Payment {
 				ID:      payment.PayeeIDs[idx],
 				Name:    payment.PayeeNames[idx],
 				Balance: payment.PayeeBalances[idx],
+				Active:  payment.PayeeActives[idx],
+				Version: payment.PayeeVersions[idx],
 			})
 		}
 
@@ -101,8 +316,109 @@ func toPaymentList(payments []database.Payment) []Payment {
 				ID:      payment.PayerID,
 				Name:    payment.PayerName,
 				Balance: payment.PayerBalance,
+				Active:  payment.PayerActive,
+				Version: payment.PayerVersion,
 			},
-			Payees: payees,
+			Payees:  payees,
+			Version: payment.Version,
+		})
+	}
+	return res
+}
+
+func toPersonView(person database.Person) Person {
+	return Person{
+		ID:   person.ID,
+		Name: person.Name,
+	}
+}
+
+func toGroupBalanceList(memberships []database.Membership) []GroupBalance {
+	res := make([]GroupBalance, 0, len(memberships))
+	for _, membership := range memberships {
+		res = append(res, GroupBalance{
+			GroupID:   membership.GroupID,
+			GroupName: membership.GroupName,
+			UserID:    membership.UserID,
+			Balance:   membership.Balance,
+		})
+	}
+	return res
+}
+
+func toGroupSummaryView(summary database.GroupSummary) GroupSummary {
+	memberCount := 0
+	for _, user := range summary.Users {
+		if user.Active {
+			memberCount++
+		}
+	}
+
+	return GroupSummary{
+		Group:          toGroupView(summary.Group),
+		Users:          toUserList(summary.Users),
+		RecentPayments: toPaymentList(summary.RecentPayments),
+		Totals: Totals{
+			MemberCount:  memberCount,
+			PaymentCount: summary.PaymentCount,
+			TotalSpent:   summary.TotalSpent,
+		},
+		IOUs: calculate(summary.Users),
+	}
+}
+
+func toRecurringPaymentList(recurring []database.RecurringPayment) []RecurringPayment {
+	res := make([]RecurringPayment, 0, len(recurring))
+	for _, rp := range recurring {
+		var endDate *string
+		if rp.EndDate != nil {
+			formatted := rp.EndDate.Format(time.DateOnly)
+			endDate = &formatted
+		}
+
+		res = append(res, RecurringPayment{
+			ID:          rp.ID,
+			Description: rp.Description,
+			Amount:      rp.Amount,
+			PayerID:     rp.PayerID,
+			PayeeIDs:    rp.PayeeIDs,
+			Frequency:   rp.Frequency,
+			DayOfMonth:  rp.DayOfMonth,
+			StartDate:   rp.StartDate.Format(time.DateOnly),
+			EndDate:     endDate,
+			NextRun:     rp.NextRun.Format(time.DateOnly),
+			Paused:      rp.Paused,
+		})
+	}
+	return res
+}
+
+func toWebhookList(webhooks []database.Webhook) []Webhook {
+	res := make([]Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		res = append(res, Webhook{
+			ID:        webhook.ID,
+			URL:       webhook.URL,
+			Events:    webhook.Events,
+			CreatedAt: webhook.CreatedAt,
+		})
+	}
+	return res
+}
+
+func toWebhookDeliveryList(deliveries []database.WebhookDelivery) []WebhookDelivery {
+	res := make([]WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		res = append(res, WebhookDelivery{
+			ID:             delivery.ID,
+			EventID:        delivery.EventID,
+			Event:          delivery.Event,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			NextAttemptAt:  delivery.NextAttemptAt,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			DeliveredAt:    delivery.DeliveredAt,
 		})
 	}
 	return res
@@ -157,3 +473,76 @@ func calculate(users []database.User) []IOU {
 
 	return ious
 }
+
+// settle a person's debts across groups with one transfer per counterparty
+func netAcrossGroups(person database.Person, memberships []database.Membership, usersByGroup map[int][]database.User, people map[int]database.Person) []Transfer {
+	self := Party{
+		PersonID: &person.ID,
+		Name:     person.Name,
+	}
+
+	// positive means the counterparty owes the person
+	net := map[string]float32{}
+	parties := map[string]Party{}
+	order := []string{}
+	for _, membership := range memberships {
+		users := usersByGroup[membership.GroupID]
+		byID := map[int]database.User{}
+		for _, user := range users {
+			byID[user.ID] = user
+		}
+
+		for _, iou := range calculate(users) {
+			var otherID int
+			var amount float32
+			if iou.ToID == membership.UserID {
+				otherID, amount = iou.FromID, -iou.Amount
+			} else if iou.FromID == membership.UserID {
+				otherID, amount = iou.ToID, iou.Amount
+			} else {
+				continue
+			}
+
+			other := byID[otherID]
+			var key string
+			var party Party
+			if other.PersonID != nil {
+				key = "p" + strconv.Itoa(*other.PersonID)
+				party = Party{
+					PersonID: other.PersonID,
+					Name:     people[*other.PersonID].Name,
+				}
+			} else {
+				key = "u" + strconv.Itoa(other.ID)
+				party = Party{
+					UserID: &other.ID,
+					Name:   other.Name,
+				}
+			}
+			if _, ok := parties[key]; !ok {
+				parties[key] = party
+				order = append(order, key)
+			}
+			net[key] += amount
+		}
+	}
+
+	transfers := []Transfer{}
+	for _, key := range order {
+		amount := net[key]
+		if amount >= database.SettledEpsilon {
+			transfers = append(transfers, Transfer{
+				Debtor:   parties[key],
+				Creditor: self,
+				Amount:   amount,
+			})
+		} else if amount <= -database.SettledEpsilon {
+			transfers = append(transfers, Transfer{
+				Debtor:   self,
+				Creditor: parties[key],
+				Amount:   -amount,
+			})
+		}
+	}
+	return transfers
+}
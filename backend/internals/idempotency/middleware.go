@@ -0,0 +1,193 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+const (
+	Header       = "Idempotency-Key"
+	ReplayHeader = "Idempotent-Replayed"
+	maxKeyLength = 255
+	saveTimeout  = 5 * time.Second
+)
+
+// the response headers a replay repeats. The rest, such as X-Request-ID and the RateLimit-*
+// headers, describe the request that was answered, and the retry sets its own
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Middleware makes POST, PATCH and DELETE requests that carry an Idempotency-Key safe to retry.
+// The first response for a key is stored and replayed as-is for retries within retention,
+// and reusing a key for a different request is rejected. Requests without the header are untouched.
+func Middleware(store database.Store, L *slog.Logger, retention time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" || !mutates(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				writeError(w, r, L, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key longer than %d characters", maxKeyLength))
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, handlers.MaxBodyBytes))
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, r, L, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", maxBytesErr.Limit))
+				return
+			}
+			if err != nil {
+				writeError(w, r, L, http.StatusBadRequest, "Unreadable body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			reserved, existing, err := store.ReserveIdempotencyKey(r.Context(), key, fingerprint(r, body), retention)
+			if err != nil {
+				writeError(w, r, L, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if !reserved {
+				replay(w, L, r, existing, body)
+				return
+			}
+
+			// saving or releasing the key must happen even if the client has gone away
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), saveTimeout)
+			defer cancel()
+
+			rec := &recorder{ResponseWriter: w, statusCode: http.StatusOK}
+			completed := false
+			defer func() {
+				// a 5xx or a panic means the request may not have happened, so let the retry run it
+				if !completed || rec.statusCode >= http.StatusInternalServerError {
+					store.ReleaseIdempotencyKey(ctx, key)
+					return
+				}
+				if rec.header == nil {
+					rec.header = kept(rec.Header())
+				}
+				store.SaveIdempotentResponse(ctx, key, rec.statusCode, rec.header, rec.body.Bytes())
+			}()
+
+			next.ServeHTTP(rec, r)
+			completed = true
+		})
+	}
+}
+
+// Start deletes expired keys now and then on every tick until ctx is done
+func Start(ctx context.Context, store database.Store, L *slog.Logger, interval time.Duration, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			deleted, err := store.DeleteExpiredIdempotencyKeys(ctx, retention)
+			if err != nil {
+				L.Error(fmt.Sprintf("Pruning idempotency keys failed: %v", err))
+			} else if deleted > 0 {
+				L.Info(fmt.Sprintf("Pruned %d idempotency keys", deleted))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func mutates(method string) bool {
+	return method == http.MethodPost || method == http.MethodPatch || method == http.MethodDelete
+}
+
+// fingerprint identifies the request a key was first used for
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", r.Method, r.URL.RequestURI(), r.Header.Get("X-Person-ID"), r.Header.Get("If-Match"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, L *slog.Logger, r *http.Request, existing database.IdempotentResponse, body []byte) {
+	if existing.Fingerprint != fingerprint(r, body) {
+		writeError(w, r, L, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		return
+	}
+	if existing.StatusCode == nil {
+		writeError(w, r, L, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		return
+	}
+
+	// keys saved before the allow-list may still hold every header
+	maps.Copy(w.Header(), kept(existing.Headers))
+	w.Header().Set(ReplayHeader, "true")
+	w.WriteHeader(*existing.StatusCode)
+	w.Write(existing.Body)
+}
+
+// kept is the part of header a replay repeats
+func kept(header http.Header) http.Header {
+	res := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			res[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+	return res
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, L *slog.Logger, code int, message string) {
+	handlers.WriteError(w, r, L, &handlers.HttpError{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// recorder passes the response through while keeping a copy of it
+type recorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	header      http.Header
+	body        bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.statusCode = code
+	rec.header = kept(rec.Header())
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,194 @@
+package idempotency
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+// served wraps handler in the middleware over an empty store and counts the requests that reach it
+func served(handler http.HandlerFunc) (http.Handler, *atomic.Int32) {
+	L := slog.New(slog.NewTextHandler(io.Discard, nil))
+	calls := &atomic.Int32{}
+	counted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		handler(w, r)
+	})
+	return Middleware(database.NewMemoryStore(L), L, time.Hour)(counted), calls
+}
+
+func send(h http.Handler, method string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/groups", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func created(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"echo": %s}`, body)
+}
+
+func TestReplay(t *testing.T) {
+	h, calls := served(created)
+
+	first := send(h, "POST", "k1", `{"name": "Trip"}`)
+	second := send(h, "POST", "k1", `{"name": "Trip"}`)
+	if calls.Load() != 1 {
+		t.Errorf("the handler ran %d times, want once", calls.Load())
+	}
+	if first.Header().Get(ReplayHeader) != "" {
+		t.Errorf("the first response is marked replayed")
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() ||
+		second.Header().Get("Content-Type") != "application/json" || second.Header().Get(ReplayHeader) != "true" {
+		t.Errorf("replay = %d %q %v, want the first response %d %q marked replayed", second.Code, second.Body, second.Header(), first.Code, first.Body)
+	}
+
+	// a different key is a different request
+	if send(h, "POST", "k2", `{"name": "Trip"}`); calls.Load() != 2 {
+		t.Errorf("a new key ran the handler %d times in total, want 2", calls.Load())
+	}
+}
+
+func TestReplayKeepsPerRequestHeaders(t *testing.T) {
+	h, _ := served(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1"`)
+		w.Header().Set("Location", "/groups/1")
+		w.Header().Set("RateLimit-Remaining", "9")
+		w.Header().Set("Retry-After", "30")
+		created(w, r)
+	})
+	// stands in for the request ID and rate limit middleware, which run before this one
+	outer := func(requestID string, remaining string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-ID", requestID)
+			w.Header().Set("RateLimit-Remaining", remaining)
+			h.ServeHTTP(w, r)
+		})
+	}
+
+	send(outer("first", "9"), "POST", "k1", `{"name": "Trip"}`)
+	rec := send(outer("retry", "4"), "POST", "k1", `{"name": "Trip"}`)
+	header := rec.Header()
+	if header.Get(ReplayHeader) != "true" || header.Get("ETag") != `"1"` || header.Get("Location") != "/groups/1" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("replay headers = %v, want Content-Type, ETag and Location replayed", header)
+	}
+	if header.Get("X-Request-ID") != "retry" || header.Get("RateLimit-Remaining") != "4" || header.Get("Retry-After") != "" {
+		t.Errorf("replay headers = %v, want the retry's own request ID and rate limit, and no stale Retry-After", header)
+	}
+}
+
+func TestKeyReusedForAnotherRequest(t *testing.T) {
+	h, calls := served(created)
+
+	send(h, "POST", "k1", `{"name": "Trip"}`)
+	rec := send(h, "POST", "k1", `{"name": "Other trip"}`)
+	if rec.Code != http.StatusUnprocessableEntity || rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("same key with another body = %d %q, want a 422 problem", rec.Code, rec.Body)
+	}
+	if rec := send(h, "PATCH", "k1", `{"name": "Trip"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key and body with another method = %d, want 422", rec.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("the handler ran %d times, want only for the first request", calls.Load())
+	}
+}
+
+func TestKeyInProgress(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	h, calls := served(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		created(w, r)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- send(h, "POST", "k1", `{"name": "Trip"}`)
+	}()
+	<-entered
+
+	rec := send(h, "POST", "k1", `{"name": "Trip"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("retry while the first request runs = %d %q, want 409", rec.Code, rec.Body)
+	}
+
+	close(release)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Errorf("first request = %d, want 201", first.Code)
+	}
+	if rec := send(h, "POST", "k1", `{"name": "Trip"}`); rec.Code != http.StatusCreated || rec.Header().Get(ReplayHeader) != "true" {
+		t.Errorf("retry once the first finished = %d replayed %q, want the 201 replayed", rec.Code, rec.Header().Get(ReplayHeader))
+	}
+	if calls.Load() != 1 {
+		t.Errorf("the handler ran %d times, want once", calls.Load())
+	}
+}
+
+func TestFailureReleasesKey(t *testing.T) {
+	tests := []struct {
+		name string
+		fail http.HandlerFunc
+	}{
+		{"5xx", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}},
+		{"panic", func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failed := false
+			h, calls := served(func(w http.ResponseWriter, r *http.Request) {
+				if !failed {
+					failed = true
+					test.fail(w, r)
+					return
+				}
+				created(w, r)
+			})
+
+			func() {
+				defer func() { recover() }()
+				send(h, "POST", "k1", `{"name": "Trip"}`)
+			}()
+
+			rec := send(h, "POST", "k1", `{"name": "Trip"}`)
+			if calls.Load() != 2 || rec.Code != http.StatusCreated || rec.Header().Get(ReplayHeader) != "" {
+				t.Errorf("retry after a %s = %d replayed %q after %d calls, want a fresh 201", test.name, rec.Code, rec.Header().Get(ReplayHeader), calls.Load())
+			}
+		})
+	}
+}
+
+func TestKeyless(t *testing.T) {
+	h, calls := served(created)
+
+	send(h, "POST", "", `{"name": "Trip"}`)
+	send(h, "POST", "", `{"name": "Trip"}`)
+	send(h, "GET", "k1", "")
+	send(h, "GET", "k1", "")
+	if calls.Load() != 4 {
+		t.Errorf("the handler ran %d times, want every request without a key or that only reads", calls.Load())
+	}
+
+	if rec := send(h, "POST", strings.Repeat("k", maxKeyLength+1), `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("key over %d characters = %d, want 400", maxKeyLength, rec.Code)
+	}
+}
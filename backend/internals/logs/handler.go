@@ -0,0 +1,137 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Packages are the names For accepts, one per part of the server that logs
+var Packages = []string{"database", "events", "http", "idempotency", "migrations", "ratelimit", "scheduler", "webhooks"}
+
+// Levels are the minimum levels to log at, for everything and for the packages in Packages
+type Levels struct {
+	Default  slog.Level
+	Packages map[string]slog.Level
+}
+
+func (l Levels) lowest() slog.Level {
+	lowest := l.Default
+	for _, level := range l.Packages {
+		lowest = min(lowest, level)
+	}
+	return lowest
+}
+
+// ParseLevel reads debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// For returns the logger pkg should use. Its lines are tagged with the package and
+// filtered at the level set for it, or the default level
+func For(L *slog.Logger, pkg string) *slog.Logger {
+	h, ok := L.Handler().(*levelHandler)
+	if !ok {
+		return L.With("package", pkg)
+	}
+	level, ok := h.levels.Packages[pkg]
+	if !ok {
+		level = h.levels.Default
+	}
+	return slog.New(&levelHandler{
+		level:  level,
+		levels: h.levels,
+		next:   h.next.WithAttrs([]slog.Attr{slog.String("package", pkg)}),
+	})
+}
+
+// levelHandler drops records below its level, which can differ from logger to logger
+// while they share one output
+type levelHandler struct {
+	level  slog.Level
+	levels Levels
+	next   slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.next.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h.level, h.levels, h.next.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h.level, h.levels, h.next.WithGroup(name)}
+}
+
+// contextHandler tags records with what their context knows: the request id, route,
+// group and calling person from RequestID and chi, and the trace and span ids of the
+// current span. Only the *Context logging methods pass a context
+type contextHandler struct {
+	next slog.Handler
+}
+
+func (h contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx == nil {
+		return h.next.Handle(ctx, record)
+	}
+	var attrs []slog.Attr
+	if req, ok := ctx.Value(requestKey{}).(request); ok {
+		attrs = append(attrs, slog.String("request_id", req.id))
+		// the route is only complete once chi has matched the request
+		if rctx := chi.RouteContext(ctx); rctx != nil {
+			if route := rctx.RoutePattern(); route != "" {
+				attrs = append(attrs, slog.String("route", route))
+			}
+			if groupID := rctx.URLParam("group_id"); groupID != "" {
+				attrs = append(attrs, slog.String("group_id", groupID))
+			}
+		}
+		if req.actor != 0 {
+			attrs = append(attrs, slog.Int("actor", req.actor))
+		}
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		attrs = append(attrs,
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+	if len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.next.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.next.WithGroup(name)}
+}
@@ -0,0 +1,111 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels Levels
+		pkg    string
+		level  slog.Level
+		want   bool
+	}{
+		{"queries hidden at info", Levels{Default: slog.LevelInfo}, "database", slog.LevelDebug, false},
+		{"queries shown at debug", Levels{Default: slog.LevelDebug}, "database", slog.LevelDebug, true},
+		{"queries shown for their package", Levels{Default: slog.LevelInfo, Packages: map[string]slog.Level{"database": slog.LevelDebug}}, "database", slog.LevelDebug, true},
+		{"other packages keep the default", Levels{Default: slog.LevelInfo, Packages: map[string]slog.Level{"database": slog.LevelDebug}}, "http", slog.LevelDebug, false},
+		{"a package quieter than the default", Levels{Default: slog.LevelDebug, Packages: map[string]slog.Level{"http": slog.LevelWarn}}, "http", slog.LevelInfo, false},
+		{"warnings through a quiet package", Levels{Default: slog.LevelDebug, Packages: map[string]slog.Level{"http": slog.LevelWarn}}, "http", slog.LevelWarn, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			L := For(New(&out, test.levels, "text", false), test.pkg)
+			L.Log(context.Background(), test.level, "GetGroupByID", "query", "SELECT 1")
+			if logged := out.Len() > 0; logged != test.want {
+				t.Errorf("logged %v, want %v: %q", logged, test.want, out.String())
+			}
+			if test.want && !strings.Contains(out.String(), "package="+test.pkg) {
+				t.Errorf("line %q is not tagged with its package", out.String())
+			}
+		})
+	}
+
+	// a logger New did not make still gets the package tag
+	var out bytes.Buffer
+	For(slog.New(slog.NewTextHandler(&out, nil)), "http").Info("served")
+	if !strings.Contains(out.String(), "package=http") {
+		t.Errorf("line %q is not tagged with its package", out.String())
+	}
+}
+
+func TestContextAttrs(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		header  map[string]string
+		want    map[string]any
+		missing []string
+	}{
+		{"group route with actor", "/groups/7/users", map[string]string{"X-Person-ID": "3", RequestIDHeader: "req-1"},
+			map[string]any{"request_id": "req-1", "route": "/groups/{group_id}/users", "group_id": "7", "actor": float64(3)}, nil},
+		{"no actor", "/groups/7/users", map[string]string{RequestIDHeader: "req-2"},
+			map[string]any{"request_id": "req-2", "group_id": "7"}, []string{"actor"}},
+		{"bad actor", "/groups/7/users", map[string]string{"X-Person-ID": "-1", RequestIDHeader: "req-3"},
+			map[string]any{"request_id": "req-3"}, []string{"actor"}},
+		{"route without a group", "/people", map[string]string{RequestIDHeader: "req-4"},
+			map[string]any{"request_id": "req-4", "route": "/people"}, []string{"group_id"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			L := New(&out, Levels{Default: slog.LevelInfo}, "json", true)
+			r := chi.NewRouter()
+			r.Use(RequestID)
+			served := func(w http.ResponseWriter, r *http.Request) {
+				L.InfoContext(r.Context(), "served")
+			}
+			r.Get("/groups/{group_id}/users", served)
+			r.Get("/people", served)
+
+			req := httptest.NewRequest("GET", test.path, nil)
+			for name, value := range test.header {
+				req.Header.Set(name, value)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			var line map[string]any
+			if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+				t.Fatalf("line %q: %v", out.String(), err)
+			}
+			for key, want := range test.want {
+				if line[key] != want {
+					t.Errorf("%s = %v, want %v in %s", key, line[key], want, out.String())
+				}
+			}
+			for _, key := range test.missing {
+				if _, ok := line[key]; ok {
+					t.Errorf("%s is set in %s", key, out.String())
+				}
+			}
+		})
+	}
+
+	// outside a request there is nothing to add
+	var out bytes.Buffer
+	New(&out, Levels{Default: slog.LevelInfo}, "json", true).InfoContext(context.Background(), "tick")
+	if strings.Contains(out.String(), "request_id") || strings.Contains(out.String(), "trace_id") {
+		t.Errorf("background line %s carries request attributes", out.String())
+	}
+}
@@ -1,37 +1,65 @@
 package logs
 
 import (
+	"io"
 	"log/slog"
 	"net/http"
 	"time"
 )
 
+// New makes the server's logger, writing text or json lines. It logs at levels.Default;
+// For gives a package its own logger at the level set for it. With redact on, the
+// values under Redacted keys are masked
+func New(w io.Writer, levels Levels, format string, redact bool) *slog.Logger {
+	// everything reaches the level handlers, which do the filtering
+	options := &slog.HandlerOptions{Level: levels.lowest()}
+	if redact {
+		options.ReplaceAttr = redactAttr
+	}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(&levelHandler{
+		level:  levels.Default,
+		levels: levels,
+		next:   contextHandler{handler},
+	})
+}
+
 func RequestLogger(logger *slog.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-            start := time.Now()
-            // Use a ResponseWriter wrapper to capture status code
-            rw := &responseWriter{w, http.StatusOK}
-            next.ServeHTTP(rw, r)
-            duration := time.Since(start)
+			start := time.Now()
+			// Use a ResponseWriter wrapper to capture status code
+			rw := &responseWriter{w, http.StatusOK}
+			next.ServeHTTP(rw, r)
+			duration := time.Since(start)
 
-            logger.Info("HTTP request",
-                slog.String("method", r.Method),
-                slog.String("path", r.URL.Path),
-                slog.Int("status", rw.statusCode),
-                slog.Duration("duration", duration),
-                slog.String("remote_addr", r.RemoteAddr),
-            )
-        })
+			logger.InfoContext(r.Context(), "HTTP request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rw.statusCode),
+				slog.Duration("duration", duration),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
 	}
 }
 
 type responseWriter struct {
-    http.ResponseWriter
-    statusCode int
+	http.ResponseWriter
+	statusCode int
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
-    rw.statusCode = code
-    rw.ResponseWriter.WriteHeader(code)
+	rw.statusCode = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// lets http.ResponseController reach the underlying writer, e.g. to flush event streams
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
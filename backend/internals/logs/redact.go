@@ -0,0 +1,49 @@
+package logs
+
+import (
+	"log/slog"
+	"reflect"
+)
+
+// Redacted are the keys whose values are masked, whether they are logged as attributes or
+// appear in the SQL arguments logged with a query. They hold free text people typed, like
+// names and descriptions, or secrets, like webhook URLs and idempotency keys
+var Redacted = map[string]bool{
+	"name":        true,
+	"description": true,
+	"search":      true,
+	"payload":     true,
+	"body":        true,
+	"headers":     true,
+	"secret":      true,
+	"url":         true,
+	"key":         true,
+}
+
+const redactedValue = "[redacted]"
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if Redacted[a.Key] {
+		return slog.String(a.Key, redactedValue)
+	}
+	if a.Value.Kind() != slog.KindAny {
+		return a
+	}
+
+	// SQL arguments are maps of parameter name to value, like pgx.StrictNamedArgs
+	v := reflect.ValueOf(a.Value.Any())
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return a
+	}
+	masked := make(map[string]any, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		if Redacted[key] {
+			masked[key] = redactedValue
+		} else {
+			masked[key] = iter.Value().Interface()
+		}
+	}
+	return slog.Any(a.Key, masked)
+}
@@ -0,0 +1,51 @@
+package logs
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestRedactAttr(t *testing.T) {
+	tests := []struct {
+		name string
+		attr slog.Attr
+		want any
+	}{
+		{"name", slog.String("name", "Alice"), redactedValue},
+		{"description", slog.String("description", "Rent for March"), redactedValue},
+		{"webhook url", slog.String("url", "https://example.com/hook?token=1"), redactedValue},
+		{"redacted key of another kind", slog.Int("key", 5), redactedValue},
+		{"kept string", slog.String("method", "POST"), "POST"},
+		{"kept number", slog.Int("group_id", 3), int64(3)},
+		{"SQL args", slog.Any("args", pgx.StrictNamedArgs{"name": "Bob", "description": "Taxi", "id": 3, "amount": float32(12.5)}),
+			map[string]any{"name": redactedValue, "description": redactedValue, "id": 3, "amount": float32(12.5)}},
+		{"typed map", slog.Any("args", map[string]string{"search": "trip", "sort": "name"}),
+			map[string]any{"search": redactedValue, "sort": "name"}},
+		{"map without string keys", slog.Any("ids", map[int]string{1: "Alice"}), map[int]string{1: "Alice"}},
+		{"positional args", slog.Any("args", []any{"Alice", 3}), []any{"Alice", 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := redactAttr(nil, test.attr)
+			if got.Key != test.attr.Key || !reflect.DeepEqual(got.Value.Any(), test.want) {
+				t.Errorf("redactAttr(%v) = %v, want %v", test.attr, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactOption(t *testing.T) {
+	for _, redact := range []bool{true, false} {
+		var out bytes.Buffer
+		L := New(&out, Levels{Default: slog.LevelInfo}, "json", redact)
+		L.Info("Created", "name", "Alice", "args", pgx.StrictNamedArgs{"description": "Rent"})
+		if leaked := strings.Contains(out.String(), "Alice") || strings.Contains(out.String(), "Rent"); leaked == redact {
+			t.Errorf("with redact %v the line is %s", redact, out.String())
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package logs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// an id a client sends is kept when it is short and safe to echo back and log
+var clientRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/-]{1,128}$`)
+
+type requestKey struct{}
+
+// request is what log lines written while serving a request are tagged with
+type request struct {
+	id    string
+	actor int
+}
+
+// RequestID gives every request an id, taken from X-Request-ID when the client sent a usable
+// one and made up otherwise. The id is echoed in the response and travels in the request's
+// context, where log lines pick it up along with the route, group and calling person
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !clientRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		// only logged, handlers still check the header themselves
+		actor, err := strconv.Atoi(r.Header.Get("X-Person-ID"))
+		if err != nil || actor < 0 {
+			actor = 0
+		}
+
+		ctx := context.WithValue(r.Context(), requestKey{}, request{id, actor})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetRequestID returns the id RequestID gave the request ctx belongs to, or ""
+func GetRequestID(ctx context.Context) string {
+	req, _ := ctx.Value(requestKey{}).(request)
+	return req.id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
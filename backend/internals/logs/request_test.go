@@ -0,0 +1,61 @@
+package logs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"usable id", "req-42", true},
+		{"trace style id", "svc/a:b.c_d-1", true},
+		{"longest id", strings.Repeat("a", 128), true},
+		{"none sent", "", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"spaces", "req 42", false},
+		{"markup", "<script>", false},
+		{"line break", "req\n42", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var seen string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = GetRequestID(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/groups", nil)
+			if test.sent != "" {
+				req.Header.Set(RequestIDHeader, test.sent)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			echoed := rec.Header().Get(RequestIDHeader)
+			if echoed != seen {
+				t.Errorf("response id %q, handler saw %q, want the same", echoed, seen)
+			}
+			if test.kept && seen != test.sent {
+				t.Errorf("id = %q, want the client's %q", seen, test.sent)
+			}
+			if !test.kept && !generated.MatchString(seen) {
+				t.Errorf("id = %q, want a new one in place of %q", seen, test.sent)
+			}
+		})
+	}
+
+	// every new id is different
+	ids := map[string]bool{}
+	for range 10 {
+		ids[newRequestID()] = true
+	}
+	if len(ids) != 10 {
+		t.Errorf("10 new ids had %d distinct values", len(ids))
+	}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	paymentsCreated = factory.NewCounter(prometheus.CounterOpts{
+		Name: "split_payments_created_total",
+		Help: "Payments created through the API, on their own or in a batch.",
+	})
+
+	paymentAmount = factory.NewCounter(prometheus.CounterOpts{
+		Name: "split_payment_amount_total",
+		Help: "Sum of the amounts of the payments created through the API.",
+	})
+
+	calculations = factory.NewCounter(prometheus.CounterOpts{
+		Name: "split_calculate_invocations_total",
+		Help: "Times a group's IOUs were calculated.",
+	})
+
+	recurringBooked = factory.NewCounter(prometheus.CounterOpts{
+		Name: "split_recurring_payments_booked_total",
+		Help: "Payments booked from recurring payment templates.",
+	})
+)
+
+// PaymentCreated counts a payment the API committed
+func PaymentCreated(amount float32) {
+	paymentsCreated.Inc()
+	paymentAmount.Add(float64(amount))
+}
+
+func CalculateInvoked() {
+	calculations.Inc()
+}
+
+func RecurringBooked(count int) {
+	recurringBooked.Add(float64(count))
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware(t *testing.T) {
+	// a registry of its own, so only the collectors under test are gathered
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(httpRequests, httpDuration)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/groups/{group_id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Post("/groups/{group_id}/payments", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/groups/1", nil),
+		httptest.NewRequest("GET", "/groups/2", nil),
+		httptest.NewRequest("GET", "/groups/3", nil),
+		httptest.NewRequest("POST", "/groups/1/payments", nil),
+		httptest.NewRequest("GET", "/nowhere/1", nil),
+		httptest.NewRequest("BREW", "/groups/1", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// every id is counted under the one route pattern
+	want := `
+# HELP http_requests_total HTTP requests served, by method, route pattern and status.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/groups/{group_id}",status="200"} 3
+http_requests_total{method="GET",route="unmatched",status="404"} 1
+http_requests_total{method="OTHER",route="unmatched",status="405"} 1
+http_requests_total{method="POST",route="/groups/{group_id}/payments",status="201"} 1
+`
+	if err := testutil.CollectAndCompare(httpRequests, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// durations vary from run to run, so the histogram is checked by its series and counts
+	if series := testutil.CollectAndCount(httpDuration); series != 4 {
+		t.Errorf("duration series = %d, want one per method and route", series)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "http_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["method"] == "GET" && labels["route"] == "/groups/{group_id}" && metric.GetHistogram().GetSampleCount() != 3 {
+				t.Errorf("GET /groups/{group_id} durations = %d, want 3", metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	if problems, err := testutil.GatherAndLint(registry); err != nil || len(problems) > 0 {
+		t.Errorf("lint = %v, %v", problems, err)
+	}
+}
+
+func TestPaymentCreated(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(paymentsCreated, paymentAmount)
+
+	PaymentCreated(12.5)
+	PaymentCreated(30)
+
+	want := `
+# HELP split_payment_amount_total Sum of the amounts of the payments created through the API.
+# TYPE split_payment_amount_total counter
+split_payment_amount_total 42.5
+# HELP split_payments_created_total Payments created through the API, on their own or in a batch.
+# TYPE split_payments_created_total counter
+split_payments_created_total 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStatementLabels(t *testing.T) {
+	tests := []struct {
+		sql       string
+		statement string
+		table     string
+	}{
+		{"SELECT id, name FROM groups WHERE id = @id", "select", "groups"},
+		{"insert into payments (amount) values (@amount)", "insert", "payments"},
+		{"UPDATE users SET active = false", "update", "users"},
+		{"DELETE FROM webhooks WHERE id = @id", "delete", "webhooks"},
+		{"WITH due AS (SELECT id FROM webhook_deliveries) UPDATE webhook_deliveries SET attempts = 1", "with", "webhook_deliveries"},
+		{"SELECT pg_notify('events', '1')", "select", "none"},
+		{"BEGIN", "other", "none"},
+		{"", "other", "none"},
+	}
+	for _, test := range tests {
+		statement, table := statementLabels(test.sql)
+		if statement != test.statement || table != test.table {
+			t.Errorf("statementLabels(%q) = %s, %s, want %s, %s", test.sql, statement, table, test.statement, test.table)
+		}
+	}
+}
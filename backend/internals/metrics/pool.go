@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reads pgxpool statistics each time /metrics is scraped
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns     *prometheus.Desc
+	idleConns         *prometheus.Desc
+	constructingConns *prometheus.Desc
+	totalConns        *prometheus.Desc
+	maxConns          *prometheus.Desc
+	acquires          *prometheus.Desc
+	acquireDuration   *prometheus.Desc
+	emptyAcquires     *prometheus.Desc
+	canceledAcquires  *prometheus.Desc
+	newConns          *prometheus.Desc
+}
+
+// RegisterPool exposes the statistics of pool
+func RegisterPool(pool *pgxpool.Pool) error {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc("pgxpool_"+name, help, nil, nil)
+	}
+	return Registry.Register(&poolCollector{
+		pool:              pool,
+		acquiredConns:     desc("acquired_conns", "Connections currently checked out of the pool."),
+		idleConns:         desc("idle_conns", "Connections idle in the pool."),
+		constructingConns: desc("constructing_conns", "Connections being opened."),
+		totalConns:        desc("total_conns", "Connections in the pool, in any state."),
+		maxConns:          desc("max_conns", "Most connections the pool will open."),
+		acquires:          desc("acquires_total", "Connections successfully checked out."),
+		acquireDuration:   desc("acquire_duration_seconds_total", "Time spent waiting to check out connections."),
+		emptyAcquires:     desc("empty_acquires_total", "Checkouts that had to wait because no connection was idle."),
+		canceledAcquires:  desc("canceled_acquires_total", "Checkouts canceled by their context."),
+		newConns:          desc("new_conns_total", "Connections opened."),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(c.acquiredConns, float64(stat.AcquiredConns()))
+	gauge(c.idleConns, float64(stat.IdleConns()))
+	gauge(c.constructingConns, float64(stat.ConstructingConns()))
+	gauge(c.totalConns, float64(stat.TotalConns()))
+	gauge(c.maxConns, float64(stat.MaxConns()))
+	counter(c.acquires, float64(stat.AcquireCount()))
+	counter(c.acquireDuration, stat.AcquireDuration().Seconds())
+	counter(c.emptyAcquires, float64(stat.EmptyAcquireCount()))
+	counter(c.canceledAcquires, float64(stat.CanceledAcquireCount()))
+	counter(c.newConns, float64(stat.NewConnsCount()))
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var queryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "db_query_duration_seconds",
+	Help:    "How long SQL statements took, by statement kind, first table and outcome.",
+	Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"statement", "table", "status"})
+
+// the table a statement reads or writes first
+var statementTable = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+([a-z_][a-z0-9_]*)`)
+
+// QueryTracer times every statement run through a pgx connection. Set it as the
+// pool's ConnConfig.Tracer
+type QueryTracer struct{}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	at        time.Time
+	statement string
+	table     string
+}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	statement, table := statementLabels(data.SQL)
+	return context.WithValue(ctx, queryStartKey{}, queryStart{time.Now(), statement, table})
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	status := "ok"
+	if data.Err != nil {
+		status = "error"
+	}
+	queryDuration.WithLabelValues(start.statement, start.table, status).Observe(time.Since(start.at).Seconds())
+}
+
+// statementLabels names a statement by its first keyword and table, which keeps the
+// labels bounded by the schema rather than by the queries written against it
+func statementLabels(sql string) (string, string) {
+	statement := "other"
+	fields := strings.Fields(sql)
+	if len(fields) > 0 {
+		switch keyword := strings.ToLower(fields[0]); keyword {
+		case "select", "insert", "update", "delete", "with":
+			statement = keyword
+		}
+	}
+
+	table := "none"
+	if match := statementTable.FindStringSubmatch(sql); match != nil {
+		table = strings.ToLower(match[1])
+	}
+	return statement, table
+}
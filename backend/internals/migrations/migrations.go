@@ -0,0 +1,267 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// numbered migrations, each a NNNN_name.up.sql with a matching NNNN_name.down.sql
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+var (
+	// the database has migrations this binary does not know about, so it is older than the schema
+	ErrNewerSchema = errors.New("database schema is newer than this server")
+
+	// the database is missing migrations this binary needs
+	ErrPendingMigrations = errors.New("database schema has pending migrations")
+)
+
+// any constant works, it only has to be the same for every server sharing a database
+const lockKey = 7_385_211
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// All returns the embedded migrations in order
+func All() ([]Migration, error) {
+	return load(files)
+}
+
+// load reads the migrations in the sql directory of fsys
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for idx, migration := range migrations {
+		if migration.Version != idx+1 {
+			return nil, fmt.Errorf("migration %d is missing", idx+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest is the version the embedded migrations bring a database to
+func Latest() (int, error) {
+	migrations, err := All()
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Current returns the version the database is at. A database set up by the old init
+// script, before there was a schema_version table, counts as version 1
+func Current(ctx context.Context, db querier) (int, error) {
+	var hasTable, hasSchema bool
+	query := "SELECT to_regclass('schema_version') IS NOT NULL, to_regclass('groups') IS NOT NULL"
+	err := db.QueryRow(ctx, query).Scan(&hasTable, &hasSchema)
+	if err != nil {
+		return 0, err
+	}
+	if !hasTable {
+		if hasSchema {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	var version int
+	err = db.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// Check makes sure the database is at exactly the version this server was built for
+func Check(ctx context.Context, db querier) error {
+	latest, err := Latest()
+	if err != nil {
+		return err
+	}
+	current, err := Current(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if err := refuseNewer(current, latest); err != nil {
+		return err
+	}
+	if current < latest {
+		return fmt.Errorf("%w: database is at version %d, this server needs %d", ErrPendingMigrations, current, latest)
+	}
+	return nil
+}
+
+// refuseNewer stops a server from running against, or migrating, a schema it does not know
+func refuseNewer(current int, latest int) error {
+	if current > latest {
+		return fmt.Errorf("%w: database is at version %d, this server knows up to %d", ErrNewerSchema, current, latest)
+	}
+	return nil
+}
+
+// Up applies every pending migration, each in its own transaction, and returns the new version
+func Up(ctx context.Context, db *pgxpool.Pool, L *slog.Logger) (int, error) {
+	migrations, err := All()
+	if err != nil {
+		return 0, err
+	}
+
+	return withLock(ctx, db, L, func(conn *pgxpool.Conn, current int) (int, error) {
+		if err := refuseNewer(current, len(migrations)); err != nil {
+			return current, err
+		}
+		for _, migration := range migrations[current:] {
+			L.Info(fmt.Sprintf("Applying migration %04d_%s", migration.Version, migration.Name))
+			err := apply(ctx, conn, migration.Up, "INSERT INTO schema_version (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			if err != nil {
+				L.Error(fmt.Sprintf("Migration %04d_%s failed: %v", migration.Version, migration.Name, err))
+				return current, err
+			}
+			current = migration.Version
+		}
+		return current, nil
+	})
+}
+
+// Down reverts the last steps migrations, newest first, and returns the new version
+func Down(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, steps int) (int, error) {
+	migrations, err := All()
+	if err != nil {
+		return 0, err
+	}
+
+	return withLock(ctx, db, L, func(conn *pgxpool.Conn, current int) (int, error) {
+		if err := refuseNewer(current, len(migrations)); err != nil {
+			return current, err
+		}
+		for ; steps > 0 && current > 0; steps-- {
+			migration := migrations[current-1]
+			L.Info(fmt.Sprintf("Reverting migration %04d_%s", migration.Version, migration.Name))
+			err := apply(ctx, conn, migration.Down, "DELETE FROM schema_version WHERE version = $1", migration.Version)
+			if err != nil {
+				L.Error(fmt.Sprintf("Migration %04d_%s failed: %v", migration.Version, migration.Name, err))
+				return current, err
+			}
+			current--
+		}
+		return current, nil
+	})
+}
+
+// withLock runs fn holding an advisory lock, so servers starting together migrate one at a time,
+// and with the schema_version table in place
+func withLock(ctx context.Context, db *pgxpool.Pool, L *slog.Logger, fn func(conn *pgxpool.Conn, current int) (int, error)) (int, error) {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	current, err := Current(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+	_, err = conn.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	if current == 1 {
+		// record the init script's schema, which Current only inferred, as the first migration
+		_, err = conn.Exec(ctx, "INSERT INTO schema_version (version, name) VALUES (1, 'init') ON CONFLICT DO NOTHING")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return fn(conn, current)
+}
+
+// apply runs a migration and records it in one transaction, so a failed migration leaves no trace
+func apply(ctx context.Context, conn *pgxpool.Conn, script string, record string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, script)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,120 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestEmbedded(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"init", "rate_limit", "recurring_user_refs", "recurring_paused"}
+	if len(migrations) != len(names) {
+		t.Fatalf("migrations = %d, want %d", len(migrations), len(names))
+	}
+	for idx, migration := range migrations {
+		if migration.Version != idx+1 || migration.Name != names[idx] {
+			t.Errorf("migration %d = %04d_%s, want %04d_%s", idx, migration.Version, migration.Name, idx+1, names[idx])
+		}
+		if strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			t.Errorf("migration %04d_%s has an empty script", migration.Version, migration.Name)
+		}
+	}
+
+	// every file in the directory is one half of a migration
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil || len(entries) != 2*len(migrations) {
+		t.Errorf("sql has %d files, want an up and a down for each of the %d migrations", len(entries), len(migrations))
+	}
+	if latest, err := Latest(); latest != len(names) || err != nil {
+		t.Errorf("Latest = %d, %v, want %d", latest, err, len(names))
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"gap", []string{"0001_a.up.sql", "0001_a.down.sql", "0003_c.up.sql", "0003_c.down.sql"}, "migration 2 is missing"},
+		{"starts late", []string{"0002_b.up.sql", "0002_b.down.sql"}, "migration 1 is missing"},
+		{"two names", []string{"0001_a.up.sql", "0001_b.down.sql"}, "migration 1 is named both"},
+		{"no down", []string{"0001_a.up.sql"}, "migration 1 needs both"},
+		{"stray file", []string{"0001_a.up.sql", "0001_a.down.sql", "README.md"}, "unexpected migration file README.md"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range test.files {
+				fsys["sql/"+name] = file
+			}
+			if _, err := load(fsys); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("load = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// versionRow answers the queries Current makes of a database at a given version
+type versionRow struct {
+	query   string
+	version int
+}
+
+func (r versionRow) Scan(dest ...any) error {
+	if strings.Contains(r.query, "to_regclass") {
+		*dest[0].(*bool) = r.version > 0
+		*dest[1].(*bool) = r.version > 0
+		return nil
+	}
+	*dest[0].(*int) = r.version
+	return nil
+}
+
+type atVersion int
+
+func (v atVersion) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return versionRow{sql, int(v)}
+}
+
+func TestCheck(t *testing.T) {
+	latest, err := Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		current int
+		want    error
+	}{
+		{0, ErrPendingMigrations},
+		{latest - 1, ErrPendingMigrations},
+		{latest, nil},
+		{latest + 1, ErrNewerSchema},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.current), func(t *testing.T) {
+			if err := Check(context.Background(), atVersion(test.current)); !errors.Is(err, test.want) {
+				t.Errorf("Check = %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	// Up and Down refuse the same newer schema before touching it
+	if err := refuseNewer(latest+1, latest); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("refuseNewer = %v, want %v", err, ErrNewerSchema)
+	}
+	if err := refuseNewer(latest, latest); err != nil {
+		t.Errorf("refuseNewer at the latest version = %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS idempotency_key;
+DROP TABLE IF EXISTS webhook_delivery;
+DROP TABLE IF EXISTS group_event;
+DROP TABLE IF EXISTS webhook;
+DROP TABLE IF EXISTS recurring_occurrence;
+DROP TABLE IF EXISTS recurring_payment;
+DROP TABLE IF EXISTS balance_transfer;
+DROP TABLE IF EXISTS users_payment;
+DROP TABLE IF EXISTS payment;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS people;
+DROP TABLE IF EXISTS groups;
+
+DROP FUNCTION IF EXISTS check_payment_has_users();
+DROP FUNCTION IF EXISTS check_payment_users_in_same_group();
+DROP FUNCTION IF EXISTS touch_group_activity();
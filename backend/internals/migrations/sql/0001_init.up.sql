@@ -0,0 +1,230 @@
+-- version columns count edits to a row's own fields and back ETags and If-Match.
+-- balance changes from payments do not bump them
+CREATE TABLE groups (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_activity_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- a person links member rows across groups
+CREATE TABLE people (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    person_id INTEGER REFERENCES people (id)
+        ON DELETE SET NULL,
+    name TEXT NOT NULL,
+    balance NUMERIC NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    version INTEGER NOT NULL DEFAULT 1,
+    UNIQUE (group_id, person_id)
+);
+
+CREATE TABLE payment (
+    id SERIAL PRIMARY KEY,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    amount NUMERIC NOT NULL CHECK (amount > 0),
+    payer_id INTEGER REFERENCES users (id)
+        ON DELETE RESTRICT,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE users_payment (
+    user_id INTEGER REFERENCES users (id) ON DELETE RESTRICT,
+    payment_id INTEGER REFERENCES payment (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, payment_id)
+);
+
+-- audit trail of balances moved off a user when they are deactivated
+CREATE TABLE balance_transfer (
+    id SERIAL PRIMARY KEY,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    from_user_id INTEGER REFERENCES users (id),
+    to_user_id INTEGER REFERENCES users (id),
+    amount NUMERIC NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- templates that the scheduler turns into payments
+CREATE TABLE recurring_payment (
+    id SERIAL PRIMARY KEY,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    amount NUMERIC NOT NULL CHECK (amount > 0),
+    payer_id INTEGER REFERENCES users (id)
+        ON DELETE CASCADE,
+    payee_ids INTEGER[] NOT NULL CHECK (CARDINALITY(payee_ids) > 0),
+    frequency TEXT NOT NULL CHECK (frequency IN ('weekly', 'monthly', 'yearly')),
+    day_of_month INTEGER CHECK (day_of_month BETWEEN 1 AND 31),
+    start_date DATE NOT NULL,
+    end_date DATE,
+    next_run DATE NOT NULL
+);
+
+-- one row per materialized occurrence, so an occurrence can never be booked twice
+CREATE TABLE recurring_occurrence (
+    recurring_id INTEGER REFERENCES recurring_payment (id)
+        ON DELETE CASCADE,
+    occurs_on DATE NOT NULL,
+    payment_id INTEGER REFERENCES payment (id)
+        ON DELETE SET NULL,
+    PRIMARY KEY (recurring_id, occurs_on)
+);
+
+-- webhook subscriptions for a group
+CREATE TABLE webhook (
+    id SERIAL PRIMARY KEY,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- log of group events, written in the same transaction as the change.
+-- it is the outbox for webhooks and the replay log for live updates
+CREATE TABLE group_event (
+    id SERIAL PRIMARY KEY,
+    group_id INTEGER REFERENCES groups (id)
+        ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- one row per attempt to get an event to a webhook, kept as the delivery log
+CREATE TABLE webhook_delivery (
+    id SERIAL PRIMARY KEY,
+    webhook_id INTEGER REFERENCES webhook (id)
+        ON DELETE CASCADE,
+    event_id INTEGER REFERENCES group_event (id)
+        ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX webhook_delivery_pending ON webhook_delivery (next_attempt_at)
+    WHERE status = 'pending';
+
+-- first response to each Idempotency-Key, replayed when the request is retried.
+-- status_code is null while the first request is still running
+CREATE TABLE idempotency_key (
+    key TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    headers JSONB,
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- payment has to have at least 1 user associated.
+-- trigger exceptions name the trigger and column, so the API can point at the request field
+CREATE OR REPLACE FUNCTION check_payment_has_users()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM users_payment WHERE payment_id = NEW.id
+    ) THEN
+        RAISE EXCEPTION 'Payment % must have at least one associated user', NEW.id
+            USING CONSTRAINT = 'ensure_payment_has_users', COLUMN = 'payee_ids';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ensure_payment_has_users
+AFTER INSERT OR UPDATE ON payment
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW
+EXECUTE FUNCTION check_payment_has_users();
+
+-- payees and payer have to be in the same group as the payment
+CREATE OR REPLACE FUNCTION check_payment_users_in_same_group()
+RETURNS TRIGGER AS 
+$$
+DECLARE
+    payer_group_id INTEGER;
+    payee_group_id INTEGER;
+BEGIN
+    -- check payer is in the same group as the payment
+    SELECT group_id INTO payer_group_id FROM users WHERE id = NEW.payer_id;
+
+    IF payer_group_id IS NULL THEN
+        RAISE EXCEPTION 'Payer % does not exist', NEW.payer_id
+            USING CONSTRAINT = 'ensure_payment_users_in_same_group', COLUMN = 'payer_id';
+    END IF;
+
+    IF payer_group_id != NEW.group_id THEN
+        RAISE EXCEPTION 'Payer % is not in the same group as the payment (group %)', NEW.payer_id, NEW.group_id
+            USING CONSTRAINT = 'ensure_payment_users_in_same_group', COLUMN = 'payer_id';
+    END IF;
+
+    -- check all associated payees are in the same group
+    FOR payee_group_id IN
+        SELECT u.group_id
+        FROM users_payment up
+        JOIN users u ON u.id = up.user_id
+        WHERE up.payment_id = NEW.id
+    LOOP
+        IF payee_group_id != NEW.group_id THEN
+            RAISE EXCEPTION 'One or more users in users_payment are not in the same group as the payment (group %)', NEW.group_id
+                USING CONSTRAINT = 'ensure_payment_users_in_same_group', COLUMN = 'payee_ids';
+        END IF;
+    END LOOP;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ensure_payment_users_in_same_group
+AFTER INSERT OR UPDATE ON payment
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW
+EXECUTE FUNCTION check_payment_users_in_same_group();
+
+-- any change to a group's members or payments counts as activity on the group
+CREATE OR REPLACE FUNCTION touch_group_activity()
+RETURNS TRIGGER AS
+$$
+DECLARE
+    touched_group_id INTEGER;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        touched_group_id := OLD.group_id;
+    ELSE
+        touched_group_id := NEW.group_id;
+    END IF;
+
+    UPDATE groups SET last_activity_at = NOW() WHERE id = touched_group_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER touch_group_activity_on_users
+AFTER INSERT OR UPDATE OR DELETE ON users
+FOR EACH ROW
+EXECUTE FUNCTION touch_group_activity();
+
+CREATE TRIGGER touch_group_activity_on_payment
+AFTER INSERT OR UPDATE OR DELETE ON payment
+FOR EACH ROW
+EXECUTE FUNCTION touch_group_activity();
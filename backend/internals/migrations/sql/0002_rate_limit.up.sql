@@ -0,0 +1,8 @@
+-- token buckets shared by every server that rate limits through Postgres
+CREATE TABLE rate_limit (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX rate_limit_updated_at ON rate_limit (updated_at);
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS recurring_payment_payee_ids_fkey ON users;
+DROP FUNCTION IF EXISTS check_recurring_payees_not_referenced();
+
+ALTER TABLE recurring_payment
+    DROP CONSTRAINT recurring_payment_payer_id_fkey,
+    ADD CONSTRAINT recurring_payment_payer_id_fkey FOREIGN KEY (payer_id) REFERENCES users (id)
+        ON DELETE CASCADE;
@@ -0,0 +1,28 @@
+-- deleting a member used to delete the templates they pay for and leave the ones they are
+-- a payee of pointing at nobody. Templates now hold on to their members the way payments do
+ALTER TABLE recurring_payment
+    DROP CONSTRAINT recurring_payment_payer_id_fkey,
+    ADD CONSTRAINT recurring_payment_payer_id_fkey FOREIGN KEY (payer_id) REFERENCES users (id)
+        ON DELETE RESTRICT;
+
+-- a foreign key cannot cover the elements of an array, so this stands in for one on payee_ids
+-- and raises the same error a foreign key would
+CREATE OR REPLACE FUNCTION check_recurring_payees_not_referenced()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM recurring_payment WHERE OLD.id = ANY(payee_ids)
+    ) THEN
+        RAISE EXCEPTION 'update or delete on table "users" violates foreign key constraint "recurring_payment_payee_ids_fkey" on table "recurring_payment"'
+            USING ERRCODE = 'foreign_key_violation', CONSTRAINT = 'recurring_payment_payee_ids_fkey';
+    END IF;
+
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER recurring_payment_payee_ids_fkey
+BEFORE DELETE ON users
+FOR EACH ROW
+EXECUTE FUNCTION check_recurring_payees_not_referenced();
@@ -0,0 +1 @@
+ALTER TABLE recurring_payment DROP COLUMN IF EXISTS paused;
@@ -0,0 +1,3 @@
+-- a template with an inactive member can never be booked, so the scheduler pauses it
+-- once instead of failing it on every run
+ALTER TABLE recurring_payment ADD COLUMN paused BOOLEAN NOT NULL DEFAULT FALSE;
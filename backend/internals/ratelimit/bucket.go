@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit is a token bucket that holds Requests tokens and refills at an even pace,
+// from empty to full over Window. Each request takes one token
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Bucket is one of the buckets a request draws from
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Result is what taking a token left in the bucket
+type Result struct {
+	// the bucket had a token for the request
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// until the bucket is full again
+	Reset time.Duration
+	// until the next token, when the request was not allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. The in-memory one is for a single server, the Postgres one
+// shares buckets between every server on the same database
+type Store interface {
+	// Take takes a token from each of buckets if every one has a token, and from none of them
+	// otherwise, so a request turned away by one bucket costs the others nothing. A new key
+	// gets a full bucket. The results are in the order of buckets
+	Take(ctx context.Context, buckets []Bucket) ([]Result, error)
+	// Prune forgets buckets untouched for idle. Once idle is a full window they are full
+	// again, and a missing bucket starts full
+	Prune(ctx context.Context, idle time.Duration) (int64, error)
+}
+
+// takeAll refills buckets that held tokens elapsed ago, then takes a token from each of them
+// if every one has a whole token. It returns the tokens left for the store to save
+func takeAll(tokens []float64, elapsed []time.Duration, buckets []Bucket) ([]float64, []Result) {
+	left := make([]float64, len(buckets))
+	allowed := true
+	for i, b := range buckets {
+		left[i] = refill(tokens[i], elapsed[i], b.Limit)
+		allowed = allowed && left[i] >= 1
+	}
+
+	results := make([]Result, len(buckets))
+	for i, b := range buckets {
+		capacity := float64(b.Limit.Requests)
+		perSecond := capacity / b.Limit.Window.Seconds()
+
+		res := Result{Limit: b.Limit.Requests, Allowed: left[i] >= 1}
+		if allowed {
+			left[i]--
+		} else if !res.Allowed {
+			res.RetryAfter = seconds((1 - left[i]) / perSecond)
+		}
+		res.Remaining = int(math.Floor(left[i]))
+		res.Reset = seconds((capacity - left[i]) / perSecond)
+		results[i] = res
+	}
+	return left, results
+}
+
+// refill adds what a bucket that held tokens elapsed ago has gained since
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	capacity := float64(limit.Requests)
+	// clocks of different servers, or a row written after our transaction began, can put elapsed below zero
+	if elapsed > 0 {
+		tokens += elapsed.Seconds() * capacity / limit.Window.Seconds()
+	}
+	// a bucket can also hold more than a limit that was lowered since it was saved
+	return min(tokens, capacity)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
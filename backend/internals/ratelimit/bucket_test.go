@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTakeAll(t *testing.T) {
+	// a token a second
+	perMinute := Limit{Requests: 60, Window: time.Minute}
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		limit   Limit
+		want    Result
+		left    float64
+	}{
+		{"full", 60, 0, perMinute, Result{Allowed: true, Limit: 60, Remaining: 59, Reset: time.Second}, 59},
+		{"empty", 0, 0, perMinute, Result{Limit: 60, Remaining: 0, Reset: time.Minute, RetryAfter: time.Second}, 0},
+		{"half a token back", 0, 500 * time.Millisecond, perMinute, Result{Limit: 60, Remaining: 0, Reset: 59500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}, 0.5},
+		{"a token back", 0, time.Second, perMinute, Result{Allowed: true, Limit: 60, Remaining: 0, Reset: time.Minute}, 0},
+		{"refilled past full", 0, 2 * time.Hour, perMinute, Result{Allowed: true, Limit: 60, Remaining: 59, Reset: time.Second}, 59},
+		{"clock behind", 0.5, -time.Second, perMinute, Result{Limit: 60, Remaining: 0, Reset: 59500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}, 0.5},
+		{"limit lowered since", 100, 0, Limit{Requests: 10, Window: 10 * time.Second}, Result{Allowed: true, Limit: 10, Remaining: 9, Reset: time.Second}, 9},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			left, results := takeAll([]float64{test.tokens}, []time.Duration{test.elapsed}, []Bucket{{Key: "k", Limit: test.limit}})
+			if left[0] != test.left || results[0] != test.want {
+				t.Errorf("takeAll = %v %+v, want %v %+v", left[0], results[0], test.left, test.want)
+			}
+		})
+	}
+}
+
+func TestTakeAllOrNothing(t *testing.T) {
+	limit := Limit{Requests: 10, Window: 10 * time.Second}
+	buckets := []Bucket{{Key: "client", Limit: limit}, {Key: "group", Limit: limit}}
+
+	left, results := takeAll([]float64{5, 0.25}, []time.Duration{0, 0}, buckets)
+	if left[0] != 5 || left[1] != 0.25 {
+		t.Errorf("tokens left = %v, want neither bucket spent when one is empty", left)
+	}
+	if !results[0].Allowed || results[0].Remaining != 5 || results[1].Allowed || results[1].RetryAfter != 750*time.Millisecond {
+		t.Errorf("results = %+v, want the client bucket untouched and the group one empty", results)
+	}
+
+	left, results = takeAll([]float64{5, 1}, []time.Duration{0, 0}, buckets)
+	if left[0] != 4 || left[1] != 0 || !results[0].Allowed || !results[1].Allowed {
+		t.Errorf("tokens left = %v %+v, want a token from each", left, results)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+// testStore runs the same takes against any Store, which starts out empty
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	// a token every 100ms
+	limit := Limit{Requests: 2, Window: 200 * time.Millisecond}
+	take := func(keys ...string) Result {
+		t.Helper()
+		buckets := []Bucket{}
+		for _, key := range keys {
+			buckets = append(buckets, Bucket{Key: key, Limit: limit})
+		}
+		results, err := store.Take(ctx, buckets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range results {
+			if !res.Allowed {
+				return res
+			}
+		}
+		return results[0]
+	}
+
+	for i := range 2 {
+		if res := take("a"); !res.Allowed || res.Remaining != 1-i {
+			t.Fatalf("take %d = %+v, want allowed with %d left", i+1, res, 1-i)
+		}
+	}
+	if res := take("a"); res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > 100*time.Millisecond {
+		t.Errorf("third take = %+v, want turned away for up to 100ms", res)
+	}
+	if res := take("b"); !res.Allowed {
+		t.Errorf("another key = %+v, want its own full bucket", res)
+	}
+
+	// a take turned away by one bucket leaves the others alone
+	if res := take("c", "a"); res.Allowed {
+		t.Errorf("take from c and the empty a = %+v, want turned away", res)
+	}
+	if res := take("c"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("take from c = %+v, want its first token", res)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if res := take("a"); !res.Allowed {
+		t.Errorf("take after the refill = %+v, want allowed", res)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if pruned, err := store.Prune(ctx, 5*time.Millisecond); err != nil || pruned != 3 {
+		t.Errorf("Prune = %d, %v, want every idle bucket gone", pruned, err)
+	}
+	if res := take("a"); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("take after pruning = %+v, want a full bucket again", res)
+	}
+}
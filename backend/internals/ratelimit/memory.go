@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in process memory, so each server limits on its own
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]bucket
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]bucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, buckets []Bucket) ([]Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]float64, len(buckets))
+	elapsed := make([]time.Duration, len(buckets))
+	for i, b := range buckets {
+		saved, ok := s.buckets[b.Key]
+		if !ok {
+			saved = bucket{tokens: float64(b.Limit.Requests), updatedAt: now}
+		}
+		tokens[i] = saved.tokens
+		elapsed[i] = now.Sub(saved.updatedAt)
+	}
+	tokens, results := takeAll(tokens, elapsed, buckets)
+	for i, b := range buckets {
+		s.buckets[b.Key] = bucket{tokens: tokens[i], updatedAt: now}
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, idle time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-idle)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for key, b := range s.buckets {
+		if b.updatedAt.Before(cutoff) {
+			delete(s.buckets, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
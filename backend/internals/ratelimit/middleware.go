@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/michaelzhan1/split/internals/handlers"
+)
+
+const (
+	LimitHeader      = "RateLimit-Limit"
+	RemainingHeader  = "RateLimit-Remaining"
+	ResetHeader      = "RateLimit-Reset"
+	RetryAfterHeader = "Retry-After"
+)
+
+// what identifies a client
+const (
+	KeyIP    = "ip"
+	KeyToken = "token"
+)
+
+// Limits are the buckets requests take from, with reads (GET, HEAD and OPTIONS) and writes
+// counted apart. A limit of zero requests is off
+type Limits struct {
+	// per client
+	Read  Limit
+	Write Limit
+	// shared by every client of one group, for routes under /groups/{group_id}
+	GroupRead  Limit
+	GroupWrite Limit
+	// KeyIP, or KeyToken to tell clients apart by their bearer token and fall back to the IP
+	// without one. The server does not check tokens, so only use it behind something that does
+	ClientKey string
+	// take the IP from the last X-Forwarded-For address, which is only safe behind a proxy that sets it
+	TrustProxy bool
+}
+
+// Middleware answers 429 once a client or a group has used up its bucket, and tells every
+// response how much is left with the RateLimit-* headers of the most drained bucket.
+// It has to run inside the router, since it matches the route to find the group
+func Middleware(store Store, L *slog.Logger, limits Limits) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			kind, client, group := "read", limits.Read, limits.GroupRead
+			if writes(r.Method) {
+				kind, client, group = "write", limits.Write, limits.GroupWrite
+			}
+
+			type check struct {
+				key   string
+				limit Limit
+				// the end of the 429 message
+				scope string
+			}
+			checks := []check{{key: kind + ":" + clientKey(r, limits), limit: client, scope: "from this client"}}
+			if groupID := groupID(r); groupID != "" {
+				checks = append(checks, check{key: kind + ":group:" + groupID, limit: group, scope: "in this group"})
+			}
+
+			checks = slices.DeleteFunc(checks, func(c check) bool { return c.limit.Requests <= 0 })
+			if len(checks) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			buckets := make([]Bucket, len(checks))
+			for i, c := range checks {
+				buckets[i] = Bucket{Key: c.key, Limit: c.limit}
+			}
+
+			// one call for every bucket, so a request the group turns away leaves the client's alone
+			results, err := store.Take(r.Context(), buckets)
+			if err != nil {
+				// a limiter that is down should not take the API down with it
+				L.WarnContext(r.Context(), fmt.Sprintf("Rate limit not applied: %v", err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			shown := results[0]
+			for i, res := range results {
+				if !res.Allowed {
+					setHeaders(w, res)
+					retryAfter := max(1, ceilSeconds(res.RetryAfter))
+					w.Header().Set(RetryAfterHeader, strconv.Itoa(retryAfter))
+					handlers.WriteError(w, r, L, &handlers.HttpError{
+						Code:    http.StatusTooManyRequests,
+						Message: fmt.Sprintf("Too many %ss %s, retry in %ds", kind, checks[i].scope, retryAfter),
+					})
+					return
+				}
+				if res.Remaining < shown.Remaining {
+					shown = res
+				}
+			}
+			setHeaders(w, shown)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writes(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+func setHeaders(w http.ResponseWriter, res Result) {
+	w.Header().Set(LimitHeader, strconv.Itoa(res.Limit))
+	w.Header().Set(RemainingHeader, strconv.Itoa(res.Remaining))
+	w.Header().Set(ResetHeader, strconv.Itoa(ceilSeconds(res.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func clientKey(r *http.Request, limits Limits) string {
+	if limits.ClientKey == KeyToken {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && token != "" {
+			// the token itself never reaches the store
+			sum := sha256.Sum256([]byte(token))
+			return "token:" + hex.EncodeToString(sum[:16])
+		}
+	}
+	return "ip:" + clientIP(r, limits.TrustProxy)
+}
+
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			// the proxy appends the address it saw, so earlier ones are up to the client
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			ip := strings.TrimSpace(addresses[len(addresses)-1])
+			if net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// groupID finds the group the request is for. Middleware runs before chi routes the
+// request, so this matches the route on its own
+func groupID(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return ""
+	}
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	match := chi.NewRouteContext()
+	if rctx.Routes.Find(match, r.Method, path) == "" {
+		return ""
+	}
+	return match.URLParam("group_id")
+}
+
+// Start forgets buckets untouched for idle now and then on every tick until ctx is done
+func Start(ctx context.Context, store Store, L *slog.Logger, interval time.Duration, idle time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			pruned, err := store.Prune(ctx, idle)
+			if err != nil {
+				L.Error(fmt.Sprintf("Pruning rate limit buckets failed: %v", err))
+			} else if pruned > 0 {
+				L.Debug(fmt.Sprintf("Pruned %d rate limit buckets", pruned))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// limited serves a group route and a route outside any group behind the middleware
+func limited(limits Limits) http.Handler {
+	L := slog.New(slog.NewTextHandler(io.Discard, nil))
+	r := chi.NewRouter()
+	r.Use(Middleware(NewMemoryStore(), L, limits))
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.Get("/groups", ok)
+	r.Post("/groups", ok)
+	r.Get("/groups/{group_id}", ok)
+	r.Patch("/groups/{group_id}", ok)
+	return r
+}
+
+func request(h http.Handler, method string, path string, client string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = client + ":4000"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReadsAndWritesApart(t *testing.T) {
+	h := limited(Limits{
+		Read:  Limit{Requests: 1, Window: time.Minute},
+		Write: Limit{Requests: 1, Window: time.Minute},
+	})
+
+	steps := []struct {
+		method string
+		want   int
+	}{
+		{"GET", http.StatusOK},
+		{"GET", http.StatusTooManyRequests},
+		{"POST", http.StatusOK},
+		{"POST", http.StatusTooManyRequests},
+		{"GET", http.StatusTooManyRequests},
+	}
+	for i, step := range steps {
+		rec := request(h, step.method, "/groups", "10.0.0.1")
+		if rec.Code != step.want {
+			t.Errorf("step %d %s = %d, want %d", i+1, step.method, rec.Code, step.want)
+		}
+	}
+	if rec := request(h, "POST", "/groups", "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("write from another client = %d, want 200", rec.Code)
+	}
+}
+
+func TestGroupBucket(t *testing.T) {
+	h := limited(Limits{
+		Read:       Limit{Requests: 2, Window: time.Minute},
+		GroupRead:  Limit{Requests: 1, Window: time.Minute},
+		GroupWrite: Limit{Requests: 5, Window: time.Minute},
+	})
+
+	if rec := request(h, "GET", "/groups/1", "10.0.0.1"); rec.Code != http.StatusOK || rec.Header().Get(RemainingHeader) != "0" {
+		t.Errorf("first read of group 1 = %d with %s left, want 200 with the group's 0", rec.Code, rec.Header().Get(RemainingHeader))
+	}
+	rec := request(h, "GET", "/groups/1", "10.0.0.2")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "Too many reads in this group") {
+		t.Errorf("read of group 1 by another client = %d %s, want the group's 429", rec.Code, rec.Body)
+	}
+	if rec := request(h, "PATCH", "/groups/1", "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("write to group 1 = %d, want its own bucket", rec.Code)
+	}
+
+	// the read the group turned away cost the client nothing, so it still has both tokens
+	for i, path := range []string{"/groups/2", "/groups"} {
+		if rec := request(h, "GET", path, "10.0.0.2"); rec.Code != http.StatusOK {
+			t.Errorf("read %d of %s = %d, want the client's tokens untouched by the 429", i+1, path, rec.Code)
+		}
+	}
+	if rec := request(h, "GET", "/groups", "10.0.0.2"); rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "from this client") {
+		t.Errorf("third read = %d %s, want the client's 429", rec.Code, rec.Body)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	hashed := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:16])
+	}
+	tests := []struct {
+		name      string
+		limits    Limits
+		auth      string
+		forwarded []string
+		want      string
+	}{
+		{"ip", Limits{ClientKey: KeyIP}, "Bearer abc", nil, "ip:192.0.2.1"},
+		{"token", Limits{ClientKey: KeyToken}, "Bearer abc", nil, hashed("abc")},
+		{"empty token", Limits{ClientKey: KeyToken}, "Bearer ", nil, "ip:192.0.2.1"},
+		{"other scheme", Limits{ClientKey: KeyToken}, "Basic abc", nil, "ip:192.0.2.1"},
+		{"forwarded untrusted", Limits{}, "", []string{"203.0.113.9"}, "ip:192.0.2.1"},
+		{"forwarded", Limits{TrustProxy: true}, "", []string{"203.0.113.9"}, "ip:203.0.113.9"},
+		{"spoofed hops", Limits{TrustProxy: true}, "", []string{"198.51.100.7, 203.0.113.9"}, "ip:203.0.113.9"},
+		{"spoofed header", Limits{TrustProxy: true}, "", []string{"198.51.100.7", "10.1.1.1, 203.0.113.9"}, "ip:203.0.113.9"},
+		{"garbage hop", Limits{TrustProxy: true}, "", []string{"203.0.113.9, unknown"}, "ip:192.0.2.1"},
+		{"token behind a proxy", Limits{ClientKey: KeyToken, TrustProxy: true}, "Bearer abc", []string{"203.0.113.9"}, hashed("abc")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/groups", nil)
+			req.RemoteAddr = "192.0.2.1:4000"
+			if test.auth != "" {
+				req.Header.Set("Authorization", test.auth)
+			}
+			for _, hop := range test.forwarded {
+				req.Header.Add("X-Forwarded-For", hop)
+			}
+			if got := clientKey(req, test.limits); got != test.want {
+				t.Errorf("clientKey = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  string
+	}{
+		{Limit{Requests: 1, Window: 90 * time.Second}, "90"},
+		// the next token is 1.5s away
+		{Limit{Requests: 2, Window: 3 * time.Second}, "2"},
+		// under a second is still at least 1
+		{Limit{Requests: 100, Window: time.Second}, "1"},
+	}
+	for _, test := range tests {
+		h := limited(Limits{Read: test.limit})
+		var rec *httptest.ResponseRecorder
+		for range test.limit.Requests + 1 {
+			rec = request(h, "GET", "/groups", "10.0.0.1")
+		}
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get(RetryAfterHeader) != test.want {
+			t.Errorf("%d per %s: %d with Retry-After %q, want 429 with %s", test.limit.Requests, test.limit.Window, rec.Code, rec.Header().Get(RetryAfterHeader), test.want)
+		}
+	}
+
+	for d, want := range map[time.Duration]int{0: 0, time.Nanosecond: 1, time.Second: 1, 1500 * time.Millisecond: 2} {
+		if got := ceilSeconds(d); got != want {
+			t.Errorf("ceilSeconds(%s) = %d, want %d", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+// PgStore keeps buckets in the rate_limit table, so every server on the database
+// draws from the same ones. It costs a short transaction per bucket per request
+type PgStore struct {
+	db *pgxpool.Pool
+	L  *slog.Logger
+}
+
+func NewPgStore(db *pgxpool.Pool, L *slog.Logger) *PgStore {
+	return &PgStore{db: db, L: L}
+}
+
+func (s *PgStore) Take(ctx context.Context, buckets []Bucket) ([]Result, error) {
+	return database.WithTx(ctx, s.db, func(tx pgx.Tx) ([]Result, error) {
+		tokens := make([]float64, len(buckets))
+		elapsed := make([]time.Duration, len(buckets))
+		now := make([]time.Time, len(buckets))
+		// rows are locked in key order, so requests sharing buckets cannot deadlock
+		order := make([]int, len(buckets))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortFunc(order, func(a, b int) int {
+			return strings.Compare(buckets[a].Key, buckets[b].Key)
+		})
+
+		for _, i := range order {
+			// the no-op update locks the row, so concurrent requests for a key take turns
+			query := `INSERT INTO rate_limit (key, tokens) VALUES (@key, @tokens)
+ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+RETURNING tokens, updated_at, clock_timestamp()`
+			args := pgx.StrictNamedArgs{
+				"key":    buckets[i].Key,
+				"tokens": float64(buckets[i].Limit.Requests),
+			}
+
+			var updatedAt time.Time
+			s.L.DebugContext(ctx, "Take", "query", query, "args", args)
+			err := tx.QueryRow(ctx, query, args).Scan(&tokens[i], &updatedAt, &now[i])
+			if err != nil {
+				s.L.ErrorContext(ctx, fmt.Sprintf("Upsert failed: %v", err))
+				return nil, err
+			}
+			elapsed[i] = now[i].Sub(updatedAt)
+		}
+
+		tokens, results := takeAll(tokens, elapsed, buckets)
+
+		for _, i := range order {
+			query := "UPDATE rate_limit SET tokens = @tokens, updated_at = @now WHERE key = @key"
+			args := pgx.StrictNamedArgs{
+				"key":    buckets[i].Key,
+				"tokens": tokens[i],
+				"now":    now[i],
+			}
+
+			s.L.DebugContext(ctx, "Take", "query", query, "args", args)
+			_, err := tx.Exec(ctx, query, args)
+			if err != nil {
+				s.L.ErrorContext(ctx, fmt.Sprintf("Update failed: %v", err))
+				return nil, err
+			}
+		}
+
+		return results, nil
+	})
+}
+
+func (s *PgStore) Prune(ctx context.Context, idle time.Duration) (int64, error) {
+	query := "DELETE FROM rate_limit WHERE updated_at < NOW() - @idle::INTERVAL"
+	args := pgx.StrictNamedArgs{
+		"idle": idle,
+	}
+
+	s.L.DebugContext(ctx, "Prune", "query", query, "args", args)
+	tag, err := s.db.Exec(ctx, query, args)
+	if err != nil {
+		s.L.ErrorContext(ctx, fmt.Sprintf("Delete failed: %v", err))
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/michaelzhan1/split/internals/migrations"
+)
+
+// TestPgStore runs against the database in TEST_DATABASE_URL, whose rate_limit table it empties
+func TestPgStore(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	L := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer db.Close()
+	if _, err := migrations.Up(ctx, db, L); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	empty := func(t *testing.T) {
+		if _, err := db.Exec(ctx, "TRUNCATE rate_limit"); err != nil {
+			t.Fatalf("truncate: %v", err)
+		}
+	}
+	store := NewPgStore(db, L)
+
+	t.Run("buckets", func(t *testing.T) {
+		empty(t)
+		testStore(t, store)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		empty(t)
+		// servers sharing the database never hand out more tokens than the bucket holds,
+		// and requests locking the same buckets in a different order do not deadlock
+		limit := Limit{Requests: 5, Window: time.Hour}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		allowed := 0
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buckets := []Bucket{{Key: "client", Limit: limit}, {Key: "group", Limit: limit}}
+				if i%2 == 1 {
+					buckets[0], buckets[1] = buckets[1], buckets[0]
+				}
+				results, err := store.Take(ctx, buckets)
+				if err != nil {
+					t.Errorf("take %d = %v", i, err)
+					return
+				}
+				if results[0].Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed != limit.Requests {
+			t.Errorf("%d takes allowed, want the bucket's %d", allowed, limit.Requests)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/metrics"
+)
+
+// Start books due recurring payments now and then on every tick until ctx is done.
+// Safe to run on several instances at once, since templates are locked while they are booked.
+func Start(ctx context.Context, store database.Store, L *slog.Logger, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			run(ctx, store, L)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func run(ctx context.Context, store database.Store, L *slog.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	booked, err := store.MaterializeRecurringPayments(ctx, today)
+	if err != nil {
+		L.Error(fmt.Sprintf("Scheduler run failed: %v", err))
+		return
+	}
+	if booked > 0 {
+		metrics.RecurringBooked(booked)
+		L.Info(fmt.Sprintf("Scheduler booked %d recurring payments", booked))
+	}
+}
@@ -0,0 +1,324 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Store wraps a store so every call to it is a span, whichever database is behind it
+func Store(store database.Store) database.Store {
+	return tracedStore{store}
+}
+
+type tracedStore struct {
+	next database.Store
+}
+
+func (s tracedStore) GetGroupByID(ctx context.Context, id int) (database.Group, error) {
+	ctx, span := startStoreSpan(ctx, "GetGroupByID")
+	result, err := s.next.GetGroupByID(ctx, id)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) ListGroupsByPersonID(ctx context.Context, personID int, search string, sort string, limit int, offset int) ([]database.GroupListing, int, error) {
+	ctx, span := startStoreSpan(ctx, "ListGroupsByPersonID")
+	result1, result2, err := s.next.ListGroupsByPersonID(ctx, personID, search, sort, limit, offset)
+	endSpan(span, err)
+	return result1, result2, err
+}
+
+func (s tracedStore) CreateGroup(ctx context.Context, name string) (int, error) {
+	ctx, span := startStoreSpan(ctx, "CreateGroup")
+	result, err := s.next.CreateGroup(ctx, name)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) PatchGroup(ctx context.Context, id int, version *int, name string) error {
+	ctx, span := startStoreSpan(ctx, "PatchGroup")
+	err := s.next.PatchGroup(ctx, id, version, name)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) DeleteGroup(ctx context.Context, id int, version *int) error {
+	ctx, span := startStoreSpan(ctx, "DeleteGroup")
+	err := s.next.DeleteGroup(ctx, id, version)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) GetGroupSummary(ctx context.Context, id int, recent int) (database.GroupSummary, error) {
+	ctx, span := startStoreSpan(ctx, "GetGroupSummary")
+	result, err := s.next.GetGroupSummary(ctx, id, recent)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) GetUsersByGroupID(ctx context.Context, groupID int) ([]database.User, error) {
+	ctx, span := startStoreSpan(ctx, "GetUsersByGroupID", attribute.Int("split.group_id", groupID))
+	result, err := s.next.GetUsersByGroupID(ctx, groupID)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) AddUserToGroupByID(ctx context.Context, groupID int, name string) (int, error) {
+	ctx, span := startStoreSpan(ctx, "AddUserToGroupByID", attribute.Int("split.group_id", groupID))
+	result, err := s.next.AddUserToGroupByID(ctx, groupID, name)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) PatchUser(ctx context.Context, groupID int, userID int, version *int, name string) error {
+	ctx, span := startStoreSpan(ctx, "PatchUser", attribute.Int("split.group_id", groupID))
+	err := s.next.PatchUser(ctx, groupID, userID, version, name)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) DeleteUser(ctx context.Context, groupID int, userID int, version *int) error {
+	ctx, span := startStoreSpan(ctx, "DeleteUser", attribute.Int("split.group_id", groupID))
+	err := s.next.DeleteUser(ctx, groupID, userID, version)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) DeactivateUser(ctx context.Context, groupID int, userID int, transferTo *int) error {
+	ctx, span := startStoreSpan(ctx, "DeactivateUser", attribute.Int("split.group_id", groupID))
+	err := s.next.DeactivateUser(ctx, groupID, userID, transferTo)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) MergeUser(ctx context.Context, groupID int, sourceID int, targetID int) error {
+	ctx, span := startStoreSpan(ctx, "MergeUser", attribute.Int("split.group_id", groupID))
+	err := s.next.MergeUser(ctx, groupID, sourceID, targetID)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) GetPaymentsByGroupID(ctx context.Context, groupID int) ([]database.Payment, error) {
+	ctx, span := startStoreSpan(ctx, "GetPaymentsByGroupID", attribute.Int("split.group_id", groupID))
+	result, err := s.next.GetPaymentsByGroupID(ctx, groupID)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) GetPaymentByID(ctx context.Context, id int) (database.Payment, error) {
+	ctx, span := startStoreSpan(ctx, "GetPaymentByID")
+	result, err := s.next.GetPaymentByID(ctx, id)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) AddPaymentByGroupId(ctx context.Context, groupID int, body database.InsertPayment) (int, error) {
+	ctx, span := startStoreSpan(ctx, "AddPaymentByGroupId", attribute.Int("split.group_id", groupID))
+	result, err := s.next.AddPaymentByGroupId(ctx, groupID, body)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) PatchPayment(ctx context.Context, groupID int, paymentID int, version *int, amount *float32, description *string) error {
+	ctx, span := startStoreSpan(ctx, "PatchPayment", attribute.Int("split.group_id", groupID))
+	err := s.next.PatchPayment(ctx, groupID, paymentID, version, amount, description)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) DeletePayment(ctx context.Context, groupID int, paymentID int, version *int) error {
+	ctx, span := startStoreSpan(ctx, "DeletePayment", attribute.Int("split.group_id", groupID))
+	err := s.next.DeletePayment(ctx, groupID, paymentID, version)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) DeleteAllPayments(ctx context.Context, groupID int) error {
+	ctx, span := startStoreSpan(ctx, "DeleteAllPayments", attribute.Int("split.group_id", groupID))
+	err := s.next.DeleteAllPayments(ctx, groupID)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) RecordSettlement(ctx context.Context, groupID int, fromID int, toID int, amount float32) (int, error) {
+	ctx, span := startStoreSpan(ctx, "RecordSettlement", attribute.Int("split.group_id", groupID))
+	result, err := s.next.RecordSettlement(ctx, groupID, fromID, toID, amount)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) ApplyBatch(ctx context.Context, groupID int, ops []database.BatchOp) ([]int, error) {
+	ctx, span := startStoreSpan(ctx, "ApplyBatch", attribute.Int("split.group_id", groupID))
+	result, err := s.next.ApplyBatch(ctx, groupID, ops)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) GetRecurringPaymentsByGroupID(ctx context.Context, groupID int) ([]database.RecurringPayment, error) {
+	ctx, span := startStoreSpan(ctx, "GetRecurringPaymentsByGroupID", attribute.Int("split.group_id", groupID))
+	result, err := s.next.GetRecurringPaymentsByGroupID(ctx, groupID)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) AddRecurringPayment(ctx context.Context, groupID int, body database.InsertRecurringPayment) (int, error) {
+	ctx, span := startStoreSpan(ctx, "AddRecurringPayment", attribute.Int("split.group_id", groupID))
+	result, err := s.next.AddRecurringPayment(ctx, groupID, body)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) DeleteRecurringPayment(ctx context.Context, groupID int, id int) error {
+	ctx, span := startStoreSpan(ctx, "DeleteRecurringPayment", attribute.Int("split.group_id", groupID))
+	err := s.next.DeleteRecurringPayment(ctx, groupID, id)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) MaterializeRecurringPayments(ctx context.Context, today time.Time) (int, error) {
+	ctx, span := startStoreSpan(ctx, "MaterializeRecurringPayments")
+	result, err := s.next.MaterializeRecurringPayments(ctx, today)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) GetPersonByID(ctx context.Context, id int) (database.Person, error) {
+	ctx, span := startStoreSpan(ctx, "GetPersonByID")
+	result, err := s.next.GetPersonByID(ctx, id)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) CreatePerson(ctx context.Context, name string) (int, error) {
+	ctx, span := startStoreSpan(ctx, "CreatePerson")
+	result, err := s.next.CreatePerson(ctx, name)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) GetMembershipsByPersonID(ctx context.Context, id int) ([]database.Membership, error) {
+	ctx, span := startStoreSpan(ctx, "GetMembershipsByPersonID")
+	result, err := s.next.GetMembershipsByPersonID(ctx, id)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) LinkUserToPerson(ctx context.Context, personID int, userID int) error {
+	ctx, span := startStoreSpan(ctx, "LinkUserToPerson")
+	err := s.next.LinkUserToPerson(ctx, personID, userID)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) UnlinkUserFromPerson(ctx context.Context, personID int, userID int) error {
+	ctx, span := startStoreSpan(ctx, "UnlinkUserFromPerson")
+	err := s.next.UnlinkUserFromPerson(ctx, personID, userID)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) GetWebhooksByGroupID(ctx context.Context, groupID int) ([]database.Webhook, error) {
+	ctx, span := startStoreSpan(ctx, "GetWebhooksByGroupID", attribute.Int("split.group_id", groupID))
+	result, err := s.next.GetWebhooksByGroupID(ctx, groupID)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) GetWebhookByID(ctx context.Context, groupID int, id int) (database.Webhook, error) {
+	ctx, span := startStoreSpan(ctx, "GetWebhookByID", attribute.Int("split.group_id", groupID))
+	result, err := s.next.GetWebhookByID(ctx, groupID, id)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) AddWebhook(ctx context.Context, groupID int, url string, secret string, events []string) (int, error) {
+	ctx, span := startStoreSpan(ctx, "AddWebhook", attribute.Int("split.group_id", groupID))
+	result, err := s.next.AddWebhook(ctx, groupID, url, secret, events)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) DeleteWebhook(ctx context.Context, groupID int, id int) error {
+	ctx, span := startStoreSpan(ctx, "DeleteWebhook", attribute.Int("split.group_id", groupID))
+	err := s.next.DeleteWebhook(ctx, groupID, id)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) GetDeliveriesByWebhookID(ctx context.Context, id int) ([]database.WebhookDelivery, error) {
+	ctx, span := startStoreSpan(ctx, "GetDeliveriesByWebhookID")
+	result, err := s.next.GetDeliveriesByWebhookID(ctx, id)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) ReplayDelivery(ctx context.Context, webhookID int, id int) (int, error) {
+	ctx, span := startStoreSpan(ctx, "ReplayDelivery")
+	result, err := s.next.ReplayDelivery(ctx, webhookID, id)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) ClaimPendingDeliveries(ctx context.Context, limit int, lease time.Duration) ([]database.PendingDelivery, error) {
+	ctx, span := startStoreSpan(ctx, "ClaimPendingDeliveries")
+	result, err := s.next.ClaimPendingDeliveries(ctx, limit, lease)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) RecordDeliveryAttempt(ctx context.Context, id int, statusCode *int, deliveryErr *string, succeeded bool, retry *time.Time) error {
+	ctx, span := startStoreSpan(ctx, "RecordDeliveryAttempt")
+	err := s.next.RecordDeliveryAttempt(ctx, id, statusCode, deliveryErr, succeeded, retry)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) GetLatestEventID(ctx context.Context, groupID int) (int, error) {
+	ctx, span := startStoreSpan(ctx, "GetLatestEventID", attribute.Int("split.group_id", groupID))
+	result, err := s.next.GetLatestEventID(ctx, groupID)
+	endSpan(span, err)
+	return result, err
+}
+
+func (s tracedStore) GetEventsSince(ctx context.Context, groupID int, afterID int, limit int) ([]database.GroupEvent, error) {
+	ctx, span := startStoreSpan(ctx, "GetEventsSince", attribute.Int("split.group_id", groupID))
+	result, err := s.next.GetEventsSince(ctx, groupID, afterID, limit)
+	endSpan(span, err)
+	return result, err
+}
+
+// ListenEvents runs for as long as the server does, so it gets no span of its own
+func (s tracedStore) ListenEvents(ctx context.Context, listener database.EventListener) error {
+	return s.next.ListenEvents(ctx, listener)
+}
+
+func (s tracedStore) ReserveIdempotencyKey(ctx context.Context, key string, fingerprint string, retention time.Duration) (bool, database.IdempotentResponse, error) {
+	ctx, span := startStoreSpan(ctx, "ReserveIdempotencyKey")
+	result1, result2, err := s.next.ReserveIdempotencyKey(ctx, key, fingerprint, retention)
+	endSpan(span, err)
+	return result1, result2, err
+}
+
+func (s tracedStore) SaveIdempotentResponse(ctx context.Context, key string, statusCode int, headers map[string][]string, body []byte) error {
+	ctx, span := startStoreSpan(ctx, "SaveIdempotentResponse")
+	err := s.next.SaveIdempotentResponse(ctx, key, statusCode, headers, body)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	ctx, span := startStoreSpan(ctx, "ReleaseIdempotencyKey")
+	err := s.next.ReleaseIdempotencyKey(ctx, key)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracedStore) DeleteExpiredIdempotencyKeys(ctx context.Context, retention time.Duration) (int64, error) {
+	ctx, span := startStoreSpan(ctx, "DeleteExpiredIdempotencyKeys")
+	result, err := s.next.DeleteExpiredIdempotencyKeys(ctx, retention)
+	endSpan(span, err)
+	return result, err
+}
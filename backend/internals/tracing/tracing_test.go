@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/michaelzhan1/split/internals/database"
+	"github.com/michaelzhan1/split/internals/logs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spans records every span ended in the tests. The package tracer follows the first
+// provider installed globally, so there is one for the whole package
+var spans = tracetest.NewSpanRecorder()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// queryingStore runs every GetGroupByID through the pgx tracer, the way a PgStore
+// connection would, so SQL spans can be checked without a database
+type queryingStore struct {
+	database.Store
+}
+
+func (s queryingStore) GetGroupByID(ctx context.Context, id int) (database.Group, error) {
+	ctx = QueryTracer{}.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT id, name FROM groups WHERE id = @id"})
+	group, err := s.Store.GetGroupByID(ctx, id)
+	QueryTracer{}.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: err})
+	return group, err
+}
+
+func ended(name string) []sdktrace.ReadOnlySpan {
+	var res []sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		if span.Name() == name {
+			res = append(res, span)
+		}
+	}
+	return res
+}
+
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestRequestSpans(t *testing.T) {
+	var logged bytes.Buffer
+	L := logs.New(&logged, logs.Levels{Default: slog.LevelInfo}, "json", true)
+	store := Store(queryingStore{database.NewMemoryStore(slog.New(slog.NewTextHandler(io.Discard, nil)))})
+	group, err := store.CreateGroup(context.Background(), "Trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/groups/{group_id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := store.GetGroupByID(r.Context(), group); err != nil {
+			t.Errorf("GetGroupByID = %v", err)
+		}
+		L.InfoContext(r.Context(), "served")
+	})
+
+	// the caller's trace is continued
+	callerTrace := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/groups/1", nil)
+	req.Header.Set("traceparent", "00-"+callerTrace+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	servers := ended("GET /groups/{group_id}")
+	if len(servers) != 1 {
+		t.Fatalf("server spans = %d, want one named after the route", len(servers))
+	}
+	server := servers[0]
+	if server.SpanKind() != trace.SpanKindServer || server.SpanContext().TraceID().String() != callerTrace ||
+		attr(server, "http.route") != "/groups/{group_id}" || attr(server, "http.response.status_code") != "200" {
+		t.Errorf("server span = %s in trace %s with %v, want a server span in the caller's trace", server.SpanKind(), server.SpanContext().TraceID(), server.Attributes())
+	}
+
+	calls := ended("database.GetGroupByID")
+	if len(calls) != 1 || calls[0].Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatalf("store spans = %v, want one below the server span", calls)
+	}
+	queries := ended("db.SELECT")
+	if len(queries) != 1 || queries[0].Parent().SpanID() != calls[0].SpanContext().SpanID() {
+		t.Fatalf("SQL spans = %v, want one below the store span", queries)
+	}
+	if query := queries[0]; query.SpanKind() != trace.SpanKindClient || attr(query, "db.query.text") != "SELECT id, name FROM groups WHERE id = @id" {
+		t.Errorf("SQL span = %s with %v, want a client span with the statement", query.SpanKind(), query.Attributes())
+	}
+
+	var line struct {
+		TraceID string `json:"trace_id"`
+		SpanID  string `json:"span_id"`
+	}
+	if err := json.Unmarshal(logged.Bytes(), &line); err != nil || line.TraceID != callerTrace || line.SpanID != server.SpanContext().SpanID().String() {
+		t.Errorf("log line %s, want the request's trace and span ids", logged.Bytes())
+	}
+}
+
+func TestStoreSpanErrors(t *testing.T) {
+	store := Store(database.NewMemoryStore(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	// a missing row is an answer, not a failure
+	store.GetGroupByID(context.Background(), 404)
+	if calls := ended("database.GetGroupByID"); calls[len(calls)-1].Status().Code == codes.Error {
+		t.Errorf("a missing group marked the span failed")
+	}
+
+	// a user in a group that does not exist breaks a foreign key
+	store.AddUserToGroupByID(context.Background(), 404, "Alice")
+	calls := ended("database.AddUserToGroupByID")
+	if len(calls) != 1 || calls[0].Status().Code != codes.Error || len(calls[0].Events()) == 0 {
+		t.Errorf("a failed call = %v, want the span failed with the error recorded", calls)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	shutdown, err := Setup(context.Background(), ExporterNone, "", 1, "split", io.Discard)
+	if err != nil || shutdown(context.Background()) != nil {
+		t.Errorf("Setup(none) = %v, want a no-op", err)
+	}
+	if _, err := Setup(context.Background(), "jaeger", "", 1, "split", io.Discard); err == nil {
+		t.Errorf("Setup(jaeger) = nil, want an unknown exporter error")
+	}
+}
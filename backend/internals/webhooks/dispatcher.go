@@ -0,0 +1,157 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+const (
+	batchSize     = 20
+	sendTimeout   = 10 * time.Second
+	recordTimeout = 5 * time.Second
+	// a claimed batch is sent one delivery after another, so the lease has to outlast the
+	// slowest batch. Otherwise the rows due again would be claimed and sent twice
+	lease       = batchSize*(sendTimeout+recordTimeout) + time.Minute
+	maxAttempts = 8
+	maxBackoff  = time.Hour
+)
+
+type envelope struct {
+	ID        int             `json:"id"`
+	Event     string          `json:"event"`
+	GroupID   int             `json:"group_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Start sends queued deliveries on every tick until ctx is done.
+// Deliveries are leased while in flight, so several instances can run this at once.
+func Start(ctx context.Context, store database.Store, L *slog.Logger, interval time.Duration) {
+	client := &http.Client{Timeout: sendTimeout}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			dispatch(ctx, store, L, client)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Sign returns the X-Split-Signature header value for a delivery body.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func dispatch(ctx context.Context, store database.Store, L *slog.Logger, client *http.Client) {
+	claimCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	deliveries, err := store.ClaimPendingDeliveries(claimCtx, batchSize, lease)
+	cancel()
+	if err != nil {
+		L.Error(fmt.Sprintf("Webhook claim failed: %v", err))
+		return
+	}
+
+	for _, delivery := range deliveries {
+		statusCode, err := deliver(ctx, client, delivery)
+
+		var deliveryErr *string
+		if err != nil {
+			msg := err.Error()
+			if len(msg) > 500 {
+				msg = msg[:500]
+			}
+			deliveryErr = &msg
+		}
+		succeeded := err == nil
+
+		var retry *time.Time
+		if !succeeded {
+			retry = nextAttempt(delivery.Attempts, time.Now())
+		}
+
+		recordCtx, cancel := context.WithTimeout(ctx, recordTimeout)
+		err = store.RecordDeliveryAttempt(recordCtx, delivery.ID, statusCode, deliveryErr, succeeded, retry)
+		cancel()
+		if err != nil {
+			L.Error(fmt.Sprintf("Webhook record failed for delivery %v: %v", delivery.ID, err))
+		}
+	}
+}
+
+func deliver(ctx context.Context, client *http.Client, delivery database.PendingDelivery) (*int, error) {
+	body, err := json.Marshal(envelope{
+		ID:        delivery.EventID,
+		Event:     delivery.Event,
+		GroupID:   delivery.GroupID,
+		CreatedAt: delivery.EventCreatedAt,
+		Data:      delivery.Payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Split-Event", delivery.Event)
+	req.Header.Set("X-Split-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Split-Timestamp", timestamp)
+	req.Header.Set("X-Split-Signature", Sign(delivery.Secret, timestamp, body))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	statusCode := res.StatusCode
+	if statusCode < 200 || statusCode >= 300 {
+		return &statusCode, fmt.Errorf("unexpected status %d", statusCode)
+	}
+	return &statusCode, nil
+}
+
+// nextAttempt is when to retry a delivery that failed after attempts earlier tries,
+// or nil once the attempt budget is spent
+func nextAttempt(attempts int, now time.Time) *time.Time {
+	if attempts+1 >= maxAttempts {
+		return nil
+	}
+	next := now.Add(backoff(attempts))
+	return &next
+}
+
+// 30s, 1m, 2m, ... capped at an hour
+func backoff(attempts int) time.Duration {
+	wait := 30 * time.Second << attempts
+	if wait <= 0 || wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,175 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/michaelzhan1/split/internals/database"
+)
+
+func TestSign(t *testing.T) {
+	// worked out apart from Sign, as HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret
+	want := "sha256=c466df187c04568e79e1c494f4bcb1a0645e065fc5f8b904aadcf18353cbb20a"
+	if got := Sign("whsec", "1760000000", []byte(`{"id":1}`)); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("whsec", "1760000001", []byte(`{"id":1}`)) == want {
+		t.Errorf("the signature does not cover the timestamp")
+	}
+	if Sign("other", "1760000000", []byte(`{"id":1}`)) == want {
+		t.Errorf("the signature does not depend on the secret")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		// past the point where the shift overflows
+		{40, time.Hour},
+		{64, time.Hour},
+	}
+	for _, test := range tests {
+		if got := backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%d) = %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	now := time.Date(2026, time.January, 1, 12, 0, 0, 0, time.UTC)
+	schedule := []time.Duration{
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		16 * time.Minute,
+		32 * time.Minute,
+	}
+	if len(schedule) != maxAttempts-1 {
+		t.Fatalf("schedule has %d retries, want one fewer than the %d attempts", len(schedule), maxAttempts)
+	}
+	for attempts, wait := range schedule {
+		if next := nextAttempt(attempts, now); next == nil || !next.Equal(now.Add(wait)) {
+			t.Errorf("retry after attempt %d = %v, want in %s", attempts+1, next, wait)
+		}
+	}
+	if next := nextAttempt(maxAttempts-1, now); next != nil {
+		t.Errorf("retry after the last attempt = %v, want none", next)
+	}
+}
+
+func TestLeaseOutlastsBatch(t *testing.T) {
+	if slowest := batchSize * (sendTimeout + recordTimeout); lease <= slowest {
+		t.Errorf("lease of %s runs out before a batch that can take %s is sent", lease, slowest)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	delivery := database.PendingDelivery{
+		ID:             7,
+		URL:            server.URL,
+		Secret:         "whsec",
+		EventID:        3,
+		GroupID:        1,
+		Event:          database.EventPaymentCreated,
+		Payload:        []byte(`{"payment_id":5}`),
+		EventCreatedAt: time.Date(2026, time.January, 1, 12, 0, 0, 0, time.UTC),
+	}
+	code, err := deliver(context.Background(), server.Client(), delivery)
+	if err != nil || code == nil || *code != http.StatusNoContent {
+		t.Fatalf("deliver = %v, %v, want 204", code, err)
+	}
+
+	if got.Method != http.MethodPost || got.Header.Get("Content-Type") != "application/json" ||
+		got.Header.Get("X-Split-Event") != database.EventPaymentCreated || got.Header.Get("X-Split-Delivery") != "7" {
+		t.Errorf("request = %s %v, want a JSON POST naming the event and delivery", got.Method, got.Header)
+	}
+	timestamp := got.Header.Get("X-Split-Timestamp")
+	if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)).Abs() > time.Minute {
+		t.Errorf("X-Split-Timestamp = %q, want the current Unix time", timestamp)
+	}
+	if signature := got.Header.Get("X-Split-Signature"); signature != Sign("whsec", timestamp, body) {
+		t.Errorf("X-Split-Signature = %q, want the body signed with the timestamp", signature)
+	}
+
+	var sent envelope
+	if err := json.Unmarshal(body, &sent); err != nil || sent.ID != 3 || sent.GroupID != 1 ||
+		sent.Event != database.EventPaymentCreated || string(sent.Data) != `{"payment_id":5}` {
+		t.Errorf("body = %s, want the event in its envelope", body)
+	}
+
+	status = http.StatusInternalServerError
+	if code, err := deliver(context.Background(), server.Client(), delivery); err == nil || code == nil || *code != http.StatusInternalServerError {
+		t.Errorf("deliver to a failing endpoint = %v, %v, want its 500 as an error", code, err)
+	}
+}
+
+func TestDispatchSchedulesRetry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	L := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := database.NewMemoryStore(L)
+	group, err := store.CreateGroup(ctx, "Trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := store.AddWebhook(ctx, group, server.URL, "whsec", []string{database.EventUserAdded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddUserToGroupByID(ctx, group, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	dispatch(ctx, store, L, server.Client())
+
+	deliveries, err := store.GetDeliveriesByWebhookID(ctx, webhook)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries = %+v, %v, want one", deliveries, err)
+	}
+	delivery := deliveries[0]
+	if delivery.Status != database.DeliveryPending || delivery.Attempts != 1 ||
+		delivery.LastStatusCode == nil || *delivery.LastStatusCode != http.StatusServiceUnavailable {
+		t.Errorf("delivery = %+v, want it pending after one failed attempt", delivery)
+	}
+	if wait := delivery.NextAttemptAt.Sub(before); wait < 30*time.Second || wait > 31*time.Second {
+		t.Errorf("next attempt in %s, want the first backoff of 30s", wait)
+	}
+
+	// nothing is due until the backoff is over
+	dispatch(ctx, store, L, server.Client())
+	if deliveries, _ := store.GetDeliveriesByWebhookID(ctx, webhook); deliveries[0].Attempts != 1 {
+		t.Errorf("delivery was tried again %s early", time.Until(deliveries[0].NextAttemptAt))
+	}
+}
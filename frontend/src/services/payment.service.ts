@@ -34,10 +34,15 @@ export async function patchPayment(
   id: number,
   data: PatchPaymentRequest,
 ): Promise<void> {
-  await axios.patch<void, AxiosResponse, PatchPaymentRequest>(
-    `${import.meta.env.VITE_API_PREFIX}/groups/${groupId}/payments/${id}`,
-    data,
-  );
+  // the API rejects unknown fields, so leave out the payment's own id
+  await axios.patch<
+    void,
+    AxiosResponse,
+    Pick<PatchPaymentRequest, 'amount' | 'description'>
+  >(`${import.meta.env.VITE_API_PREFIX}/groups/${groupId}/payments/${id}`, {
+    amount: data.amount,
+    description: data.description,
+  });
 }
 
 export async function deletePayment(